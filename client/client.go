@@ -1,11 +1,15 @@
 package client
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"strings"
+
+	"github.com/joyent/containerpilot/control"
 )
 
 // HTTPClient provides a properly configured http.Client object used to send
@@ -13,35 +17,74 @@ import (
 type HTTPClient struct {
 	http.Client
 	socketPath string
+	socketType string
+	scheme     string
 }
 
-var socketType = "unix"
+var defaultSocketType = "unix"
 
-func socketDialer(socketPath string) func(string, string) (net.Conn, error) {
+func socketDialer(socketType, socketPath string) func(string, string) (net.Conn, error) {
 	return func(_, _ string) (net.Conn, error) {
-		return net.Dial(socketType, socketPath)
+		return control.Dial(socketType, socketPath)
 	}
 }
 
+// tokenTransport adds the control plane token to every request
+type tokenTransport struct {
+	token string
+	http.RoundTripper
+}
+
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.RoundTripper.RoundTrip(req)
+}
+
 // NewHTTPClient initializes an client.HTTPClient object by configuring it's
-// socketPath for HTTP communication through the local file system.
-func NewHTTPClient(socketPath string) (*HTTPClient, error) {
+// socketPath for HTTP communication through the local file system, or
+// through a TCP address if the socketType is "tcp". If tlsConfig is non-nil
+// the client will use HTTPS. If token is non-empty the client will send it
+// as a bearer token with every request.
+func NewHTTPClient(socketType, socketPath string, tlsConfig *tls.Config, token string) (*HTTPClient, error) {
 	if socketPath == "" {
 		err := errors.New("control server not loading due to missing config")
 		return nil, err
 	}
+	if socketType == "" {
+		socketType = defaultSocketType
+	}
 
-	client := &HTTPClient{}
+	client := &HTTPClient{
+		socketPath: socketPath,
+		socketType: socketType,
+		scheme:     "http",
+	}
 	client.Transport = &http.Transport{
-		Dial: socketDialer(socketPath),
+		Dial:            socketDialer(socketType, socketPath),
+		TLSClientConfig: tlsConfig,
+	}
+	if token != "" {
+		client.Transport = tokenTransport{token, client.Transport}
+	}
+	if tlsConfig != nil {
+		// the transport layers the TLS handshake over the connection
+		// from our dialer only for https requests
+		client.scheme = "https"
 	}
 
 	return client, nil
 }
 
+// url returns the URL for a control plane endpoint. Note the host name
+// 'control' is meaningless here but the client requires it for the
+// connection string.
+func (c HTTPClient) url(path string) string {
+	return c.scheme + "://control" + path
+}
+
 // Reload makes a request to the reload endpoint of a ContainerPilot process.
 func (c HTTPClient) Reload() error {
-	resp, err := c.Post("http://control/v3/reload", "application/json", nil)
+	resp, err := c.Post(c.url("/v3/reload"), "application/json", nil)
 	if err != nil {
 		return err
 	}
@@ -57,7 +100,7 @@ func (c HTTPClient) SetMaintenance(isEnabled bool) error {
 		flag = "enable"
 	}
 
-	resp, err := c.Post("http://control/v3/maintenance/"+flag, "application/json", nil)
+	resp, err := c.Post(c.url("/v3/maintenance/"+flag), "application/json", nil)
 	if err != nil {
 		return err
 	}
@@ -68,7 +111,7 @@ func (c HTTPClient) SetMaintenance(isEnabled bool) error {
 // PutEnv makes a request to the environ endpoint of a ContainerPilot process
 // for setting environ variable pairs.
 func (c HTTPClient) PutEnv(body string) error {
-	resp, err := c.Post("http://control/v3/environ", "application/json",
+	resp, err := c.Post(c.url("/v3/environ"), "application/json",
 		strings.NewReader(body))
 	if err != nil {
 		return err
@@ -84,7 +127,7 @@ func (c HTTPClient) PutEnv(body string) error {
 // PutMetric makes a request to the metric endpoint of a ContainerPilot process
 // for setting custom metrics.
 func (c HTTPClient) PutMetric(body string) error {
-	resp, err := c.Post("http://control/v3/metric", "application/json",
+	resp, err := c.Post(c.url("/v3/metric"), "application/json",
 		strings.NewReader(body))
 	if err != nil {
 		return err
@@ -96,3 +139,23 @@ func (c HTTPClient) PutMetric(body string) error {
 	}
 	return nil
 }
+
+// GetStatus makes a request to the status endpoint of a ContainerPilot
+// process and returns the state of each of its jobs.
+func (c HTTPClient) GetStatus() (*control.StatusResponse, error) {
+	resp, err := c.Get(c.url("/v3/status"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status '%s' received by control server",
+			resp.Status)
+	}
+	status := &control.StatusResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, fmt.Errorf("unable to decode status response: %v", err)
+	}
+	return status, nil
+}
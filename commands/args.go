@@ -1,20 +1,32 @@
 package commands
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"os/exec"
 	"strings"
+	"unicode"
 
 	"github.com/joyent/containerpilot/utils"
 )
 
+// Shell is the shell that runs the command of an exec in shell mode
+const Shell = "/bin/sh"
+
 // ParseArgs parses the executable and its arguments from supported
-// types.
+// types. A string is split into arguments at whitespace outside of
+// quotes, and an object of the form {shell: "command"} runs the command
+// with the shell, so that it can use pipes, redirection, and variables.
 func ParseArgs(raw interface{}) (executable string, args []string, err error) {
 	switch t := raw.(type) {
 	case string:
-		if t != "" {
-			args = strings.Split(strings.TrimSpace(t), " ")
+		if args, err = splitArgs(t); err != nil {
+			return "", nil, err
+		}
+	case map[string]interface{}:
+		if args, err = shellArgs(t); err != nil {
+			return "", nil, err
 		}
 	default:
 		args, err = utils.ToStringArray(raw)
@@ -31,6 +43,70 @@ func ParseArgs(raw interface{}) (executable string, args []string, err error) {
 	return executable, args, err
 }
 
+// splitArgs splits a command line into arguments the way a POSIX shell
+// does, without expanding anything. Whitespace separates arguments unless
+// it's quoted. Single quotes keep everything between them literally, and
+// within double quotes a backslash escapes only ", \, $, and `. Outside of
+// quotes a backslash escapes any character.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var arg bytes.Buffer
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, errors.New("unterminated escape at end of command")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// shellArgs returns the arguments that run the command of an exec of the
+// form {shell: "command"} with the Shell
+func shellArgs(raw map[string]interface{}) ([]string, error) {
+	command, ok := raw["shell"].(string)
+	if !ok || len(raw) != 1 || strings.TrimSpace(command) == "" {
+		return nil, errors.New("exec object must have only a 'shell' command")
+	}
+	return []string{Shell, "-c", command}, nil
+}
+
 // ArgsToCmd creates a command from a list of arguments
 func ArgsToCmd(executable string, args []string) *exec.Cmd {
 	if len(args) == 0 {
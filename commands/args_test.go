@@ -25,6 +25,25 @@ func TestParseArgs(t *testing.T) {
 	exec, args, err = ParseArgs([]interface{}{"/testdata/test.sh", "arg3"})
 	validateParsing(t, exec, "/testdata/test.sh", args, []string{"arg3"}, err, nil)
 
+	// quoted and escaped string args ok
+	exec, args, err = ParseArgs(`  grep -e 'a  b' "it's \"q\" \n" c\ d  `)
+	validateParsing(t, exec, "grep",
+		args, []string{"-e", "a  b", `it's "q" \n`, "c d"}, err, nil)
+
+	// unterminated quotes return error
+	exec, args, err = ParseArgs(`echo "hello`)
+	validateParsing(t, exec, "", args, nil,
+		err, errors.New("unterminated \" quote in command"))
+
+	// shell args ok
+	exec, args, err = ParseArgs(map[string]interface{}{"shell": "ps | grep app"})
+	validateParsing(t, exec, "/bin/sh", args, []string{"-c", "ps | grep app"}, err, nil)
+
+	// shell args with other keys return error
+	exec, args, err = ParseArgs(map[string]interface{}{"shell": "ps", "user": "app"})
+	validateParsing(t, exec, "", args, nil,
+		err, errors.New("exec object must have only a 'shell' command"))
+
 	// map of bools args return error
 	exec, args, err = ParseArgs([]bool{true})
 	validateParsing(t, exec, "", args, nil,
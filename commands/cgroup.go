@@ -0,0 +1,186 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// paths of the cgroup v2 hierarchy, which can be replaced in tests
+var (
+	cgroupRoot     = "/sys/fs/cgroup"
+	selfCgroupFile = "/proc/self/cgroup"
+)
+
+// Cgroup is a cgroup v2 that a Command's process is placed in, so that
+// its CPU and memory use can be limited separately from other jobs
+type Cgroup struct {
+	Name      string
+	CPUMax    string // contents of cpu.max, ex. "50000 100000"
+	MemoryMax string // contents of memory.max, ex. "268435456"
+
+	once sync.Once
+	path string
+	err  error
+}
+
+// cgroupParent is the cgroup that ContainerPilot was started in, under
+// which each job's cgroup is created. The cgroup v2 "no internal process"
+// rule means the parent can't have processes of its own once its
+// controllers are enabled for the job cgroups, so ContainerPilot and any
+// other processes are moved into a "containerpilot" leaf cgroup.
+var cgroupParent struct {
+	sync.Mutex
+	path string
+}
+
+// add moves the process into the cgroup, creating it the first time
+func (cg *Cgroup) add(pid int) error {
+	path, err := cg.dir()
+	if err != nil {
+		return err
+	}
+	return writeCgroupFile(path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// open opens the directory of the cgroup, creating it the first time
+func (cg *Cgroup) open() (*os.File, error) {
+	path, err := cg.dir()
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (cg *Cgroup) dir() (string, error) {
+	cg.once.Do(func() {
+		cg.path, cg.err = cg.create()
+	})
+	return cg.path, cg.err
+}
+
+func (cg *Cgroup) create() (string, error) {
+	cgroupParent.Lock()
+	defer cgroupParent.Unlock()
+	if cgroupParent.path == "" {
+		parent, err := findSelfCgroup()
+		if err != nil {
+			return "", err
+		}
+		cgroupParent.path = parent
+	}
+	parent := cgroupParent.path
+
+	var controllers []string
+	if cg.CPUMax != "" {
+		controllers = append(controllers, "cpu")
+	}
+	if cg.MemoryMax != "" {
+		controllers = append(controllers, "memory")
+	}
+	if err := enableControllers(parent, controllers); err != nil {
+		return "", err
+	}
+	path := filepath.Join(parent, "job-"+cg.Name)
+	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	if cg.CPUMax != "" {
+		if err := writeCgroupFile(path, "cpu.max", cg.CPUMax); err != nil {
+			return "", err
+		}
+	}
+	if cg.MemoryMax != "" {
+		if err := writeCgroupFile(path, "memory.max", cg.MemoryMax); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// findSelfCgroup returns the path of our own cgroup v2
+func findSelfCgroup() (string, error) {
+	f, err := os.Open(selfCgroupFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "0::") {
+			path := filepath.Join(cgroupRoot, strings.TrimPrefix(scanner.Text(), "0::"))
+			if _, err := os.Stat(filepath.Join(path, "cgroup.controllers")); err != nil {
+				break
+			}
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 isn't available")
+}
+
+// enableControllers enables the controllers for the parent's children,
+// first moving any processes in the parent into the leaf cgroup
+func enableControllers(parent string, controllers []string) error {
+	available, err := readCgroupFile(parent, "cgroup.controllers")
+	if err != nil {
+		return err
+	}
+	enabled, err := readCgroupFile(parent, "cgroup.subtree_control")
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, controller := range controllers {
+		if !contains(available, controller) {
+			return fmt.Errorf("cgroup controller '%s' isn't available", controller)
+		}
+		if !contains(enabled, controller) {
+			missing = append(missing, "+"+controller)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	procs, err := readCgroupFile(parent, "cgroup.procs")
+	if err != nil {
+		return err
+	}
+	if len(procs) > 0 {
+		leaf := filepath.Join(parent, "containerpilot")
+		if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		for _, pid := range procs {
+			if err := writeCgroupFile(leaf, "cgroup.procs", pid); err != nil {
+				return err
+			}
+		}
+	}
+	return writeCgroupFile(parent, "cgroup.subtree_control", strings.Join(missing, " "))
+}
+
+func readCgroupFile(dir, name string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
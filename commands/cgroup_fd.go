@@ -0,0 +1,35 @@
+// +build linux,go1.20
+
+package commands
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+// startInCgroup starts the process in the Command's cgroup, so that it
+// never runs outside of it. Before Linux 5.7 the kernel can't start a
+// process in a cgroup, so the process is moved into it once started.
+func (c *Command) startInCgroup() error {
+	dir, err := c.Cgroup.open()
+	if err != nil {
+		log.Warnf("%s: unable to use cgroup: %v", c.Name, err)
+		return c.Cmd.Start()
+	}
+	defer dir.Close()
+	c.Cmd.SysProcAttr.UseCgroupFD = true
+	c.Cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	if err := c.Cmd.Start(); err == nil {
+		return nil
+	}
+	// an exec.Cmd can't be started twice, so retry with a new one
+	stdout, stderr, env := c.Cmd.Stdout, c.Cmd.Stderr, c.Cmd.Env
+	c.setUpCmd()
+	c.Cmd.Stdout, c.Cmd.Stderr, c.Cmd.Env = stdout, stderr, env
+	if err := c.Cmd.Start(); err != nil {
+		return err
+	}
+	if err := c.Cgroup.add(c.Cmd.Process.Pid); err != nil {
+		log.Warnf("%s: unable to use cgroup: %v", c.Name, err)
+	}
+	return nil
+}
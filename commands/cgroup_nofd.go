@@ -0,0 +1,20 @@
+// +build linux,!go1.20
+
+package commands
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+// startInCgroup starts the process and moves it into the Command's
+// cgroup. Go can only start a process in a cgroup since 1.20, so when
+// built with an older Go the process briefly runs outside of it.
+func (c *Command) startInCgroup() error {
+	if err := c.Cmd.Start(); err != nil {
+		return err
+	}
+	if err := c.Cgroup.add(c.Cmd.Process.Pid); err != nil {
+		log.Warnf("%s: unable to use cgroup: %v", c.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package commands
+
+import "fmt"
+
+// startInCgroup fails, as cgroups are only supported on Linux
+func (c *Command) startInCgroup() error {
+	return fmt.Errorf("%s: cgroups are not supported on this platform", c.Name)
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCgroupAdd(t *testing.T) {
+	root, _ := ioutil.TempDir("", "cgroup")
+	defer os.RemoveAll(root)
+	defer func(root, self string) {
+		cgroupRoot, selfCgroupFile = root, self
+		cgroupParent.path = ""
+	}(cgroupRoot, selfCgroupFile)
+	cgroupRoot = root
+	selfCgroupFile = filepath.Join(root, "self")
+	cgroupParent.path = ""
+
+	parent := filepath.Join(root, "container")
+	os.MkdirAll(parent, 0755)
+	write := func(path, value string) {
+		if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	read := func(path string) string {
+		data, _ := ioutil.ReadFile(path)
+		return string(data)
+	}
+	write(selfCgroupFile, "0::/container\n")
+	write(filepath.Join(parent, "cgroup.controllers"), "cpuset cpu io memory pids\n")
+	write(filepath.Join(parent, "cgroup.subtree_control"), "")
+	write(filepath.Join(parent, "cgroup.procs"), "1\n")
+
+	cg := &Cgroup{Name: "app", CPUMax: "50000 100000", MemoryMax: "268435456"}
+	if err := cg.add(42); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := map[string]string{
+		"containerpilot/cgroup.procs": "1",
+		"cgroup.subtree_control":      "+cpu +memory",
+		"job-app/cpu.max":             "50000 100000",
+		"job-app/memory.max":          "268435456",
+		"job-app/cgroup.procs":        "42",
+	}
+	for path, value := range expect {
+		if got := read(filepath.Join(parent, path)); got != value {
+			t.Fatalf("expected '%s' in %s but got '%s'", value, path, got)
+		}
+	}
+
+	// the parent is found only once, as we've moved out of it
+	write(selfCgroupFile, "0::/container/containerpilot\n")
+	write(filepath.Join(parent, "cgroup.subtree_control"), "cpu memory")
+	other := &Cgroup{Name: "worker", MemoryMax: "1024"}
+	if err := other.add(43); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := read(filepath.Join(parent, "job-worker", "cgroup.procs")); got != "43" {
+		t.Fatalf("expected pid 43 in the worker cgroup but got '%s'", got)
+	}
+
+	missing := &Cgroup{Name: "missing", CPUMax: "max 100000"}
+	write(filepath.Join(parent, "cgroup.controllers"), "memory\n")
+	if err := missing.add(44); err == nil ||
+		err.Error() != "cgroup controller 'cpu' isn't available" {
+		t.Fatalf("expected unavailable controller error but got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"sync"
 	"syscall"
@@ -20,9 +21,55 @@ type Command struct {
 	Exec      string
 	Args      []string
 	Timeout   time.Duration
+	Logs      *LogBuffer // optional, keeps recent output
+	Output    *Output    // optional, how output is written to our log
+	Stdout    io.Writer  // optional, captures stdout rather than logging it
 	logger    io.WriteCloser
+	logFile   io.WriteCloser // optional, replaces our log unless logTee
+	logTee    bool
 	logFields log.Fields
 	lock      *sync.Mutex
+
+	// how the process is asked to stop before it's killed
+	StopSignal       syscall.Signal // 0 kills immediately
+	StopGracePeriod  time.Duration
+	KillProcessGroup bool // kill the process group, not just the process
+
+	// optional user and group to run the process as
+	Credential *syscall.Credential
+
+	// optional KEY=VALUE variables set over our own environment
+	Env []string
+
+	// optional working directory and file mode creation mask
+	Dir   string
+	Umask *int
+
+	// optional resource limits
+	Rlimits []Rlimit
+	Cgroup  *Cgroup
+
+	// optional hooks run before the process is asked to stop, and after
+	// the process exits for any reason
+	PreStop  *Command
+	PostStop *Command
+
+	// optional limits on how many Commands run at the same time, in the
+	// order they're acquired
+	Limiters []*Limiter
+
+	// optional exit codes that are a success (true) or a failure (false),
+	// overriding the usual success for an exit code of 0 only
+	ExitCodes map[int]bool
+
+	// exitCode, exitSignal, pid, lastPid, and exited are guarded by
+	// exitCodeLock
+	exitCode     int
+	exitSignal   syscall.Signal // set if the process was killed by a signal
+	pid          int
+	lastPid      int           // pid of the latest run, kept after it exits
+	exited       chan struct{} // closed when the current run exits
+	exitCodeLock *sync.RWMutex
 }
 
 // NewCommand parses JSON config into a Command
@@ -39,6 +86,9 @@ func NewCommand(rawArgs interface{}, timeout time.Duration, fields log.Fields) (
 		lock:      &sync.Mutex{},
 		logger:    log.StandardLogger().Writer(),
 		logFields: fields,
+
+		KillProcessGroup: true,
+		exitCodeLock:     &sync.RWMutex{},
 	} // exec.Cmd created at Run
 	return cmd, nil
 }
@@ -57,8 +107,12 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 	log.Debugf("%s.Run start", c.Name)
 	c.setUpCmd()
 	defer reapChildren(c.Cmd.SysProcAttr.Pgid)
-	c.Cmd.Stdout = c.logger
-	c.Cmd.Stderr = c.logger
+	stdout, stderr, flushOutput := c.outputs()
+	c.Cmd.Stdout = stdout
+	c.Cmd.Stderr = stderr
+
+	exited := make(chan struct{})
+	c.setExited(exited)
 
 	var (
 		ctx    context.Context
@@ -78,26 +132,48 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 			defer c.lock.Unlock()
 			if ctx.Err() == context.DeadlineExceeded {
 				log.Warnf("%s timeout after %s: '%s'", c.Name, c.Timeout, c.Args)
+				c.Kill()
+				return
 			}
 			// if the context was canceled we don't know if its because we
 			// canceled it in the caller or the applicaton exited gracefully,
-			// so Kill() will have to handle both cases safely
-			c.Kill()
+			// so Stop() will have to handle both cases safely
+			c.Stop()
 		}
 	}()
 
 	go func() {
 		defer cancel()
+		defer close(exited)
 		defer log.Debugf("%s.Run end", c.Name)
-		if err := c.Cmd.Start(); err != nil {
+		// time spent waiting for a turn counts against the timeout
+		if err := c.acquire(ctx); err != nil {
+			c.setExitCode(-1)
+			log.Debugf("%s gave up waiting to run: %v", c.Name, err)
+			bus.Publish(events.Event{events.ExitFailed, c.Name})
+			return
+		}
+		defer c.release()
+		if err := c.start(); err != nil {
+			c.setExitCode(-1)
 			log.Errorf("unable to start %s: %v", c.Name, err)
 			bus.Publish(events.Event{events.ExitFailed, c.Name})
 			bus.Publish(events.Event{events.Error, err.Error()})
 			return
 		}
+		c.setPid(c.Cmd.Process.Pid)
+		c.applyLimits(c.Cmd.Process.Pid)
+		if ctx.Err() != nil {
+			// we were stopped before the process started, so there
+			// was nothing to stop then
+			c.kill(c.Cmd.Process.Pid)
+		}
 		// blocks this goroutine here; if the context gets cancelled
 		// we'll return from wait() and do all the cleanup
-		if err := c.wait(); err != nil {
+		err := c.exitError(c.wait())
+		flushOutput()
+		c.runHook(c.PostStop)
+		if err != nil {
 			log.Errorf("%s exited with error: %v", c.Name, err)
 			bus.Publish(events.Event{events.ExitFailed, c.Name})
 			bus.Publish(events.Event{events.Error, err.Error()})
@@ -108,27 +184,168 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 	}()
 }
 
+// startLock serializes starting processes, as the umask and environment
+// are inherited from our own process and can only be changed for the
+// whole process
+var startLock sync.Mutex
+
+// UpdateEnviron runs fn, which changes our own environment, while no
+// process is being started, so that a process sees either all of the
+// changes or none of them
+func UpdateEnviron(fn func()) {
+	startLock.Lock()
+	defer startLock.Unlock()
+	fn()
+}
+
+// start starts the process with the Command's umask, if any, and in the
+// Command's cgroup
+func (c *Command) start() error {
+	startLock.Lock()
+	defer startLock.Unlock()
+	if len(c.Env) > 0 {
+		// for duplicate keys, os/exec uses the last value
+		c.Cmd.Env = append(os.Environ(), c.Env...)
+	}
+	if c.Umask != nil {
+		old := syscall.Umask(*c.Umask)
+		defer syscall.Umask(old)
+	}
+	if c.Cgroup != nil {
+		return c.startInCgroup()
+	}
+	return c.Cmd.Start()
+}
+
+// exitError returns the error from the exit of the process, changed to
+// match the outcome of its exit code in ExitCodes, if any
+func (c *Command) exitError(err error) error {
+	code := c.ExitCode()
+	success, ok := c.ExitCodes[code]
+	switch {
+	case !ok || code < 0:
+		return err
+	case success:
+		if err != nil {
+			log.Debugf("%s exited with %d, which is a success", c.Name, code)
+		}
+		return nil
+	case err == nil:
+		return fmt.Errorf("%s: exit status %d", c.Name, code)
+	}
+	return err
+}
+
 func (c *Command) wait() error {
 	err := c.Cmd.Wait()
+	c.setPid(0)
 	if err != nil {
+		exitCode := -1
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				if status.ExitStatus() == 0 {
+				exitCode = status.ExitStatus()
+				if exitCode == 0 {
+					c.setExitCode(0)
 					return nil
 				}
+				if status.Signaled() {
+					c.setExitSignal(status.Signal())
+					return fmt.Errorf("%s: %s", c.Name, err.Error())
+				}
 			}
 		}
+		c.setExitCode(exitCode)
 		return fmt.Errorf("%s: %s", c.Name, err.Error())
 	}
+	c.setExitCode(0)
 	return nil
 }
 
+// ExitCode returns the exit code of the most recent run of the Command.
+// This will be -1 if the process failed to start or was killed by a signal.
+func (c *Command) ExitCode() int {
+	c.exitCodeLock.RLock()
+	defer c.exitCodeLock.RUnlock()
+	return c.exitCode
+}
+
+// ExitStatus returns the exit status of the most recent run of the
+// Command the way a shell reports it: the exit code, or 128 plus the
+// signal number if the process was killed by a signal. It's 1 if the
+// process failed to start.
+func (c *Command) ExitStatus() int {
+	c.exitCodeLock.RLock()
+	defer c.exitCodeLock.RUnlock()
+	if c.exitSignal != 0 {
+		return 128 + int(c.exitSignal)
+	}
+	if c.exitCode < 0 {
+		return 1
+	}
+	return c.exitCode
+}
+
+func (c *Command) setExitCode(code int) {
+	c.exitCodeLock.Lock()
+	defer c.exitCodeLock.Unlock()
+	c.exitCode = code
+	c.exitSignal = 0
+}
+
+func (c *Command) setExitSignal(sig syscall.Signal) {
+	c.exitCodeLock.Lock()
+	defer c.exitCodeLock.Unlock()
+	c.exitCode = -1
+	c.exitSignal = sig
+}
+
+// Pid returns the process ID of the Command while it's running, or 0
+// if it isn't running.
+func (c *Command) Pid() int {
+	c.exitCodeLock.RLock()
+	defer c.exitCodeLock.RUnlock()
+	return c.pid
+}
+
+func (c *Command) setPid(pid int) {
+	c.exitCodeLock.Lock()
+	defer c.exitCodeLock.Unlock()
+	c.pid = pid
+	if pid != 0 {
+		c.lastPid = pid
+	}
+}
+
+// runPids returns the pid of the running process, or 0 if it has exited,
+// and the pid of the latest run
+func (c *Command) runPids() (int, int) {
+	c.exitCodeLock.RLock()
+	defer c.exitCodeLock.RUnlock()
+	return c.pid, c.lastPid
+}
+
+func (c *Command) setExited(exited chan struct{}) {
+	c.exitCodeLock.Lock()
+	defer c.exitCodeLock.Unlock()
+	c.exited = exited
+}
+
+func (c *Command) getExited() chan struct{} {
+	c.exitCodeLock.RLock()
+	defer c.exitCodeLock.RUnlock()
+	return c.exited
+}
+
 func (c *Command) setUpCmd() {
 	cmd := ArgsToCmd(c.Exec, c.Args)
 
 	// assign a unique process group ID so we can kill all
 	// its children on timeout
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Dir = c.Dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Credential: c.Credential,
+	}
 	c.Cmd = cmd
 }
 
@@ -141,6 +358,61 @@ func (c *Command) Kill() {
 	}
 }
 
+// Stop runs the PreStop hook, then sends the StopSignal to the underlying
+// process and kills it if it hasn't exited after the StopGracePeriod.
+// Without a StopSignal this kills the process after the hook, and if the
+// process has already exited, this kills what's left of its process group.
+// Stop blocks until the process exits or is killed. Only the run that was
+// current when Stop was called is killed, and never a run started since.
+func (c *Command) Stop() {
+	exited := c.getExited()
+	_, pid := c.runPids()
+	if exited == nil {
+		c.kill(pid)
+		return
+	}
+	select {
+	case <-exited:
+		c.kill(pid)
+		return
+	default:
+	}
+	c.runHook(c.PreStop)
+	if c.StopSignal == 0 {
+		c.kill(pid)
+		return
+	}
+	if err := c.Signal(c.StopSignal); err != nil {
+		log.Debugf("unable to signal %s: %v", c.Name, err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(c.StopGracePeriod):
+		log.Warnf("%s did not stop within %v of %v", c.Name,
+			c.StopGracePeriod, c.StopSignal)
+	}
+	c.kill(pid)
+}
+
+// kill kills the process group of the run with the pid, or only the
+// process if the Command doesn't kill its process group. The process
+// alone is only killed while it's still running, as its pid may since
+// have been reused.
+func (c *Command) kill(pid int) {
+	if pid == 0 {
+		return
+	}
+	if c.KillProcessGroup {
+		log.Debugf("killing command '%v' at pid: %d", c.Name, pid)
+		syscall.Kill(-pid, syscall.SIGKILL)
+		return
+	}
+	if running, _ := c.runPids(); running == pid {
+		log.Debugf("killing command '%v' at pid: %d", c.Name, pid)
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
 // CloseLogs safely closes the io.WriteCloser we're using to pipe logs
 func (c *Command) CloseLogs() {
 	// need to nil check these because they might have been closed
@@ -148,5 +420,8 @@ func (c *Command) CloseLogs() {
 	if c != nil && c.logger != nil {
 		c.logger.Close()
 	}
+	if c != nil && c.logFile != nil {
+		c.logFile.Close()
+	}
 	return
 }
@@ -3,6 +3,9 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -28,6 +31,9 @@ func TestCommandRunWithTimeoutKilled(t *testing.T) {
 	if got[testTimeout] > 0 || got[expired] != 1 || got[errMsg] != 1 {
 		t.Fatalf("expected:\n%v\n%v\ngot events:\n%v", expired, errMsg, got)
 	}
+	if code, status := cmd.ExitCode(), cmd.ExitStatus(); code != -1 || status != 137 {
+		t.Fatalf("expected exit code -1 and status 137 but got %d and %d", code, status)
+	}
 }
 
 func TestCommandRunChildrenKilled(t *testing.T) {
@@ -51,6 +57,28 @@ func TestCommandRunExecFailed(t *testing.T) {
 	if got[failed] != 1 || got[errMsg] != 1 {
 		t.Fatalf("expected:\n%v\n%v\ngot events:\n%v", failed, errMsg, got)
 	}
+	if code := cmd.ExitCode(); code != 255 || cmd.ExitStatus() != 255 {
+		t.Fatalf("expected exit code 255 but got %d", code)
+	}
+}
+
+func TestCommandRunExitCodes(t *testing.T) {
+	cmd, _ := NewCommand("./testdata/test.sh failStuff --debug", time.Duration(0), nil)
+	cmd.ExitCodes = map[int]bool{255: true}
+	got := runtestCommandRun(cmd)
+	success := events.Event{events.ExitSuccess, "./testdata/test.sh"}
+	if got[success] != 1 || len(got) != 1 {
+		t.Fatalf("expected exit code 255 to be a success but got events %v", got)
+	}
+
+	cmd, _ = NewCommand("true", time.Duration(0), nil)
+	cmd.ExitCodes = map[int]bool{0: false}
+	got = runtestCommandRun(cmd)
+	failed := events.Event{events.ExitFailed, "true"}
+	errMsg := events.Event{events.Error, "true: exit status 0"}
+	if got[failed] != 1 || got[errMsg] != 1 {
+		t.Fatalf("expected exit code 0 to be a failure but got events %v", got)
+	}
 }
 
 func TestCommandRunExecInvalid(t *testing.T) {
@@ -76,6 +104,105 @@ func TestCommandRunReuseCmd(t *testing.T) {
 	runtestCommandRun(cmd)
 }
 
+func TestCommandPid(t *testing.T) {
+	cmd, _ := NewCommand("sleep 0.2", time.Duration(0), nil)
+	if pid := cmd.Pid(); pid != 0 {
+		t.Fatalf("expected no pid before running but got %d", pid)
+	}
+	bus := events.NewEventBus()
+	cmd.Run(context.Background(), bus)
+	time.Sleep(100 * time.Millisecond)
+	if pid := cmd.Pid(); pid == 0 {
+		t.Fatalf("expected pid while running")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if pid := cmd.Pid(); pid != 0 {
+		t.Fatalf("expected no pid after exit but got %d", pid)
+	}
+}
+
+func TestCommandStop(t *testing.T) {
+	stop := func(script string) (int, time.Duration) {
+		cmd, _ := NewCommand([]string{"sh", "-c", script}, time.Duration(0), nil)
+		cmd.StopSignal = syscall.SIGINT
+		cmd.StopGracePeriod = 200 * time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		cmd.Run(ctx, events.NewEventBus())
+		time.Sleep(100 * time.Millisecond)
+		start := time.Now()
+		cancel()
+		for i := 0; i < 100 && cmd.Pid() != 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return cmd.ExitCode(), time.Since(start)
+	}
+
+	code, elapsed := stop("trap 'exit 0' INT; while true; do sleep 0.01; done")
+	if code != 0 || elapsed >= 200*time.Millisecond {
+		t.Fatalf("expected exit 0 before the grace period but got %d after %v",
+			code, elapsed)
+	}
+	code, elapsed = stop("trap '' INT; while true; do sleep 0.01; done")
+	if code != -1 || elapsed < 200*time.Millisecond {
+		t.Fatalf("expected kill after the grace period but got %d after %v",
+			code, elapsed)
+	}
+}
+
+func TestCommandRunWithEnv(t *testing.T) {
+	os.Setenv("CP_TEST_ENV", "global")
+	defer os.Unsetenv("CP_TEST_ENV")
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		`test "$CP_TEST_ENV" = job && test -n "$PATH"`}, time.Duration(0), nil)
+	cmd.Env = []string{"CP_TEST_ENV=file", "CP_TEST_ENV=job"}
+	got := runtestCommandRun(cmd)
+	if got[events.Event{events.ExitSuccess, "sh"}] != 1 {
+		t.Fatalf("expected job env over global env but got events %v", got)
+	}
+}
+
+func TestCommandUpdateEnviron(t *testing.T) {
+	defer os.Unsetenv("CP_TEST_ENV_A")
+	defer os.Unsetenv("CP_TEST_ENV_B")
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		`test "$CP_TEST_ENV_A" = "$CP_TEST_ENV_B"`}, time.Duration(0), nil)
+	cmd.Env = []string{"CP_TEST=1"}
+	result := make(chan error, 1)
+	UpdateEnviron(func() {
+		os.Setenv("CP_TEST_ENV_A", "new")
+		go func() { result <- cmd.RunAndWait(context.Background()) }()
+		time.Sleep(50 * time.Millisecond)
+		os.Setenv("CP_TEST_ENV_B", "new")
+	})
+	if err := <-result; err != nil {
+		t.Fatalf("expected command to see the whole update but got: %v", err)
+	}
+}
+
+func TestCommandRunWithWorkdirAndUmask(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "workdir")
+	defer os.RemoveAll(dir)
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		`test "$(pwd)" = "$1" && test "$(umask)" = 0027`, "sh", dir},
+		time.Duration(0), nil)
+	cmd.Dir = dir
+	umask := 027
+	cmd.Umask = &umask
+	before := syscall.Umask(0)
+	syscall.Umask(before)
+	got := runtestCommandRun(cmd)
+	if got[events.Event{events.ExitSuccess, "sh"}] != 1 {
+		t.Fatalf("expected command to run in %s with umask 027 but got events %v",
+			dir, got)
+	}
+	// our own umask is unchanged
+	after := syscall.Umask(0)
+	syscall.Umask(after)
+	if after != before {
+		t.Fatalf("expected our umask %o to be restored but got %o", before, after)
+	}
+}
+
 // test helpers
 
 func runtestCommandRun(cmd *Command) map[events.Event]int {
@@ -92,3 +219,15 @@ func runtestCommandRun(cmd *Command) map[events.Event]int {
 	}
 	return got
 }
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"SIGHUP", "HUP", "hup"} {
+		sig, err := ParseSignal(name)
+		if err != nil || sig != syscall.SIGHUP {
+			t.Fatalf("expected SIGHUP for '%s' but got %v (%v)", name, sig, err)
+		}
+	}
+	if _, err := ParseSignal("SIGBOGUS"); err == nil {
+		t.Fatalf("expected error for unsupported signal")
+	}
+}
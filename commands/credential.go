@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// LookupCredential returns the credential to run a process as the given
+// user and group, each of which may be a name or a numeric ID, and the
+// user's home directory, if it's known. If the group is empty the user's
+// primary group is used, and if the user is empty the process keeps our
+// own user. When we're root the process has no supplementary groups, so it
+// doesn't inherit any of ours. Otherwise we can't change its groups, so
+// it keeps ours.
+func LookupCredential(userName, groupName string) (*syscall.Credential, string, error) {
+	if userName == "" && groupName == "" {
+		return nil, "", nil
+	}
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	setNoSetGroups(cred, os.Getuid() != 0)
+	var primaryGid, home string
+	if userName != "" {
+		u, err := lookupUser(userName)
+		if err != nil {
+			return nil, "", err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid uid '%s' for user '%s'", u.Uid, userName)
+		}
+		cred.Uid = uint32(uid)
+		primaryGid, home = u.Gid, u.HomeDir
+	}
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return nil, "", err
+		}
+		primaryGid = g.Gid
+	}
+	if primaryGid == "" {
+		return nil, "", fmt.Errorf("user '%s' has no primary group", userName)
+	}
+	gid, err := strconv.ParseUint(primaryGid, 10, 32)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid gid '%s'", primaryGid)
+	}
+	cred.Gid = uint32(gid)
+	return cred, home, nil
+}
+
+// lookupUser finds a user by name or uid. A uid that isn't in the user
+// database is allowed but has no primary group.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+		return &user.User{Uid: name}, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user '%s'", name)
+	}
+	return u, nil
+}
+
+// lookupGroup finds a group by name or gid
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return &user.Group{Gid: name}, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown group '%s'", name)
+	}
+	return g, nil
+}
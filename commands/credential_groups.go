@@ -0,0 +1,11 @@
+// +build go1.9
+
+package commands
+
+import "syscall"
+
+// setNoSetGroups keeps the supplementary groups of the process unchanged,
+// which requires Go 1.9
+func setNoSetGroups(cred *syscall.Credential, noSetGroups bool) {
+	cred.NoSetGroups = noSetGroups
+}
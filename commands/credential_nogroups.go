@@ -0,0 +1,10 @@
+// +build !go1.9
+
+package commands
+
+import "syscall"
+
+// setNoSetGroups does nothing, as Go only supports keeping the
+// supplementary groups of the process since 1.9. When built with an older
+// Go, only root can run a process as another user or group.
+func setNoSetGroups(cred *syscall.Credential, noSetGroups bool) {}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+func TestLookupCredential(t *testing.T) {
+	expect := func(user, group string, uid, gid uint32) {
+		cred, _, err := LookupCredential(user, group)
+		if err != nil {
+			t.Fatalf("unexpected error for '%s:%s': %v", user, group, err)
+		}
+		if cred.Uid != uid || cred.Gid != gid {
+			t.Fatalf("expected %d:%d for '%s:%s' but got %d:%d",
+				uid, gid, user, group, cred.Uid, cred.Gid)
+		}
+	}
+	expect("root", "", 0, 0)
+	expect("0", "", 0, 0)
+	expect("12345", "100", 12345, 100)
+	expect("", "100", uint32(os.Getuid()), 100)
+
+	if cred, _, err := LookupCredential("", ""); cred != nil || err != nil {
+		t.Fatalf("expected no credential but got %v (%v)", cred, err)
+	}
+	if _, home, _ := LookupCredential("root", ""); home != "/root" {
+		t.Fatalf("expected home /root but got '%s'", home)
+	}
+	if _, home, _ := LookupCredential("12345", "100"); home != "" {
+		t.Fatalf("expected no home for unknown uid but got '%s'", home)
+	}
+	expectErr := func(user, group, msg string) {
+		_, _, err := LookupCredential(user, group)
+		if err == nil || err.Error() != msg {
+			t.Fatalf("expected error '%s' but got '%v'", msg, err)
+		}
+	}
+	expectErr("nosuchuser", "", "unknown user 'nosuchuser'")
+	expectErr("root", "nosuchgroup", "unknown group 'nosuchgroup'")
+	expectErr("12345", "", "user '12345' has no primary group")
+}
+
+func TestCommandRunAsUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("must be root to run a command as another user")
+	}
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		"test $(id -u) = 65534 && test $(id -g) = 65534 && test $(id -G) = 65534"},
+		time.Duration(0), nil)
+	cmd.Credential = &syscall.Credential{Uid: 65534, Gid: 65534}
+	cmd.Run(context.Background(), events.NewEventBus())
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 100 && cmd.Pid() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := cmd.ExitCode(); code != 0 {
+		t.Fatalf("expected command to run as 65534:65534 but it exited %d", code)
+	}
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// RunAndWait runs the Command and blocks until it exits or its timeout
+// expires. Unlike Run it doesn't publish events, so it's meant for hooks
+// and checks whose outcome only matters to the caller. Time spent waiting
+// for a turn from the Command's Limiters counts against the timeout.
+func (c *Command) RunAndWait(pctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.setUpCmd()
+	defer reapChildren(c.Cmd.SysProcAttr.Pgid)
+	stdout, stderr, flushOutput := c.outputs()
+	c.Cmd.Stdout = stdout
+	c.Cmd.Stderr = stderr
+	defer flushOutput()
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(pctx, c.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(pctx)
+	}
+	defer cancel()
+
+	if err := c.acquire(ctx); err != nil {
+		c.setExitCode(-1)
+		return fmt.Errorf("%s gave up waiting to run: %v", c.Name, err)
+	}
+	defer c.release()
+	if err := c.start(); err != nil {
+		c.setExitCode(-1)
+		return err
+	}
+	c.setPid(c.Cmd.Process.Pid)
+	c.applyLimits(c.Cmd.Process.Pid)
+	waited := make(chan error, 1)
+	go func() {
+		waited <- c.wait()
+	}()
+	select {
+	case err := <-waited:
+		return err
+	case <-ctx.Done():
+		c.Kill()
+		<-waited
+		if pctx.Err() != nil {
+			// the caller gave up, rather than our own timeout expiring
+			return pctx.Err()
+		}
+		return fmt.Errorf("%s timeout after %s", c.Name, c.Timeout)
+	}
+}
+
+// runHook runs the PreStop or PostStop hook, if any. A hook that fails is
+// logged but doesn't stop the Command from being stopped.
+func (c *Command) runHook(hook *Command) {
+	if hook == nil {
+		return
+	}
+	log.Debugf("%s: running %s", c.Name, hook.Name)
+	if err := hook.RunAndWait(context.Background()); err != nil {
+		log.Warnf("%s failed: %v", hook.Name, err)
+	}
+}
+
+// Wait blocks until the current run of the Command, including its
+// PostStop hook, has finished. It returns immediately if the Command
+// has never run.
+func (c *Command) Wait() {
+	if c == nil {
+		return
+	}
+	if exited := c.getExited(); exited != nil {
+		<-exited
+	}
+}
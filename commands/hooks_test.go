@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+func TestCommandRunAndWait(t *testing.T) {
+	cmd, _ := NewCommand("true", time.Second, nil)
+	if err := cmd.RunAndWait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cmd, _ = NewCommand("false", time.Second, nil)
+	if err := cmd.RunAndWait(context.Background()); err == nil {
+		t.Fatalf("expected error from failed command")
+	}
+	cmd, _ = NewCommand("sleep 2", 100*time.Millisecond, nil)
+	start := time.Now()
+	err := cmd.RunAndWait(context.Background())
+	if err == nil || err.Error() != "sleep timeout after 100ms" {
+		t.Fatalf("expected timeout but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected command to be killed at its timeout but took %v", elapsed)
+	}
+}
+
+func TestCommandStopHooks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hooks")
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+
+	cmd, _ := NewCommand([]string{"sh", "-c", "echo $$ > " + pidFile + "; exec sleep 5"},
+		time.Duration(0), nil)
+	cmd.StopSignal = syscall.SIGTERM
+	cmd.StopGracePeriod = time.Second
+	// preStop sees the process still running; postStop sees it gone
+	cmd.PreStop, _ = NewCommand([]string{"sh", "-c",
+		"kill -0 $(cat " + pidFile + ") && touch " + filepath.Join(dir, "pre")},
+		time.Second, nil)
+	cmd.PostStop, _ = NewCommand([]string{"sh", "-c",
+		"! kill -0 $(cat " + pidFile + ") && touch " + filepath.Join(dir, "post")},
+		time.Second, nil)
+
+	bus := events.NewEventBus()
+	cmd.Run(context.Background(), bus)
+	time.Sleep(100 * time.Millisecond)
+	cmd.Stop()
+	cmd.Wait()
+	for _, name := range []string{"pre", "post"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("expected %sStop hook to run: %v", name, err)
+		}
+	}
+}
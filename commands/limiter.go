@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var execQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "containerpilot",
+	Subsystem: "exec",
+	Name:      "queue_depth",
+	Help:      "Number of commands waiting for a turn to run, by concurrency limit.",
+}, []string{"limit"})
+
+func init() {
+	prometheus.MustRegister(execQueueDepth)
+}
+
+// Limiter limits how many Commands run at the same time. A Command over
+// the limit waits in a queue until another Command finishes. A nil
+// Limiter has no limit.
+type Limiter struct {
+	slots  chan struct{}
+	queued prometheus.Gauge
+}
+
+// limiters are the Limiters created so far, by name, so that the Commands
+// of jobs that are kept running across a reload share their Limiters with
+// the Commands of the reloaded jobs
+var limiters = struct {
+	sync.Mutex
+	byName map[string]*Limiter
+}{byName: make(map[string]*Limiter)}
+
+// NewLimiter returns a Limiter for the given number of Commands, or nil
+// if the limit is 0. The name labels the Limiter's queue depth metric.
+// The Limiter is shared with any earlier Limiter of the same name and
+// limit.
+func NewLimiter(name string, limit int) *Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	limiters.Lock()
+	defer limiters.Unlock()
+	if limiter, ok := limiters.byName[name]; ok && cap(limiter.slots) == limit {
+		return limiter
+	}
+	limiter := &Limiter{
+		slots:  make(chan struct{}, limit),
+		queued: execQueueDepth.WithLabelValues(name),
+	}
+	limiters.byName[name] = limiter
+	return limiter
+}
+
+// Acquire blocks until there's room under the limit or the context is
+// done. Each successful Acquire must be followed by a Release.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	l.queued.Inc()
+	defer l.queued.Dec()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release makes room for the next Command in the queue
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// acquire waits for a turn from each of the Command's limiters in order.
+// Every Command acquires its limiters in the same order (narrowest first),
+// so Commands holding a turn from one limiter can't deadlock waiting on
+// each other for another.
+func (c *Command) acquire(ctx context.Context) error {
+	for i, limiter := range c.Limiters {
+		if err := limiter.Acquire(ctx); err != nil {
+			for _, held := range c.Limiters[:i] {
+				held.Release()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Command) release() {
+	for _, limiter := range c.Limiters {
+		limiter.Release()
+	}
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter("test", 1)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected to time out in the queue but got %v", err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		limiter.Acquire(context.Background())
+		close(acquired)
+	}()
+	limiter.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("expected queued Acquire to succeed after Release")
+	}
+
+	if NewLimiter("test", 1) != limiter {
+		t.Fatalf("expected limiter of the same name and limit to be shared")
+	}
+	if NewLimiter("test", 2) == limiter {
+		t.Fatalf("expected new limiter for a new limit")
+	}
+	if NewLimiter("test", 0) != nil {
+		t.Fatalf("expected no limiter for a limit of 0")
+	}
+	var unlimited *Limiter
+	if err := unlimited.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error from nil limiter: %v", err)
+	}
+	unlimited.Release()
+}
+
+func TestCommandRunWithLimiter(t *testing.T) {
+	limiter := NewLimiter("test-run", 1)
+	limiter.Acquire(context.Background())
+
+	cmd, _ := NewCommand("true", 50*time.Millisecond, nil)
+	cmd.Limiters = []*Limiter{limiter}
+	got := runtestCommandRun(cmd)
+	if got[events.Event{events.ExitFailed, "true"}] != 1 || cmd.ExitCode() != -1 {
+		t.Fatalf("expected command to time out waiting to run but got events %v", got)
+	}
+
+	limiter.Release()
+	cmd, _ = NewCommand("true", 50*time.Millisecond, nil)
+	cmd.Limiters = []*Limiter{limiter}
+	got = runtestCommandRun(cmd)
+	if got[events.Event{events.ExitSuccess, "true"}] != 1 {
+		t.Fatalf("expected command to run but got events %v", got)
+	}
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("expected command to release its turn: %v", err)
+	}
+}
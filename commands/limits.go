@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// rlimitNames are the resource limits that can be set per Command
+var rlimitNames = map[string]int{
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+	"core":   unix.RLIMIT_CORE,
+}
+
+// Rlimit is a resource limit set on the Command's process
+type Rlimit struct {
+	Name     string
+	Resource int
+	Soft     uint64
+	Hard     uint64
+}
+
+// ParseRlimit parses a resource limit such as "nofile" from a number,
+// which sets both the soft and hard limits, a "soft:hard" string, or
+// "unlimited"
+func ParseRlimit(name string, raw interface{}) (Rlimit, error) {
+	if err := rlimitsSupported(); err != nil {
+		return Rlimit{}, err
+	}
+	resource, ok := rlimitNames[name]
+	if !ok {
+		return Rlimit{}, fmt.Errorf("unsupported limit '%s'", name)
+	}
+	value := strings.TrimSpace(fmt.Sprintf("%v", raw))
+	parts := strings.Split(value, ":")
+	if len(parts) > 2 {
+		return Rlimit{}, fmt.Errorf("invalid %s limit '%s'", name, value)
+	}
+	limits := make([]uint64, len(parts))
+	for i, part := range parts {
+		if part == "unlimited" {
+			limits[i] = unix.RLIM_INFINITY
+			continue
+		}
+		limit, err := strconv.ParseUint(part, 10, 64)
+		if err != nil || limit == math.MaxUint64 {
+			return Rlimit{}, fmt.Errorf("invalid %s limit '%s'", name, value)
+		}
+		limits[i] = limit
+	}
+	rlimit := Rlimit{Name: name, Resource: resource, Soft: limits[0], Hard: limits[0]}
+	if len(limits) == 2 {
+		rlimit.Hard = limits[1]
+	}
+	if rlimit.Soft > rlimit.Hard {
+		return Rlimit{}, fmt.Errorf("soft %s limit can't be more than the hard limit",
+			name)
+	}
+	return rlimit, nil
+}
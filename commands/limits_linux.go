@@ -0,0 +1,23 @@
+// +build linux
+
+package commands
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+func rlimitsSupported() error { return nil }
+
+// applyLimits sets the Command's resource limits on the process once it
+// has started. Our own limits are left alone, so that a low limit for a
+// job can't starve ContainerPilot. Failures are logged rather than
+// stopping the process.
+func (c *Command) applyLimits(pid int) {
+	for _, rlimit := range c.Rlimits {
+		limit := &unix.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}
+		if err := unix.Prlimit(pid, rlimit.Resource, limit, nil); err != nil {
+			log.Warnf("%s: unable to set %s limit: %v", c.Name, rlimit.Name, err)
+		}
+	}
+}
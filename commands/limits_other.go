@@ -0,0 +1,12 @@
+// +build !linux
+
+package commands
+
+import "errors"
+
+func rlimitsSupported() error {
+	return errors.New("resource limits are not supported on this platform")
+}
+
+// applyLimits does nothing, as ParseRlimit never returns a limit to apply
+func (c *Command) applyLimits(pid int) {}
@@ -0,0 +1,52 @@
+// +build linux
+
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseRlimit(t *testing.T) {
+	expect := func(name string, raw interface{}, soft, hard uint64) {
+		rlimit, err := ParseRlimit(name, raw)
+		if err != nil {
+			t.Fatalf("unexpected error for %s '%v': %v", name, raw, err)
+		}
+		if rlimit.Soft != soft || rlimit.Hard != hard {
+			t.Fatalf("expected %d:%d for %s '%v' but got %d:%d",
+				soft, hard, name, raw, rlimit.Soft, rlimit.Hard)
+		}
+	}
+	expect("nofile", 1024, 1024, 1024)
+	expect("nofile", "1024:4096", 1024, 4096)
+	expect("core", "0", 0, 0)
+	expect("nproc", "unlimited", unix.RLIM_INFINITY, unix.RLIM_INFINITY)
+	expect("nproc", "64:unlimited", 64, unix.RLIM_INFINITY)
+
+	expectErr := func(name string, raw interface{}, msg string) {
+		_, err := ParseRlimit(name, raw)
+		if err == nil || err.Error() != msg {
+			t.Fatalf("expected error '%s' but got '%v'", msg, err)
+		}
+	}
+	expectErr("stack", 1024, "unsupported limit 'stack'")
+	expectErr("nofile", "lots", "invalid nofile limit 'lots'")
+	expectErr("nofile", "1:2:3", "invalid nofile limit '1:2:3'")
+	expectErr("nofile", "-1", "invalid nofile limit '-1'")
+	expectErr("nofile", "4096:1024", "soft nofile limit can't be more than the hard limit")
+}
+
+func TestCommandRunWithRlimits(t *testing.T) {
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		`sleep 0.1; test "$(ulimit -n)" = 64`}, time.Duration(0), nil)
+	rlimit, _ := ParseRlimit("nofile", 64)
+	cmd.Rlimits = []Rlimit{rlimit}
+	got := runtestCommandRun(cmd)
+	if got[events.Event{events.ExitSuccess, "sh"}] != 1 {
+		t.Fatalf("expected command to run with nofile limit 64 but got events %v", got)
+	}
+}
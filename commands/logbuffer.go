@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultLogBufferSize is the number of bytes of output a LogBuffer keeps
+var DefaultLogBufferSize = 64 * 1024
+
+// LogBuffer is an io.Writer that keeps the most recent output of a
+// Command so that it can be fetched via the control plane.
+type LogBuffer struct {
+	buf       []byte
+	size      int
+	truncated bool
+	lock      sync.Mutex
+}
+
+// NewLogBuffer creates a LogBuffer that keeps the last size bytes written
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{size: size}
+}
+
+// Write satisfies io.Writer, dropping the oldest output once the buffer
+// is full. It never returns an error so that it can't interrupt logging.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.size {
+		// copy so that we don't keep growing the underlying array
+		b.buf = append([]byte(nil), b.buf[len(b.buf)-b.size:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// Reset discards all of the output kept so far
+func (b *LogBuffer) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf = nil
+	b.truncated = false
+}
+
+// Lines returns up to the last n complete lines of output, or all lines
+// if n is less than 1. If older output has been dropped, the partial line
+// at the start of the buffer is omitted.
+func (b *LogBuffer) Lines(n int) []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	lines := strings.Split(strings.TrimSuffix(string(b.buf), "\n"), "\n")
+	if b.truncated {
+		lines = lines[1:]
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		return []string{}
+	}
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestLogBuffer(t *testing.T) {
+	buf := NewLogBuffer(14)
+	assert.Equal(t, buf.Lines(10), []string{}, "expected %v but got %v")
+
+	fmt.Fprint(buf, "one\ntwo\n")
+	assert.Equal(t, buf.Lines(0), []string{"one", "two"}, "expected %v but got %v")
+	assert.Equal(t, buf.Lines(1), []string{"two"}, "expected %v but got %v")
+
+	// overflows the buffer so that "one" and part of "two" are dropped
+	fmt.Fprint(buf, "three\nfour\n")
+	assert.Equal(t, buf.Lines(10), []string{"three", "four"},
+		"expected %v but got %v")
+	assert.Equal(t, len(buf.buf), 14, "expected buffer length %v but got %v")
+
+	buf.Reset()
+	fmt.Fprint(buf, "five\n")
+	assert.Equal(t, buf.Lines(10), []string{"five"}, "expected %v but got %v")
+}
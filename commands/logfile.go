@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// LogFile is an io.WriteCloser that writes a Command's output to a file.
+// The file is rotated once it reaches its maximum size and, if it has an
+// interval, each time the interval passes.
+type LogFile struct {
+	*lumberjack.Logger
+	interval time.Duration
+
+	lock    sync.Mutex
+	started bool
+	stop    chan struct{}
+}
+
+// NewLogFile returns a LogFile for the path. The maxSize is in megabytes,
+// and rotated files beyond maxBackups are removed unless it's 0.
+func NewLogFile(path string, maxSize int, interval time.Duration, maxBackups int, compress bool) *LogFile {
+	return &LogFile{
+		Logger: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSize,
+			MaxBackups: maxBackups,
+			Compress:   compress,
+		},
+		interval: interval,
+	}
+}
+
+// Write satisfies io.Writer. The rotation timer starts with the first
+// write, so that a LogFile from a config that's never run has nothing
+// to clean up.
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	if !f.started && f.interval > 0 {
+		f.started = true
+		f.stop = make(chan struct{})
+		go f.rotateEvery(f.interval, f.stop)
+	}
+	f.lock.Unlock()
+	return f.Logger.Write(p)
+}
+
+func (f *LogFile) rotateEvery(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Rotate(); err != nil {
+				log.Warnf("unable to rotate %s: %v", f.Filename, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close stops the rotation timer and closes the file
+func (f *LogFile) Close() error {
+	f.lock.Lock()
+	if f.started {
+		close(f.stop)
+		f.started = false
+	}
+	f.lock.Unlock()
+	return f.Logger.Close()
+}
+
+// LogTo sends the Command's output to w instead of our own log, or to
+// both if tee is true
+func (c *Command) LogTo(w io.WriteCloser, tee bool) {
+	c.logFile = w
+	c.logTee = tee
+}
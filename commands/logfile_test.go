@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandLogTo(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "logfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+
+	cmd, _ := NewCommand([]string{"sh", "-c", "echo hello; echo oops >&2"},
+		time.Duration(0), nil)
+	cmd.LogTo(NewLogFile(path, 1, 0, 0, false), false)
+	runtestCommandRun(cmd)
+	cmd.CloseLogs()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "hello\noops\n" {
+		t.Fatalf("expected stdout and stderr in log file but got %q", data)
+	}
+}
+
+func TestLogFileRotateInterval(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "logfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+
+	logFile := NewLogFile(path, 1, 50*time.Millisecond, 0, true)
+	logFile.Write([]byte("first\n"))
+	time.Sleep(120 * time.Millisecond)
+	logFile.Write([]byte("second\n"))
+	logFile.Close()
+	time.Sleep(50 * time.Millisecond) // compression is asynchronous
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "second\n" {
+		t.Fatalf("expected only output since the last rotation but got %q", data)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	var compressed int
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".log.gz") {
+			compressed++
+		}
+	}
+	if compressed == 0 {
+		t.Fatalf("expected compressed rotated files but got %v", files)
+	}
+}
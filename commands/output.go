@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Output configures how each line of a Command's output is written to
+// our own log
+type Output struct {
+	Raw    bool     // write lines as they are, rather than as log entries
+	Prefix string   // prepended to each line
+	Fields []string // log entry fields: "job", "pid", and "stream"
+}
+
+// OutputFields are the fields that can be added to each log entry
+var OutputFields = []string{"job", "pid", "stream"}
+
+// outputs returns the writers for the stdout and stderr of a run of the
+// Command, which send its output to its LogBuffer, its log file, and our
+// own log, and a func that writes any partial last line once it's done.
+// If the Command's Stdout is set, it gets all of stdout instead.
+func (c *Command) outputs() (io.Writer, io.Writer, func()) {
+	var stdout, stderr []io.Writer
+	flush := func() {}
+	if c.Logs != nil {
+		stdout = append(stdout, c.Logs)
+		stderr = append(stderr, c.Logs)
+	}
+	if c.logFile != nil {
+		stdout = append(stdout, c.logFile)
+		stderr = append(stderr, c.logFile)
+	}
+	if c.logFile == nil || c.logTee {
+		if c.Output != nil {
+			out := &lineWriter{cmd: c, stream: "stdout"}
+			err := &lineWriter{cmd: c, stream: "stderr"}
+			stdout = append(stdout, out)
+			stderr = append(stderr, err)
+			flush = func() {
+				out.flush()
+				err.flush()
+			}
+		} else {
+			stdout = append(stdout, c.logger)
+			stderr = append(stderr, c.logger)
+		}
+	}
+	if c.Stdout != nil {
+		return c.Stdout, multiWriter(stderr), flush
+	}
+	return multiWriter(stdout), multiWriter(stderr), flush
+}
+
+func multiWriter(writers []io.Writer) io.Writer {
+	if len(writers) == 1 {
+		return writers[0]
+	}
+	return io.MultiWriter(writers...)
+}
+
+// lineWriter writes each line of one stream of a Command's output to our
+// log, as configured by the Command's Output
+type lineWriter struct {
+	cmd    *Command
+	stream string
+	pid    int
+
+	lock sync.Mutex
+	buf  []byte
+}
+
+// Write satisfies io.Writer. Partial lines are held until they're
+// completed or the run ends.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) emit(line string) {
+	output := w.cmd.Output
+	line = output.Prefix + line
+	if output.Raw {
+		fmt.Fprintln(log.StandardLogger().Out, line)
+		return
+	}
+	if pid := w.cmd.Pid(); pid != 0 {
+		w.pid = pid // keep the pid for output flushed after exit
+	}
+	fields := log.Fields{}
+	for _, field := range output.Fields {
+		switch field {
+		case "job":
+			fields["job"] = w.cmd.Name
+		case "pid":
+			fields["pid"] = w.pid
+		case "stream":
+			fields["stream"] = w.stream
+		}
+	}
+	log.WithFields(fields).Info(line)
+}
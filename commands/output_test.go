@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// captureLog sends our log to a buffer in the given format for the
+// duration of a test
+func captureLog(formatter log.Formatter) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	log.SetFormatter(formatter)
+	return buf
+}
+
+func restoreLog() {
+	log.SetOutput(os.Stdout)
+	log.SetFormatter(&log.TextFormatter{})
+}
+
+func TestCommandOutputFields(t *testing.T) {
+	buf := captureLog(&log.JSONFormatter{})
+	defer restoreLog()
+
+	cmd, _ := NewCommand([]string{"sh", "-c", "echo hello; printf oops >&2"},
+		time.Duration(0), nil)
+	cmd.Name = "myjob"
+	cmd.Output = &Output{Prefix: "> ", Fields: []string{"job", "pid", "stream"}}
+	runtestCommandRun(cmd)
+
+	entries := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		entry := map[string]interface{}{}
+		json.Unmarshal([]byte(line), &entry)
+		if stream, ok := entry["stream"].(string); ok {
+			entries[stream] = entry
+		}
+	}
+	stdout, stderr := entries["stdout"], entries["stderr"]
+	if stdout == nil || stdout["msg"] != "> hello" || stdout["job"] != "myjob" {
+		t.Fatalf("expected stdout entry for myjob but got %v", entries)
+	}
+	if pid, _ := stdout["pid"].(float64); pid == 0 {
+		t.Fatalf("expected stdout entry to have the pid but got %v", stdout)
+	}
+	// the partial last line is written once the process exits
+	if stderr == nil || stderr["msg"] != "> oops" {
+		t.Fatalf("expected stderr entry but got %v", entries)
+	}
+}
+
+func TestCommandOutputRaw(t *testing.T) {
+	buf := captureLog(&log.JSONFormatter{})
+	defer restoreLog()
+
+	cmd, _ := NewCommand([]string{"sh", "-c", "echo hello"}, time.Duration(0), nil)
+	cmd.Output = &Output{Raw: true, Prefix: "[myjob] "}
+	runtestCommandRun(cmd)
+	if !strings.Contains(buf.String(), "[myjob] hello\n") ||
+		strings.Contains(buf.String(), `"msg":"[myjob] hello"`) {
+		t.Fatalf("expected raw prefixed output but got %q", buf.String())
+	}
+}
@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"SIGABRT":  syscall.SIGABRT,
+	"SIGALRM":  syscall.SIGALRM,
+	"SIGCONT":  syscall.SIGCONT,
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGKILL":  syscall.SIGKILL,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGSTOP":  syscall.SIGSTOP,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGTSTP":  syscall.SIGTSTP,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGWINCH": syscall.SIGWINCH,
+}
+
+// ParseSignal returns the signal for a name such as "SIGHUP" or "HUP"
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signalNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal '%s'", name)
+	}
+	return sig, nil
+}
+
+// Signal sends a signal to the underlying process, if it's running. Unlike
+// Kill, the signal is sent only to the process and not its process group.
+func (c *Command) Signal(sig syscall.Signal) error {
+	pid := c.Pid()
+	if pid == 0 {
+		return fmt.Errorf("%s is not running", c.Name)
+	}
+	log.Debugf("sending %v to command '%v' at pid: %d", sig, c.Name, pid)
+	return syscall.Kill(pid, sig)
+}
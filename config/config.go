@@ -6,11 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"reflect"
 	"strings"
 
 	"github.com/flynn/json5"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/control"
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/jobs"
@@ -21,12 +23,23 @@ import (
 
 type rawConfig struct {
 	consul      interface{}
+	kubernetes  interface{}
+	zookeeper   interface{}
+	dns         interface{}
+	cloudmap    interface{}
+	nomad       interface{}
+	mdns        interface{}
+	plugin      interface{}
 	logConfig   *LogConfig
 	stopTimeout int
+	concurrency int
+	strict      bool
 	jobs        []interface{}
+	groups      []interface{}
 	watches     []interface{}
 	telemetry   interface{}
 	control     interface{}
+	vault       interface{}
 }
 
 // Config contains the parsed config elements
@@ -35,9 +48,14 @@ type Config struct {
 	LogConfig   *LogConfig
 	StopTimeout int
 	Jobs        []*jobs.Config
+	Groups      []*jobs.GroupConfig
 	Watches     []*watches.Config
 	Telemetry   *telemetry.Config
 	Control     *control.Config
+	Vault       *VaultConfig
+
+	raw     map[string]interface{} // the unmarshalled configuration file
+	secrets *vaultSecrets          // read by the template, or nil if none
 }
 
 const (
@@ -61,13 +79,22 @@ func (cfg *rawConfig) parseStopTimeout() (int, error) {
 	return cfg.stopTimeout, nil
 }
 
-// RenderConfig renders the templated config in configFlag to renderFlag.
+// RenderConfig renders the templated config in configFlag to renderFlag. A
+// config directory is rendered as its merged configuration in JSON.
 func RenderConfig(configFlag, renderFlag string) error {
-	configData, err := loadConfigFile(configFlag)
+	paths, err := configFiles(configFlag)
 	if err != nil {
 		return err
 	}
-	renderedConfig, err := renderConfigTemplate(configData)
+	var renderedConfig []byte
+	if paths != nil {
+		renderedConfig, err = renderConfigDir(configFlag)
+	} else {
+		var configData []byte
+		if configData, err = loadConfigFile(configFlag); err == nil {
+			renderedConfig, err = renderConfigTemplate(configData, newVaultSecrets())
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -87,25 +114,58 @@ func RenderConfig(configFlag, renderFlag string) error {
 
 // LoadConfig loads, parses, and validates the configuration
 func LoadConfig(configFlag string) (*Config, error) {
-	configData, err := loadConfigFile(configFlag)
+	return LoadConfigFrom(configFlag, nil)
+}
+
+// LoadConfigFrom loads, parses, and validates the configuration to replace
+// the running Config when everything is restarted. It reuses the leased
+// Vault secrets of the running Config rather than reading them again, and
+// if the configuration is invalid revokes the leases of those it read.
+func LoadConfigFrom(configFlag string, running *Config) (*Config, error) {
+	secrets := newVaultSecretsFrom(running)
+	configMap, err := loadConfigMap(configFlag, secrets)
 	if err != nil {
+		secrets.release(running.vaultSecrets())
 		return nil, err
 	}
-	renderedConfig, err := renderConfigTemplate(configData)
+	cfg, err := buildConfig(configMap, nil, secrets)
 	if err != nil {
+		secrets.release(running.vaultSecrets())
 		return nil, err
 	}
-	config, err := newConfig(renderedConfig)
+	return cfg, nil
+}
+
+// LoadControlConfig loads only the control plane configuration, for the
+// subcommands that talk to a running ContainerPilot. The `vault` template
+// function renders as an empty string rather than reading Vault, so that
+// a subcommand run by a frequent health check doesn't log in to Vault or
+// create new leases for dynamic secrets.
+func LoadControlConfig(configFlag string) (*control.Config, error) {
+	configMap, err := loadConfigMap(configFlag, newOfflineVaultSecrets())
 	if err != nil {
 		return nil, err
 	}
-	return config, nil
+	controlConfig, err := control.NewConfig(configMap["control"])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse control: %v", err)
+	}
+	if controlConfig.Token == "" {
+		// the token may have been one of the secrets we didn't read
+		controlConfig.Token = os.Getenv(control.TokenEnv)
+	}
+	return controlConfig, nil
 }
 
+// loadConfigFile reads the configuration file, or fetches the configuration
+// if the -config flag is a Consul KV key or a URL
 func loadConfigFile(configFlag string) ([]byte, error) {
 	if configFlag == "" {
 		return nil, errors.New("-config flag is required")
 	}
+	if isRemoteConfig(configFlag) {
+		return fetchRemoteConfig(configFlag)
+	}
 	data, err := ioutil.ReadFile(configFlag)
 	if err != nil {
 		return nil, fmt.Errorf("could not read config file: %s", err)
@@ -113,8 +173,8 @@ func loadConfigFile(configFlag string) ([]byte, error) {
 	return data, nil
 }
 
-func renderConfigTemplate(configData []byte) ([]byte, error) {
-	template, err := ApplyTemplate(configData)
+func renderConfigTemplate(configData []byte, secrets *vaultSecrets) ([]byte, error) {
+	template, err := applyTemplate(configData, secrets)
 	if err != nil {
 		err = fmt.Errorf("could not apply template to config: %v", err)
 	}
@@ -124,20 +184,32 @@ func renderConfigTemplate(configData []byte) ([]byte, error) {
 // newConfig unmarshals the textual configuration data into the
 // validated Config struct that we'll use the run the application
 func newConfig(configData []byte) (*Config, error) {
-	configMap, err := unmarshalConfig(configData)
+	configMap, err := unmarshalConfig(configData, formatJSON5)
 	if err != nil {
 		return nil, err
 	}
+	return buildConfig(configMap, nil, nil)
+}
 
+// buildConfig validates the unmarshalled configuration. A new discovery
+// backend is created unless one is passed in. The secrets are those read
+// from Vault while the configuration was rendered.
+func buildConfig(configMap map[string]interface{}, disc discovery.Backend,
+	secrets *vaultSecrets) (*Config, error) {
+	cfg := &Config{raw: make(map[string]interface{}, len(configMap))}
+	for key, val := range configMap {
+		cfg.raw[key] = val // decodeConfig deletes the keys it uses
+	}
 	raw := &rawConfig{}
-	if err = decodeConfig(configMap, raw); err != nil {
+	if err := decodeConfig(configMap, raw); err != nil {
 		return nil, err
 	}
-	cfg := &Config{}
 
-	disc, err := discovery.NewConsul(raw.consul)
-	if err != nil {
-		return nil, err
+	if disc == nil {
+		var err error
+		if disc, err = newDiscovery(raw); err != nil {
+			return nil, err
+		}
 	}
 	cfg.Discovery = disc
 
@@ -149,6 +221,16 @@ func newConfig(configData []byte) (*Config, error) {
 	}
 	cfg.StopTimeout = stopTimeout
 
+	vaultConfig, err := newVaultConfig(raw.vault)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Vault = vaultConfig
+	if !secrets.empty() {
+		secrets.cfg = vaultConfig
+		cfg.secrets = secrets
+	}
+
 	controlConfig, err := control.NewConfig(raw.control)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse control: %v", err)
@@ -161,6 +243,12 @@ func newConfig(configData []byte) (*Config, error) {
 	}
 	cfg.Jobs = jobConfigs
 
+	groups, err := jobs.NewGroups(raw.groups, jobConfigs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse groups: %v", err)
+	}
+	cfg.Groups = groups
+
 	watches, err := watches.NewConfigs(raw.watches, disc)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse watches: %v", err)
@@ -176,10 +264,28 @@ func newConfig(configData []byte) (*Config, error) {
 		cfg.Jobs = append(cfg.Jobs, telemetry.JobConfig)
 	}
 
+	if raw.concurrency < 0 {
+		return nil, fmt.Errorf("execConcurrency must be positive")
+	}
+	limiter := commands.NewLimiter("global", raw.concurrency)
+	jobs.LimitExecs(cfg.Jobs, limiter)
+	if telemetry != nil {
+		telemetry.LimitSensors(limiter)
+	}
+
+	if Strict || raw.strict {
+		if err := validateStrict(cfg); err != nil {
+			return nil, fmt.Errorf("strict mode: %v", err)
+		}
+	}
+
 	return cfg, nil
 }
 
-func unmarshalConfig(data []byte) (map[string]interface{}, error) {
+func unmarshalConfig(data []byte, format string) (map[string]interface{}, error) {
+	if format == formatYAML {
+		return unmarshalYAML(data)
+	}
 	var config map[string]interface{}
 	if err := json5.Unmarshal(data, &config); err != nil {
 		syntax, ok := err.(*json5.SyntaxError)
@@ -248,33 +354,111 @@ func decodeArray(raw interface{}) []interface{} {
 	return nil
 }
 
+// newDiscovery creates the service discovery backend. Consul is the
+// default. If more than one backend is configured, registrations are sent
+// to all of them and watches see the instances in all of them.
+func newDiscovery(raw *rawConfig) (discovery.Backend, error) {
+	backends := []discovery.Backend{}
+	add := func(backend discovery.Backend, err error) error {
+		if err != nil {
+			return err
+		}
+		backends = append(backends, backend)
+		return nil
+	}
+	var err error
+	if raw.consul != nil {
+		err = add(discovery.NewConsul(raw.consul))
+	}
+	if err == nil && raw.kubernetes != nil {
+		err = add(discovery.NewKubernetes(raw.kubernetes))
+	}
+	if err == nil && raw.zookeeper != nil {
+		err = add(discovery.NewZooKeeper(raw.zookeeper))
+	}
+	if err == nil && raw.dns != nil {
+		err = add(discovery.NewDNS(raw.dns))
+	}
+	if err == nil && raw.cloudmap != nil {
+		err = add(discovery.NewCloudMap(raw.cloudmap))
+	}
+	if err == nil && raw.nomad != nil {
+		err = add(discovery.NewNomad(raw.nomad))
+	}
+	if err == nil && raw.mdns != nil {
+		err = add(discovery.NewMDNS(raw.mdns))
+	}
+	if err == nil && raw.plugin != nil {
+		err = add(discovery.NewPlugin(raw.plugin))
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch len(backends) {
+	case 0:
+		return discovery.NewConsul(raw.consul)
+	case 1:
+		return backends[0], nil
+	}
+	return discovery.NewMulti(backends...), nil
+}
+
 // We can't use mapstructure to decode our config map since we want the values
 // to also be raw interface{} types. mapstructure can only decode
 // into concrete structs and primitives
 func decodeConfig(configMap map[string]interface{}, result *rawConfig) error {
 	var logConfig LogConfig
-	var stopTimeout int
+	var stopTimeout, concurrency int
+	var strict bool
 	if err := utils.DecodeRaw(configMap["logging"], &logConfig); err != nil {
 		return err
 	}
 	if err := utils.DecodeRaw(configMap["stopTimeout"], &stopTimeout); err != nil {
 		return err
 	}
+	if err := utils.DecodeRaw(configMap["execConcurrency"], &concurrency); err != nil {
+		return err
+	}
+	if err := utils.DecodeRaw(configMap["strict"], &strict); err != nil {
+		return err
+	}
 	result.consul = configMap["consul"]
+	result.kubernetes = configMap["kubernetes"]
+	result.zookeeper = configMap["zookeeper"]
+	result.dns = configMap["dns"]
+	result.cloudmap = configMap["cloudmap"]
+	result.nomad = configMap["nomad"]
+	result.mdns = configMap["mdns"]
+	result.plugin = configMap["plugin"]
 	result.stopTimeout = stopTimeout
+	result.concurrency = concurrency
+	result.strict = strict
 	result.logConfig = &logConfig
 	result.control = configMap["control"]
 	result.jobs = decodeArray(configMap["jobs"])
+	result.groups = decodeArray(configMap["groups"])
 	result.watches = decodeArray(configMap["watches"])
 	result.telemetry = configMap["telemetry"]
+	result.vault = configMap["vault"]
 
 	delete(configMap, "consul")
+	delete(configMap, "kubernetes")
+	delete(configMap, "zookeeper")
+	delete(configMap, "dns")
+	delete(configMap, "cloudmap")
+	delete(configMap, "nomad")
+	delete(configMap, "mdns")
+	delete(configMap, "plugin")
 	delete(configMap, "logging")
 	delete(configMap, "control")
 	delete(configMap, "stopTimeout")
+	delete(configMap, "execConcurrency")
+	delete(configMap, "strict")
 	delete(configMap, "jobs")
+	delete(configMap, "groups")
 	delete(configMap, "watches")
 	delete(configMap, "telemetry")
+	delete(configMap, "vault")
 	var unused []string
 	for key := range configMap {
 		unused = append(unused, key)
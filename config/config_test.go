@@ -1,9 +1,16 @@
 package config
 
 import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/tests/assert"
 )
 
@@ -86,6 +93,30 @@ func TestValidConfigJobs(t *testing.T) {
 }
 
 // telemetry.Config
+func TestValidConfigYAML(t *testing.T) {
+	json5Cfg, err := LoadConfig("./testdata/test.json5")
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	yamlCfg, err := LoadConfig("./testdata/test.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	// the YAML file is the same configuration, with the same types
+	assert.Equal(t, yamlCfg.raw, json5Cfg.raw, "expected %v but got %v")
+	assert.Equal(t, len(yamlCfg.Jobs), 10, "expected %v jobs but got %v")
+
+	Format = "yaml"
+	defer func() { Format = "" }()
+	_, err = LoadConfig("./testdata/test.json5")
+	if err == nil {
+		t.Fatal("expected error parsing JSON5 comments as YAML")
+	}
+	Format = "toml"
+	_, err = LoadConfig("./testdata/test.yaml")
+	assert.Error(t, err, "unknown config format 'toml': accepts 'json5' or 'yaml'")
+}
+
 func TestValidConfigTelemetry(t *testing.T) {
 	os.Setenv("TEST", "HELLO")
 	cfg, err := LoadConfig("./testdata/test.json5")
@@ -146,3 +177,310 @@ func TestCustomConfigControl(t *testing.T) {
 		"/var/run/cp3-test.sock",
 		"expected '%v' for control.socket, but got '%v'")
 }
+
+func TestConfigDiscoveryBackend(t *testing.T) {
+	cfg, err := newConfig([]byte(`{
+	"kubernetes": {"api": "http://localhost:8001", "namespace": "default", "pod": "app-1"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	if _, ok := cfg.Discovery.(*discovery.Kubernetes); !ok {
+		t.Fatalf("expected Kubernetes discovery backend but got %T", cfg.Discovery)
+	}
+
+	cfg, err = newConfig([]byte(`{
+	"consul": "consul:8500",
+	"kubernetes": {"api": "http://localhost:8001", "namespace": "default", "pod": "app-1"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	multi, ok := cfg.Discovery.(discovery.Multi)
+	if !ok || len(multi) != 2 {
+		t.Fatalf("expected Multi discovery backend with 2 backends but got %v", cfg.Discovery)
+	}
+	if _, ok := multi[0].(*discovery.Consul); !ok {
+		t.Fatalf("expected Consul discovery backend first but got %T", multi[0])
+	}
+
+	_, err = newConfig([]byte(`{
+	"consul": "consul:8500",
+	"cloudmap": {"region": "us-east-1"}}`))
+	assert.Error(t, err, "cloudmap.namespace must not be empty")
+
+	cfg, err = newConfig([]byte(`{"zookeeper": "127.0.0.1:2181"}`))
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	if _, ok := cfg.Discovery.(*discovery.ZooKeeper); !ok {
+		t.Fatalf("expected ZooKeeper discovery backend but got %T", cfg.Discovery)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "reload")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(f.Name())
+	write := func(config string) {
+		if err := ioutil.WriteFile(f.Name(), []byte(config), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	write(`{consul: "consul:8500", jobs: [{name: "app", exec: "app"}]}`)
+	running, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	write(`{consul: "consul:8500", jobs: [{name: "app", exec: "app --new"}],
+	watches: [{name: "db", interval: 5}]}`)
+	cfg, onlyJobs, err := ReloadConfig(f.Name(), running)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.True(t, onlyJobs, "expected only jobs changed to be %v but got %v")
+	if cfg.Discovery != running.Discovery {
+		t.Fatalf("expected running discovery backend to be kept")
+	}
+
+	write(`{consul: "consul:8501", jobs: [{name: "app", exec: "app"}]}`)
+	cfg, onlyJobs, err = ReloadConfig(f.Name(), running)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.False(t, onlyJobs, "expected only jobs changed to be %v but got %v")
+	if cfg.Discovery == running.Discovery {
+		t.Fatalf("expected new discovery backend")
+	}
+
+	write(`{consul: "consul:8500", jobs: [{exec: "app"}]}`)
+	_, _, err = ReloadConfig(f.Name(), running)
+	if err == nil {
+		t.Fatalf("expected error for invalid config")
+	}
+}
+
+func TestConfigDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf.d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, config string) {
+		path := dir + "/" + name
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	write("00-base.json5", `{
+	consul: "consul:8500",
+	stopTimeout: 5,
+	jobs: [{name: "base", exec: "base"}],
+	telemetry: {port: 9090, metrics: [
+		{namespace: "base", subsystem: "a", name: "x", help: "x", type: "counter"}]}
+}`)
+	write("10-app.yaml", `
+stopTimeout: 10
+jobs:
+  - name: app
+    exec: app
+watches:
+  - name: db
+    interval: 5
+telemetry:
+  metrics:
+    - {namespace: app, subsystem: a, name: y, help: y, type: gauge}
+`)
+	write("README.md", "not configuration")
+	write(".10-app.yaml.swp", "not configuration")
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, cfg.StopTimeout, 10, "expected stopTimeout %v but got %v")
+	assert.Equal(t, len(cfg.Jobs), 3, "expected %v jobs but got %v") // + telemetry
+	assert.Equal(t, cfg.Jobs[0].Name, "base", "expected job0 %v but got %v")
+	assert.Equal(t, cfg.Jobs[1].Name, "app", "expected job1 %v but got %v")
+	assert.Equal(t, len(cfg.Watches), 1, "expected %v watches but got %v")
+	assert.Equal(t, cfg.Telemetry.Port, 9090, "expected telemetry port %v but got %v")
+	assert.Equal(t, len(cfg.Telemetry.Metrics), 2, "expected %v metrics but got %v")
+
+	write("20-bad.json5", `{jobs: [}`)
+	_, err = LoadConfig(dir)
+	if err == nil || !strings.HasPrefix(err.Error(), dir+"/20-bad.json5: ") {
+		t.Fatalf("expected parse error naming the file but got %v", err)
+	}
+
+	empty, err := ioutil.TempDir("", "conf.d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(empty)
+	_, err = LoadConfig(empty)
+	assert.Error(t, err, "no configuration files in config directory "+empty)
+}
+
+func TestRemoteConfig(t *testing.T) {
+	defer func(delay time.Duration) { remoteRetryDelay = delay }(remoteRetryDelay)
+	remoteRetryDelay = time.Millisecond
+
+	failures := 2
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/containerpilot.yaml":
+				if failures > 0 {
+					failures--
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte("consul: consul:8500\njobs:\n  - {name: app, exec: app}\n"))
+			case "/v1/kv/containerpilot/config":
+				w.Write([]byte(`[{"Key": "containerpilot/config",
+				"Value": "e2NvbnN1bDogImNvbnN1bDo4NTAwIiwgam9iczogW3tuYW1lOiAiZGIiLCBleGVjOiAiZGIifV19"}]`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	defer server.Close()
+
+	_, err := LoadConfig(server.URL + "/containerpilot.yaml")
+	assert.Error(t, err, "refusing to fetch config from "+server.URL+
+		"/containerpilot.yaml over plain HTTP: use an https:// URL or the "+
+		"-config-allow-http flag")
+	assert.Equal(t, failures, 2, "expected %v failures left but got %v")
+
+	AllowHTTP = true
+	defer func() { AllowHTTP = false }()
+	cfg, err := LoadConfig(server.URL + "/containerpilot.yaml?version=2")
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, cfg.Jobs[0].Name, "app", "expected job %v but got %v")
+
+	_, err = LoadConfig(server.URL + "/missing.json5")
+	assert.Error(t, err, "could not fetch config from "+server.URL+
+		"/missing.json5: unexpected status 404")
+
+	os.Setenv("CONSUL_HTTP_ADDR", server.Listener.Addr().String())
+	defer os.Unsetenv("CONSUL_HTTP_ADDR")
+	cfg, err = LoadConfig("consul://containerpilot/config")
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, cfg.Jobs[0].Name, "db", "expected job %v but got %v")
+
+	_, err = LoadConfig("consul://containerpilot/missing")
+	assert.Error(t, err, "could not fetch config from consul://containerpilot/missing: "+
+		"key 'containerpilot/missing' not found")
+}
+
+func TestStrictConfig(t *testing.T) {
+	Strict = true
+	_, err := LoadConfig("./testdata/test.json5")
+	Strict = false
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+
+	testStrict := func(config, expected string) {
+		_, err := newConfig([]byte(config))
+		if expected == "" {
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", config, err)
+			}
+			return
+		}
+		assert.Error(t, err, expected)
+	}
+	// only checked in strict mode
+	testStrict(`{consul: "consul:8500", jobs: [{name: "app", exec: "app", tags: ["x"]}]}`, "")
+
+	testStrict(`{strict: true, consul: "consul:8500", jobs: [
+		{name: "app", exec: "app"}, {name: "app", exec: "web"}]}`,
+		"strict mode: duplicate job name 'app'")
+	testStrict(`{strict: true, consul: "consul:8500",
+		watches: [{name: "db", interval: 5}, {name: "db", interval: 10}]}`,
+		"strict mode: duplicate watch name 'db'")
+	testStrict(`{strict: true, consul: "consul:8500",
+		jobs: [{name: "app", exec: "app", tags: ["x"]}]}`,
+		"strict mode: job[app].tags requires the job to have a port")
+	testStrict(`{strict: true, consul: "consul:8500",
+		jobs: [{name: "app", exec: "app", interfaces: ["eth0"]}]}`,
+		"strict mode: job[app].interfaces requires the job to have a port")
+	testStrict(`{strict: true, consul: "consul:8500", jobs: [
+		{name: "app", exec: "app"},
+		{name: "web", exec: "web", when: {source: "A", once: "exitSuccess"}}]}`,
+		"strict mode: job[web].when.source 'A' isn't the name of a job, watch, or group")
+	testStrict(`{strict: true, consul: "consul:8500", jobs: [
+		{name: "app", exec: "app"},
+		{name: "web", exec: "web", when: {all: [
+			{source: "app", once: "exitSuccess"},
+			{source: "watch.db", once: "healthy"}]}}]}`,
+		"strict mode: job[web].when.all[1].source 'watch.db' isn't the name of a job, watch, or group")
+	testStrict(`{strict: true, consul: "consul:8500", jobs: [
+		{name: "app", exec: "app"},
+		{name: "web", exec: "web", when: {source: "custom.deploy"}},
+		{name: "worker", exec: "worker", when: {source: "watch.db", each: "changed"}}],
+		watches: [{name: "db", interval: 5}]}`, "")
+	if _, err := newConfig([]byte(`{strict: "yes"}`)); err == nil {
+		t.Fatal("expected error for invalid strict")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var out bytes.Buffer
+	if err := DryRun("./testdata/test.json5", DryRunText, &out); err != nil {
+		t.Fatalf("unexpected error in DryRun: %v", err)
+	}
+	text := out.String()
+	for _, expected := range []string{
+		"\npreStart\n    starts once on startup\n    triggers serviceA\n",
+		"\nserviceA\n    starts once on exitSuccess from preStart\n",
+		"\nonChange-upstreamA\n    starts each time on changed from watch.upstreamA\n",
+		"\nwatch.upstreamA\n    polls every 11s\n    triggers onChange-upstreamA\n",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("expected %q in dry run output:\n%s", expected, text)
+		}
+	}
+	if strings.Index(text, "\npreStart\n") > strings.Index(text, "\nserviceA\n") ||
+		strings.Index(text, "\nserviceA\n") > strings.Index(text, "\npreStop\n") {
+		t.Fatalf("expected jobs in the order they start:\n%s", text)
+	}
+
+	out.Reset()
+	if err := DryRun("./testdata/test.json5", DryRunDOT, &out); err != nil {
+		t.Fatalf("unexpected error in DryRun: %v", err)
+	}
+	for _, expected := range []string{
+		"digraph containerpilot {\n",
+		`  "preStart" -> "serviceA" [label="exitSuccess"];`,
+		`  "watch.upstreamA" [shape=ellipse];`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q in dry run output:\n%s", expected, out.String())
+		}
+	}
+
+	err := DryRun("./testdata/test.json5", "svg", &out)
+	assert.Error(t, err, "unknown -dry-run format 'svg': accepts 'text' or 'dot'")
+
+	cfg, err := newConfig([]byte(`{consul: "consul:8500", jobs: [
+		{name: "app", exec: "app", when: {source: "web", once: "healthy"}},
+		{name: "web", exec: "web", when: {source: "app", once: "healthy"}},
+		{name: "worker", exec: "worker", when: {source: "watch.db", each: "changed"}}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	out.Reset()
+	newEventGraph(cfg).writeText(&out)
+	text = out.String()
+	if !strings.HasPrefix(text, "Jobs, in the order they can start:\n\nworker\n") ||
+		!strings.Contains(text, "'watch.db' isn't a job, watch, or group") ||
+		strings.Count(text, "waits on a cycle of jobs") != 2 {
+		t.Fatalf("expected warnings for the unknown source and the cycle:\n%s", text)
+	}
+}
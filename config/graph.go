@@ -0,0 +1,298 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/jobs"
+)
+
+// Formats of the -dry-run output
+const (
+	DryRunText = "text"
+	DryRunDOT  = "dot"
+)
+
+// DryRun loads and validates the configuration without running it, and
+// writes the graph of the events that start each job to w, either as text
+// or as a Graphviz DOT graph
+func DryRun(configFlag, format string, w io.Writer) error {
+	if format != DryRunText && format != DryRunDOT {
+		return fmt.Errorf("unknown -dry-run format '%s': accepts '%s' or '%s'",
+			format, DryRunText, DryRunDOT)
+	}
+	cfg, err := LoadConfig(configFlag)
+	if err != nil {
+		return err
+	}
+	graph := newEventGraph(cfg)
+	if format == DryRunDOT {
+		graph.writeDOT(w)
+	} else {
+		graph.writeText(w)
+	}
+	return nil
+}
+
+// eventGraph is the jobs and watches of a configuration, with the jobs
+// in the order in which they can start
+type eventGraph struct {
+	jobs    []*graphNode
+	watches []*graphNode
+	known   map[string]bool // event sources other than jobs and watches
+}
+
+// graphNode is a job or watch. Each of a job's edges is an event that
+// starts it.
+type graphNode struct {
+	name       string
+	edges      []events.Event
+	all        bool // the job starts once all of its edges happen
+	once       bool
+	interval   string
+	schedule   string
+	timeout    string
+	stopsAfter string
+	triggers   []string // the jobs that this job or watch starts
+	cycle      bool     // the job waits on a cycle of jobs that start each other
+}
+
+func newEventGraph(cfg *Config) *eventGraph {
+	graph := &eventGraph{known: map[string]bool{
+		events.GlobalStartup.Source: true}}
+	nodes := map[string]*graphNode{}
+	for _, watch := range cfg.Watches {
+		node := &graphNode{name: watch.Name,
+			interval: fmt.Sprintf("%ds", watch.Poll)}
+		graph.watches = append(graph.watches, node)
+		nodes[node.name] = node
+	}
+	for _, group := range cfg.Groups {
+		graph.known[group.Name] = true
+	}
+	unsorted := []*graphNode{}
+	for _, job := range cfg.Jobs {
+		node := newJobNode(job)
+		unsorted = append(unsorted, node)
+		nodes[node.name] = node
+	}
+	for _, node := range unsorted {
+		for _, edge := range node.edges {
+			if source, ok := nodes[edge.Source]; ok {
+				source.triggers = appendUnique(source.triggers, node.name)
+			}
+		}
+	}
+	graph.jobs = sortJobs(unsorted)
+	return graph
+}
+
+func newJobNode(job *jobs.Config) *graphNode {
+	edges, once := job.StartEvents()
+	node := &graphNode{
+		name:       job.Name,
+		edges:      edges,
+		all:        len(job.When.All) > 0,
+		once:       once,
+		interval:   job.When.Frequency,
+		schedule:   job.When.Schedule,
+		timeout:    job.When.Timeout,
+		stopsAfter: job.StopsAfter(),
+	}
+	if job.When.Timezone != "" {
+		node.schedule += " (" + job.When.Timezone + ")"
+	}
+	return node
+}
+
+// sortJobs returns the jobs in the order in which they can start: each
+// job comes after the jobs whose events start it, and otherwise in the
+// order of the configuration. Jobs that depend on each other in a cycle,
+// and the jobs that depend on them, come last.
+func sortJobs(unsorted []*graphNode) []*graphNode {
+	isJob := map[string]bool{}
+	for _, node := range unsorted {
+		isJob[node.name] = true
+	}
+	sorted := []*graphNode{}
+	placed := map[string]bool{}
+	ready := func(node *graphNode) bool {
+		for _, edge := range node.edges {
+			if isJob[edge.Source] && !placed[edge.Source] && edge.Source != node.name {
+				return false
+			}
+		}
+		return true
+	}
+	for len(unsorted) > 0 {
+		next := -1
+		for i, node := range unsorted {
+			if ready(node) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			for _, node := range unsorted {
+				node.cycle = true
+			}
+			return append(sorted, unsorted...)
+		}
+		placed[unsorted[next].name] = true
+		sorted = append(sorted, unsorted[next])
+		unsorted = append(unsorted[:next:next], unsorted[next+1:]...)
+	}
+	return sorted
+}
+
+func findNode(nodes []*graphNode, name string) (int, bool) {
+	for i, node := range nodes {
+		if node.name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// isKnown returns true if the source of an event is a job, watch, group,
+// custom event, or ContainerPilot itself
+func (graph *eventGraph) isKnown(source string) bool {
+	if graph.known[source] || strings.HasPrefix(source, events.CustomPrefix) {
+		return true
+	}
+	if _, ok := findNode(graph.jobs, source); ok {
+		return true
+	}
+	_, ok := findNode(graph.watches, source)
+	return ok
+}
+
+// describeEvent returns the event as it's written in the configuration
+func describeEvent(event events.Event) string {
+	if event.Source == events.GlobalStartup.Source {
+		return event.Code.Name()
+	}
+	return fmt.Sprintf("%s from %s", event.Code.Name(), event.Source)
+}
+
+// describeStart returns how the job starts, ex. "once on exitSuccess from
+// preStart"
+func (node *graphNode) describeStart() string {
+	if node.schedule != "" {
+		return fmt.Sprintf("on the schedule '%s'", node.schedule)
+	}
+	described := make([]string, len(node.edges))
+	for i, edge := range node.edges {
+		described[i] = describeEvent(edge)
+	}
+	start := "on " + strings.Join(described, " and ")
+	switch {
+	case node.interval != "":
+		start += ", then every " + node.interval
+	case node.all:
+		start = "once all of these happen: " + strings.Join(described, ", ")
+	case node.once:
+		start = "once " + start
+	default:
+		start = "each time " + start
+	}
+	if node.timeout != "" {
+		start += fmt.Sprintf(" (gives up after %s)", node.timeout)
+	}
+	return start
+}
+
+func (graph *eventGraph) writeText(w io.Writer) {
+	fmt.Fprintln(w, "Jobs, in the order they can start:")
+	for _, node := range graph.jobs {
+		fmt.Fprintf(w, "\n%s\n", node.name)
+		fmt.Fprintf(w, "    starts %s\n", node.describeStart())
+		for _, edge := range node.edges {
+			if !graph.isKnown(edge.Source) {
+				fmt.Fprintf(w, "    WARNING: '%s' isn't a job, watch, or group, "+
+					"so this job won't start\n", edge.Source)
+			}
+		}
+		if node.cycle {
+			fmt.Fprintln(w, "    WARNING: waits on a cycle of jobs that start "+
+				"each other, so it may never start")
+		}
+		if node.stopsAfter != "" {
+			fmt.Fprintf(w, "    waits for %s to stop before it stops\n", node.stopsAfter)
+		}
+		if len(node.triggers) > 0 {
+			fmt.Fprintf(w, "    triggers %s\n", strings.Join(node.triggers, ", "))
+		}
+	}
+	if len(graph.watches) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nWatches:")
+	for _, node := range graph.watches {
+		fmt.Fprintf(w, "\n%s\n", node.name)
+		fmt.Fprintf(w, "    polls every %s\n", node.interval)
+		if len(node.triggers) > 0 {
+			fmt.Fprintf(w, "    triggers %s\n", strings.Join(node.triggers, ", "))
+		}
+	}
+}
+
+func (graph *eventGraph) writeDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph containerpilot {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintf(w, "  %q [shape=circle];\n", events.GlobalStartup.Source)
+	external := map[string]bool{}
+	for _, node := range graph.jobs {
+		attrs := "shape=box"
+		if node.cycle {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(w, "  %q [%s];\n", node.name, attrs)
+		for _, edge := range node.edges {
+			if edge.Source != events.GlobalStartup.Source {
+				if _, ok := findNode(graph.jobs, edge.Source); !ok {
+					external[edge.Source] = true
+				}
+			}
+		}
+	}
+	for _, node := range graph.watches {
+		fmt.Fprintf(w, "  %q [shape=ellipse];\n", node.name)
+		delete(external, node.name)
+	}
+	sources := make([]string, 0, len(external))
+	for source := range external {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		attrs := "shape=note"
+		if !graph.isKnown(source) {
+			attrs += ", style=dashed, color=red"
+		}
+		fmt.Fprintf(w, "  %q [%s];\n", source, attrs)
+	}
+	for _, node := range graph.jobs {
+		for _, edge := range node.edges {
+			fmt.Fprintf(w, "  %q -> %q [label=%q];\n",
+				edge.Source, node.name, edge.Code.Name())
+		}
+		if node.stopsAfter != "" {
+			fmt.Fprintf(w, "  %q -> %q [label=\"stopped\", style=dotted];\n",
+				node.stopsAfter, node.name)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
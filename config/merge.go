@@ -0,0 +1,131 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// appendedKeys are the lists that are appended to, rather than replaced,
+// when the files of a configuration directory are merged
+var appendedKeys = map[string]bool{
+	"jobs":              true,
+	"groups":            true,
+	"watches":           true,
+	"telemetry.metrics": true,
+}
+
+// loadConfigMap reads, renders, and unmarshals the configuration, and
+// records the Vault secrets that rendering it read in secrets. If the
+// -config flag is a directory, each of its configuration files is loaded
+// and merged in lexical order of their names.
+func loadConfigMap(configFlag string, secrets *vaultSecrets) (map[string]interface{}, error) {
+	paths, err := configFiles(configFlag)
+	if err != nil {
+		return nil, err
+	}
+	if paths == nil {
+		return loadConfigFileMap(configFlag, secrets)
+	}
+	merged := map[string]interface{}{}
+	for _, path := range paths {
+		configMap, err := loadConfigFileMap(path, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		mergeConfig(merged, configMap, "")
+	}
+	return merged, nil
+}
+
+func loadConfigFileMap(path string, secrets *vaultSecrets) (map[string]interface{}, error) {
+	configData, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format, err := configFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	renderedConfig, err := renderConfigTemplate(configData, secrets)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalConfig(renderedConfig, format)
+}
+
+// configFiles returns the configuration files in the directory named by
+// the -config flag, sorted by name, or nil if the flag isn't a directory.
+// Hidden files and files without a JSON5 or YAML extension are skipped, so
+// that editor backups and READMEs can live alongside the configuration.
+func configFiles(configFlag string) ([]string, error) {
+	if configFlag == "" || isRemoteConfig(configFlag) {
+		return nil, nil
+	}
+	info, err := os.Stat(configFlag)
+	if err != nil || !info.IsDir() {
+		return nil, nil // loadConfigFile reports the error
+	}
+	entries, err := ioutil.ReadDir(configFlag)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config directory: %s", err)
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".json5", ".json", ".yaml", ".yml":
+			paths = append(paths, filepath.Join(configFlag, name))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no configuration files in config directory %s",
+			configFlag)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// mergeConfig merges the src configuration into dst. The appendedKeys
+// lists are appended to, objects are merged key by key, and any other
+// value in src replaces the value in dst.
+func mergeConfig(dst, src map[string]interface{}, prefix string) {
+	for key, val := range src {
+		path := prefix + key
+		if appendedKeys[path] {
+			existing, _ := dst[key].([]interface{})
+			if list, ok := val.([]interface{}); ok {
+				dst[key] = append(existing, list...)
+				continue
+			}
+		}
+		srcMap, srcOk := val.(map[string]interface{})
+		dstMap, dstOk := dst[key].(map[string]interface{})
+		if srcOk && dstOk {
+			mergeConfig(dstMap, srcMap, path+".")
+			continue
+		}
+		dst[key] = val
+	}
+}
+
+// renderConfigDir returns the merged configuration of a directory, as
+// JSON, for the -template flag
+func renderConfigDir(configFlag string) ([]byte, error) {
+	configMap, err := loadConfigMap(configFlag, newVaultSecrets())
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := json.MarshalIndent(configMap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(rendered, '\n'), nil
+}
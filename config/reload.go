@@ -0,0 +1,49 @@
+package config
+
+import "reflect"
+
+// ReloadConfig loads, parses, and validates the configuration again for a
+// running ContainerPilot. If nothing but the jobs and watches changed, the
+// new Config shares the discovery backend of the running Config and the
+// returned bool is true, so that only the jobs and watches whose
+// definitions changed need to be restarted. Otherwise everything has to
+// be restarted with the new Config. Either way, the leased Vault secrets
+// of the running Config are reused rather than read again, and if the
+// configuration is invalid the leases of those it read are revoked.
+func ReloadConfig(configFlag string, running *Config) (*Config, bool, error) {
+	secrets := newVaultSecretsFrom(running)
+	configMap, err := loadConfigMap(configFlag, secrets)
+	if err != nil {
+		secrets.release(running.vaultSecrets())
+		return nil, false, err
+	}
+	if running == nil || !onlyJobsChanged(running.raw, configMap) {
+		cfg, err := buildConfig(configMap, nil, secrets)
+		if err != nil {
+			secrets.release(running.vaultSecrets())
+		}
+		return cfg, false, err
+	}
+	cfg, err := buildConfig(configMap, running.Discovery, secrets)
+	if err != nil {
+		secrets.release(running.vaultSecrets())
+		return nil, false, err
+	}
+	return cfg, true, nil
+}
+
+// onlyJobsChanged returns true if the configurations are the same apart
+// from their jobs and watches
+func onlyJobsChanged(old, new map[string]interface{}) bool {
+	for _, cfg := range []map[string]interface{}{old, new} {
+		for key := range cfg {
+			if key == "jobs" || key == "watches" {
+				continue
+			}
+			if !reflect.DeepEqual(old[key], new[key]) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// consulScheme prefixes a -config flag that names a Consul KV key
+const consulScheme = "consul://"
+
+// maxRemoteConfigSize is the most of a remote configuration that we read
+const maxRemoteConfigSize = 1 << 20
+
+var (
+	// remoteAttempts is how many times a remote configuration is fetched
+	// before giving up, so that ContainerPilot can start alongside the
+	// service that holds its configuration
+	remoteAttempts = 5
+
+	// remoteRetryDelay is the delay after the first failed fetch of a
+	// remote configuration, which doubles after each failure
+	remoteRetryDelay = time.Second
+
+	remoteClient = &http.Client{
+		Timeout:       10 * time.Second,
+		CheckRedirect: checkRemoteRedirect,
+	}
+)
+
+// AllowHTTP allows the configuration to be fetched from a plain http://
+// URL, as set by the -config-allow-http flag. Otherwise only https:// URLs
+// are fetched, because the configuration runs commands as our user.
+var AllowHTTP bool
+
+// isRemoteConfig returns true if the -config flag is a Consul KV key or
+// an HTTP(S) URL rather than a file
+func isRemoteConfig(configFlag string) bool {
+	return strings.HasPrefix(configFlag, consulScheme) ||
+		strings.HasPrefix(configFlag, "http://") ||
+		strings.HasPrefix(configFlag, "https://")
+}
+
+// configPath returns the part of the -config flag that names the
+// configuration, without the query of a URL, so that its extension can
+// tell us the format
+func configPath(configFlag string) string {
+	if !isRemoteConfig(configFlag) || strings.HasPrefix(configFlag, consulScheme) {
+		return configFlag
+	}
+	if parsed, err := url.Parse(configFlag); err == nil {
+		return parsed.Path
+	}
+	return configFlag
+}
+
+// fetchRemoteConfig fetches the configuration from Consul or the URL,
+// retrying with a backoff if the fetch fails
+func fetchRemoteConfig(configFlag string) ([]byte, error) {
+	if strings.HasPrefix(configFlag, "http://") && !AllowHTTP {
+		return nil, fmt.Errorf("refusing to fetch config from %s over plain "+
+			"HTTP: use an https:// URL or the -config-allow-http flag", configFlag)
+	}
+	fetch := fetchURL
+	if strings.HasPrefix(configFlag, consulScheme) {
+		fetch = fetchConsulKey
+	}
+	delay := remoteRetryDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		var data []byte
+		if data, err = fetch(configFlag); err == nil {
+			return data, nil
+		}
+		if attempt >= remoteAttempts {
+			break
+		}
+		log.Warnf("could not fetch config from %s (retrying in %v): %v",
+			configFlag, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return nil, fmt.Errorf("could not fetch config from %s: %v", configFlag, err)
+}
+
+// fetchConsulKey reads the configuration from a Consul KV key. The Consul
+// agent is configured by the standard environment variables such as
+// CONSUL_HTTP_ADDR and CONSUL_HTTP_TOKEN, because the configuration that
+// would tell us otherwise hasn't been read yet.
+func fetchConsulKey(configFlag string) ([]byte, error) {
+	key := strings.TrimPrefix(configFlag, consulScheme)
+	if key == "" {
+		return nil, fmt.Errorf("missing Consul KV key")
+	}
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	pair, _, err := client.KV().Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("key '%s' not found", key)
+	}
+	return pair.Value, nil
+}
+
+// checkRemoteRedirect stops a redirect from an https:// URL to a plain
+// http:// URL, unless that's allowed
+func checkRemoteRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	if req.URL.Scheme != "https" && !AllowHTTP {
+		return fmt.Errorf("refusing to follow redirect to %s over plain HTTP", req.URL)
+	}
+	return nil
+}
+
+func fetchURL(configFlag string) ([]byte, error) {
+	resp, err := remoteClient.Get(configFlag)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return data, nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/jobs"
+)
+
+// Strict is set by the -strict flag. In strict mode, or if the
+// configuration sets `strict: true`, a configuration is also rejected for
+// mistakes that ContainerPilot can otherwise run with, but that usually
+// mean that a job will never start or never be registered.
+var Strict bool
+
+// validateStrict rejects:
+//
+//   - jobs or watches with the same name, such as from two files of a
+//     configuration directory
+//   - service discovery fields on a job without a 'port', which are
+//     ignored because the job isn't registered
+//   - a job's 'when.source' that isn't a job, watch, group, or custom
+//     event, because the job would never start
+func validateStrict(cfg *Config) error {
+	sources := map[string]bool{events.GlobalStartup.Source: true}
+	for _, job := range cfg.Jobs {
+		if sources[job.Name] {
+			return fmt.Errorf("duplicate job name '%s'", job.Name)
+		}
+		sources[job.Name] = true
+	}
+	for _, watch := range cfg.Watches {
+		if sources[watch.Name] {
+			return fmt.Errorf("duplicate watch name '%s'",
+				strings.TrimPrefix(watch.Name, "watch."))
+		}
+		sources[watch.Name] = true
+	}
+	for _, group := range cfg.Groups {
+		sources[group.Name] = true
+	}
+	for _, job := range cfg.Jobs {
+		if err := validateStrictDiscovery(job); err != nil {
+			return err
+		}
+		if job.When == nil {
+			continue
+		}
+		if err := validateStrictSource(job.Name, "when", job.When.Source, sources); err != nil {
+			return err
+		}
+		for i, condition := range job.When.All {
+			field := fmt.Sprintf("when.all[%d]", i)
+			if err := validateStrictSource(job.Name, field, condition.Source, sources); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateStrictDiscovery(job *jobs.Config) error {
+	if job.Port != 0 {
+		return nil
+	}
+	var field string
+	switch {
+	case len(job.Tags) > 0:
+		field = "tags"
+	case job.Interfaces != nil:
+		field = "interfaces"
+	case job.ConsulExtras != nil:
+		field = "consul"
+	default:
+		return nil
+	}
+	return fmt.Errorf("job[%s].%s requires the job to have a port", job.Name, field)
+}
+
+func validateStrictSource(name, field, source string, sources map[string]bool) error {
+	if source == "" || sources[source] || strings.HasPrefix(source, events.CustomPrefix) {
+		return nil
+	}
+	return fmt.Errorf("job[%s].%s.source '%s' isn't the name of a job, "+
+		"watch, or group", name, field, source)
+}
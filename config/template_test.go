@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/joyent/containerpilot/tests/assert"
@@ -62,6 +63,41 @@ func TestTemplate(t *testing.T) {
 		`Hello, {{.NAME | replaceAll "e" "_" }}!`, "Hello, T_mplat_!")
 	testTemplate("Regex Replace All",
 		`Hello, {{.NAME | regexReplaceAll "[epa]+" "_" }}!`, "Hello, T_m_l_t_!")
+	testTemplate("Regex Replace",
+		`Hello, {{.NAME | regexReplace "([epa]+)" "<$1>" }}!`, "Hello, T<e>mplate!")
+	testTemplate("Regex Match",
+		`{{ if .USER | regexMatch "^pi" }}yes{{ end }}`, "yes")
+	testTemplate("Required", `Hello, {{.USER | required "USER is required" }}!`,
+		"Hello, pilot!")
+	testTemplate("JSON", `{{ (fromJSON "{\"a\": [1, \"b\"]}").a | toJSON }}`,
+		`[1,"b"]`)
+	testTemplate("Base64", `{{ .USER | base64Encode }} {{ "cGlsb3Q=" | base64Decode }}`,
+		"cGlsb3Q= pilot")
+	testTemplate("Env prefix",
+		`{{ range $k, $v := envPrefix "NAME_" }}{{ $k }}={{ $v }}{{ end }}`,
+		"NAME_1=Template")
+
+	f, err := ioutil.TempFile("", "template")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("s3cr3t\n")
+	f.Close()
+	testTemplate("File", `{{ file "`+f.Name()+`" | trimSpace }}`, "s3cr3t")
+
+	testTemplateError := func(name string, template string, expected string) {
+		_, err := ApplyTemplate([]byte(template))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("%s - expected error containing %q but got: %v",
+				name, expected, err)
+		}
+	}
+	testTemplateError("Required missing",
+		`{{ .NONAME | required "NONAME must be set" }}`, "NONAME must be set")
+	testTemplateError("File missing", `{{ file "/does/not/exist" }}`,
+		"no such file or directory")
+	testTemplateError("Bad JSON", `{{ fromJSON "{" }}`, "fromJSON: ")
 }
 
 func TestInvalidRenderConfigFileMissing(t *testing.T) {
@@ -112,7 +148,7 @@ func TestRenderedConfigIsParseable(t *testing.T) {
 	watches: [{"name": "upstreamA{{.TESTRENDERCONFIGISPARSEABLE}}", "interval": 11}]}`
 
 	os.Setenv("TESTRENDERCONFIGISPARSEABLE", "-ok")
-	template, _ := renderConfigTemplate([]byte(testJSON))
+	template, _ := renderConfigTemplate([]byte(testJSON), newVaultSecrets())
 	config, err := newConfig(template)
 	if err != nil {
 		t.Fatalf("unexpected error in LoadConfig: %v", err)
@@ -123,6 +159,25 @@ func TestRenderedConfigIsParseable(t *testing.T) {
 	}
 }
 
+func TestRenderedJobEnabled(t *testing.T) {
+	var testJSON = `{
+	consul: "consul:8500",
+	jobs: [
+		{name: "web", exec: "/bin/web", enabled: {{ eq .TESTJOBROLE "web" }}},
+		{name: "worker", exec: "/bin/worker", enabled: {{ eq .TESTJOBROLE "worker" }}}]}`
+
+	os.Setenv("TESTJOBROLE", "worker")
+	defer os.Unsetenv("TESTJOBROLE")
+	template, _ := renderConfigTemplate([]byte(testJSON), newVaultSecrets())
+	config, err := newConfig(template)
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	if len(config.Jobs) != 1 || config.Jobs[0].Name != "worker" {
+		t.Fatalf("expected only the worker job to be enabled but got %v", config.Jobs)
+	}
+}
+
 // ----------------------------------------------------
 // test helpers
 
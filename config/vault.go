@@ -0,0 +1,577 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/utils"
+)
+
+const (
+	defaultVaultInterval = "5m"
+	defaultK8sTokenFile  = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// vaultTick is how often the secrets are checked for renewal
+var vaultTick = 10 * time.Second
+
+var errVaultForbidden = errors.New("vault: permission denied")
+
+// VaultConfig is the optional `vault` configuration, which sets what
+// happens when a secret read by the `vault` template function rotates
+type VaultConfig struct {
+	// OnRotate is one of "reload" (the default), "restart", or "none"
+	OnRotate string `mapstructure:"onRotate"`
+
+	// Interval is how often secrets without a lease, such as those of a
+	// KV engine, are read again to find out if they've changed
+	Interval string `mapstructure:"interval"`
+
+	interval time.Duration
+}
+
+func newVaultConfig(raw interface{}) (*VaultConfig, error) {
+	cfg := &VaultConfig{OnRotate: "reload", Interval: defaultVaultInterval}
+	if raw != nil {
+		if err := utils.DecodeRaw(raw, cfg); err != nil {
+			return nil, fmt.Errorf("vault config parsing error: %v", err)
+		}
+	}
+	switch cfg.OnRotate {
+	case "reload", "restart", "none":
+	default:
+		return nil, fmt.Errorf("vault.onRotate must be one of 'reload', "+
+			"'restart', or 'none' but got '%s'", cfg.OnRotate)
+	}
+	interval, err := utils.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid vault.interval '%s': must be a "+
+			"positive duration", cfg.Interval)
+	}
+	cfg.interval = interval
+	return cfg, nil
+}
+
+// RenewSecrets keeps the leases of the Vault secrets that the template
+// read renewed, and reads the secrets again when they're due, until
+// StopSecrets or ReleaseSecrets is called. When a secret rotates, reload is called with
+// true if `vault.onRotate` asks for everything to be restarted, or false
+// if it asks for a reload. If reload fails, the secrets are still renewed
+// and reload is retried on the next tick.
+func (cfg *Config) RenewSecrets(reload func(restart bool) error) {
+	if cfg != nil && cfg.secrets != nil {
+		cfg.secrets.run(reload)
+	}
+}
+
+// StopSecrets stops renewing the Vault secrets. Their leases are kept
+// until ReleaseSecrets is called, so that they're still valid if the
+// configuration that replaces this one can't be loaded.
+func (cfg *Config) StopSecrets() {
+	if cfg != nil && cfg.secrets != nil {
+		cfg.secrets.stopRenewing()
+	}
+}
+
+// ReleaseSecrets stops renewing the Vault secrets and revokes their
+// leases, including those of secrets that were read again, once the
+// configuration that read them has been replaced by next. The leases
+// that next reused are kept. Pass a nil next when exiting.
+func (cfg *Config) ReleaseSecrets(next *Config) {
+	if cfg != nil && cfg.secrets != nil {
+		cfg.secrets.release(next.vaultSecrets())
+	}
+}
+
+// vaultSecrets returns the secrets that the template read, or nil if none
+func (cfg *Config) vaultSecrets() *vaultSecrets {
+	if cfg == nil {
+		return nil
+	}
+	return cfg.secrets
+}
+
+// vaultSecrets are the secrets read from Vault by the `vault` template
+// function while the configuration was rendered. run keeps their leases
+// renewed and reads them again when they're due, and reports if any of
+// them have changed so that the configuration can be rendered again.
+type vaultSecrets struct {
+	cfg      *VaultConfig
+	client   *vaultClient
+	lock     sync.Mutex
+	secrets  map[string]*vaultLease // by path
+	reuse    map[string]*vaultLease // of the running configuration, by path
+	retired  []string               // IDs of the leases replaced by refresh
+	stop     chan struct{}
+	once     sync.Once
+	released sync.Once
+	offline  bool // the template function doesn't read Vault
+}
+
+// vaultLease is a secret and its lease
+type vaultLease struct {
+	data      map[string]interface{}
+	leaseID   string
+	renewable bool
+	duration  time.Duration // of the lease, or 0 if it has none
+	updated   time.Time     // when the secret was read or renewed
+}
+
+// due returns true if the secret should be renewed or read again, which
+// is at two thirds of its lease so there's time to read it again if
+// renewal fails. Secrets without a lease are read again every interval.
+func (lease *vaultLease) due(now time.Time, interval time.Duration) bool {
+	if lease.duration > 0 {
+		interval = lease.duration * 2 / 3
+	}
+	return !now.Before(lease.updated.Add(interval))
+}
+
+func newVaultSecrets() *vaultSecrets {
+	return &vaultSecrets{
+		secrets: map[string]*vaultLease{},
+		stop:    make(chan struct{}),
+	}
+}
+
+// newVaultSecretsFrom returns secrets whose template function reuses the
+// leased secrets of the running configuration rather than reading them
+// again, so that rendering the configuration again after a secret rotated
+// gets the secret that was just read, and doesn't create a new lease for
+// each secret that didn't rotate. Secrets without a lease are read again.
+func newVaultSecretsFrom(running *Config) *vaultSecrets {
+	secrets := newVaultSecrets()
+	if running == nil || running.secrets == nil {
+		return secrets
+	}
+	v := running.secrets
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	secrets.reuse = map[string]*vaultLease{}
+	for path, lease := range v.secrets {
+		if lease.leaseID != "" {
+			copied := *lease // the running config keeps renewing its own
+			secrets.reuse[path] = &copied
+		}
+	}
+	return secrets
+}
+
+// newOfflineVaultSecrets returns secrets whose template function renders
+// every secret as an empty string without reading Vault
+func newOfflineVaultSecrets() *vaultSecrets {
+	secrets := newVaultSecrets()
+	secrets.offline = true
+	return secrets
+}
+
+// templateFunc is the `vault` template function, which returns a field of
+// the secret at path. Each secret is read once per render, unless it's
+// reused from the running configuration.
+func (v *vaultSecrets) templateFunc(path, field string) (string, error) {
+	if v.offline {
+		return "", nil
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	path = strings.Trim(path, "/")
+	lease, ok := v.secrets[path]
+	if !ok {
+		if v.client == nil {
+			client, err := newVaultClient()
+			if err != nil {
+				return "", err
+			}
+			v.client = client
+		}
+		if lease, ok = v.reuse[path]; !ok {
+			var err error
+			if lease, err = v.client.read(path, time.Now()); err != nil {
+				return "", err
+			}
+		}
+		v.secrets[path] = lease
+	}
+	value, ok := lease.data[field]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no '%s' field", path, field)
+	}
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// empty returns true if no secrets were read
+func (v *vaultSecrets) empty() bool {
+	if v == nil {
+		return true
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return len(v.secrets) == 0
+}
+
+// run renews and reads the secrets until stopRenewing is called. See
+// Config.RenewSecrets.
+func (v *vaultSecrets) run(reload func(restart bool) error) {
+	if v.empty() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(vaultTick)
+		defer ticker.Stop()
+		rotated := false // and not yet reloaded
+		for {
+			select {
+			case <-v.stop:
+				return
+			case now := <-ticker.C:
+				if v.refresh(now) {
+					if v.cfg.OnRotate == "none" {
+						log.Info("vault: secret rotated")
+						continue
+					}
+					rotated = true
+				}
+				if !rotated {
+					continue
+				}
+				restart := v.cfg.OnRotate == "restart"
+				if restart {
+					log.Info("vault: secret rotated, restarting")
+				} else {
+					log.Info("vault: secret rotated, reloading config")
+				}
+				// a successful reload stops us, because the new
+				// configuration renews the secrets it reused or read
+				if err := reload(restart); err != nil {
+					log.Errorf("vault: unable to reload after secret rotated "+
+						"(retrying in %v): %v", vaultTick, err)
+					continue
+				}
+				rotated = false
+			}
+		}
+	}()
+}
+
+// stopRenewing stops run. It doesn't wait for run to exit, so that it can
+// be called from the reload that run calls.
+func (v *vaultSecrets) stopRenewing() {
+	if v == nil {
+		return
+	}
+	v.once.Do(func() { close(v.stop) })
+}
+
+// leaseIDs returns the set of the IDs of the secrets' leases
+func (v *vaultSecrets) leaseIDs() map[string]bool {
+	ids := map[string]bool{}
+	if v == nil {
+		return ids
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for _, lease := range v.secrets {
+		if lease.leaseID != "" {
+			ids[lease.leaseID] = true
+		}
+	}
+	return ids
+}
+
+// release stops run and revokes the leases of the secrets, and of those
+// that refresh replaced, other than the leases that keep also holds, so
+// that credentials from a dynamic secrets engine don't outlive the
+// configuration that used them
+func (v *vaultSecrets) release(keep *vaultSecrets) {
+	v.stopRenewing()
+	v.released.Do(func() {
+		kept := keep.leaseIDs()
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		now := time.Now()
+		for _, leaseID := range v.retired {
+			if kept[leaseID] {
+				continue
+			}
+			// the old lease may already have expired
+			if err := v.client.revoke(leaseID, now); err != nil {
+				log.Debugf("vault: unable to revoke old lease %s: %v", leaseID, err)
+			}
+		}
+		v.retired = nil
+		for path, lease := range v.secrets {
+			if lease.leaseID == "" || kept[lease.leaseID] {
+				continue
+			}
+			if err := v.client.revoke(lease.leaseID, now); err != nil {
+				log.Warnf("vault: unable to revoke lease of %s: %v", path, err)
+			}
+		}
+	})
+}
+
+// refresh renews or reads again each secret that's due, and returns true
+// if any of them changed. A secret that can't be refreshed is logged and
+// retried on the next tick.
+func (v *vaultSecrets) refresh(now time.Time) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	changed := false
+	for path, lease := range v.secrets {
+		if !lease.due(now, v.cfg.interval) {
+			continue
+		}
+		if lease.renewable {
+			duration, err := v.client.renew(lease.leaseID, now)
+			if err == nil {
+				lease.duration = duration
+				lease.updated = now
+				continue
+			}
+			log.Warnf("vault: unable to renew lease of %s, reading it again: %v",
+				path, err)
+		}
+		next, err := v.client.read(path, now)
+		if err != nil {
+			log.Errorf("vault: unable to read %s: %v", path, err)
+			continue
+		}
+		if lease.leaseID != "" {
+			// the jobs may still be using the old lease, so it's only
+			// revoked once the configuration has been replaced
+			v.retired = append(v.retired, lease.leaseID)
+		}
+		if !reflect.DeepEqual(lease.data, next.data) {
+			changed = true
+		}
+		v.secrets[path] = next
+	}
+	return changed
+}
+
+// vaultClient reads secrets from Vault. It authenticates with the
+// credentials in the environment, because the configuration is rendered
+// before any of it has been read.
+type vaultClient struct {
+	client    *http.Client
+	address   string
+	token     string
+	tokenFile string
+
+	// login is the path of the auth method, ex. "auth/approle/login", for
+	// the approle and kubernetes auth methods
+	login     string
+	loginBody func() (map[string]string, error)
+	loginAt   time.Time // when to log in again, or zero if never
+}
+
+// vaultResponse is the subset of a Vault response that we need
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+}
+
+// newVaultClient creates a client for the Vault at VAULT_ADDR that uses
+// the first of these auth methods that's configured:
+//
+//   - a token, from VAULT_TOKEN or the file at VAULT_TOKEN_FILE
+//   - approle, with VAULT_ROLE_ID and VAULT_SECRET_ID (or the file at
+//     VAULT_SECRET_ID_FILE), mounted at VAULT_APPROLE_PATH
+//   - kubernetes, with the role VAULT_K8S_ROLE and the service account
+//     token at VAULT_K8S_TOKEN_FILE, mounted at VAULT_K8S_PATH
+func newVaultClient() (*vaultClient, error) {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		return nil, fmt.Errorf("vault: VAULT_ADDR must be set")
+	}
+	c := &vaultClient{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		address: strings.TrimSuffix(address, "/"),
+	}
+	switch {
+	case os.Getenv("VAULT_TOKEN") != "":
+		c.token = os.Getenv("VAULT_TOKEN")
+	case os.Getenv("VAULT_TOKEN_FILE") != "":
+		c.tokenFile = os.Getenv("VAULT_TOKEN_FILE")
+	case os.Getenv("VAULT_ROLE_ID") != "":
+		roleID := os.Getenv("VAULT_ROLE_ID")
+		c.login = "auth/" + envDefault("VAULT_APPROLE_PATH", "approle") + "/login"
+		c.loginBody = func() (map[string]string, error) {
+			secretID := os.Getenv("VAULT_SECRET_ID")
+			if path := os.Getenv("VAULT_SECRET_ID_FILE"); path != "" {
+				var err error
+				if secretID, err = readTrimmed(path); err != nil {
+					return nil, err
+				}
+			}
+			return map[string]string{"role_id": roleID, "secret_id": secretID}, nil
+		}
+	case os.Getenv("VAULT_K8S_ROLE") != "":
+		role := os.Getenv("VAULT_K8S_ROLE")
+		c.login = "auth/" + envDefault("VAULT_K8S_PATH", "kubernetes") + "/login"
+		c.loginBody = func() (map[string]string, error) {
+			jwt, err := readTrimmed(envDefault("VAULT_K8S_TOKEN_FILE", defaultK8sTokenFile))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"role": role, "jwt": jwt}, nil
+		}
+	default:
+		return nil, fmt.Errorf("vault: one of VAULT_TOKEN, VAULT_TOKEN_FILE, " +
+			"VAULT_ROLE_ID, or VAULT_K8S_ROLE must be set")
+	}
+	return c, nil
+}
+
+// read reads the secret at path. Secrets from a KV version 2 engine are
+// unwrapped, so their fields can be used like those of any other secret.
+func (c *vaultClient) read(path string, now time.Time) (*vaultLease, error) {
+	resp := &vaultResponse{}
+	if err := c.do("GET", path, nil, resp, now); err != nil {
+		return nil, err
+	}
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested // KV version 2
+	}
+	return &vaultLease{
+		data:      data,
+		leaseID:   resp.LeaseID,
+		renewable: resp.Renewable && resp.LeaseID != "",
+		duration:  time.Duration(resp.LeaseDuration) * time.Second,
+		updated:   now,
+	}, nil
+}
+
+// renew extends the lease and returns its new duration
+func (c *vaultClient) renew(leaseID string, now time.Time) (time.Duration, error) {
+	body, _ := json.Marshal(map[string]string{"lease_id": leaseID})
+	resp := &vaultResponse{}
+	if err := c.do("PUT", "sys/leases/renew", body, resp, now); err != nil {
+		return 0, err
+	}
+	return time.Duration(resp.LeaseDuration) * time.Second, nil
+}
+
+// revoke revokes the lease
+func (c *vaultClient) revoke(leaseID string, now time.Time) error {
+	body, _ := json.Marshal(map[string]string{"lease_id": leaseID})
+	return c.do("PUT", "sys/leases/revoke", body, nil, now)
+}
+
+// authToken returns the Vault token, logging in if the auth method needs
+// to and the last login's token is due to expire
+func (c *vaultClient) authToken(now time.Time) (string, error) {
+	if c.tokenFile != "" {
+		// reread each time so that a Vault agent can rotate the token
+		return readTrimmed(c.tokenFile)
+	}
+	if c.login == "" || (c.token != "" && (c.loginAt.IsZero() || now.Before(c.loginAt))) {
+		return c.token, nil
+	}
+	body, err := c.loginBody()
+	if err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(body)
+	resp := &vaultResponse{}
+	if err := c.request("POST", c.login, data, "", resp); err != nil {
+		return "", err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault: %s returned no token", c.login)
+	}
+	c.token = resp.Auth.ClientToken
+	c.loginAt = time.Time{}
+	if resp.Auth.LeaseDuration > 0 {
+		lease := time.Duration(resp.Auth.LeaseDuration) * time.Second
+		c.loginAt = now.Add(lease * 2 / 3)
+	}
+	return c.token, nil
+}
+
+// do makes an authenticated request. If Vault rejects the token from a
+// login, we log in again and retry once.
+func (c *vaultClient) do(method, path string, body []byte, result interface{}, now time.Time) error {
+	token, err := c.authToken(now)
+	if err != nil {
+		return err
+	}
+	err = c.request(method, path, body, token, result)
+	if err != errVaultForbidden || c.login == "" {
+		return err
+	}
+	c.token = ""
+	if token, err = c.authToken(now); err != nil {
+		return err
+	}
+	return c.request(method, path, body, token, result)
+}
+
+func (c *vaultClient) request(method, path string, body []byte, token string, result interface{}) error {
+	req, err := http.NewRequest(method, c.address+"/v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return errVaultForbidden
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault: %s returned %s: %s",
+			path, resp.Status, bytes.TrimSpace(respBody))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func envDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func readTrimmed(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+// fakeVault serves a KV version 2 secret and a database secret with a
+// renewable lease, to the token it issues for the approle login
+type fakeVault struct {
+	password          string
+	reads, renewals   int
+	logins            int
+	renewErr, expired bool
+	revoked           []string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.logins++
+		f.expired = false
+		fmt.Fprintf(w, `{"auth": {"client_token": "login-%d", "lease_duration": 60}}`,
+			f.logins)
+		return
+	}
+	token := r.Header.Get("X-Vault-Token")
+	if f.expired || (token != "vault-token" && token != fmt.Sprintf("login-%d", f.logins)) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/secret/data/app":
+		fmt.Fprintf(w, `{"data": {"data": {"password": "%s", "port": 5432}}}`,
+			f.password)
+	case r.Method == "GET" && r.URL.Path == "/v1/database/creds/app":
+		f.reads++
+		fmt.Fprintf(w, `{"lease_id": "database/creds/app/%d", "renewable": true,
+			"lease_duration": 30, "data": {"username": "user-%d"}}`, f.reads, f.reads)
+	case r.Method == "PUT" && r.URL.Path == "/v1/sys/leases/renew":
+		f.renewals++
+		if f.renewErr {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"lease_duration": 30, "renewable": true}`)
+	case r.Method == "PUT" && r.URL.Path == "/v1/sys/leases/revoke":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		f.revoked = append(f.revoked, body["lease_id"])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func loadVaultConfig(t *testing.T, config string) (*Config, error) {
+	f, err := ioutil.TempFile("", "vault")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(config)
+	f.Close()
+	return LoadConfig(f.Name())
+}
+
+func setVaultEnv(env map[string]string) func() {
+	for key, val := range env {
+		os.Setenv(key, val)
+	}
+	return func() {
+		for key := range env {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestVaultTemplate(t *testing.T) {
+	fake := &fakeVault{password: "p1"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	defer setVaultEnv(map[string]string{
+		"VAULT_ADDR": server.URL, "VAULT_TOKEN": "vault-token"})()
+
+	cfg, err := loadVaultConfig(t, `{consul: "consul:8500", jobs: [{name: "app",
+	exec: "app {{ vault "secret/data/app" "password" }} {{ vault "/secret/data/app/" "port" }}"}]}`)
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, cfg.Jobs[0].Exec, "app p1 5432", "expected exec %q but got %q")
+	assert.Equal(t, cfg.Vault.OnRotate, "reload", "expected onRotate %v but got %v")
+
+	// KV secrets are read again every interval
+	now := time.Now()
+	assert.False(t, cfg.secrets.refresh(now.Add(time.Minute)),
+		"expected secret changed to be %v but got %v")
+	assert.False(t, cfg.secrets.refresh(now.Add(6*time.Minute)),
+		"expected secret changed to be %v but got %v")
+	fake.password = "p2"
+	assert.False(t, cfg.secrets.refresh(now.Add(7*time.Minute)),
+		"expected secret changed to be %v but got %v")
+	assert.True(t, cfg.secrets.refresh(now.Add(12*time.Minute)),
+		"expected secret changed to be %v but got %v")
+
+	_, err = loadVaultConfig(t, `{jobs: [{name: "app",
+	exec: "app {{ vault "secret/data/app" "user" }}"}]}`)
+	if err == nil || !strings.HasSuffix(err.Error(),
+		"error calling vault: vault secret secret/data/app has no 'user' field") {
+		t.Fatalf("expected missing field error but got %v", err)
+	}
+
+	cfg, err = loadVaultConfig(t, `{consul: "consul:8500", jobs: [{name: "app", exec: "app"}]}`)
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, cfg.secrets, (*vaultSecrets)(nil),
+		"expected no secrets but got %v")
+}
+
+func TestVaultLease(t *testing.T) {
+	fake := &fakeVault{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	defer setVaultEnv(map[string]string{
+		"VAULT_ADDR": server.URL, "VAULT_ROLE_ID": "role", "VAULT_SECRET_ID": "secret"})()
+
+	cfg, err := loadVaultConfig(t, `{consul: "consul:8500", vault: {onRotate: "restart"},
+	jobs: [{name: "app", exec: "app {{ vault "database/creds/app" "username" }}"}]}`)
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, cfg.Jobs[0].Exec, "app user-1", "expected exec %q but got %q")
+	assert.Equal(t, fake.logins, 1, "expected %v logins but got %v")
+
+	// renewed at two thirds of the lease, and a rejected token logs in again
+	now := time.Now()
+	assert.False(t, cfg.secrets.refresh(now.Add(10*time.Second)),
+		"expected secret changed to be %v but got %v")
+	assert.Equal(t, fake.renewals, 0, "expected %v renewals but got %v")
+	fake.expired = true
+	assert.False(t, cfg.secrets.refresh(now.Add(25*time.Second)),
+		"expected secret changed to be %v but got %v")
+	assert.Equal(t, fake.renewals, 1, "expected %v renewals but got %v")
+	assert.Equal(t, fake.logins, 2, "expected %v logins but got %v")
+
+	// read again if the lease can't be renewed, but keep the old lease
+	// until the configuration is replaced
+	fake.renewErr = true
+	assert.True(t, cfg.secrets.refresh(now.Add(50*time.Second)),
+		"expected secret changed to be %v but got %v")
+	assert.Equal(t, fake.reads, 2, "expected %v reads but got %v")
+	assert.Equal(t, len(fake.revoked), 0, "expected %v revoked leases but got %v")
+
+	// a failed reload is retried until one succeeds and stops renewal
+	defer func(tick time.Duration) { vaultTick = tick }(vaultTick)
+	vaultTick = 10 * time.Millisecond
+	cfg.secrets.secrets["database/creds/app"].updated = time.Time{}
+	reloaded := make(chan bool, 2)
+	attempts := 0
+	cfg.RenewSecrets(func(restart bool) error {
+		if attempts++; attempts == 1 {
+			return fmt.Errorf("consul unreachable")
+		}
+		cfg.StopSecrets()
+		reloaded <- restart
+		return nil
+	})
+	defer cfg.StopSecrets()
+	select {
+	case restart := <-reloaded:
+		assert.True(t, restart, "expected restart to be %v but got %v")
+	case <-time.After(time.Second):
+		t.Fatal("expected the rotated secret to restart")
+	}
+	assert.Equal(t, len(fake.revoked), 0, "expected %v revoked leases but got %v")
+
+	// the new configuration reuses the lease that was just read, and the
+	// replaced configuration revokes the rest
+	f, err := ioutil.TempFile("", "vault")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{consul: "consul:8500", vault: {onRotate: "restart"},
+	jobs: [{name: "app", exec: "app {{ vault "database/creds/app" "username" }}"}]}`)
+	f.Close()
+	next, err := LoadConfigFrom(f.Name(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfigFrom: %v", err)
+	}
+	assert.Equal(t, next.Jobs[0].Exec, "app user-3", "expected exec %q but got %q")
+	assert.Equal(t, fake.reads, 3, "expected %v reads but got %v")
+	cfg.ReleaseSecrets(next)
+	assert.Equal(t, fake.revoked, []string{"database/creds/app/1",
+		"database/creds/app/2"}, "expected revoked leases %v but got %v")
+	next.ReleaseSecrets(nil)
+	assert.Equal(t, fake.revoked, []string{"database/creds/app/1",
+		"database/creds/app/2", "database/creds/app/3"},
+		"expected revoked leases %v but got %v")
+}
+
+func TestVaultControlConfig(t *testing.T) {
+	fake := &fakeVault{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	defer setVaultEnv(map[string]string{"VAULT_ADDR": server.URL,
+		"VAULT_ROLE_ID": "role", "VAULT_SECRET_ID": "secret",
+		"CONTAINERPILOT_CONTROL_TOKEN": "from-env"})()
+
+	f, err := ioutil.TempFile("", "vault")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{control: {socket: "/tmp/cp-vault.sock",
+	token: "{{ vault "database/creds/app" "username" }}"},
+	jobs: [{name: "app", exec: "app {{ vault "database/creds/app" "username" }}"}]}`)
+	f.Close()
+
+	// the subcommands don't read secrets from Vault
+	cfg, err := LoadControlConfig(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error in LoadControlConfig: %v", err)
+	}
+	assert.Equal(t, cfg.SocketPath, "/tmp/cp-vault.sock", "expected socket %q but got %q")
+	assert.Equal(t, cfg.Token, "from-env", "expected token %q but got %q")
+	assert.Equal(t, fake.logins, 0, "expected %v logins but got %v")
+	assert.Equal(t, fake.reads, 0, "expected %v reads but got %v")
+}
+
+func TestVaultConfigErrors(t *testing.T) {
+	_, err := newVaultConfig(map[string]interface{}{"onRotate": "exit"})
+	assert.Error(t, err, "vault.onRotate must be one of 'reload', 'restart', "+
+		"or 'none' but got 'exit'")
+	_, err = newVaultConfig(map[string]interface{}{"interval": "0s"})
+	assert.Error(t, err, "invalid vault.interval '0s': must be a positive duration")
+
+	os.Unsetenv("VAULT_ADDR")
+	_, err = ApplyTemplate([]byte(`{{ vault "secret/data/app" "password" }}`))
+	if err == nil {
+		t.Fatal("expected error without VAULT_ADDR")
+	}
+	defer setVaultEnv(map[string]string{"VAULT_ADDR": "http://vault:8200"})()
+	_, err = newVaultClient()
+	assert.Error(t, err, "vault: one of VAULT_TOKEN, VAULT_TOKEN_FILE, "+
+		"VAULT_ROLE_ID, or VAULT_K8S_ROLE must be set")
+}
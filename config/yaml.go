@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the configuration file
+const (
+	formatJSON5 = "json5"
+	formatYAML  = "yaml"
+)
+
+// Format is the format of the configuration file, "json5" or "yaml", as
+// set by the -config-format flag. If it's empty, files with a ".yaml" or
+// ".yml" extension are parsed as YAML and any others as JSON5.
+var Format string
+
+// configFormat returns the format of the configuration file
+func configFormat(configFlag string) (string, error) {
+	switch strings.ToLower(Format) {
+	case formatJSON5, "json":
+		return formatJSON5, nil
+	case formatYAML, "yml":
+		return formatYAML, nil
+	case "":
+		switch strings.ToLower(filepath.Ext(configPath(configFlag))) {
+		case ".yaml", ".yml":
+			return formatYAML, nil
+		}
+		return formatJSON5, nil
+	}
+	return "", fmt.Errorf(
+		"unknown config format '%s': accepts 'json5' or 'yaml'", Format)
+}
+
+// unmarshalYAML parses a YAML configuration. The result is converted to
+// JSON and back so that it has the same types as a JSON5 configuration,
+// ex. every number is a float64 and every object is a
+// map[string]interface{}.
+func unmarshalYAML(data []byte) (map[string]interface{}, error) {
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("could not parse configuration: %v", err)
+	}
+	asJSON, err := json.Marshal(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse configuration: %v", err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(asJSON, &config); err != nil {
+		return nil, fmt.Errorf("could not parse configuration: %v", err)
+	}
+	return config, nil
+}
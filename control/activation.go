@@ -0,0 +1,142 @@
+package control
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// listenFdsStart is the first file descriptor passed to us by socket
+// activation, after stdin, stdout, and stderr
+const listenFdsStart = 3
+
+var errListenerClosed = errors.New("control: listener closed")
+
+var (
+	activated     *sharedListener
+	activatedOnce sync.Once
+)
+
+// activatedListener returns the listener passed to us by a supervisor such
+// as systemd via LISTEN_PID and LISTEN_FDS, or nil if we weren't socket
+// activated. The environment is only read once, and the variables are
+// unset so that they aren't inherited by our jobs.
+func activatedListener() *sharedListener {
+	activatedOnce.Do(func() {
+		count := listenFds(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"))
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		if count == 0 {
+			return
+		}
+		if count > 1 {
+			log.Warnf("control: received %d activated sockets, "+
+				"only the first will be used", count)
+		}
+		file := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_3")
+		ln, err := net.FileListener(file)
+		// FileListener dups the fd, so close the original to keep it
+		// from leaking into our jobs
+		file.Close()
+		if err != nil {
+			log.Errorf("control: unable to use activated socket: %v", err)
+			return
+		}
+		activated = newSharedListener(ln)
+	})
+	return activated
+}
+
+// listenFds returns the number of activated sockets passed to this process
+func listenFds(pid, fds string) int {
+	if pid == "" || fds == "" {
+		return 0
+	}
+	if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+		return 0
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return 0
+	}
+	return count
+}
+
+// isAbstractSocket returns true for Linux abstract namespace socket
+// addresses, which have no socket file on disk
+func isAbstractSocket(socketType, addr string) bool {
+	return socketType == "unix" && strings.HasPrefix(addr, "@")
+}
+
+// sharedListener accepts connections from a listener that must outlive the
+// control server, because it can't be reopened after a reload. Each control
+// server takes connections from it via a handoffListener.
+type sharedListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func newSharedListener(ln net.Listener) *sharedListener {
+	sl := &sharedListener{Listener: ln, conns: make(chan net.Conn)}
+	go sl.run()
+	return sl
+}
+
+func (sl *sharedListener) run() {
+	defer close(sl.conns)
+	for {
+		conn, err := sl.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			log.Errorf("control: activated socket closed: %v", err)
+			return
+		}
+		sl.conns <- conn
+	}
+}
+
+// handoff returns a listener for a single control server. Closing it
+// doesn't close the shared listener.
+func (sl *sharedListener) handoff() net.Listener {
+	return &handoffListener{shared: sl, done: make(chan struct{})}
+}
+
+type handoffListener struct {
+	shared *sharedListener
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (hl *handoffListener) Accept() (net.Conn, error) {
+	select {
+	case <-hl.done:
+		return nil, errListenerClosed
+	default:
+	}
+	select {
+	case conn, ok := <-hl.shared.conns:
+		if !ok {
+			return nil, errListenerClosed
+		}
+		return conn, nil
+	case <-hl.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (hl *handoffListener) Close() error {
+	hl.once.Do(func() { close(hl.done) })
+	return nil
+}
+
+func (hl *handoffListener) Addr() net.Addr {
+	return hl.shared.Addr()
+}
@@ -0,0 +1,52 @@
+package control
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestListenFds(t *testing.T) {
+	pid := fmt.Sprintf("%d", os.Getpid())
+	assert.Equal(t, listenFds(pid, "1"), 1, "expected %v but got %v")
+	assert.Equal(t, listenFds(pid, ""), 0, "expected %v but got %v")
+	assert.Equal(t, listenFds(pid, "x"), 0, "expected %v but got %v")
+	assert.Equal(t, listenFds("1", "1"), 0, "expected %v for other pid but got %v")
+	assert.Equal(t, listenFds("", "1"), 0, "expected %v but got %v")
+}
+
+// the activated listener must survive the control server being stopped
+// and replaced on reload
+func TestServerActivatedSocket(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	shared := newSharedListener(ln)
+	addr := ln.Addr().String()
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial:              tcpDialer(addr),
+			DisableKeepAlives: true,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		s := SetupHTTPServer(t, `{}`)
+		s.activated = shared
+		s.Start()
+		resp, err := client.Get("http://control/v3/xxxx")
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusNotFound,
+			"expected %v but got %v")
+		s.Stop()
+	}
+}
@@ -0,0 +1,153 @@
+package control
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AuditEntry records a single request that may have changed ContainerPilot's
+// state. The payload is summarized by its top-level JSON keys so that we
+// never write the values (which may be secrets) to the log.
+type AuditEntry struct {
+	Time      string   `json:"time"`
+	RequestID string   `json:"requestId"`
+	Remote    string   `json:"remote"`
+	UserAgent string   `json:"userAgent,omitempty"`
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	Payload   []string `json:"payload,omitempty"`
+	Status    int      `json:"status"`
+	Duration  string   `json:"duration"`
+}
+
+// AuditLog wraps an http.Handler and assigns a request ID to each request
+// that may mutate state (anything but GET and HEAD). These requests are
+// logged along with their outcome, and appended as JSON to the File if set.
+type AuditLog struct {
+	File    string
+	Handler http.Handler
+
+	lock *sync.Mutex
+}
+
+// NewAuditLog creates an AuditLog for the handler, writing entries to file
+// if it's non-empty
+func NewAuditLog(file string, handler http.Handler) AuditLog {
+	return AuditLog{File: file, Handler: handler, lock: &sync.Mutex{}}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (a AuditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		a.Handler.ServeHTTP(w, r)
+		return
+	}
+	start := time.Now()
+	entry := AuditEntry{
+		Time:      start.UTC().Format(time.RFC3339),
+		RequestID: newRequestID(),
+		Remote:    r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+	}
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err == nil {
+			entry.Payload = summarizePayload(body)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if entry.Remote == "" || entry.Remote == "@" {
+		entry.Remote = "unix"
+	}
+	w.Header().Set("X-Request-Id", entry.RequestID)
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	a.Handler.ServeHTTP(rec, r)
+
+	entry.Status = rec.status
+	entry.Duration = time.Since(start).String()
+	log.WithFields(log.Fields{
+		"requestId": entry.RequestID,
+		"remote":    entry.Remote,
+		"payload":   entry.Payload,
+		"status":    entry.Status,
+	}).Infof("control: %s %s", entry.Method, entry.Path)
+	a.write(entry)
+}
+
+// write appends the entry to the audit file. We open the file for each
+// entry so that it can be rotated without reloading ContainerPilot.
+func (a AuditLog) write(entry AuditEntry) {
+	if a.File == "" {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("control: unable to serialize audit entry: %v", err)
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	f, err := os.OpenFile(a.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Errorf("control: unable to open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Errorf("control: unable to write audit log: %v", err)
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// summarizePayload returns the sorted top-level keys of a JSON object, or
+// a description of any other payload
+func summarizePayload(body []byte) []string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err == nil {
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	var arr []interface{}
+	if err := json.Unmarshal(body, &arr); err == nil {
+		return []string{fmt.Sprintf("<%d items>", len(arr))}
+	}
+	return []string{fmt.Sprintf("<%d bytes>", len(body))}
+}
@@ -0,0 +1,65 @@
+package control
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	f, _ := ioutil.TempFile("", "containerpilot-audit")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	var gotBody string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	})
+	audit := NewAuditLog(f.Name(), handler)
+
+	body := `{"DB_PASSWORD": "s3cret", "APP": "x"}`
+	req := httptest.NewRequest("POST", "/v3/environ", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	audit.ServeHTTP(w, req)
+	assert.Equal(t, gotBody, body, "expected handler to get body %v but got %v")
+	requestID := w.Result().Header.Get("X-Request-Id")
+	assert.Equal(t, len(requestID), 16, "expected request ID of length %v but got %v")
+
+	// reads aren't audited
+	audit.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest("GET", "/v3/status", nil))
+
+	raw, _ := ioutil.ReadFile(f.Name())
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	assert.Equal(t, len(lines), 1, "expected %v audit entries but got %v")
+	if strings.Contains(lines[0], "s3cret") {
+		t.Fatalf("audit entry contains payload value: %s", lines[0])
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("unable to parse audit entry: %v", err)
+	}
+	assert.Equal(t, entry.RequestID, requestID, "expected request ID %v but got %v")
+	assert.Equal(t, entry.Path, "/v3/environ", "expected path %v but got %v")
+	assert.Equal(t, entry.Payload, []string{"APP", "DB_PASSWORD"},
+		"expected payload %v but got %v")
+	assert.Equal(t, entry.Status, http.StatusUnprocessableEntity,
+		"expected status %v but got %v")
+}
+
+func TestSummarizePayload(t *testing.T) {
+	assert.Equal(t, summarizePayload([]byte(`[1, 2]`)), []string{"<2 items>"},
+		"expected %v but got %v")
+	assert.Equal(t, summarizePayload([]byte(`{{`)), []string{"<2 bytes>"},
+		"expected %v but got %v")
+	assert.Equal(t, len(summarizePayload([]byte(" \n"))), 0,
+		"expected %v items but got %v")
+}
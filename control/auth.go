@@ -0,0 +1,59 @@
+package control
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// TokenEnv is the environment variable used for the control plane token
+// if control.token isn't set in the config
+var TokenEnv = "CONTAINERPILOT_CONTROL_TOKEN"
+
+// protectedReads are the paths whose GET requests always require the token,
+// because they expose the environment (including the token itself), the
+// rendered configuration, or the output of jobs
+var protectedReads = []string{"/v3/environ", "/v3/config", "/v3/jobs/"}
+
+// TokenAuth wraps an http.Handler and requires requests to carry the shared
+// secret token as a bearer token in their Authorization header. Requests that
+// only read state (GET and HEAD) are passed through unless ProtectReads is set
+// or they read one of the protectedReads.
+type TokenAuth struct {
+	Token        string
+	ProtectReads bool
+	Handler      http.Handler
+}
+
+func (a TokenAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.ProtectReads && !isProtectedRead(r.URL.Path) &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		a.Handler.ServeHTTP(w, r)
+		return
+	}
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized),
+			http.StatusUnauthorized)
+		return
+	}
+	a.Handler.ServeHTTP(w, r)
+}
+
+func (a TokenAuth) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+func isProtectedRead(path string) bool {
+	for _, prefix := range protectedReads {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
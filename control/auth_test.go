@@ -0,0 +1,64 @@
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestTokenAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	testPath := func(auth TokenAuth, method, path, header string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		auth.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+	testFunc := func(auth TokenAuth, method, header string) int {
+		return testPath(auth, method, "/v3/reload", header)
+	}
+	auth := TokenAuth{Token: "s3cret", Handler: ok}
+
+	t.Run("POST without token", func(t *testing.T) {
+		status := testFunc(auth, "POST", "")
+		assert.Equal(t, status, http.StatusUnauthorized, "expected %v but got %v")
+	})
+	t.Run("POST with wrong token", func(t *testing.T) {
+		status := testFunc(auth, "POST", "Bearer wrong")
+		assert.Equal(t, status, http.StatusUnauthorized, "expected %v but got %v")
+	})
+	t.Run("POST with token but wrong scheme", func(t *testing.T) {
+		status := testFunc(auth, "POST", "Basic s3cret")
+		assert.Equal(t, status, http.StatusUnauthorized, "expected %v but got %v")
+	})
+	t.Run("POST with token", func(t *testing.T) {
+		status := testFunc(auth, "POST", "Bearer s3cret")
+		assert.Equal(t, status, http.StatusOK, "expected %v but got %v")
+	})
+	t.Run("GET without token", func(t *testing.T) {
+		status := testFunc(auth, "GET", "")
+		assert.Equal(t, status, http.StatusOK, "expected %v but got %v")
+	})
+	t.Run("GET without token protectReads", func(t *testing.T) {
+		auth := TokenAuth{Token: "s3cret", ProtectReads: true, Handler: ok}
+		status := testFunc(auth, "GET", "")
+		assert.Equal(t, status, http.StatusUnauthorized, "expected %v but got %v")
+		status = testFunc(auth, "GET", "Bearer s3cret")
+		assert.Equal(t, status, http.StatusOK, "expected %v but got %v")
+	})
+	t.Run("GET environ, config, and logs without token", func(t *testing.T) {
+		for _, path := range []string{"/v3/environ", "/v3/config", "/v3/jobs/app/logs"} {
+			status := testPath(auth, "GET", path, "")
+			assert.Equal(t, status, http.StatusUnauthorized, "expected %v but got %v")
+			status = testPath(auth, "GET", path, "Bearer s3cret")
+			assert.Equal(t, status, http.StatusOK, "expected %v but got %v")
+		}
+	})
+}
@@ -2,6 +2,12 @@ package control
 
 import (
 	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/joyent/containerpilot/utils"
 )
@@ -9,23 +15,213 @@ import (
 // DefaultSocket is the default location of the unix domain socket file
 var DefaultSocket = "/var/run/containerpilot.socket"
 
+// DefaultRedactPattern matches the names of environment variables whose
+// values are redacted by GET /v3/environ?redact=true
+var DefaultRedactPattern = `(?i)(secret|passw(or)?d|token|key|credential)`
+
 // Config represents the location on the file system which serves the Unix
-// control socket file.
+// control socket file, or the TCP address the control server listens on.
 type Config struct {
-	SocketPath string `mapstructure:"socket"`
+	SocketPath string     `mapstructure:"socket"`
+	SocketType string     `mapstructure:"socketType"`
+	TLS        *TLSConfig `mapstructure:"tls"`
+
+	SocketMode  string `mapstructure:"socketMode"`
+	SocketUser  string `mapstructure:"socketUser"`
+	SocketGroup string `mapstructure:"socketGroup"`
+	socketPerms socketPerms
+
+	Token        string `mapstructure:"token"`
+	ProtectReads bool   `mapstructure:"protectReads"`
+
+	RedactPattern string `mapstructure:"redactPattern"`
+	redact        *regexp.Regexp
+
+	DrainPeriod string `mapstructure:"drainPeriod"`
+	drainPeriod time.Duration
+
+	Metrics bool `mapstructure:"metrics"`
+
+	AuditLog string `mapstructure:"auditLog"`
+
+	RateLimits map[string]float64 `mapstructure:"rateLimits"`
+
+	GRPCSocket string `mapstructure:"grpcSocket"`
+
+	HealthFile         string `mapstructure:"healthFile"`
+	HealthFileInterval string `mapstructure:"healthFileInterval"`
+	healthFileInterval time.Duration
 }
 
+// DefaultDrainPeriod is how long POST /v3/drain waits between deregistering
+// services and stopping their jobs
+var DefaultDrainPeriod = "10s"
+
 // NewConfig parses a json config into a validated Config used by control
 // Server.
 func NewConfig(raw interface{}) (*Config, error) {
-	cfg := &Config{SocketPath: DefaultSocket} // defaults
+	cfg := &Config{
+		SocketPath:    DefaultSocket,
+		SocketType:    SocketType,
+		RedactPattern: DefaultRedactPattern,
+		Token:         os.Getenv(TokenEnv),
+		DrainPeriod:   DefaultDrainPeriod,
+
+		HealthFileInterval: DefaultHealthFileInterval,
+	} // defaults
 	if raw == nil {
+		cfg.redact = regexp.MustCompile(DefaultRedactPattern)
+		cfg.drainPeriod, _ = utils.GetTimeout(DefaultDrainPeriod)
 		return cfg, nil
 	}
 
 	if err := utils.DecodeRaw(raw, cfg); err != nil {
 		return nil, fmt.Errorf("control config parsing error: %v", err)
 	}
-
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
+
+// Validate ensures that the listener type is supported and that the
+// socket address is valid for that listener type.
+func (cfg *Config) Validate() error {
+	switch cfg.SocketType {
+	case "unix":
+		if cfg.SocketPath == "" {
+			return fmt.Errorf("control.socket must not be blank")
+		}
+	case "tcp":
+		if cfg.SocketPath == DefaultSocket {
+			return fmt.Errorf(
+				"control.socket must be set to a TCP address when socketType is 'tcp'")
+		}
+		if _, err := net.ResolveTCPAddr("tcp", cfg.SocketPath); err != nil {
+			return fmt.Errorf("invalid control.socket TCP address '%s': %v",
+				cfg.SocketPath, err)
+		}
+	default:
+		return fmt.Errorf(
+			"invalid control.socketType '%s': accepts 'unix' or 'tcp'",
+			cfg.SocketType)
+	}
+	if err := cfg.validateSocketPerms(); err != nil {
+		return err
+	}
+	if cfg.TLS != nil {
+		if err := cfg.TLS.Validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.GRPCSocket != "" && cfg.GRPCSocket == cfg.SocketPath {
+		return fmt.Errorf("control.grpcSocket must not be the same as control.socket")
+	}
+	if cfg.ProtectReads && cfg.Token == "" {
+		return fmt.Errorf("control.token must be set if 'protectReads' is set")
+	}
+	redact, err := regexp.Compile(cfg.RedactPattern)
+	if err != nil {
+		return fmt.Errorf("invalid control.redactPattern '%s': %v",
+			cfg.RedactPattern, err)
+	}
+	cfg.redact = redact
+
+	drainPeriod, err := utils.GetTimeout(cfg.DrainPeriod)
+	if err != nil {
+		return fmt.Errorf("unable to parse control.drainPeriod '%s': %v",
+			cfg.DrainPeriod, err)
+	}
+	cfg.drainPeriod = drainPeriod
+
+	for endpoint, rate := range cfg.RateLimits {
+		if rate <= 0 {
+			return fmt.Errorf("invalid control.rateLimits '%s': "+
+				"must be a positive number of requests per second", endpoint)
+		}
+	}
+
+	interval, err := utils.GetTimeout(cfg.HealthFileInterval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid control.healthFileInterval '%s': "+
+			"must be a positive duration", cfg.HealthFileInterval)
+	}
+	cfg.healthFileInterval = interval
+	return nil
+}
+
+// socketPerms are the file mode and ownership applied to the unix socket
+// file after we start listening. The zero value leaves the socket file
+// unchanged, and a uid or gid of -1 leaves that ID unchanged.
+type socketPerms struct {
+	mode  os.FileMode
+	chown bool
+	uid   int
+	gid   int
+}
+
+func (cfg *Config) validateSocketPerms() error {
+	cfg.socketPerms = socketPerms{}
+	if cfg.SocketMode == "" && cfg.SocketUser == "" && cfg.SocketGroup == "" {
+		return nil
+	}
+	if cfg.SocketType != "unix" {
+		return fmt.Errorf("control.socketMode, socketUser, and socketGroup " +
+			"are only valid when socketType is 'unix'")
+	}
+	if isAbstractSocket(cfg.SocketType, cfg.SocketPath) {
+		return fmt.Errorf("control.socketMode, socketUser, and socketGroup " +
+			"are not valid for abstract sockets")
+	}
+	if cfg.SocketMode != "" {
+		mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("invalid control.socketMode '%s': "+
+				"must be an octal file mode such as '0660'", cfg.SocketMode)
+		}
+		cfg.socketPerms.mode = os.FileMode(mode)
+	}
+	cfg.socketPerms.uid, cfg.socketPerms.gid = -1, -1
+	cfg.socketPerms.chown = cfg.SocketUser != "" || cfg.SocketGroup != ""
+	if cfg.SocketUser != "" {
+		uid, err := lookupID(cfg.SocketUser, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("invalid control.socketUser '%s': %v",
+				cfg.SocketUser, err)
+		}
+		cfg.socketPerms.uid = uid
+	}
+	if cfg.SocketGroup != "" {
+		gid, err := lookupID(cfg.SocketGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("invalid control.socketGroup '%s': %v",
+				cfg.SocketGroup, err)
+		}
+		cfg.socketPerms.gid = gid
+	}
+	return nil
+}
+
+// lookupID accepts either a numeric ID or a name to resolve with lookup
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
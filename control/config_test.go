@@ -1,10 +1,13 @@
 package control
 
 import (
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/assert"
 )
 
 func TestControlConfigDefault(t *testing.T) {
@@ -35,3 +38,146 @@ func TestControlConfigParse(t *testing.T) {
 	}
 }
 
+func TestControlConfigParseTCP(t *testing.T) {
+	testRaw := tests.DecodeRaw(
+		`{ "socket": "127.0.0.1:2812", "socketType": "tcp" }`)
+	cfg, err := NewConfig(testRaw)
+	if err != nil {
+		t.Fatalf("could not parse control config JSON: %s", err)
+	}
+	if cfg.SocketType != "tcp" || cfg.SocketPath != "127.0.0.1:2812" {
+		t.Fatalf("parsed TCP listener does not match: %+v", cfg)
+	}
+}
+
+func TestControlConfigValidation(t *testing.T) {
+	_, err := NewConfig(tests.DecodeRaw(`{ "socketType": "udp" }`))
+	assert.Error(t, err,
+		"invalid control.socketType 'udp': accepts 'unix' or 'tcp'")
+
+	_, err = NewConfig(tests.DecodeRaw(`{ "socketType": "tcp" }`))
+	assert.Error(t, err,
+		"control.socket must be set to a TCP address when socketType is 'tcp'")
+
+	_, err = NewConfig(tests.DecodeRaw(
+		`{ "socket": "localhost", "socketType": "tcp" }`))
+	if err == nil {
+		t.Fatal("expected error for TCP address without port")
+	}
+	_, err = NewConfig(tests.DecodeRaw(
+		`{ "socket": "/tmp/cp.sock", "grpcSocket": "/tmp/cp.sock" }`))
+	assert.Error(t, err,
+		"control.grpcSocket must not be the same as control.socket")
+}
+
+func TestControlConfigRedactPattern(t *testing.T) {
+	_, err := NewConfig(tests.DecodeRaw(`{ "redactPattern": "(" }`))
+	if err == nil {
+		t.Fatal("expected error for invalid redactPattern")
+	}
+	cfg, err := NewConfig(tests.DecodeRaw(`{ "redactPattern": "^MY_" }`))
+	if err != nil {
+		t.Fatalf("could not parse control config JSON: %s", err)
+	}
+	assert.True(t, cfg.redact.MatchString("MY_VAR"),
+		"expected redact pattern to match: %v but got %v")
+}
+
+func TestControlConfigToken(t *testing.T) {
+	os.Setenv(TokenEnv, "from-env")
+	defer os.Unsetenv(TokenEnv)
+
+	cfg, err := NewConfig(nil)
+	if err != nil {
+		t.Fatalf("could not parse control config JSON: %s", err)
+	}
+	assert.Equal(t, cfg.Token, "from-env", "expected token %v but got %v")
+
+	cfg, err = NewConfig(tests.DecodeRaw(`{ "token": "from-config" }`))
+	if err != nil {
+		t.Fatalf("could not parse control config JSON: %s", err)
+	}
+	assert.Equal(t, cfg.Token, "from-config", "expected token %v but got %v")
+
+	os.Unsetenv(TokenEnv)
+	_, err = NewConfig(tests.DecodeRaw(`{ "protectReads": true }`))
+	assert.Error(t, err, "control.token must be set if 'protectReads' is set")
+}
+
+func TestControlConfigSocketPerms(t *testing.T) {
+	cfg, err := NewConfig(tests.DecodeRaw(
+		`{ "socketMode": "0660", "socketUser": "0", "socketGroup": "root" }`))
+	if err != nil {
+		t.Fatalf("could not parse control config JSON: %s", err)
+	}
+	expected := socketPerms{mode: 0660, chown: true, uid: 0, gid: 0}
+	assert.Equal(t, cfg.socketPerms, expected, "expected %v but got %v")
+
+	cfg, _ = NewConfig(tests.DecodeRaw(`{ "socketGroup": "12" }`))
+	expected = socketPerms{chown: true, uid: -1, gid: 12}
+	assert.Equal(t, cfg.socketPerms, expected, "expected %v but got %v")
+
+	_, err = NewConfig(tests.DecodeRaw(`{ "socketMode": "rw-rw----" }`))
+	assert.Error(t, err, "invalid control.socketMode 'rw-rw----': "+
+		"must be an octal file mode such as '0660'")
+
+	_, err = NewConfig(tests.DecodeRaw(
+		`{ "socketUser": "no-such-user-containerpilot" }`))
+	if err == nil {
+		t.Fatal("expected error for unknown socketUser")
+	}
+
+	_, err = NewConfig(tests.DecodeRaw(
+		`{ "socket": "127.0.0.1:2812", "socketType": "tcp", "socketMode": "0660" }`))
+	assert.Error(t, err, "control.socketMode, socketUser, and socketGroup "+
+		"are only valid when socketType is 'unix'")
+
+	_, err = NewConfig(tests.DecodeRaw(
+		`{ "socket": "@containerpilot", "socketMode": "0660" }`))
+	assert.Error(t, err, "control.socketMode, socketUser, and socketGroup "+
+		"are not valid for abstract sockets")
+}
+
+func TestControlConfigDrainPeriod(t *testing.T) {
+	cfg, _ := NewConfig(nil)
+	assert.Equal(t, cfg.drainPeriod, 10*time.Second, "expected %v but got %v")
+
+	cfg, err := NewConfig(tests.DecodeRaw(`{ "drainPeriod": "30s" }`))
+	if err != nil {
+		t.Fatalf("could not parse control config JSON: %s", err)
+	}
+	assert.Equal(t, cfg.drainPeriod, 30*time.Second, "expected %v but got %v")
+
+	_, err = NewConfig(tests.DecodeRaw(`{ "drainPeriod": "soon" }`))
+	if err == nil {
+		t.Fatal("expected error for invalid drainPeriod")
+	}
+}
+
+func TestControlConfigRateLimits(t *testing.T) {
+	cfg, err := NewConfig(tests.DecodeRaw(
+		`{ "rateLimits": { "default": 10, "/v3/reload": 0.2 }}`))
+	if err != nil {
+		t.Fatalf("could not parse control config JSON: %s", err)
+	}
+	assert.Equal(t, cfg.RateLimits["/v3/reload"], 0.2, "expected %v but got %v")
+	assert.Equal(t, cfg.RateLimits["default"], 10.0, "expected %v but got %v")
+
+	_, err = NewConfig(tests.DecodeRaw(`{ "rateLimits": { "default": 0 }}`))
+	assert.Error(t, err, "invalid control.rateLimits 'default': "+
+		"must be a positive number of requests per second")
+}
+
+func TestControlConfigHealthFile(t *testing.T) {
+	cfg, err := NewConfig(tests.DecodeRaw(
+		`{ "healthFile": "/tmp/containerpilot.health" }`))
+	if err != nil {
+		t.Fatalf("could not parse control config JSON: %s", err)
+	}
+	assert.Equal(t, cfg.healthFileInterval, 5*time.Second,
+		"expected %v but got %v")
+
+	_, err = NewConfig(tests.DecodeRaw(`{ "healthFileInterval": "0s" }`))
+	assert.Error(t, err, "invalid control.healthFileInterval '0s': "+
+		"must be a positive duration")
+}
@@ -2,25 +2,50 @@ package control
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"net/http"
 	"os"
+	"regexp"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
 )
 
 // SocketType is the default listener type
 var SocketType = "unix"
 
 // HTTPServer contains the state of the HTTP Server used by ContainerPilot's
-// HTTP transport control plane. Currently this is listening via a UNIX socket
-// file.
+// HTTP transport control plane. This listens via a UNIX socket file by
+// default, or via a TCP address if configured.
 type HTTPServer struct {
 	http.Server
 	Addr                string
+	SocketType          string
+	Jobs                []*jobs.Job
+	Version             string
+	GitHash             string
+	Config              interface{}  // the loaded config, for GET /v3/config
+	Reload              func() error // reloads the config, for POST /v3/reload
+	endpoints           *Endpoints
+	redact              *regexp.Regexp
+	stream              *eventStream
+	token               string
+	protectReads        bool
+	socketPerms         socketPerms
+	drainPeriod         time.Duration
+	metrics             bool
+	auditLog            string
+	rateLimits          map[string]float64
+	activated           *sharedListener
+	healthFile          *healthFile
+	grpcAddr            string
+	grpc                *grpc.Server
 	events.EventHandler // Event handling
 }
 
@@ -31,8 +56,38 @@ func NewHTTPServer(cfg *Config) (*HTTPServer, error) {
 		err := errors.New("control server not loading due to missing config")
 		return nil, err
 	}
+	socketType := cfg.SocketType
+	if socketType == "" {
+		socketType = SocketType
+	}
+	redact := cfg.redact
+	if redact == nil {
+		redact = regexp.MustCompile(DefaultRedactPattern)
+	}
 	srv := &HTTPServer{
-		Addr: cfg.SocketPath,
+		Addr:       cfg.SocketPath,
+		SocketType: socketType,
+		redact:     redact,
+		stream:     newEventStream(),
+
+		token:        cfg.Token,
+		protectReads: cfg.ProtectReads,
+		socketPerms:  cfg.socketPerms,
+		drainPeriod:  cfg.drainPeriod,
+		metrics:      cfg.Metrics,
+		auditLog:     cfg.AuditLog,
+		rateLimits:   cfg.RateLimits,
+		activated:    activatedListener(),
+		grpcAddr:     cfg.GRPCSocket,
+	}
+	if cfg.TLS != nil {
+		srv.TLSConfig = cfg.TLS.ServerConfig()
+	}
+	if cfg.HealthFile != "" {
+		srv.healthFile = &healthFile{
+			path:     cfg.HealthFile,
+			interval: cfg.healthFileInterval,
+		}
 	}
 	srv.Rx = make(chan events.Event, 10)
 	return srv, nil
@@ -46,37 +101,124 @@ func (srv *HTTPServer) Run(bus *events.EventBus) {
 
 	go func() {
 		defer srv.Stop()
+		var tick <-chan time.Time
+		if srv.healthFile != nil {
+			ticker := time.NewTicker(srv.healthFile.interval)
+			defer ticker.Stop()
+			tick = ticker.C
+			srv.healthFile.update(srv.endpoints.getJobs())
+		}
 		for {
-			event := <-srv.Rx
-			switch event {
-			case
-				events.QuitByClose,
-				events.GlobalShutdown:
-				return
+			select {
+			case event := <-srv.Rx:
+				srv.stream.publish(event)
+				switch event {
+				case
+					events.QuitByClose,
+					events.GlobalShutdown:
+					return
+				}
+				if srv.healthFile != nil && healthChanged(event) {
+					srv.healthFile.update(srv.endpoints.getJobs())
+				}
+			case <-tick:
+				srv.healthFile.update(srv.endpoints.getJobs())
 			}
 		}
 	}()
 }
 
+// healthChanged returns true if the event may have changed the health of
+// a job, so that the health file has to be written again
+func healthChanged(event events.Event) bool {
+	switch event.Code {
+	case events.StatusHealthy, events.StatusUnhealthy, events.Flapping,
+		events.EnterMaintenance, events.ExitMaintenance:
+		return true
+	}
+	return false
+}
+
 // Start sets up API routes with the event bus, listens on the control
 // socket, and serves the HTTP server.
 func (srv *HTTPServer) Start() {
-	endpoints := &Endpoints{srv.Bus}
+	endpoints := &Endpoints{
+		bus:    srv.Bus,
+		jobs:   srv.Jobs,
+		reload: srv.Reload,
+		redact: srv.redact,
+		stream: srv.stream,
+
+		version:     srv.Version,
+		gitHash:     srv.GitHash,
+		drainPeriod: srv.drainPeriod,
+		config:      srv.Config,
+	}
+	srv.endpoints = endpoints
 
 	router := http.NewServeMux()
-	router.Handle("/v3/environ", PostHandler(endpoints.PutEnviron))
-	router.Handle("/v3/reload", PostHandler(endpoints.PostReload))
-	router.Handle("/v3/metric", PostHandler(endpoints.PostMetric))
-	router.Handle("/v3/maintenance/enable",
+	handle := func(pattern string, handler http.Handler) {
+		router.Handle(pattern, instrument(pattern, handler))
+	}
+	handle("/v3/environ", MethodHandler{
+		http.MethodGet:  GetHandler(endpoints.GetEnviron),
+		http.MethodPost: PostHandler(endpoints.PutEnviron),
+	})
+	handle("/v3/reload", &reloadGuard{
+		bus:     srv.Bus,
+		handler: PostHandler(endpoints.PostReload),
+	})
+	handle("/v3/metric", PostHandler(endpoints.PostMetric))
+	handle("/v3/maintenance/enable",
 		PostHandler(endpoints.PostEnableMaintenanceMode))
-	router.Handle("/v3/maintenance/disable",
+	handle("/v3/maintenance/disable",
 		PostHandler(endpoints.PostDisableMaintenanceMode))
+	handle("/v3/status", GetHandler(endpoints.GetStatus))
+	// streams stay open for as long as the client wants, so their
+	// latency isn't meaningful
+	router.HandleFunc("/v3/events/stream", endpoints.GetEventStream)
+	handle("/v3/events/", PostHandler(endpoints.PostEvent))
+	handle("/v3/jobs/", MethodHandler{
+		http.MethodGet:  GetHandler(endpoints.GetJobLogs),
+		http.MethodPost: PostHandler(endpoints.PostJobAction),
+	})
+	handle("/v3/checks/", MethodHandler{
+		http.MethodGet:  GetHandler(endpoints.GetCheckResult),
+		http.MethodPost: PostHandler(endpoints.PostCheckAction),
+	})
+	handle("/v3/drain", PostHandler(endpoints.PostDrain))
+	handle("/v3/version", GetHandler(endpoints.GetVersion))
+	handle("/v3/config", GetHandler(endpoints.GetConfig))
+	router.HandleFunc("/", NotFound)
+	if srv.metrics {
+		// same handler as the telemetry server, so that a node-local
+		// agent can scrape via the control socket
+		router.Handle("/metrics", prometheus.Handler())
+	}
 
-	srv.Handler = router
+	var handler http.Handler = router
+	if len(srv.rateLimits) > 0 {
+		handler = RateLimit{Limits: srv.rateLimits, Handler: handler}
+	}
+	if srv.token != "" {
+		handler = TokenAuth{
+			Token:        srv.token,
+			ProtectReads: srv.protectReads,
+			Handler:      handler,
+		}
+	}
+	// audit outside of auth so that we record rejected requests too
+	srv.Handler = NewAuditLog(srv.auditLog, handler)
+	if srv.grpcAddr != "" {
+		srv.startGRPC(srv.Handler)
+	}
 	srv.SetKeepAlivesEnabled(false)
 	log.Debug("control: initialized router for control server")
 
-	ln := srv.listenWithRetry()
+	ln := srv.listen()
+	if srv.TLSConfig != nil {
+		ln = tls.NewListener(ln, srv.TLSConfig)
+	}
 
 	go func() {
 		log.Infof("control: serving at %s", srv.Addr)
@@ -86,25 +228,75 @@ func (srv *HTTPServer) Start() {
 
 }
 
+// Update replaces the Jobs and config of the running control server
+// after a reload that changed only the jobs and watches
+func (srv *HTTPServer) Update(jobs []*jobs.Job, config interface{}) {
+	srv.Jobs = jobs
+	srv.Config = config
+	if srv.endpoints != nil {
+		srv.endpoints.update(jobs, config)
+	}
+}
+
+// listen uses the activated socket if we were passed one, and otherwise
+// opens our own listener.
+func (srv *HTTPServer) listen() net.Listener {
+	if srv.activated != nil {
+		return srv.activated.handoff()
+	}
+	return srv.listenWithRetry()
+}
+
 // on a reload we can't guarantee that the control server will be shut down
-// and the socket file cleaned up before we're ready to start again, so we'll
-// retry with the listener a few times before bailing out.
+// and the socket file cleaned up (or the TCP port released) before we're
+// ready to start again, so we'll retry with the listener a few times before
+// bailing out.
 func (srv *HTTPServer) listenWithRetry() net.Listener {
 	var (
 		err error
 		ln  net.Listener
 	)
 	for i := 0; i < 10; i++ {
-		ln, err = net.Listen(SocketType, srv.Addr)
+		ln, err = listen(srv.SocketType, srv.Addr)
 		if err == nil {
+			if srv.hasSocketFile() {
+				srv.applySocketPerms(srv.Addr)
+			}
 			return ln
 		}
+		log.Debugf("control: unable to listen at %s, retrying: %v", srv.Addr, err)
 		time.Sleep(time.Second)
 	}
 	log.Fatalf("error listening to socket at %s: %v", srv.Addr, err)
 	return nil
 }
 
+// applySocketPerms applies the configured file mode and ownership to a
+// socket file. We only warn on failure because the control plane is still
+// usable by processes that already had access.
+func (srv *HTTPServer) applySocketPerms(path string) {
+	perms := srv.socketPerms
+	if perms.mode != 0 {
+		if err := os.Chmod(path, perms.mode); err != nil {
+			log.Warnf("control: unable to set mode of socket %s: %v",
+				path, err)
+		}
+	}
+	if perms.chown {
+		if err := os.Chown(path, perms.uid, perms.gid); err != nil {
+			log.Warnf("control: unable to set owner of socket %s: %v",
+				path, err)
+		}
+	}
+}
+
+// hasSocketFile returns true if we listen on a unix socket file of our own,
+// rather than an abstract or activated socket
+func (srv *HTTPServer) hasSocketFile() bool {
+	return srv.SocketType == "unix" && srv.activated == nil &&
+		!isAbstractSocket(srv.SocketType, srv.Addr)
+}
+
 // Stop shuts down the control server gracefully
 func (srv *HTTPServer) Stop() error {
 	// This timeout won't stop the configuration reload process, since that
@@ -116,7 +308,13 @@ func (srv *HTTPServer) Stop() error {
 	log.Debug("control: stopping control server")
 	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
 	defer cancel()
-	defer os.Remove(srv.Addr)
+	if srv.hasSocketFile() {
+		defer os.Remove(srv.Addr)
+	}
+	// streaming clients never go idle, so we need to disconnect them
+	// before the server can shut down gracefully
+	srv.stream.close()
+	srv.stopGRPC()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Warnf("control: failed to gracefully shutdown control server: %v", err)
 		return err
@@ -25,6 +25,12 @@ func socketDialer(tempSocketPath string) func(string, string) (net.Conn, error)
 	}
 }
 
+func tcpDialer(addr string) func(string, string) (net.Conn, error) {
+	return func(_, _ string) (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	}
+}
+
 func tempSocketPath() string {
 	filename := fmt.Sprintf("containerpilot-test-socket-%d", rand.Int())
 	return filepath.Join(os.TempDir(), filename)
@@ -84,3 +90,97 @@ func TestServerSmokeTest(t *testing.T) {
 		t.Fatalf("expected 404 but got %v\n%+v", resp.StatusCode, resp)
 	}
 }
+
+func TestServerSocketPerms(t *testing.T) {
+	tempSocketPath := tempSocketPath()
+	defer os.Remove(tempSocketPath)
+
+	s := SetupHTTPServer(t, fmt.Sprintf(
+		`{ "socket": %q, "socketMode": "0600" }`, tempSocketPath))
+	defer s.Stop()
+	s.Start()
+
+	info, err := os.Stat(tempSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600),
+		"expected socket mode %v but got %v")
+}
+
+func TestServerMetrics(t *testing.T) {
+	testFunc := func(t *testing.T, raw string) int {
+		tempSocketPath := tempSocketPath()
+		defer os.Remove(tempSocketPath)
+		s := SetupHTTPServer(t, fmt.Sprintf(raw, tempSocketPath))
+		defer s.Stop()
+		s.Start()
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: socketDialer(tempSocketPath),
+			},
+		}
+		resp, err := client.Get("http://control/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	status := testFunc(t, `{ "socket": %q, "metrics": true }`)
+	assert.Equal(t, status, http.StatusOK, "expected %v but got %v")
+	status = testFunc(t, `{ "socket": %q }`)
+	assert.Equal(t, status, http.StatusNotFound, "expected %v but got %v")
+}
+
+func TestServerSmokeTestTCP(t *testing.T) {
+	// grab a free port from the OS and then release it for the server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := SetupHTTPServer(t,
+		fmt.Sprintf(`{ "socket": %q, "socketType": "tcp" }`, addr))
+	defer s.Stop()
+	s.Start()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: tcpDialer(addr),
+		},
+	}
+	resp, err := client.Get("http://control/v3/xxxx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 but got %v\n%+v", resp.StatusCode, resp)
+	}
+}
+
+func TestServerAbstractSocket(t *testing.T) {
+	addr := fmt.Sprintf("@containerpilot-test-%d", rand.Int())
+	s := SetupHTTPServer(t, fmt.Sprintf(`{ "socket": %q }`, addr))
+	defer s.Stop()
+	s.Start()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: socketDialer(addr),
+		},
+	}
+	resp, err := client.Get("http://control/v3/xxxx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound, "expected %v but got %v")
+	if _, err := os.Stat(addr); !os.IsNotExist(err) {
+		t.Fatalf("expected no socket file for abstract socket: %v", err)
+	}
+}
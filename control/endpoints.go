@@ -7,15 +7,57 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/jobs"
+	"github.com/joyent/containerpilot/utils"
 )
 
-// Endpoints wraps the EventBus so we can bridge data across the App and
-// HTTPServer API boundary
+// Endpoints wraps the EventBus and Jobs so we can bridge data across the
+// App and HTTPServer API boundary
 type Endpoints struct {
-	bus *events.EventBus
+	bus    *events.EventBus
+	jobs   []*jobs.Job
+	reload func() error
+	redact *regexp.Regexp
+	stream *eventStream
+	lock   sync.RWMutex // guards jobs and config, which a reload replaces
+
+	version     string
+	gitHash     string
+	drainPeriod time.Duration
+	config      interface{}
+}
+
+const redactedValue = "<redacted>"
+
+func (e *Endpoints) getJobs() []*jobs.Job {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.jobs
+}
+
+func (e *Endpoints) getConfig() interface{} {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.config
+}
+
+// update replaces the Jobs and config after a reload that kept the
+// control server running
+func (e *Endpoints) update(jobs []*jobs.Job, config interface{}) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.jobs = jobs
+	e.config = config
 }
 
 // PostHandler is an adapter which allows a normal function to serve itself and
@@ -30,6 +72,40 @@ func (pw PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	resp, status := pw(r)
+	writeResponse(w, resp, status)
+}
+
+// GetHandler is an adapter which allows a normal function to serve itself and
+// handle incoming HTTP GET requests
+type GetHandler func(*http.Request) (interface{}, int)
+
+func (gw GetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failedStatus := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		return
+	}
+	resp, status := gw(r)
+	writeResponse(w, resp, status)
+}
+
+// MethodHandler dispatches requests for a single route to a handler for
+// each of the supported HTTP methods
+type MethodHandler map[string]http.Handler
+
+func (mh MethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := mh[r.Method]
+	if !ok {
+		failedStatus := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// writeResponse writes the response body as JSON if the handler succeeded
+// and returned a body, or writes the HTTP status text otherwise
+func writeResponse(w http.ResponseWriter, resp interface{}, status int) {
 	switch status {
 	case http.StatusOK:
 		if resp != nil {
@@ -47,9 +123,11 @@ func (pw PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // PutEnviron handles incoming HTTP POST requests containing JSON environment
 // variables and updates the environment of our current ContainerPilot
-// process. Returns empty response or HTTP422.
-func (e Endpoints) PutEnviron(r *http.Request) (interface{}, int) {
-	var postEnv map[string]string
+// process. An "unset" field holding a list of names removes those variables,
+// and the query parameter replace=true replaces the whole environment rather
+// than merging into it. Returns empty response or HTTP422.
+func (e *Endpoints) PutEnviron(r *http.Request) (interface{}, int) {
+	var postEnv map[string]json.RawMessage
 	jsonBlob, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
@@ -59,56 +137,167 @@ func (e Endpoints) PutEnviron(r *http.Request) (interface{}, int) {
 	if err != nil {
 		return nil, http.StatusUnprocessableEntity
 	}
-	for envKey, envValue := range postEnv {
-		os.Setenv(envKey, envValue)
+	env := make(map[string]string, len(postEnv))
+	var unset []string
+	for envKey, raw := range postEnv {
+		// for backwards compatibility a variable named "unset" can still
+		// be set with a string value
+		if envKey == "unset" && strings.HasPrefix(string(raw), "[") {
+			if err := json.Unmarshal(raw, &unset); err != nil {
+				return nil, http.StatusUnprocessableEntity
+			}
+			continue
+		}
+		var envValue string
+		if err := json.Unmarshal(raw, &envValue); err != nil {
+			return nil, http.StatusUnprocessableEntity
+		}
+		env[envKey] = envValue
 	}
+	replace := r.URL.Query().Get("replace") == "true"
+	// jobs and hooks aren't started while the environment is changed, so
+	// they never see it half updated
+	commands.UpdateEnviron(func() {
+		if replace {
+			for _, pair := range os.Environ() {
+				key := strings.SplitN(pair, "=", 2)[0]
+				if _, ok := env[key]; !ok {
+					os.Unsetenv(key)
+				}
+			}
+		}
+		for envKey, envValue := range env {
+			os.Setenv(envKey, envValue)
+		}
+		for _, envKey := range unset {
+			os.Unsetenv(envKey)
+		}
+	})
 	return nil, http.StatusOK
 }
 
+// GetEnviron handles incoming HTTP GET requests and returns the current
+// environment of our ContainerPilot process, which is the environment that
+// jobs will receive. If the request has the query parameter `redact=true`,
+// the values of any variables with names that match the redaction pattern
+// are replaced. Returns a JSON body with HTTP200.
+func (e *Endpoints) GetEnviron(r *http.Request) (interface{}, int) {
+	redact := r.URL.Query().Get("redact") == "true"
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		key, val := pair[0], pair[1]
+		if redact && e.redact != nil && e.redact.MatchString(key) {
+			val = redactedValue
+		}
+		env[key] = val
+	}
+	return env, http.StatusOK
+}
+
 // PostReload handles incoming HTTP POST requests and reloads our current
-// ContainerPilot process configuration.  Returns empty response or HTTP422.
-func (e Endpoints) PostReload(r *http.Request) (interface{}, int) {
+// ContainerPilot process configuration. Only the jobs and watches whose
+// definitions changed are restarted, unless something else changed.
+// Returns empty response or HTTP422 if the configuration is invalid.
+func (e *Endpoints) PostReload(r *http.Request) (interface{}, int) {
 	log.Debug("control: reloading app via control plane")
 	if r.Body != nil {
 		defer r.Body.Close()
 	}
-	e.bus.SetReloadFlag()
-	e.bus.Shutdown()
+	if e.reload == nil {
+		e.bus.SetReloadFlag()
+		e.bus.Shutdown()
+	} else if err := e.reload(); err != nil {
+		return nil, http.StatusUnprocessableEntity
+	}
 	log.Debug("control: reloaded app via control plane")
 	return nil, http.StatusOK
 }
 
 // PostEnableMaintenanceMode handles incoming HTTP POST requests and toggles
 // ContainerPilot maintenance mode on. Returns empty response or HTTP422.
-func (e Endpoints) PostEnableMaintenanceMode(r *http.Request) (interface{}, int) {
-	if r.Body != nil {
-		defer r.Body.Close()
-	}
-	e.bus.Publish(events.GlobalEnterMaintenance)
-	return nil, http.StatusOK
+func (e *Endpoints) PostEnableMaintenanceMode(r *http.Request) (interface{}, int) {
+	return e.setMaintenance(r, events.EnterMaintenance)
 }
 
 // PostDisableMaintenanceMode handles incoming HTTP POST requests and toggles
 // ContainerPilot maintenance mode on. Returns empty response or HTTP422.
-func (e Endpoints) PostDisableMaintenanceMode(r *http.Request) (interface{}, int) {
+func (e *Endpoints) PostDisableMaintenanceMode(r *http.Request) (interface{}, int) {
+	return e.setMaintenance(r, events.ExitMaintenance)
+}
+
+// MaintenanceRequest is the optional body of a request to the maintenance
+// endpoints, which limits the request to a single service
+type MaintenanceRequest struct {
+	Service string `json:"service"`
+}
+
+// setMaintenance publishes the maintenance event for the service named in
+// the request body, or for the whole container if the request has no body.
+// Returns HTTP422 if the body is invalid, or HTTP404 if the service isn't
+// advertised by any job.
+func (e *Endpoints) setMaintenance(r *http.Request, code events.EventCode) (interface{}, int) {
+	var req MaintenanceRequest
 	if r.Body != nil {
 		defer r.Body.Close()
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && err != io.EOF {
+			return nil, http.StatusUnprocessableEntity
+		}
+	}
+	if req.Service == "" {
+		e.bus.Publish(events.Event{Code: code, Source: "global"})
+		return nil, http.StatusOK
 	}
-	e.bus.Publish(events.GlobalExitMaintenance)
+	job := e.findJob(req.Service)
+	if job == nil || job.Service == nil {
+		return nil, http.StatusNotFound
+	}
+	e.bus.Publish(events.Event{Code: code, Source: req.Service})
 	return nil, http.StatusOK
 }
 
+// MetricRecord is a single measurement in a batch POSTed to /v3/metric
+type MetricRecord struct {
+	Name      string            `json:"name"`
+	Value     interface{}       `json:"value"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp interface{}       `json:"timestamp,omitempty"`
+}
+
+// event serializes the record into a Metric event in the format
+// name|value or, if the record has labels, name|value|key=val,key=val
+func (m MetricRecord) event() events.Event {
+	eventVal := fmt.Sprintf("%v|%v", m.Name, m.Value)
+	if len(m.Labels) > 0 {
+		pairs := make([]string, 0, len(m.Labels))
+		for key, val := range m.Labels {
+			pairs = append(pairs, key+"="+val)
+		}
+		sort.Strings(pairs)
+		eventVal = eventVal + "|" + strings.Join(pairs, ",")
+	}
+	return events.Event{Code: events.Metric, Source: eventVal}
+}
+
 // PostMetric handles incoming HTTP POST requests, serializes the metrics
-// into Events, and publishes them for sensors to record their values.
-// Returns empty response or HTTP422.
-func (e Endpoints) PostMetric(r *http.Request) (interface{}, int) {
-	var postMetrics map[string]interface{}
+// into Events, and publishes them for sensors to record their values. The
+// body is either an object of metric names to values, or an array of
+// MetricRecords. Returns empty response or HTTP422.
+func (e *Endpoints) PostMetric(r *http.Request) (interface{}, int) {
 	jsonBlob, err := ioutil.ReadAll(r.Body)
 
 	defer r.Body.Close()
 	if err != nil {
 		return nil, http.StatusUnprocessableEntity
 	}
+	if strings.HasPrefix(strings.TrimSpace(string(jsonBlob)), "[") {
+		return e.postMetricBatch(jsonBlob)
+	}
+	var postMetrics map[string]interface{}
 	err = json.Unmarshal(jsonBlob, &postMetrics)
 	if err != nil {
 		log.Debug(err)
@@ -120,3 +309,400 @@ func (e Endpoints) PostMetric(r *http.Request) (interface{}, int) {
 	}
 	return nil, http.StatusOK
 }
+
+// postMetricBatch publishes an array of MetricRecords. The whole batch is
+// rejected if any record is invalid so that a client can safely retry it.
+func (e *Endpoints) postMetricBatch(jsonBlob []byte) (interface{}, int) {
+	var records []MetricRecord
+	if err := json.Unmarshal(jsonBlob, &records); err != nil {
+		log.Debug(err)
+		return nil, http.StatusUnprocessableEntity
+	}
+	for _, record := range records {
+		if record.Name == "" || record.Value == nil {
+			log.Debugf("control: invalid metric record: %+v", record)
+			return nil, http.StatusUnprocessableEntity
+		}
+		for key, val := range record.Labels {
+			if key == "" || strings.ContainsAny(key+val, "|,=") {
+				log.Debugf("control: invalid metric label: %s=%s", key, val)
+				return nil, http.StatusUnprocessableEntity
+			}
+		}
+	}
+	for _, record := range records {
+		e.bus.Publish(record.event())
+	}
+	return nil, http.StatusOK
+}
+
+// StatusResponse is the body returned by the status endpoint
+type StatusResponse struct {
+	Jobs []jobs.Report `json:"jobs"`
+}
+
+// GetStatus handles incoming HTTP GET requests and reports the state,
+// last exit code, restart count, and health of each job. Returns a JSON
+// body with HTTP200.
+func (e *Endpoints) GetStatus(r *http.Request) (interface{}, int) {
+	resp := StatusResponse{Jobs: []jobs.Report{}}
+	for _, job := range e.getJobs() {
+		resp.Jobs = append(resp.Jobs, job.Report())
+	}
+	return resp, http.StatusOK
+}
+
+// jobActions maps the actions of the job lifecycle endpoints to the event
+// code that we publish for the job
+var jobActions = map[string]events.EventCode{
+	"start":   events.Start,
+	"stop":    events.Stop,
+	"restart": events.Restart,
+}
+
+// PostJobAction handles incoming HTTP POST requests to
+// /v3/jobs/{name}/{start|stop|restart|signal} and publishes the corresponding
+// event for that job only. Returns empty response, HTTP404 if the job or
+// action doesn't exist, or HTTP409 if the job has exited for good and
+// can't be started or restarted.
+func (e *Endpoints) PostJobAction(r *http.Request) (interface{}, int) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	name, action := parseActionPath("/v3/jobs/", r.URL.Path)
+	code, ok := jobActions[action]
+	job := e.findJob(name)
+	if (!ok && action != "signal") || job == nil {
+		return nil, http.StatusNotFound
+	}
+	if action == "signal" {
+		return e.signalJob(r, job)
+	}
+	if code != events.Stop && job.Done() {
+		return nil, http.StatusConflict
+	}
+	log.Debugf("control: %s job %s via control plane", action, name)
+	e.bus.Publish(events.Event{Code: code, Source: name})
+	return nil, http.StatusOK
+}
+
+// PostEvent handles incoming HTTP POST requests to /v3/events/{name} and
+// publishes a custom event with the source "custom.{name}", which jobs can
+// wait for in their 'when' field. Returns empty response or HTTP404 if the
+// name isn't valid.
+func (e *Endpoints) PostEvent(r *http.Request) (interface{}, int) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/v3/events/")
+	if err := utils.ValidateServiceName(name); err != nil {
+		return nil, http.StatusNotFound
+	}
+	log.Debugf("control: custom event %s via control plane", name)
+	e.bus.Publish(events.Event{Code: events.Triggered, Source: events.CustomPrefix + name})
+	return nil, http.StatusOK
+}
+
+// checkActions maps the actions of the health check endpoints to the event
+// code that we publish for the job
+var checkActions = map[string]events.EventCode{
+	"pause":  events.PauseCheck,
+	"resume": events.ResumeCheck,
+}
+
+// PostCheckAction handles incoming HTTP POST requests to
+// /v3/checks/{name}/{pause|resume|set} and publishes the corresponding event
+// for that job's health check. Returns empty response or HTTP404 if the job
+// doesn't exist or has no health check, or if the action doesn't exist.
+func (e *Endpoints) PostCheckAction(r *http.Request) (interface{}, int) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	name, action := parseActionPath("/v3/checks/", r.URL.Path)
+	code, ok := checkActions[action]
+	if !ok && action != "set" {
+		return nil, http.StatusNotFound
+	}
+	job := e.findJob(name)
+	if job == nil || !job.HasHealthCheck() {
+		return nil, http.StatusNotFound
+	}
+	if action == "set" {
+		return e.setHealthOverride(r, job)
+	}
+	log.Debugf("control: %s health check for %s via control plane", action, name)
+	e.bus.Publish(events.Event{Code: code, Source: name})
+	return nil, http.StatusOK
+}
+
+// CheckResultResponse is the body of the response to
+// GET /v3/checks/{name}/last
+type CheckResultResponse struct {
+	Name   string            `json:"name"`
+	Result *jobs.CheckResult `json:"result"`
+}
+
+// GetCheckResult handles incoming HTTP GET requests to
+// /v3/checks/{name}/last and returns the outcome, exit code, and output
+// of the most recent run of the job's health check. The result is null if
+// the check hasn't run yet. Returns HTTP404 if the job doesn't exist or
+// has no health check.
+func (e *Endpoints) GetCheckResult(r *http.Request) (interface{}, int) {
+	name, action := parseActionPath("/v3/checks/", r.URL.Path)
+	job := e.findJob(name)
+	if action != "last" || job == nil || !job.HasHealthCheck() {
+		return nil, http.StatusNotFound
+	}
+	return CheckResultResponse{Name: name, Result: job.LastCheck()}, http.StatusOK
+}
+
+// HealthOverrideRequest is the body of a request to
+// POST /v3/checks/{name}/set
+type HealthOverrideRequest struct {
+	Status string `json:"status"`
+	TTL    string `json:"ttl"`
+}
+
+// setHealthOverride forces the health of the job to the status in the
+// request body until the optional TTL expires. Returns empty response or
+// HTTP422 if the body is invalid.
+func (e *Endpoints) setHealthOverride(r *http.Request, job *jobs.Job) (interface{}, int) {
+	var req HealthOverrideRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		return nil, http.StatusUnprocessableEntity
+	}
+	override, err := jobs.ParseHealthOverride(req.Status)
+	if err != nil {
+		log.Debugf("control: %v", err)
+		return nil, http.StatusUnprocessableEntity
+	}
+	ttl, err := utils.GetTimeout(req.TTL)
+	if err != nil {
+		log.Debugf("control: invalid health override ttl: %v", err)
+		return nil, http.StatusUnprocessableEntity
+	}
+	log.Infof("control: set health of %s to '%s' via control plane",
+		job.Name, req.Status)
+	job.SetHealthOverride(override, ttl)
+	e.bus.Publish(events.Event{Code: events.OverrideHealth, Source: job.Name})
+	return nil, http.StatusOK
+}
+
+// LogsResponse is the body of the response to GET /v3/jobs/{name}/logs
+type LogsResponse struct {
+	Name  string   `json:"name"`
+	Lines []string `json:"lines"`
+}
+
+// GetJobLogs handles incoming HTTP GET requests to /v3/jobs/{name}/logs and
+// returns the recent output of the job. The lines query parameter limits
+// the number of lines returned (default 100). Returns HTTP404 if the job
+// doesn't exist, or HTTP422 if lines isn't a number.
+func (e *Endpoints) GetJobLogs(r *http.Request) (interface{}, int) {
+	name, action := parseActionPath("/v3/jobs/", r.URL.Path)
+	job := e.findJob(name)
+	if action != "logs" || job == nil {
+		return nil, http.StatusNotFound
+	}
+	lines := defaultLogLines
+	if val := r.URL.Query().Get("lines"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, http.StatusUnprocessableEntity
+		}
+		lines = n
+	}
+	return LogsResponse{Name: name, Lines: job.Logs(lines)}, http.StatusOK
+}
+
+const defaultLogLines = 100
+
+// SignalRequest is the body of a request to POST /v3/jobs/{name}/signal
+type SignalRequest struct {
+	Signal string `json:"signal"`
+}
+
+// signalJob delivers the signal in the request body to the job's process.
+// Returns empty response, HTTP422 if the signal is invalid, or HTTP409 if
+// the job isn't running.
+func (e *Endpoints) signalJob(r *http.Request, job *jobs.Job) (interface{}, int) {
+	var req SignalRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		return nil, http.StatusUnprocessableEntity
+	}
+	sig, err := commands.ParseSignal(req.Signal)
+	if err != nil {
+		log.Debugf("control: %v", err)
+		return nil, http.StatusUnprocessableEntity
+	}
+	if err := job.Signal(sig); err != nil {
+		log.Debugf("control: unable to signal job: %v", err)
+		return nil, http.StatusConflict
+	}
+	log.Infof("control: sent %v to job %s via control plane", sig, job.Name)
+	return nil, http.StatusOK
+}
+
+// parseActionPath splits a path of the form {prefix}{name}/{action}
+func parseActionPath(prefix, path string) (name, action string) {
+	parts := strings.Split(strings.TrimPrefix(path, prefix), "/")
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+func (e *Endpoints) findJob(name string) *jobs.Job {
+	for _, job := range e.getJobs() {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// APIVersions are the versions of the control plane API that we serve
+var APIVersions = []string{"v3"}
+
+// VersionResponse is the body of the response to GET /v3/version
+type VersionResponse struct {
+	Version     string   `json:"version"`
+	GitHash     string   `json:"gitHash"`
+	APIVersions []string `json:"apiVersions"`
+}
+
+// GetVersion handles incoming HTTP GET requests and reports the version of
+// ContainerPilot and the API versions it supports.
+func (e *Endpoints) GetVersion(r *http.Request) (interface{}, int) {
+	return VersionResponse{
+		Version:     e.version,
+		GitHash:     e.gitHash,
+		APIVersions: APIVersions,
+	}, http.StatusOK
+}
+
+// UnsupportedVersionResponse is the body of the response to requests for an
+// API version that we don't serve
+type UnsupportedVersionResponse struct {
+	Error             string   `json:"error"`
+	SupportedVersions []string `json:"supportedVersions"`
+}
+
+var apiVersionPath = regexp.MustCompile(`^/(v[0-9]+)(/|$)`)
+
+// NotFound handles requests that don't match any route. Requests for an API
+// version we don't serve get a JSON body listing the supported versions so
+// that clients can adapt, rather than a bare HTTP404.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	match := apiVersionPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		http.NotFound(w, r)
+		return
+	}
+	for _, version := range APIVersions {
+		if match[1] == version {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(UnsupportedVersionResponse{
+		Error:             fmt.Sprintf("unsupported API version '%s'", match[1]),
+		SupportedVersions: APIVersions,
+	})
+}
+
+// DrainRequest is the optional body of a request to POST /v3/drain, which
+// overrides the configured drain period
+type DrainRequest struct {
+	Period string `json:"period"`
+}
+
+// DrainResponse is the body of the response to POST /v3/drain
+type DrainResponse struct {
+	Jobs   []string `json:"jobs"`
+	Period string   `json:"period"`
+}
+
+// PostDrain handles incoming HTTP POST requests and takes this instance out
+// of rotation: each job that advertises a service is deregistered from
+// discovery immediately and is stopped once the drain period has passed.
+// Returns the drained jobs and the drain period, or HTTP422 if the request
+// body or the period in it is invalid. An empty body drains for the
+// default period.
+func (e *Endpoints) PostDrain(r *http.Request) (interface{}, int) {
+	period := e.drainPeriod
+	if r.Body != nil {
+		defer r.Body.Close()
+		var req DrainRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && err != io.EOF {
+			return nil, http.StatusUnprocessableEntity
+		}
+		if req.Period != "" {
+			p, err := utils.GetTimeout(req.Period)
+			if err != nil {
+				return nil, http.StatusUnprocessableEntity
+			}
+			period = p
+		}
+	}
+	drained := []string{}
+	for _, job := range e.getJobs() {
+		if job.Service != nil {
+			drained = append(drained, job.Name)
+		}
+	}
+	for _, name := range drained {
+		e.bus.Publish(events.Event{Code: events.EnterMaintenance, Source: name})
+	}
+	log.Infof("control: draining %v for %v via control plane", drained, period)
+	bus := e.bus
+	time.AfterFunc(period, func() {
+		for _, name := range drained {
+			bus.Publish(events.Event{Code: events.Stop, Source: name})
+		}
+	})
+	return DrainResponse{Jobs: drained, Period: period.String()}, http.StatusOK
+}
+
+// GetConfig handles incoming HTTP GET requests and serializes the config
+// that's currently loaded, after template rendering and any reloads. The
+// values of fields whose names match the redact pattern are redacted.
+// Returns HTTP500 if the config can't be serialized.
+func (e *Endpoints) GetConfig(r *http.Request) (interface{}, int) {
+	// round-trip through JSON so that we can walk the config generically
+	raw, err := json.Marshal(e.getConfig())
+	if err != nil {
+		log.Errorf("control: unable to serialize config: %v", err)
+		return nil, http.StatusInternalServerError
+	}
+	var cfg interface{}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		log.Errorf("control: unable to serialize config: %v", err)
+		return nil, http.StatusInternalServerError
+	}
+	return redactConfig(cfg, e.redact), http.StatusOK
+}
+
+// redactConfig walks a config decoded from JSON and redacts the non-empty
+// values of any fields whose names match the redact pattern
+func redactConfig(cfg interface{}, redact *regexp.Regexp) interface{} {
+	switch val := cfg.(type) {
+	case map[string]interface{}:
+		for key, field := range val {
+			if redact != nil && redact.MatchString(key) &&
+				field != nil && field != "" {
+				val[key] = redactedValue
+				continue
+			}
+			val[key] = redactConfig(field, redact)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = redactConfig(item, redact)
+		}
+	}
+	return cfg
+}
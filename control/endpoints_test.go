@@ -6,10 +6,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/tests/assert"
 )
 
@@ -56,6 +61,93 @@ func TestPutEnviron(t *testing.T) {
 	})
 }
 
+func TestPutEnvironUnsetAndReplace(t *testing.T) {
+	endpoints := &Endpoints{}
+	original := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, pair := range original {
+			kv := strings.SplitN(pair, "=", 2)
+			os.Setenv(kv[0], kv[1])
+		}
+	}()
+	post := func(path, body string) int {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		_, status := endpoints.PutEnviron(req)
+		return status
+	}
+
+	t.Run("POST unset", func(t *testing.T) {
+		os.Setenv("CP_TEST_FOO", "foo")
+		os.Setenv("CP_TEST_BAR", "bar")
+		status := post("/v3/environ",
+			`{"CP_TEST_BAZ": "baz", "unset": ["CP_TEST_FOO", "CP_TEST_BAR"]}`)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		_, foo := os.LookupEnv("CP_TEST_FOO")
+		_, bar := os.LookupEnv("CP_TEST_BAR")
+		assert.False(t, foo || bar, "expected variables to be unset")
+		assert.Equal(t, os.Getenv("CP_TEST_BAZ"), "baz", "expected %v but got %v")
+	})
+	t.Run("POST unset as variable name", func(t *testing.T) {
+		status := post("/v3/environ", `{"unset": "value"}`)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		assert.Equal(t, os.Getenv("unset"), "value", "expected %v but got %v")
+	})
+	t.Run("POST bad unset", func(t *testing.T) {
+		status := post("/v3/environ", `{"unset": ["CP_TEST_BAZ", 1]}`)
+		assert.Equal(t, status, http.StatusUnprocessableEntity, "status was not 422")
+		assert.Equal(t, os.Getenv("CP_TEST_BAZ"), "baz", "expected %v but got %v")
+	})
+	t.Run("POST replace", func(t *testing.T) {
+		os.Setenv("CP_TEST_STALE", "stale")
+		status := post("/v3/environ?replace=true",
+			`{"CP_TEST_NEW": "new", "CP_TEST_BAZ": "baz2"}`)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		expected := []string{"CP_TEST_BAZ=baz2", "CP_TEST_NEW=new"}
+		got := os.Environ()
+		sort.Strings(got)
+		assert.Equal(t, got, expected, "expected environ %v but got %v")
+	})
+}
+
+func TestGetEnviron(t *testing.T) {
+	os.Setenv("TEST_GET_ENVIRON", "visible")
+	os.Setenv("TEST_GET_ENVIRON_TOKEN", "hunter2")
+	defer os.Unsetenv("TEST_GET_ENVIRON")
+	defer os.Unsetenv("TEST_GET_ENVIRON_TOKEN")
+	endpoints := &Endpoints{redact: regexp.MustCompile(DefaultRedactPattern)}
+
+	testFunc := func(url string) map[string]string {
+		req := httptest.NewRequest("GET", url, nil)
+		resp, status := endpoints.GetEnviron(req)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		return resp.(map[string]string)
+	}
+
+	env := testFunc("/v3/environ")
+	assert.Equal(t, env["TEST_GET_ENVIRON"], "visible", "expected '%v' but got '%v'")
+	assert.Equal(t, env["TEST_GET_ENVIRON_TOKEN"], "hunter2", "expected '%v' but got '%v'")
+
+	env = testFunc("/v3/environ?redact=true")
+	assert.Equal(t, env["TEST_GET_ENVIRON"], "visible", "expected '%v' but got '%v'")
+	assert.Equal(t, env["TEST_GET_ENVIRON_TOKEN"], "<redacted>", "expected '%v' but got '%v'")
+}
+
+func TestMethodHandler(t *testing.T) {
+	mh := MethodHandler{
+		http.MethodGet: GetHandler(func(r *http.Request) (interface{}, int) {
+			return nil, 200
+		}),
+	}
+	w := httptest.NewRecorder()
+	mh.ServeHTTP(w, httptest.NewRequest("GET", "/v3/foo", nil))
+	assert.Equal(t, w.Result().StatusCode, 200, "expected HTTP 200 OK")
+
+	w = httptest.NewRecorder()
+	mh.ServeHTTP(w, httptest.NewRequest("DELETE", "/v3/foo", nil))
+	assert.Equal(t, w.Result().StatusCode, 405, "expected HTTP405 method not allowed")
+}
+
 func TestPostHandler(t *testing.T) {
 
 	testFunc := func(req *http.Request, mock PostHandler) (int, string) {
@@ -100,11 +192,47 @@ func TestPostHandler(t *testing.T) {
 	})
 }
 
+func TestGetHandler(t *testing.T) {
+	ok := GetHandler(func(r *http.Request) (interface{}, int) {
+		return map[string]string{"key": "val"}, 200
+	})
+
+	t.Run("GET ok", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		ok.ServeHTTP(w, httptest.NewRequest("GET", "/v3/foo", nil))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, resp.StatusCode, 200, "expected HTTP 200 OK")
+		assert.Equal(t, string(body), "{\"key\":\"val\"}\n",
+			"expected JSON body '%q', but got '%q'")
+	})
+
+	t.Run("POST bad method", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		ok.ServeHTTP(w, httptest.NewRequest("POST", "/v3/foo", nil))
+		assert.Equal(t, w.Result().StatusCode, 405,
+			"expected HTTP405 method not allowed")
+	})
+}
+
+func TestGetStatus(t *testing.T) {
+	cfg := &jobs.Config{Name: "myjob", Exec: "true"}
+	cfg.Validate(nil)
+	endpoints := &Endpoints{jobs: jobs.FromConfigs([]*jobs.Config{cfg})}
+	req := httptest.NewRequest("GET", "/v3/status", nil)
+	resp, status := endpoints.GetStatus(req)
+	assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+	expected := StatusResponse{Jobs: []jobs.Report{
+		{Name: "myjob", State: "waiting", Health: "unknown"}}}
+	assert.Equal(t, resp, expected, "expected %v but got %v")
+}
+
 func TestPostMetric(t *testing.T) {
 	testFunc := func(t *testing.T, expected map[events.Event]int, body string) int {
 		bus := events.NewEventBus()
 
-		endpoints := &Endpoints{bus}
+		endpoints := &Endpoints{bus: bus}
 		req, _ := http.NewRequest("POST", "/v3/metric", strings.NewReader(body))
 		_, status := endpoints.PostMetric(req)
 		got := map[events.Event]int{}
@@ -138,6 +266,30 @@ func TestPostMetric(t *testing.T) {
 		}, body)
 		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
 	})
+	t.Run("POST batch", func(t *testing.T) {
+		body := `[
+  {"name": "mymetric", "value": 1.5, "timestamp": 1500000000},
+  {"name": "mymetric", "value": 2},
+  {"name": "myothermetric", "value": 3, "labels": {"b": "2", "a": "1"}}
+]`
+		status := testFunc(t, map[events.Event]int{
+			events.Event{events.Metric, "mymetric|1.5"}:            1,
+			events.Event{events.Metric, "mymetric|2"}:              1,
+			events.Event{events.Metric, "myothermetric|3|a=1,b=2"}: 1,
+		}, body)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+	})
+	t.Run("POST batch invalid record", func(t *testing.T) {
+		for _, body := range []string{
+			`[{"name": "mymetric", "value": 1}, {"value": 2}]`,
+			`[{"name": "mymetric", "value": 1}, {"name": "myothermetric"}]`,
+			`[{"name": "mymetric", "value": 1, "labels": {"a": "x|y"}}]`,
+			`[{"name": "mymetric", "value": 1}, "myothermetric"]`,
+		} {
+			status := testFunc(t, map[events.Event]int{}, body)
+			assert.Equal(t, status, http.StatusUnprocessableEntity, "status was not 422")
+		}
+	})
 }
 
 func TestPostEnableMaintenanceMode(t *testing.T) {
@@ -145,7 +297,7 @@ func TestPostEnableMaintenanceMode(t *testing.T) {
 		bus := events.NewEventBus()
 
 		bus.Publish(events.GlobalStartup)
-		endpoints := &Endpoints{bus}
+		endpoints := &Endpoints{bus: bus}
 		_, status := endpoints.PostEnableMaintenanceMode(req)
 		results := bus.DebugEvents()
 		got := map[events.Event]int{}
@@ -161,9 +313,8 @@ func TestPostEnableMaintenanceMode(t *testing.T) {
 	t.Run("POST bad JSON", func(t *testing.T) {
 		body := "{{\n"
 		req, _ := http.NewRequest("POST", "/v3/maintenance/enable", strings.NewReader(body))
-		expected := map[events.Event]int{events.GlobalEnterMaintenance: 1}
-		status := testFunc(t, expected, req)
-		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		status := testFunc(t, map[events.Event]int{}, req)
+		assert.Equal(t, status, http.StatusUnprocessableEntity, "status was not 422")
 	})
 	t.Run("POST disable", func(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/v3/maintenance/enable", nil)
@@ -173,11 +324,66 @@ func TestPostEnableMaintenanceMode(t *testing.T) {
 	})
 }
 
+func TestPostMaintenanceModeService(t *testing.T) {
+	testFunc := func(t *testing.T, path, body string) (map[events.Event]int, int) {
+		bus := events.NewEventBus()
+		bus.Publish(events.GlobalStartup)
+		endpoints := &Endpoints{bus: bus, jobs: []*jobs.Job{
+			{Name: "nginx", Service: &discovery.ServiceDefinition{Name: "nginx"}},
+			{Name: "setup"},
+		}}
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		var status int
+		if strings.HasSuffix(path, "enable") {
+			_, status = endpoints.PostEnableMaintenanceMode(req)
+		} else {
+			_, status = endpoints.PostDisableMaintenanceMode(req)
+		}
+		bus.Wait()
+		got := map[events.Event]int{}
+		for _, result := range bus.DebugEvents() {
+			if result != events.GlobalStartup {
+				got[result]++
+			}
+		}
+		return got, status
+	}
+
+	t.Run("POST enable service", func(t *testing.T) {
+		got, status := testFunc(t, "/v3/maintenance/enable", `{"service": "nginx"}`)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		expected := map[events.Event]int{
+			events.Event{events.EnterMaintenance, "nginx"}: 1}
+		assert.Equal(t, got, expected, "got %v but expected: %v")
+	})
+	t.Run("POST disable service", func(t *testing.T) {
+		got, status := testFunc(t, "/v3/maintenance/disable", `{"service": "nginx"}`)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		expected := map[events.Event]int{
+			events.Event{events.ExitMaintenance, "nginx"}: 1}
+		assert.Equal(t, got, expected, "got %v but expected: %v")
+	})
+	t.Run("POST empty service", func(t *testing.T) {
+		got, status := testFunc(t, "/v3/maintenance/enable", `{"service": ""}`)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		expected := map[events.Event]int{events.GlobalEnterMaintenance: 1}
+		assert.Equal(t, got, expected, "got %v but expected: %v")
+	})
+	t.Run("POST unknown service", func(t *testing.T) {
+		for _, service := range []string{"nope", "setup"} {
+			body := fmt.Sprintf(`{"service": "%s"}`, service)
+			got, status := testFunc(t, "/v3/maintenance/enable", body)
+			assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+			assert.Equal(t, got, map[events.Event]int{}, "got %v but expected: %v")
+		}
+	})
+}
+
 func TestPostDisableMaintenanceMode(t *testing.T) {
 	testFunc := func(t *testing.T, expected map[events.Event]int, req *http.Request) int {
 		bus := events.NewEventBus()
 		bus.Publish(events.GlobalStartup)
-		endpoints := &Endpoints{bus}
+		endpoints := &Endpoints{bus: bus}
 		_, status := endpoints.PostDisableMaintenanceMode(req)
 		bus.Wait()
 		results := bus.DebugEvents()
@@ -194,9 +400,8 @@ func TestPostDisableMaintenanceMode(t *testing.T) {
 	t.Run("POST bad JSON", func(t *testing.T) {
 		body := "{{\n"
 		req, _ := http.NewRequest("POST", "/v3/maintenance/disable", strings.NewReader(body))
-		expected := map[events.Event]int{events.GlobalExitMaintenance: 1}
-		status := testFunc(t, expected, req)
-		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		status := testFunc(t, map[events.Event]int{}, req)
+		assert.Equal(t, status, http.StatusUnprocessableEntity, "status was not 422")
 	})
 	t.Run("POST disable", func(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/v3/maintenance/disable", nil)
@@ -205,3 +410,368 @@ func TestPostDisableMaintenanceMode(t *testing.T) {
 		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
 	})
 }
+
+func TestPostJobAction(t *testing.T) {
+	testFunc := func(t *testing.T, path string) (map[events.Event]int, int) {
+		bus := events.NewEventBus()
+		bus.Publish(events.GlobalStartup)
+		job := &jobs.Job{Name: "myjob"}
+		endpoints := &Endpoints{bus: bus, jobs: []*jobs.Job{job}}
+		req, _ := http.NewRequest("POST", path, nil)
+		_, status := endpoints.PostJobAction(req)
+		bus.Wait()
+		got := map[events.Event]int{}
+		for _, result := range bus.DebugEvents() {
+			if result != events.GlobalStartup {
+				got[result]++
+			}
+		}
+		return got, status
+	}
+
+	t.Run("POST actions", func(t *testing.T) {
+		for action, code := range map[string]events.EventCode{
+			"start": events.Start, "stop": events.Stop, "restart": events.Restart,
+		} {
+			got, status := testFunc(t, "/v3/jobs/myjob/"+action)
+			assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+			expected := map[events.Event]int{events.Event{code, "myjob"}: 1}
+			assert.Equal(t, got, expected, "got %v but expected: %v")
+		}
+	})
+	t.Run("POST unknown job", func(t *testing.T) {
+		got, status := testFunc(t, "/v3/jobs/nope/stop")
+		assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+		assert.Equal(t, got, map[events.Event]int{}, "got %v but expected: %v")
+	})
+	t.Run("POST unknown action", func(t *testing.T) {
+		for _, path := range []string{
+			"/v3/jobs/myjob/pause", "/v3/jobs/myjob", "/v3/jobs/myjob/stop/now"} {
+			got, status := testFunc(t, path)
+			assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+			assert.Equal(t, got, map[events.Event]int{}, "got %v but expected: %v")
+		}
+	})
+}
+
+func TestPostJobActionExited(t *testing.T) {
+	cfg := &jobs.Config{Name: "myjob", Exec: "true", Restarts: "never"}
+	cfg.Validate(nil)
+	job := jobs.NewJob(cfg)
+	bus := events.NewEventBus()
+	job.Run(bus)
+	bus.Publish(events.GlobalStartup)
+	time.Sleep(200 * time.Millisecond)
+	bus.Wait()
+
+	endpoints := &Endpoints{bus: bus, jobs: []*jobs.Job{job}}
+	for action, expected := range map[string]int{
+		"start":   http.StatusConflict,
+		"restart": http.StatusConflict,
+		"stop":    http.StatusOK,
+	} {
+		req, _ := http.NewRequest("POST", "/v3/jobs/myjob/"+action, nil)
+		_, status := endpoints.PostJobAction(req)
+		assert.Equal(t, status, expected, action+": expected %v but got %v")
+	}
+}
+
+func TestPostEvent(t *testing.T) {
+	testFunc := func(t *testing.T, path string) (map[events.Event]int, int) {
+		bus := events.NewEventBus()
+		bus.Publish(events.GlobalStartup)
+		endpoints := &Endpoints{bus: bus}
+		req, _ := http.NewRequest("POST", path, nil)
+		_, status := endpoints.PostEvent(req)
+		bus.Wait()
+		got := map[events.Event]int{}
+		for _, result := range bus.DebugEvents() {
+			if result != events.GlobalStartup {
+				got[result]++
+			}
+		}
+		return got, status
+	}
+
+	got, status := testFunc(t, "/v3/events/cache-warm")
+	assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+	expected := map[events.Event]int{
+		events.Event{events.Triggered, "custom.cache-warm"}: 1}
+	assert.Equal(t, got, expected, "got %v but expected: %v")
+
+	for _, path := range []string{"/v3/events/", "/v3/events/cache/warm"} {
+		got, status := testFunc(t, path)
+		assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+		assert.Equal(t, got, map[events.Event]int{}, "got %v but expected: %v")
+	}
+}
+
+func TestPostCheckAction(t *testing.T) {
+	checked := &jobs.Config{Name: "myjob", Exec: "true",
+		Health: &jobs.HealthConfig{CheckExec: "true", Heartbeat: 5, TTL: 10}}
+	checked.Validate(nil)
+	unchecked := &jobs.Config{Name: "setup", Exec: "true"}
+	unchecked.Validate(nil)
+	jobList := jobs.FromConfigs([]*jobs.Config{checked, unchecked})
+
+	testFunc := func(t *testing.T, path string) (map[events.Event]int, int) {
+		bus := events.NewEventBus()
+		bus.Publish(events.GlobalStartup)
+		endpoints := &Endpoints{bus: bus, jobs: jobList}
+		req, _ := http.NewRequest("POST", path, nil)
+		_, status := endpoints.PostCheckAction(req)
+		bus.Wait()
+		got := map[events.Event]int{}
+		for _, result := range bus.DebugEvents() {
+			if result != events.GlobalStartup {
+				got[result]++
+			}
+		}
+		return got, status
+	}
+
+	t.Run("POST pause", func(t *testing.T) {
+		got, status := testFunc(t, "/v3/checks/myjob/pause")
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		expected := map[events.Event]int{events.Event{events.PauseCheck, "myjob"}: 1}
+		assert.Equal(t, got, expected, "got %v but expected: %v")
+	})
+	t.Run("POST resume", func(t *testing.T) {
+		got, status := testFunc(t, "/v3/checks/myjob/resume")
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		expected := map[events.Event]int{events.Event{events.ResumeCheck, "myjob"}: 1}
+		assert.Equal(t, got, expected, "got %v but expected: %v")
+	})
+	t.Run("POST set", func(t *testing.T) {
+		bus := events.NewEventBus()
+		endpoints := &Endpoints{bus: bus, jobs: jobList}
+		req, _ := http.NewRequest("POST", "/v3/checks/myjob/set",
+			strings.NewReader(`{"status": "fail", "ttl": "1m"}`))
+		_, status := endpoints.PostCheckAction(req)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		assert.Equal(t, bus.DebugEvents(),
+			[]events.Event{{events.OverrideHealth, "myjob"}},
+			"expected events %v but got %v")
+		assert.Equal(t, jobList[0].Report().HealthOverride, "fail",
+			"expected override %v but got %v")
+	})
+	t.Run("POST set invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`{"status": "sick"}`, `{"status": "pass", "ttl": "soon"}`, `{{`,
+		} {
+			bus := events.NewEventBus()
+			endpoints := &Endpoints{bus: bus, jobs: jobList}
+			req, _ := http.NewRequest("POST", "/v3/checks/myjob/set",
+				strings.NewReader(body))
+			_, status := endpoints.PostCheckAction(req)
+			assert.Equal(t, status, http.StatusUnprocessableEntity, "status was not 422")
+		}
+	})
+	t.Run("POST not found", func(t *testing.T) {
+		for _, path := range []string{
+			"/v3/checks/setup/set",
+			"/v3/checks/setup/pause", "/v3/checks/nope/pause",
+			"/v3/checks/myjob/stop", "/v3/checks/myjob"} {
+			got, status := testFunc(t, path)
+			assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+			assert.Equal(t, got, map[events.Event]int{}, "got %v but expected: %v")
+		}
+	})
+}
+
+func TestGetVersion(t *testing.T) {
+	endpoints := &Endpoints{version: "3.1.0", gitHash: "abc1234"}
+	req := httptest.NewRequest("GET", "/v3/version", nil)
+	resp, status := endpoints.GetVersion(req)
+	assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+	expected := VersionResponse{
+		Version: "3.1.0", GitHash: "abc1234", APIVersions: []string{"v3"}}
+	assert.Equal(t, resp, expected, "expected %v but got %v")
+}
+
+func TestNotFound(t *testing.T) {
+	testFunc := func(path string) (int, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		NotFound(w, req)
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	t.Run("unsupported version", func(t *testing.T) {
+		status, body := testFunc("/v2/reload")
+		assert.Equal(t, status, http.StatusNotFound, "expected %v but got %v")
+		assert.Equal(t, body,
+			`{"error":"unsupported API version 'v2'","supportedVersions":["v3"]}`+"\n",
+			"expected body %v but got %v")
+	})
+	t.Run("supported version", func(t *testing.T) {
+		status, body := testFunc("/v3/xxxx")
+		assert.Equal(t, status, http.StatusNotFound, "expected %v but got %v")
+		assert.Equal(t, body, "404 page not found\n", "expected body %v but got %v")
+	})
+	t.Run("no version", func(t *testing.T) {
+		status, body := testFunc("/version")
+		assert.Equal(t, status, http.StatusNotFound, "expected %v but got %v")
+		assert.Equal(t, body, "404 page not found\n", "expected body %v but got %v")
+	})
+}
+
+func TestPostDrain(t *testing.T) {
+	// testFunc returns the events published until the drained jobs are
+	// stopped, or until the wait is over if they aren't
+	testFunc := func(t *testing.T, body string, wait time.Duration) (interface{}, int, map[events.Event]int) {
+		bus := events.NewEventBus()
+		subscriber := &events.EventHandler{Rx: make(chan events.Event, 10)}
+		subscriber.Subscribe(bus)
+		endpoints := &Endpoints{bus: bus, drainPeriod: time.Hour, jobs: []*jobs.Job{
+			{Name: "nginx", Service: &discovery.ServiceDefinition{Name: "nginx"}},
+			{Name: "setup"},
+		}}
+		req, _ := http.NewRequest("POST", "/v3/drain", strings.NewReader(body))
+		resp, status := endpoints.PostDrain(req)
+		got := map[events.Event]int{}
+		timeout := time.After(wait)
+		for {
+			select {
+			case event := <-subscriber.Rx:
+				got[event]++
+				if event.Code == events.Stop {
+					return resp, status, got
+				}
+			case <-timeout:
+				return resp, status, got
+			}
+		}
+	}
+
+	t.Run("POST drain", func(t *testing.T) {
+		resp, status, got := testFunc(t, `{"period": "10ms"}`, time.Second)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		assert.Equal(t, resp, DrainResponse{Jobs: []string{"nginx"}, Period: "10ms"},
+			"expected %v but got %v")
+		expected := map[events.Event]int{
+			events.Event{events.EnterMaintenance, "nginx"}: 1,
+			events.Event{events.Stop, "nginx"}:             1,
+		}
+		assert.Equal(t, got, expected, "got %v but expected: %v")
+	})
+	t.Run("POST drain waits for period", func(t *testing.T) {
+		resp, status, got := testFunc(t, "", 50*time.Millisecond)
+		assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+		assert.Equal(t, resp, DrainResponse{Jobs: []string{"nginx"}, Period: "1h0m0s"},
+			"expected %v but got %v")
+		expected := map[events.Event]int{
+			events.Event{events.EnterMaintenance, "nginx"}: 1}
+		assert.Equal(t, got, expected, "got %v but expected: %v")
+	})
+	t.Run("POST bad period", func(t *testing.T) {
+		_, status, got := testFunc(t, `{"period": "soon"}`, 50*time.Millisecond)
+		assert.Equal(t, status, http.StatusUnprocessableEntity, "status was not 422")
+		assert.Equal(t, got, map[events.Event]int{}, "got %v but expected: %v")
+	})
+	t.Run("POST invalid JSON", func(t *testing.T) {
+		_, status, got := testFunc(t, `{"period": 10}`, 50*time.Millisecond)
+		assert.Equal(t, status, http.StatusUnprocessableEntity, "status was not 422")
+		assert.Equal(t, got, map[events.Event]int{}, "got %v but expected: %v")
+	})
+}
+
+func TestGetConfig(t *testing.T) {
+	type tlsConfig struct {
+		Cert string
+		Key  string
+	}
+	cfg := struct {
+		Jobs    []map[string]interface{}
+		Control map[string]interface{}
+	}{
+		Jobs: []map[string]interface{}{
+			{"name": "app", "exec": "/bin/app", "apiToken": "s3cret"},
+		},
+		Control: map[string]interface{}{
+			"Token": "", "TLS": tlsConfig{Cert: "cert.pem", Key: "key.pem"},
+		},
+	}
+	endpoints := &Endpoints{config: cfg,
+		redact: regexp.MustCompile(DefaultRedactPattern)}
+	req := httptest.NewRequest("GET", "/v3/config", nil)
+	resp, status := endpoints.GetConfig(req)
+	assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+	expected := map[string]interface{}{
+		"Jobs": []interface{}{map[string]interface{}{
+			"name": "app", "exec": "/bin/app", "apiToken": redactedValue}},
+		"Control": map[string]interface{}{
+			"Token": "",
+			"TLS":   map[string]interface{}{"Cert": "cert.pem", "Key": redactedValue},
+		},
+	}
+	assert.Equal(t, resp, expected, "expected %v but got %v")
+}
+
+func TestGetCheckResult(t *testing.T) {
+	checked := &jobs.Config{Name: "myjob", Exec: "true",
+		Health: &jobs.HealthConfig{CheckExec: "true", Heartbeat: 5, TTL: 10}}
+	checked.Validate(nil)
+	unchecked := &jobs.Config{Name: "setup", Exec: "true"}
+	unchecked.Validate(nil)
+	endpoints := &Endpoints{
+		jobs: jobs.FromConfigs([]*jobs.Config{checked, unchecked})}
+	testFunc := func(path string) (interface{}, int) {
+		req := httptest.NewRequest("GET", path, nil)
+		return endpoints.GetCheckResult(req)
+	}
+
+	// the check hasn't run yet
+	resp, status := testFunc("/v3/checks/myjob/last")
+	assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+	assert.Equal(t, resp, CheckResultResponse{Name: "myjob"},
+		"expected %v but got %v")
+
+	_, status = testFunc("/v3/checks/setup/last")
+	assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+	_, status = testFunc("/v3/checks/nope/last")
+	assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+	_, status = testFunc("/v3/checks/myjob/pause")
+	assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+}
+
+func TestGetJobLogs(t *testing.T) {
+	cfg := &jobs.Config{Name: "myjob", Exec: "true"}
+	cfg.Validate(nil)
+	endpoints := &Endpoints{jobs: jobs.FromConfigs([]*jobs.Config{cfg})}
+	testFunc := func(path string) (interface{}, int) {
+		req := httptest.NewRequest("GET", path, nil)
+		return endpoints.GetJobLogs(req)
+	}
+
+	resp, status := testFunc("/v3/jobs/myjob/logs?lines=10")
+	assert.Equal(t, status, http.StatusOK, "status was not 200OK")
+	assert.Equal(t, resp, LogsResponse{Name: "myjob", Lines: []string{}},
+		"expected %v but got %v")
+
+	_, status = testFunc("/v3/jobs/myjob/logs?lines=ten")
+	assert.Equal(t, status, http.StatusUnprocessableEntity, "status was not 422")
+	_, status = testFunc("/v3/jobs/nope/logs")
+	assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+	_, status = testFunc("/v3/jobs/myjob/start")
+	assert.Equal(t, status, http.StatusNotFound, "status was not 404")
+}
+
+func TestPostJobSignal(t *testing.T) {
+	cfg := &jobs.Config{Name: "myjob", Exec: "true"}
+	cfg.Validate(nil)
+	endpoints := &Endpoints{jobs: jobs.FromConfigs([]*jobs.Config{cfg})}
+	testFunc := func(body string) int {
+		req, _ := http.NewRequest("POST", "/v3/jobs/myjob/signal",
+			strings.NewReader(body))
+		_, status := endpoints.PostJobAction(req)
+		return status
+	}
+	assert.Equal(t, testFunc(`{"signal": "SIGBOGUS"}`),
+		http.StatusUnprocessableEntity, "expected %v but got %v")
+	assert.Equal(t, testFunc(`{{`),
+		http.StatusUnprocessableEntity, "expected %v but got %v")
+	assert.Equal(t, testFunc(`{"signal": "SIGHUP"}`),
+		http.StatusConflict, "expected %v but got %v")
+}
@@ -0,0 +1,213 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The gRPC service is described by proto/control.proto. Its messages are
+// all well-known Struct types holding the same JSON as the HTTP API, so
+// the service is registered by hand rather than with generated code.
+const controlServiceName = "containerpilot.control.v3.Control"
+
+// grpcRoute is the HTTP endpoint that serves a gRPC method. The fields of
+// a request are sent as the query of a GET, or as the JSON body of a POST.
+type grpcRoute struct {
+	method string
+	path   string
+}
+
+var grpcRoutes = map[string]grpcRoute{
+	"Reload":     {http.MethodPost, "/v3/reload"},
+	"GetEnviron": {http.MethodGet, "/v3/environ"},
+	"PutEnviron": {http.MethodPost, "/v3/environ"},
+	"PutMetric":  {http.MethodPost, "/v3/metric"},
+	"GetStatus":  {http.MethodGet, "/v3/status"},
+	// SetMaintenance is routed to /v3/maintenance/enable or disable by
+	// its "enabled" field
+	"SetMaintenance": {http.MethodPost, "/v3/maintenance/"},
+}
+
+// grpcService is the handler type of the gRPC service
+type grpcService interface {
+	call(ctx context.Context, method string, in *structpb.Struct) (*structpb.Struct, error)
+}
+
+var controlServiceDesc = grpc.ServiceDesc{
+	ServiceName: controlServiceName,
+	HandlerType: (*grpcService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Reload", Handler: grpcHandler("Reload")},
+		{MethodName: "GetEnviron", Handler: grpcHandler("GetEnviron")},
+		{MethodName: "PutEnviron", Handler: grpcHandler("PutEnviron")},
+		{MethodName: "PutMetric", Handler: grpcHandler("PutMetric")},
+		{MethodName: "SetMaintenance", Handler: grpcHandler("SetMaintenance")},
+		{MethodName: "GetStatus", Handler: grpcHandler("GetStatus")},
+	},
+	Metadata: "control/proto/control.proto",
+}
+
+func grpcHandler(method string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := &structpb.Struct{}
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(grpcService).call(ctx, method, req.(*structpb.Struct))
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: "/" + controlServiceName + "/" + method,
+		}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// grpcServer serves the gRPC methods with the HTTP handler of the control
+// server, so that they're authenticated, rate limited, audited, and
+// instrumented just like the HTTP endpoints
+type grpcServer struct {
+	handler http.Handler
+}
+
+func (s *grpcServer) call(ctx context.Context, method string, in *structpb.Struct) (*structpb.Struct, error) {
+	req, err := newGRPCRequest(ctx, method, in)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp := &grpcResponse{header: http.Header{}, status: http.StatusOK}
+	s.handler.ServeHTTP(resp, req)
+	if resp.status != http.StatusOK {
+		return nil, status.Error(grpcCode(resp.status), http.StatusText(resp.status))
+	}
+	out := &structpb.Struct{}
+	body := bytes.TrimSpace(resp.body.Bytes())
+	if bytes.HasPrefix(body, []byte("{")) {
+		if err := protojson.Unmarshal(body, out); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return out, nil
+}
+
+// newGRPCRequest creates the HTTP request for a gRPC call. The bearer
+// token is passed in the call's "authorization" metadata.
+func newGRPCRequest(ctx context.Context, method string, in *structpb.Struct) (*http.Request, error) {
+	route := grpcRoutes[method]
+	path := route.path
+	fields := in.AsMap()
+	if method == "SetMaintenance" {
+		if enabled, _ := fields["enabled"].(bool); enabled {
+			path += "enable"
+		} else {
+			path += "disable"
+		}
+		delete(fields, "enabled")
+	}
+	var body []byte
+	if route.method == http.MethodGet {
+		query := url.Values{}
+		for key, val := range fields {
+			query.Set(key, fmt.Sprintf("%v", val))
+		}
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+	} else if len(fields) > 0 {
+		var err error
+		if body, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(route.method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.RemoteAddr = "grpc"
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			req.Header.Set("Authorization", auth[0])
+		}
+	}
+	return req, nil
+}
+
+// grpcResponse records the response of the HTTP handler to a gRPC call
+type grpcResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *grpcResponse) Header() http.Header         { return r.header }
+func (r *grpcResponse) Write(p []byte) (int, error) { return r.body.Write(p) }
+func (r *grpcResponse) WriteHeader(status int)      { r.status = status }
+
+// grpcCode maps the HTTP status of an endpoint to a gRPC status code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusUnprocessableEntity, http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Unknown
+}
+
+// startGRPC serves the gRPC API at the control.grpcSocket unix socket
+func (srv *HTTPServer) startGRPC(handler http.Handler) {
+	os.Remove(srv.grpcAddr) // left behind if we didn't stop cleanly
+	ln, err := net.Listen("unix", srv.grpcAddr)
+	if err != nil {
+		log.Errorf("control: unable to serve gRPC at %s: %v", srv.grpcAddr, err)
+		return
+	}
+	srv.applySocketPerms(srv.grpcAddr)
+	srv.grpc = grpc.NewServer()
+	srv.grpc.RegisterService(&controlServiceDesc, &grpcServer{handler: handler})
+	go func(server *grpc.Server) {
+		log.Infof("control: serving gRPC at %s", srv.grpcAddr)
+		server.Serve(ln)
+		log.Debugf("control: stopped serving gRPC at %s", srv.grpcAddr)
+	}(srv.grpc)
+}
+
+// stopGRPC stops the gRPC server without waiting for calls to finish,
+// because a Reload call waits on the reload that stops us
+func (srv *HTTPServer) stopGRPC() {
+	if srv.grpc == nil {
+		return
+	}
+	srv.grpc.Stop()
+	srv.grpc = nil
+	os.Remove(srv.grpcAddr)
+}
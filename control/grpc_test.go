@@ -0,0 +1,94 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestServerGRPC(t *testing.T) {
+	socketPath := tempSocketPath()
+	grpcPath := tempSocketPath()
+	defer os.Remove(socketPath)
+	defer os.Remove(grpcPath)
+	os.Setenv("TEST_GRPC_ENVIRON", "ok")
+	os.Setenv("TEST_GRPC_TOKEN", "hidden")
+	defer os.Unsetenv("TEST_GRPC_ENVIRON")
+	defer os.Unsetenv("TEST_GRPC_TOKEN")
+
+	s := SetupHTTPServer(t, fmt.Sprintf(`{ "socket": %q, "grpcSocket": %q,
+		"token": "s3cret" }`, socketPath, grpcPath))
+	defer s.Stop()
+	s.Start()
+
+	conn, err := grpc.Dial("unix://"+grpcPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	invoke := func(method, token string, in map[string]interface{}) (*structpb.Struct, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		req, err := structpb.NewStruct(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &structpb.Struct{}
+		err = conn.Invoke(ctx, "/"+controlServiceName+"/"+method, req, out)
+		return out, err
+	}
+
+	t.Run("GetStatus", func(t *testing.T) {
+		out, err := invoke("GetStatus", "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, out.AsMap()["jobs"], []interface{}{},
+			"expected jobs %v but got %v")
+	})
+	t.Run("GetEnviron", func(t *testing.T) {
+		_, err := invoke("GetEnviron", "", nil)
+		assert.Equal(t, status.Code(err), codes.Unauthenticated,
+			"expected code %v but got %v")
+		out, err := invoke("GetEnviron", "s3cret", map[string]interface{}{"redact": true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		env := out.AsMap()
+		assert.Equal(t, env["TEST_GRPC_ENVIRON"], "ok", "expected %v but got %v")
+		assert.Equal(t, env["TEST_GRPC_TOKEN"], redactedValue, "expected %v but got %v")
+	})
+	t.Run("PutMetric", func(t *testing.T) {
+		_, err := invoke("PutMetric", "wrong", map[string]interface{}{"key": 1})
+		assert.Equal(t, status.Code(err), codes.Unauthenticated,
+			"expected code %v but got %v")
+		if _, err = invoke("PutMetric", "s3cret", map[string]interface{}{"key": 1}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("SetMaintenance", func(t *testing.T) {
+		_, err := invoke("SetMaintenance", "s3cret",
+			map[string]interface{}{"enabled": true, "service": "missing"})
+		assert.Equal(t, status.Code(err), codes.NotFound,
+			"expected code %v but got %v")
+		_, err = invoke("SetMaintenance", "s3cret",
+			map[string]interface{}{"enabled": true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/jobs"
+)
+
+// DefaultHealthFileInterval is how often the health file is rewritten when
+// nothing has changed, so that a reader can tell a stale file apart from a
+// current one
+var DefaultHealthFileInterval = "5s"
+
+// HealthState is the aggregate health of the jobs with health checks, as
+// written to the health file for `containerpilot check` to read. Jobs in
+// maintenance count as healthy, since they've been taken out of service
+// on purpose.
+type HealthState struct {
+	Healthy bool             `json:"healthy"`
+	Time    time.Time        `json:"time"`
+	Expires time.Time        `json:"expires"`
+	Jobs    []HealthStateJob `json:"jobs"`
+}
+
+// HealthStateJob is the health of a single job in the HealthState
+type HealthStateJob struct {
+	Name   string `json:"name"`
+	Health string `json:"health"`
+}
+
+// Check returns an error naming the jobs that aren't healthy, or if the
+// file has expired because ContainerPilot stopped updating it
+func (state HealthState) Check(now time.Time) error {
+	if now.After(state.Expires) {
+		return fmt.Errorf("health file is stale: last written at %s",
+			state.Time.Format(time.RFC3339))
+	}
+	if state.Healthy {
+		return nil
+	}
+	var unhealthy []string
+	for _, job := range state.Jobs {
+		if !jobHealthy(job.Health) {
+			unhealthy = append(unhealthy,
+				fmt.Sprintf("%s (%s)", job.Name, job.Health))
+		}
+	}
+	return fmt.Errorf("unhealthy: %s", strings.Join(unhealthy, ", "))
+}
+
+// ReadHealthFile reads the HealthState written to the health file
+func ReadHealthFile(path string) (HealthState, error) {
+	var state HealthState
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("could not parse health file %s: %v", path, err)
+	}
+	return state, nil
+}
+
+// healthFile writes the HealthState of the jobs to path every interval
+// and whenever a job's health changes
+type healthFile struct {
+	path     string
+	interval time.Duration
+}
+
+func jobHealthy(health string) bool {
+	return health == "healthy" || health == "maintenance"
+}
+
+// newHealthState returns the aggregate health of the jobs that have
+// health checks, which expires after a few missed writes
+func newHealthState(jobList []*jobs.Job, now time.Time,
+	interval time.Duration) HealthState {
+	state := HealthState{
+		Healthy: true,
+		Time:    now.UTC(),
+		Expires: now.Add(3 * interval).UTC(),
+		Jobs:    []HealthStateJob{},
+	}
+	for _, job := range jobList {
+		if !job.HasHealthCheck() {
+			continue
+		}
+		health := job.Report().Health
+		state.Jobs = append(state.Jobs, HealthStateJob{job.Name, health})
+		if !jobHealthy(health) {
+			state.Healthy = false
+		}
+	}
+	return state
+}
+
+// write replaces the health file with the current HealthState of the
+// jobs. We write a temporary file and rename it over the old one so that
+// a reader never sees a partial file.
+func (hf *healthFile) write(jobList []*jobs.Job) error {
+	data, err := json.Marshal(newHealthState(jobList, time.Now(), hf.interval))
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(hf.path), ".containerpilot-health")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), hf.path)
+}
+
+// update writes the health file, logging rather than returning any error
+// because a missed write only makes the file go stale
+func (hf *healthFile) update(jobList []*jobs.Job) {
+	if err := hf.write(jobList); err != nil {
+		log.Warnf("control: unable to write health file %s: %v", hf.path, err)
+	}
+}
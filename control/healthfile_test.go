@@ -0,0 +1,77 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/jobs"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestHealthFileWrite(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "healthfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "containerpilot.health")
+
+	checked := &jobs.Config{Name: "myjob", Exec: "true",
+		Health: &jobs.HealthConfig{CheckExec: "true", Heartbeat: 5, TTL: 10}}
+	checked.Validate(nil)
+	unchecked := &jobs.Config{Name: "setup", Exec: "true"}
+	unchecked.Validate(nil)
+	jobList := jobs.FromConfigs([]*jobs.Config{checked, unchecked})
+
+	hf := &healthFile{path: path, interval: time.Second}
+	if err := hf.write(jobList); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state, err := ReadHealthFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// jobs without a health check aren't included, and a job that hasn't
+	// been checked yet isn't healthy
+	assert.Equal(t, state.Jobs, []HealthStateJob{{"myjob", "unknown"}},
+		"expected %v but got %v")
+	assert.Error(t, state.Check(time.Now()), "unhealthy: myjob (unknown)")
+	if !state.Expires.Equal(state.Time.Add(3 * time.Second)) {
+		t.Fatalf("expected file to expire after 3 intervals but got %v",
+			state.Expires)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("expected temporary file to be removed but got %v", files)
+	}
+}
+
+func TestHealthStateCheck(t *testing.T) {
+	now := time.Now()
+	state := HealthState{
+		Healthy: true,
+		Time:    now.Add(-time.Second),
+		Expires: now.Add(time.Second),
+		Jobs: []HealthStateJob{
+			{"app", "healthy"}, {"db", "maintenance"}},
+	}
+	if err := state.Check(now); err != nil {
+		t.Fatalf("expected healthy but got %v", err)
+	}
+
+	state.Healthy = false
+	state.Jobs = append(state.Jobs, HealthStateJob{"cache", "unhealthy"})
+	assert.Error(t, state.Check(now), "unhealthy: cache (unhealthy)")
+
+	state.Healthy = true
+	err := state.Check(now.Add(2 * time.Second))
+	if err == nil {
+		t.Fatal("expected error for a stale health file")
+	}
+
+	_, err = ReadHealthFile(filepath.Join(os.TempDir(), "no-such-health-file"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected error for missing file but got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package control
+
+import "net"
+
+// listen opens a listener for any of our supported socket types. The
+// control server and its clients go through listen and Dial so that a
+// socket type that the net package doesn't support can be added here.
+func listen(socketType, addr string) (net.Listener, error) {
+	return net.Listen(socketType, addr)
+}
+
+// Dial connects to the control server at addr, for any of our supported
+// socket types
+func Dial(socketType, addr string) (net.Conn, error) {
+	return net.Dial(socketType, addr)
+}
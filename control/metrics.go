@@ -0,0 +1,29 @@
+package control
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "containerpilot",
+	Subsystem: "control",
+	Name:      "request_duration_seconds",
+	Help:      "Latency of control plane API requests, by route, method, and status code.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"handler", "method", "code"})
+
+func init() {
+	prometheus.MustRegister(requestDuration)
+}
+
+// instrument records the latency of requests to a route. The route's
+// pattern is used as the label rather than the request path, so that
+// paths like /v3/jobs/<name>/logs don't create a series per job.
+func instrument(pattern string, handler http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerDuration(
+		requestDuration.MustCurryWith(prometheus.Labels{"handler": pattern}),
+		handler)
+}
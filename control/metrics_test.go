@@ -0,0 +1,43 @@
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joyent/containerpilot/tests/assert"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInstrument(t *testing.T) {
+	handler := instrument("/v3/jobs/", http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+	for _, path := range []string{"/v3/jobs/a/logs", "/v3/jobs/b/logs"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var count uint64
+	for _, family := range families {
+		if family.GetName() != "containerpilot_control_request_duration_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["handler"] == "/v3/jobs/" {
+				assert.Equal(t, labels["method"], "get", "expected method %v but got %v")
+				assert.Equal(t, labels["code"], "404", "expected code %v but got %v")
+				count += m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	assert.Equal(t, count, uint64(2), "expected %v requests for the route but got %v")
+}
@@ -0,0 +1,131 @@
+package control
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+// defaultRateLimitKey is the key in control.rateLimits for endpoints that
+// don't have their own limit
+const defaultRateLimitKey = "default"
+
+// bucket is a token bucket holding up to burst tokens, refilled at rate
+// tokens per second
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter tracks a token bucket for each endpoint. Because the control
+// server is replaced on every reload, the buckets are package-level so that
+// a client can't escape its limit by reloading.
+type rateLimiter struct {
+	buckets map[string]*bucket
+	lock    sync.Mutex
+}
+
+var limiter = &rateLimiter{buckets: make(map[string]*bucket)}
+
+// allow takes a token from the bucket for the endpoint, returning false and
+// the time until the next token is available if the bucket is empty
+func (rl *rateLimiter) allow(endpoint string, rate float64, now time.Time) (bool, time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	burst := math.Max(1, rate)
+	b, ok := rl.buckets[endpoint]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		rl.buckets[endpoint] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// RateLimit wraps an http.Handler and limits the rate of requests to each
+// endpoint. Limits are in requests per second, keyed by endpoint path or
+// "default" for all other endpoints. Requests over the limit receive a
+// HTTP429 with a Retry-After hint.
+type RateLimit struct {
+	Limits  map[string]float64
+	Handler http.Handler
+}
+
+func (rl RateLimit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := rateLimitEndpoint(r.URL.Path)
+	rate, ok := rl.Limits[endpoint]
+	if !ok {
+		rate, ok = rl.Limits[defaultRateLimitKey]
+	}
+	if ok {
+		if allowed, wait := limiter.allow(endpoint, rate, time.Now()); !allowed {
+			retryAfter(w, wait)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests),
+				http.StatusTooManyRequests)
+			return
+		}
+	}
+	rl.Handler.ServeHTTP(w, r)
+}
+
+// rateLimitEndpoint groups the per-job and per-check endpoints by action so
+// that each job doesn't get its own bucket, i.e. /v3/jobs/app/restart is
+// limited as /v3/jobs/restart, and all custom events share /v3/events/
+func rateLimitEndpoint(path string) string {
+	if strings.HasPrefix(path, "/v3/events/") && path != "/v3/events/stream" {
+		return "/v3/events/"
+	}
+	for _, prefix := range []string{"/v3/jobs/", "/v3/checks/"} {
+		if strings.HasPrefix(path, prefix) {
+			_, action := parseActionPath(prefix, path)
+			return prefix + action
+		}
+	}
+	return path
+}
+
+// retryAfter sets the Retry-After header to the wait rounded up to the
+// nearest second
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+}
+
+// reloadGuard wraps the reload endpoint and rejects a reload with a HTTP409
+// while a previous reload is still in progress. A full reload replaces the
+// control server, so the guard is only reset after a reload that kept it.
+type reloadGuard struct {
+	bus        *events.EventBus
+	inProgress int32
+	handler    http.Handler
+}
+
+func (g *reloadGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if g.bus.Reloading() || !atomic.CompareAndSwapInt32(&g.inProgress, 0, 1) {
+			retryAfter(w, time.Second)
+			http.Error(w, http.StatusText(http.StatusConflict),
+				http.StatusConflict)
+			return
+		}
+	}
+	g.handler.ServeHTTP(w, r)
+	if r.Method == http.MethodPost && !g.bus.Reloading() {
+		atomic.StoreInt32(&g.inProgress, 0)
+	}
+}
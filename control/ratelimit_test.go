@@ -0,0 +1,98 @@
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	rl := &rateLimiter{buckets: make(map[string]*bucket)}
+	now := time.Now()
+
+	ok, _ := rl.allow("/v3/reload", 0.5, now)
+	assert.True(t, ok, "expected first request to be allowed")
+	ok, wait := rl.allow("/v3/reload", 0.5, now)
+	assert.False(t, ok, "expected second request to be limited")
+	assert.Equal(t, wait, 2*time.Second, "expected wait %v but got %v")
+
+	// other endpoints have their own bucket
+	ok, _ = rl.allow("/v3/metric", 0.5, now)
+	assert.True(t, ok, "expected request to other endpoint to be allowed")
+
+	ok, _ = rl.allow("/v3/reload", 0.5, now.Add(2*time.Second))
+	assert.True(t, ok, "expected request to be allowed after refill")
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter = &rateLimiter{buckets: make(map[string]*bucket)}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	rl := RateLimit{
+		Limits:  map[string]float64{"default": 100, "/v3/jobs/restart": 0.1},
+		Handler: ok,
+	}
+	testFunc := func(path string) *http.Response {
+		req := httptest.NewRequest("POST", path, nil)
+		w := httptest.NewRecorder()
+		rl.ServeHTTP(w, req)
+		return w.Result()
+	}
+	resp := testFunc("/v3/jobs/app/restart")
+	assert.Equal(t, resp.StatusCode, http.StatusOK, "expected %v but got %v")
+	resp = testFunc("/v3/jobs/other/restart")
+	assert.Equal(t, resp.StatusCode, http.StatusTooManyRequests,
+		"expected %v but got %v")
+	assert.Equal(t, resp.Header.Get("Retry-After"), "10",
+		"expected Retry-After %v but got %v")
+	resp = testFunc("/v3/jobs/app/stop")
+	assert.Equal(t, resp.StatusCode, http.StatusOK, "expected %v but got %v")
+	assert.Equal(t, rateLimitEndpoint("/v3/events/reindex"), "/v3/events/",
+		"expected %v but got %v")
+	assert.Equal(t, rateLimitEndpoint("/v3/events/stream"), "/v3/events/stream",
+		"expected %v but got %v")
+}
+
+func TestReloadGuard(t *testing.T) {
+	bus := events.NewEventBus()
+	calls := 0
+	guard := &reloadGuard{
+		bus: bus,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+	testFunc := func() *http.Response {
+		req := httptest.NewRequest("POST", "/v3/reload", nil)
+		w := httptest.NewRecorder()
+		guard.ServeHTTP(w, req)
+		return w.Result()
+	}
+	// a reload that only restarts jobs keeps the control server, so
+	// another reload can follow it
+	resp := testFunc()
+	assert.Equal(t, resp.StatusCode, http.StatusOK, "expected %v but got %v")
+	resp = testFunc()
+	assert.Equal(t, resp.StatusCode, http.StatusOK, "expected %v but got %v")
+	assert.Equal(t, calls, 2, "expected %v reloads but got %v")
+
+	// a full reload blocks the endpoint until the control server is replaced
+	guard.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		bus.SetReloadFlag()
+		w.WriteHeader(http.StatusOK)
+	})
+	resp = testFunc()
+	assert.Equal(t, resp.StatusCode, http.StatusOK, "expected %v but got %v")
+	resp = testFunc()
+	assert.Equal(t, resp.StatusCode, http.StatusConflict, "expected %v but got %v")
+	assert.Equal(t, resp.Header.Get("Retry-After"), "1",
+		"expected Retry-After %v but got %v")
+	assert.Equal(t, calls, 3, "expected %v reloads but got %v")
+}
@@ -0,0 +1,142 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/events"
+)
+
+const streamBufferSize = 100
+
+// eventStream fans out the events received by the control server to
+// each client of the event stream endpoint
+type eventStream struct {
+	lock    sync.RWMutex
+	clients map[chan events.Event]bool
+	closed  bool
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{clients: make(map[chan events.Event]bool)}
+}
+
+// subscribe returns a channel that will receive all events until it
+// is unsubscribed or the stream is closed. Returns nil if the stream
+// has already been closed.
+func (s *eventStream) subscribe() chan events.Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	ch := make(chan events.Event, streamBufferSize)
+	s.clients[ch] = true
+	return ch
+}
+
+func (s *eventStream) unsubscribe(ch chan events.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.clients[ch]; ok {
+		delete(s.clients, ch)
+		close(ch)
+	}
+}
+
+// publish sends the event to all clients without blocking; a client
+// that can't keep up will miss events rather than stall the event loop
+func (s *eventStream) publish(event events.Event) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for ch := range s.clients {
+		select {
+		case ch <- event:
+		default:
+			log.Debugf("control: event stream client full, dropped %v", event)
+		}
+	}
+}
+
+// close disconnects all clients so that the HTTP server can shut down
+// without waiting on the long-lived stream connections
+func (s *eventStream) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for ch := range s.clients {
+		delete(s.clients, ch)
+		close(ch)
+	}
+	s.closed = true
+}
+
+// streamEvent is the serialized form of an events.Event
+type streamEvent struct {
+	Code   string `json:"code"`
+	Source string `json:"source"`
+}
+
+// GetEventStream handles incoming HTTP GET requests and streams each event
+// published to the EventBus until the client disconnects or the server
+// stops. Events are sent as server-sent events, or as newline-delimited
+// JSON if the request has the query parameter `format=ndjson`.
+func (e *Endpoints) GetEventStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failedStatus := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || e.stream == nil {
+		failedStatus := http.StatusInternalServerError
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		return
+	}
+	ch := e.stream.subscribe()
+	if ch == nil {
+		failedStatus := http.StatusServiceUnavailable
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		return
+	}
+	defer e.stream.unsubscribe(ch)
+
+	ndjson := r.URL.Query().Get("format") == "ndjson"
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			body, err := json.Marshal(streamEvent{
+				Code: event.Code.String(), Source: event.Source})
+			if err != nil {
+				log.Errorf("control: unable to serialize event %v: %v", event, err)
+				continue
+			}
+			if ndjson {
+				_, err = fmt.Fprintf(w, "%s\n", body)
+			} else {
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n",
+					event.Code.String(), body)
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package control
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestGetEventStream(t *testing.T) {
+	testFunc := func(t *testing.T, query string, expected []string) {
+		stream := newEventStream()
+		endpoints := &Endpoints{stream: stream}
+		server := httptest.NewServer(http.HandlerFunc(endpoints.GetEventStream))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/v3/events/stream" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK, "expected %v but got %v")
+
+		// the response headers are only flushed after we've subscribed
+		stream.publish(events.Event{Code: events.ExitSuccess, Source: "app"})
+		stream.close()
+
+		got := []string{}
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		assert.Equal(t, got, expected, "expected %q but got %q")
+	}
+
+	t.Run("SSE", func(t *testing.T) {
+		testFunc(t, "", []string{
+			"event: ExitSuccess",
+			`data: {"code":"ExitSuccess","source":"app"}`,
+			"",
+		})
+	})
+	t.Run("ndjson", func(t *testing.T) {
+		testFunc(t, "?format=ndjson", []string{
+			`{"code":"ExitSuccess","source":"app"}`,
+		})
+	})
+}
+
+func TestEventStreamClosed(t *testing.T) {
+	stream := newEventStream()
+	ch := stream.subscribe()
+	stream.close()
+	if _, ok := <-ch; ok {
+		t.Fatal("expected subscriber channel to be closed")
+	}
+	if stream.subscribe() != nil {
+		t.Fatal("expected no new subscribers after stream was closed")
+	}
+	stream.unsubscribe(ch) // should not panic on double-close
+}
@@ -0,0 +1,85 @@
+package control
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// TLSConfig configures TLS for the control server. When RequireClientCert
+// is set, clients must present a certificate signed by the CA.
+type TLSConfig struct {
+	Cert              string `mapstructure:"cert"`
+	Key               string `mapstructure:"key"`
+	CA                string `mapstructure:"ca"`
+	RequireClientCert bool   `mapstructure:"requireClientCert"`
+
+	certificate tls.Certificate
+	caPool      *x509.CertPool
+}
+
+// Validate loads the certificate, key, and CA files so that we can fail
+// at config load time rather than when we start serving.
+func (cfg *TLSConfig) Validate() error {
+	if cfg.Cert == "" || cfg.Key == "" {
+		return fmt.Errorf("control.tls requires both 'cert' and 'key'")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return fmt.Errorf("unable to load control.tls certificate: %v", err)
+	}
+	cfg.certificate = cert
+
+	if cfg.CA == "" {
+		if cfg.RequireClientCert {
+			return fmt.Errorf(
+				"control.tls.ca must be set if 'requireClientCert' is set")
+		}
+		return nil
+	}
+	pem, err := ioutil.ReadFile(cfg.CA)
+	if err != nil {
+		return fmt.Errorf("unable to read control.tls.ca: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in control.tls.ca '%s'", cfg.CA)
+	}
+	cfg.caPool = pool
+	return nil
+}
+
+// ServerConfig returns the tls.Config used by the control server.
+func (cfg *TLSConfig) ServerConfig() *tls.Config {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cfg.certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.caPool != nil {
+		tlsConfig.ClientCAs = cfg.caPool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if cfg.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig
+}
+
+// ClientConfig returns a tls.Config for clients of the control server
+// (i.e. the ContainerPilot subcommands). The client presents the same
+// certificate as the server and verifies the server against the CA. For
+// TCP listeners the server name is the host of the address, otherwise the
+// client will verify the server as "control".
+func (cfg *TLSConfig) ClientConfig(socketPath string) *tls.Config {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cfg.certificate},
+		RootCAs:      cfg.caPool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if host, _, err := net.SplitHostPort(socketPath); err == nil {
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig
+}
@@ -0,0 +1,153 @@
+package control
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestTLSConfigValidation(t *testing.T) {
+	dir := writeTestCerts(t)
+	defer os.RemoveAll(dir)
+
+	_, err := NewConfig(tests.DecodeRaw(`{"tls": {"cert": "/nope.pem"}}`))
+	assert.Error(t, err, "control.tls requires both 'cert' and 'key'")
+
+	_, err = NewConfig(tests.DecodeRaw(fmt.Sprintf(
+		`{"tls": {"cert": %q, "key": %q, "requireClientCert": true}}`,
+		filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))))
+	assert.Error(t, err,
+		"control.tls.ca must be set if 'requireClientCert' is set")
+
+	cfg, err := NewConfig(tests.DecodeRaw(fmt.Sprintf(
+		`{"tls": {"cert": %q, "key": %q, "ca": %q, "requireClientCert": true}}`,
+		filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"),
+		filepath.Join(dir, "ca.pem"))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	serverCfg := cfg.TLS.ServerConfig()
+	assert.Equal(t, serverCfg.ClientAuth, tls.RequireAndVerifyClientCert,
+		"expected client auth %v but got %v")
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	dir := writeTestCerts(t)
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := SetupHTTPServer(t, fmt.Sprintf(`{
+	"socket": %q,
+	"socketType": "tcp",
+	"tls": {"cert": %q, "key": %q, "ca": %q, "requireClientCert": true}
+}`, addr, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"),
+		filepath.Join(dir, "ca.pem")))
+	defer s.Stop()
+	s.Start()
+
+	cfg, _ := NewConfig(tests.DecodeRaw(fmt.Sprintf(
+		`{"socket": %q, "socketType": "tcp", "tls": {"cert": %q, "key": %q, "ca": %q}}`,
+		addr, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"),
+		filepath.Join(dir, "ca.pem"))))
+	clientTLS := cfg.TLS.ClientConfig(addr)
+
+	t.Run("with client cert", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{
+			Dial: tcpDialer(addr), TLSClientConfig: clientTLS}}
+		resp, err := client.Get("https://control/v3/xxxx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusNotFound,
+			"expected %v but got %v")
+	})
+
+	t.Run("without client cert", func(t *testing.T) {
+		noCert := &tls.Config{
+			RootCAs: clientTLS.RootCAs, ServerName: clientTLS.ServerName}
+		client := &http.Client{Transport: &http.Transport{
+			Dial: tcpDialer(addr), TLSClientConfig: noCert}}
+		resp, err := client.Get("https://control/v3/xxxx")
+		if err == nil {
+			resp.Body.Close()
+			t.Fatal("expected TLS handshake to fail without client cert")
+		}
+	})
+}
+
+// writeTestCerts writes a CA and a certificate signed by that CA, valid for
+// both server and client auth, to a temporary directory.
+func writeTestCerts(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "containerpilot-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "control"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"control"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	der, err := x509.CreateCertificate(
+		rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	writePEM := func(name, blockType string, data []byte) {
+		out := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+		if err := ioutil.WriteFile(filepath.Join(dir, name), out, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePEM("ca.pem", "CERTIFICATE", caDER)
+	writePEM("cert.pem", "CERTIFICATE", der)
+	writePEM("key.pem", "EC PRIVATE KEY", keyDER)
+	return dir
+}
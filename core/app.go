@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -39,6 +40,8 @@ type App struct {
 	signalLock    *sync.RWMutex
 	ConfigFlag    string
 	Bus           *events.EventBus
+	config        *config.Config
+	pending       *config.Config // loaded by Reload for Run to restart with
 }
 
 // EmptyApp creates an empty application
@@ -59,6 +62,7 @@ func LoadApp() (*App, error) {
 	var renderFlag string
 	var maintFlag string
 
+	var dryRunFlag DryRunFlag
 	var putMetricFlags MultiFlag
 	var putEnvFlags MultiFlag
 
@@ -69,11 +73,29 @@ func LoadApp() (*App, error) {
 		flag.BoolVar(&templateFlag, "template", false,
 			"Render template and quit.")
 
+		flag.Var(&dryRunFlag, "dry-run",
+			`Render and validate the configuration, print the events that start
+	each job in the order the jobs can start, and quit. Use '-dry-run=dot'
+	to print a Graphviz DOT graph instead.`)
+
 		flag.BoolVar(&reloadFlag, "reload", false,
 			"Reload a ContainerPilot process through its control socket.")
 
 		flag.StringVar(&configFlag, "config", "",
-			"File path to JSON5 configuration file. Defaults to CONTAINERPILOT env var.")
+			"File path to JSON5 or YAML configuration file, or a directory of them,\n\tor a consul://key or https:// URL to fetch it from. Defaults to CONTAINERPILOT env var.")
+
+		flag.BoolVar(&config.Strict, "strict", false,
+			`Reject configurations with duplicate job or watch names, service
+	discovery fields on jobs without a port, or 'when.source' that
+	isn't a job, watch, or group. Also set by 'strict: true'.`)
+
+		flag.BoolVar(&config.AllowHTTP, "config-allow-http", false,
+			`Allow the configuration to be fetched from a plain http:// URL.
+	Otherwise only https:// URLs are fetched.`)
+
+		flag.StringVar(&config.Format, "config-format", "",
+			`Format of the configuration file: 'json5' or 'yaml'.
+	Defaults to 'yaml' for files ending in '.yaml' or '.yml', otherwise 'json5'.`)
 
 		flag.StringVar(&renderFlag, "out", "",
 			`File path where to save rendered config file when '-template' is used.
@@ -91,6 +113,7 @@ func LoadApp() (*App, error) {
 			`Update environ of a ContainerPilot process through its control socket.
 	Pass environment in the format: 'key=value'`)
 
+		flag.Usage = usage
 		flag.Parse()
 	}
 
@@ -111,6 +134,14 @@ func LoadApp() (*App, error) {
 		os.Exit(0)
 	}
 
+	if dryRunFlag.Format != "" {
+		err := config.DryRun(configFlag, dryRunFlag.Format, os.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+
 	if reloadFlag {
 		cmd, err := subcommands.Init(configFlag)
 		if err != nil {
@@ -158,6 +189,27 @@ func LoadApp() (*App, error) {
 		os.Exit(0)
 	}
 
+	if flag.NArg() == 2 && flag.Arg(0) == "check" {
+		// given the PATH, check only reads the health file, so there's no
+		// need to load the configuration (or read its Vault secrets)
+		if err := subcommands.CheckHealth(flag.Arg(1), os.Stdout); err != nil {
+			return nil, fmt.Errorf("check: failed to run subcommand: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if flag.NArg() > 0 {
+		cmd, err := subcommands.Init(configFlag)
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Run(flag.Args(), os.Stdout); err != nil {
+			return nil,
+				fmt.Errorf("%s: failed to run subcommand: %v", flag.Arg(0), err)
+		}
+		os.Exit(0)
+	}
+
 	os.Setenv("CONTAINERPILOT_PID", fmt.Sprintf("%v", os.Getpid()))
 
 	app, err := NewApp(configFlag)
@@ -167,14 +219,41 @@ func LoadApp() (*App, error) {
 	return app, nil
 }
 
+// usage prints the default usage for our flags followed by the
+// subcommands that talk to the control socket
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprint(os.Stderr, `
+Subcommands (sent to a running ContainerPilot process):
+  status
+    	Show the state and health of each job.
+  check [PATH]
+    	Exit non-zero unless the health file (control.healthFile) is
+    	current and every job with a health check is healthy.
+  reload
+    	Reload ContainerPilot.
+  maintenance enable|disable
+    	Toggle maintenance mode.
+  env set KEY=VAL [KEY=VAL ...]
+    	Update environ.
+  metric NAME=VALUE [NAME=VALUE ...]
+    	Update metrics.
+`)
+}
+
 // NewApp creates a new App from the config
 func NewApp(configFlag string) (*App, error) {
-	a := EmptyApp()
 	cfg, err := config.LoadConfig(configFlag)
 	if err != nil {
 		return nil, err
 	}
+	return newApp(configFlag, cfg)
+}
 
+// newApp creates a new App from the loaded config
+func newApp(configFlag string, cfg *config.Config) (*App, error) {
+	a := EmptyApp()
 	if err := cfg.InitLogging(); err != nil {
 		return nil, err
 	}
@@ -195,20 +274,30 @@ func NewApp(configFlag string) (*App, error) {
 	a.StopTimeout = cfg.StopTimeout
 	a.Discovery = cfg.Discovery
 	a.Jobs = jobs.FromConfigs(cfg.Jobs)
+	a.ControlServer.Jobs = a.Jobs
+	a.ControlServer.Version = Version
+	a.ControlServer.GitHash = GitHash
+	a.ControlServer.Config = cfg
 	a.Watches = watches.FromConfigs(cfg.Watches)
 	a.Telemetry = telemetry.NewTelemetry(cfg.Telemetry)
+	if a.Telemetry != nil {
+		a.Telemetry.MonitorJobs(a.Jobs)
+	}
 	a.ConfigFlag = configFlag // stash the old config
+	a.config = cfg
+	setJobIPs(a.Jobs)
+	return a, nil
+}
 
-	// set an environment variable for each job IP address so that
-	// forked processes have access to this information
-	for _, job := range a.Jobs {
+// setJobIPs sets an environment variable for each job IP address so that
+// forked processes have access to this information
+func setJobIPs(jobList []*jobs.Job) {
+	for _, job := range jobList {
 		if job.Service != nil {
 			envKey := getEnvVarNameFromService(job.Name)
 			os.Setenv(envKey, job.Service.IPAddress)
 		}
 	}
-
-	return a, nil
 }
 
 // Normalize the validated service name as an environment variable
@@ -221,19 +310,45 @@ func getEnvVarNameFromService(service string) string {
 
 // Run starts the application and blocks until finished
 func (a *App) Run() {
+	a.handleSignals() // once, so that each signal is handled once
 	for {
 		a.Bus = events.NewEventBus()
+		a.ControlServer.Reload = a.Reload
 		a.ControlServer.Run(a.Bus)
-		a.handleSignals()
 		a.handlePolling()
-		if !a.Bus.Wait() {
+		// Reload replaces the config under the lock while we're running
+		a.signalLock.RLock()
+		a.config.RenewSecrets(a.secretRotated)
+		a.signalLock.RUnlock()
+		reloading := a.Bus.Wait()
+		a.signalLock.RLock()
+		replaced := a.config
+		a.signalLock.RUnlock()
+		replaced.StopSecrets()
+		if closer, ok := a.Discovery.(io.Closer); ok {
+			closer.Close() // stop plugins before we reload or exit
+		}
+		if !reloading {
+			replaced.ReleaseSecrets(nil)
 			break
 		}
-		if err := a.reload(); err != nil {
+		cfg, err := a.reload(replaced)
+		if err != nil {
 			log.Error(err)
+			replaced.ReleaseSecrets(nil)
 			break
 		}
+		replaced.ReleaseSecrets(cfg)
+	}
+}
+
+// ExitCode returns the code that ContainerPilot should exit with after
+// Run returns, which is the exit code of the main job if it exited
+func (a *App) ExitCode() int {
+	if a.Bus == nil {
+		return 0
 	}
+	return a.Bus.ExitCode()
 }
 
 // Render the command line args thru golang templating so we can
@@ -257,36 +372,145 @@ func (a *App) Terminate() {
 	a.signalLock.Lock()
 	defer a.signalLock.Unlock()
 	a.Bus.Shutdown()
-	if a.StopTimeout > 0 {
-		time.AfterFunc(time.Duration(a.StopTimeout)*time.Second, func() {
-			for _, job := range a.Jobs {
-				log.Infof("killing processes for job %#v", job.Name)
-				job.Kill()
-			}
-		})
-		return
-	}
+	stopTimeout := time.Duration(a.StopTimeout) * time.Second
 	for _, job := range a.Jobs {
-		log.Infof("killing processes for job %#v", job.Name)
-		job.Kill()
+		job := job
+		kill := func() {
+			log.Infof("killing processes for job %#v", job.Name)
+			job.Kill()
+		}
+		// jobs with a stop grace period get at least that long to exit
+		timeout := stopTimeout
+		if jobTimeout := job.ShutdownTimeout(); jobTimeout > timeout {
+			timeout = jobTimeout
+		}
+		if timeout > 0 {
+			time.AfterFunc(timeout, kill)
+			continue
+		}
+		kill()
 	}
 }
 
-// reload does the actual work of reloading the configuration and
-// updating the App with those changes. The EventBus should be
-// already shut down before we call this.
-func (a *App) reload() error {
-	newApp, err := NewApp(a.ConfigFlag)
+// reload does the actual work of reloading the configuration that
+// replaces the running one and updating the App with those changes, and
+// returns the new configuration. The EventBus should be already shut
+// down before we call this.
+func (a *App) reload(running *config.Config) (*config.Config, error) {
+	reloads.Inc()
+	a.signalLock.Lock()
+	cfg := a.pending
+	a.pending = nil
+	a.signalLock.Unlock()
+	if cfg == nil {
+		var err error
+		cfg, err = config.LoadConfigFrom(a.ConfigFlag, running)
+		if err != nil {
+			log.Errorf("error initializing config: %v", err)
+			return nil, err
+		}
+	}
+	app, err := newApp(a.ConfigFlag, cfg)
 	if err != nil {
 		log.Errorf("error initializing config: %v", err)
+		discardConfig(cfg, running)
+		return nil, err
+	}
+	a.signalLock.Lock()
+	defer a.signalLock.Unlock()
+	a.Discovery = app.Discovery
+	a.Jobs = app.Jobs
+	a.Watches = app.Watches
+	a.StopTimeout = app.StopTimeout
+	a.Telemetry = app.Telemetry
+	a.ControlServer = app.ControlServer
+	a.config = app.config
+	return cfg, nil
+}
+
+// Reload loads the configuration again. If only jobs and watches changed,
+// just the jobs and watches whose definitions changed are stopped and
+// started again, and everything else keeps running. Otherwise the
+// EventBus is shut down so that Run restarts everything with the new
+// configuration. An invalid configuration leaves everything running.
+func (a *App) Reload() error {
+	a.signalLock.Lock()
+	defer a.signalLock.Unlock()
+	cfg, onlyJobs, err := config.ReloadConfig(a.ConfigFlag, a.config)
+	if err != nil {
+		log.Errorf("error reloading config: %v", err)
 		return err
 	}
-	a.Discovery = newApp.Discovery
-	a.Jobs = newApp.Jobs
-	a.Watches = newApp.Watches
-	a.StopTimeout = newApp.StopTimeout
-	a.Telemetry = newApp.Telemetry
-	a.ControlServer = newApp.ControlServer
+	if !onlyJobs {
+		// Run restarts everything with the config we've already loaded
+		discardConfig(a.pending, a.config)
+		a.pending = cfg
+		a.Bus.SetReloadFlag()
+		a.Bus.Shutdown()
+		return nil
+	}
+	reloads.Inc()
+	jobList, stopJobs, startJobs := jobs.Reload(a.Jobs, cfg.Jobs)
+	watchList, stopWatches, startWatches := watches.Reload(a.Watches, cfg.Watches)
+	log.Infof("reloading config: restarting %d jobs and %d watches",
+		len(startJobs), len(startWatches))
+
+	jobs.QuitJobs(stopJobs)
+	for _, watch := range stopWatches {
+		watch.Quit()
+	}
+	a.Jobs = jobList
+	a.Watches = watchList
+	replaced := a.config
+	replaced.StopSecrets()
+	a.config = cfg
+	a.config.RenewSecrets(a.secretRotated)
+	a.ControlServer.Update(jobList, cfg)
+	if a.Telemetry != nil {
+		a.Telemetry.MonitorJobs(jobList)
+	}
+	setJobIPs(startJobs)
+	for _, watch := range startWatches {
+		watch.Run(a.Bus)
+	}
+	for _, job := range startJobs {
+		job.Run(a.Bus)
+	}
+	// only the new jobs get the startup event, since a running job that
+	// has no starts remaining quits when it gets its start event again
+	for _, job := range startJobs {
+		job.Receive(events.GlobalStartup)
+	}
+	// the old leases are revoked only now that the jobs that replaced
+	// those using them are running
+	replaced.ReleaseSecrets(cfg)
+	return nil
+}
+
+// discardConfig releases the Vault leases, other than those reused from
+// the running config, and the discovery backend of a config that won't be
+// run
+func discardConfig(cfg, running *config.Config) {
+	if cfg == nil {
+		return
+	}
+	cfg.ReleaseSecrets(running)
+	if closer, ok := cfg.Discovery.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// secretRotated reloads the configuration after a Vault secret that it
+// uses rotates, or shuts down the EventBus so that Run restarts
+// everything with the new secret if restart is true
+func (a *App) secretRotated(restart bool) error {
+	if !restart {
+		return a.Reload()
+	}
+	a.signalLock.Lock()
+	defer a.signalLock.Unlock()
+	a.Bus.SetReloadFlag()
+	a.Bus.Shutdown()
 	return nil
 }
 
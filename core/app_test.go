@@ -7,12 +7,14 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
 	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/tests/assert"
 	"github.com/joyent/containerpilot/tests/mocks"
+	dto "github.com/prometheus/client_model/go"
 )
 
 /*
@@ -209,7 +211,7 @@ func TestReloadConfig(t *testing.T) {
 	defer os.Remove(f.Name())
 	app.ConfigFlag = f.Name()
 
-	err := app.reload()
+	_, err := app.reload(app.config)
 	if err == nil {
 		t.Errorf("invalid configuration did not return error")
 	}
@@ -226,7 +228,7 @@ func TestReloadConfig(t *testing.T) {
 	if err := f2.Close(); err != nil {
 		t.Fatal(err)
 	}
-	err = app.reload()
+	_, err = app.reload(app.config)
 	if err != nil {
 		t.Errorf("valid configuration returned error: %v", err)
 	}
@@ -236,6 +238,88 @@ func TestReloadConfig(t *testing.T) {
 	}
 }
 
+// Test that a reload that restarts everything uses the config that
+// Reload already loaded, rather than loading it again
+func TestReloadPendingConfig(t *testing.T) {
+	f := testCfgToTempFile(t, `{consul: "consul:8500"}`)
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	app.Bus = events.NewEventBus()
+	if err := ioutil.WriteFile(f.Name(),
+		[]byte(`{consul: "newconsul:8500"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pending := app.pending
+	if pending == nil {
+		t.Fatal("expected Reload to keep the config it loaded")
+	}
+	cfg, err := app.reload(app.config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg != pending || app.config != pending || app.pending != nil {
+		t.Fatalf("expected reload to use the pending config %v but got %v",
+			pending, cfg)
+	}
+}
+
+// Test that a reload only restarts the jobs that changed
+func TestReloadOnlyChangedJobs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "reload")
+	defer os.RemoveAll(dir)
+	config := `{
+	consul: "consul:8500",
+	control: {socket: "%s/cp.socket"},
+	jobs: [
+		{name: "kept", exec: "sleep 10"},
+		{name: "changed", exec: "sleep %d"}]}`
+	f := testCfgToTempFile(t, fmt.Sprintf(config, dir, 10))
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go app.Run()
+	pids := func() map[string]int {
+		pids := map[string]int{}
+		for i := 0; i < 50; i++ {
+			for _, job := range app.Jobs {
+				pids[job.Name] = job.Pid()
+			}
+			if pids["kept"] != 0 && pids["changed"] != 0 {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return pids
+	}
+	before := pids()
+
+	if err := ioutil.WriteFile(f.Name(),
+		[]byte(fmt.Sprintf(config, dir, 20)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after := pids()
+	app.Terminate()
+	if before["kept"] == 0 || after["kept"] != before["kept"] {
+		t.Fatalf("expected unchanged job to keep running but got %v then %v",
+			before, after)
+	}
+	if after["changed"] == 0 || after["changed"] == before["changed"] {
+		t.Fatalf("expected changed job to be restarted but got %v then %v",
+			before, after)
+	}
+}
+
 // ----------------------------------------------------
 // test helpers
 
@@ -264,3 +348,15 @@ func argTestSetup() []string {
 func argTestCleanup(oldArgs []string) {
 	os.Args = oldArgs
 }
+
+func TestBuildInfo(t *testing.T) {
+	m := &dto.Metric{}
+	newBuildInfo("3.9.0", "abc1234").Write(m)
+	labels := map[string]string{}
+	for _, label := range m.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, labels, map[string]string{"version": "3.9.0", "commit": "abc1234"},
+		"expected labels %v but got %v")
+	assert.Equal(t, m.GetGauge().GetValue(), 1.0, "expected value %v but got %v")
+}
@@ -37,3 +37,36 @@ func (f *MultiFlag) Set(value string) error {
 func (f MultiFlag) Len() int {
 	return len(f.Values)
 }
+
+// DryRunFlag provides the -dry-run CLI flag, which can be passed without a
+// value for text output or with one to choose the format, ex. -dry-run=dot
+type DryRunFlag struct {
+	Format string
+}
+
+// String satisfies the flag.Value interface
+func (f *DryRunFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.Format
+}
+
+// Set satisfies the flag.Value interface. Passing the flag without a value
+// sets it to "true", which selects text output.
+func (f *DryRunFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.Format = "text"
+	case "false":
+		f.Format = ""
+	default:
+		f.Format = value
+	}
+	return nil
+}
+
+// IsBoolFlag lets the flag be passed without a value
+func (f *DryRunFlag) IsBoolFlag() bool {
+	return true
+}
@@ -0,0 +1,29 @@
+package core
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var reloads = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "containerpilot",
+	Name:      "reloads_total",
+	Help:      "Number of times the ContainerPilot configuration has been reloaded.",
+})
+
+func init() {
+	prometheus.MustRegister(reloads, newBuildInfo(Version, GitHash))
+}
+
+// newBuildInfo returns a gauge that is always 1, labeled with the version
+// of this build, so that rollouts can be tracked across a fleet
+func newBuildInfo(version, gitHash string) prometheus.Gauge {
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "containerpilot",
+		Name:      "build_info",
+		Help:      "The version and commit of this ContainerPilot build.",
+		ConstLabels: prometheus.Labels{
+			"version": version,
+			"commit":  gitHash,
+		},
+	})
+	buildInfo.Set(1)
+	return buildInfo
+}
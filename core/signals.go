@@ -9,7 +9,7 @@ import (
 // HandleSignals listens for and captures signals used for orchestration
 func (a *App) handleSignals() {
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	go func() {
 		for signal := range sig {
 			switch signal {
@@ -17,6 +17,8 @@ func (a *App) handleSignals() {
 				a.Terminate()
 			case syscall.SIGTERM:
 				a.Terminate()
+			case syscall.SIGHUP:
+				a.Reload() // errors are logged and leave the config as it was
 			}
 		}
 	}()
@@ -0,0 +1,386 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// ecsCredentialsHost serves the task role credentials in ECS, and imdsHost
+// the instance profile credentials in EC2
+var (
+	ecsCredentialsHost = "http://169.254.170.2"
+	imdsHost           = "http://169.254.169.254"
+)
+
+// CloudMap is a service discovery backend that registers instances into
+// AWS Cloud Map services and updates their custom health status from our
+// health checks. Watches discover the healthy instances of a service in
+// the configured namespace.
+type CloudMap struct {
+	client    *http.Client
+	region    string
+	namespace string
+	endpoint  string // for RegisterInstance, etc.
+	discovery string // for DiscoverInstances
+	serviceID map[string]string
+
+	services *serviceRecords
+
+	lock            sync.RWMutex
+	creds           awsCredentials
+	reported        map[string]string // last status sent, by instance ID
+	watchedServices map[string][]*api.ServiceEntry
+}
+
+// NewCloudMap creates a new service discovery backend for AWS Cloud Map
+func NewCloudMap(config interface{}) (*CloudMap, error) {
+	cfg := &struct {
+		Region    string            `mapstructure:"region"`
+		Namespace string            `mapstructure:"namespace"`
+		Services  map[string]string `mapstructure:"services"`
+		Endpoint  string            `mapstructure:"endpoint"`
+	}{}
+	if err := utils.DecodeRaw(config, cfg); err != nil {
+		return nil, fmt.Errorf("cloudmap config parsing error: %v", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("cloudmap.region must be set if AWS_REGION isn't")
+	}
+	if cfg.Namespace == "" {
+		return nil, fmt.Errorf("cloudmap.namespace must not be empty")
+	}
+	endpoint := "https://servicediscovery." + cfg.Region + ".amazonaws.com"
+	discovery := "https://data-servicediscovery." + cfg.Region + ".amazonaws.com"
+	if cfg.Endpoint != "" {
+		// used for testing and VPC endpoints
+		endpoint, discovery = cfg.Endpoint, cfg.Endpoint
+	}
+	if cfg.Services == nil {
+		cfg.Services = map[string]string{}
+	}
+	return &CloudMap{
+		client:          &http.Client{Timeout: 10 * time.Second},
+		region:          cfg.Region,
+		namespace:       cfg.Namespace,
+		endpoint:        endpoint,
+		discovery:       discovery,
+		serviceID:       cfg.Services,
+		services:        newServiceRecords(),
+		reported:        make(map[string]string),
+		watchedServices: make(map[string][]*api.ServiceEntry),
+	}, nil
+}
+
+// ServiceRegister registers the instance with the Cloud Map service ID
+// configured for the service name
+func (c *CloudMap) ServiceRegister(service *api.AgentServiceRegistration) error {
+	serviceID, ok := c.serviceID[service.Name]
+	if !ok {
+		return fmt.Errorf("no cloudmap.services ID for service %s", service.Name)
+	}
+	record := c.services.register(service)
+	c.lock.Lock()
+	delete(c.reported, record.ID)
+	c.lock.Unlock()
+	attributes := map[string]string{
+		"AWS_INSTANCE_PORT": strconv.Itoa(record.Port),
+	}
+	if record.Address != "" {
+		attributes["AWS_INSTANCE_IPV4"] = record.Address
+	}
+	return c.call(c.endpoint, "RegisterInstance", map[string]interface{}{
+		"ServiceId":        serviceID,
+		"InstanceId":       record.ID,
+		"CreatorRequestId": fmt.Sprintf("%s-%d", record.ID, time.Now().UnixNano()),
+		"Attributes":       attributes,
+	}, nil)
+}
+
+// ServiceDeregister deregisters the instance
+func (c *CloudMap) ServiceDeregister(serviceID string) error {
+	record, err := c.services.deregister(serviceID)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	delete(c.reported, record.ID)
+	c.lock.Unlock()
+	return c.call(c.endpoint, "DeregisterInstance", map[string]interface{}{
+		"ServiceId":  c.serviceID[record.Name],
+		"InstanceId": record.ID,
+	}, nil)
+}
+
+// CheckRegister records the check for a registered service. The Cloud Map
+// service must be created with a custom health check config.
+func (c *CloudMap) CheckRegister(check *api.AgentCheckRegistration) error {
+	return c.services.registerCheck(check)
+}
+
+// PassTTL marks the instance healthy
+func (c *CloudMap) PassTTL(checkID, note string) error {
+	return c.updateTTL(checkID, statusPassing)
+}
+
+// WarnTTL marks the instance unhealthy, as Cloud Map has no warning status
+func (c *CloudMap) WarnTTL(checkID, note string) error {
+	return c.updateTTL(checkID, statusWarning)
+}
+
+// FailTTL marks the instance unhealthy
+func (c *CloudMap) FailTTL(checkID, note string) error {
+	return c.updateTTL(checkID, statusCritical)
+}
+
+// updateTTL sends the custom health status only when it changes, because
+// Cloud Map has no TTLs and its API calls are rate limited
+func (c *CloudMap) updateTTL(checkID, status string) error {
+	record, err := c.services.update(checkID, status)
+	if err != nil {
+		return err
+	}
+	health := "UNHEALTHY"
+	if status == statusPassing {
+		health = "HEALTHY"
+	}
+	c.lock.RLock()
+	reported := c.reported[record.ID]
+	c.lock.RUnlock()
+	if reported == health {
+		return nil
+	}
+	err = c.call(c.endpoint, "UpdateInstanceCustomHealthStatus",
+		map[string]interface{}{
+			"ServiceId":  c.serviceID[record.Name],
+			"InstanceId": record.ID,
+			"Status":     health,
+		}, nil)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.reported[record.ID] = health
+	c.lock.Unlock()
+	return nil
+}
+
+// CheckForUpstreamChanges discovers the healthy instances of a service and
+// checks whether there has been a change since the last check. Tags aren't
+// supported by Cloud Map, so the tag is ignored.
+func (c *CloudMap) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
+	var resp struct {
+		Instances []struct {
+			InstanceID string            `json:"InstanceId"`
+			Attributes map[string]string `json:"Attributes"`
+		} `json:"Instances"`
+	}
+	err := c.call(c.discovery, "DiscoverInstances", map[string]interface{}{
+		"NamespaceName": c.namespace,
+		"ServiceName":   backendName,
+		"HealthStatus":  "HEALTHY",
+	}, &resp)
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	instances := []*api.ServiceEntry{}
+	for _, instance := range resp.Instances {
+		port, _ := strconv.Atoi(instance.Attributes["AWS_INSTANCE_PORT"])
+		instances = append(instances, &api.ServiceEntry{
+			Service: &api.AgentService{
+				ID:      instance.InstanceID,
+				Service: backendName,
+				Address: instance.Attributes["AWS_INSTANCE_IPV4"],
+				Port:    port,
+			},
+		})
+	}
+	isHealthy = len(instances) > 0
+	didChange = c.compareAndSwap(backendName, instances)
+	return didChange, isHealthy
+}
+
+// returns true if any addresses for the service changed and updates
+// the internal state
+func (c *CloudMap) compareAndSwap(service string, new []*api.ServiceEntry) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	existing := c.watchedServices[service]
+	c.watchedServices[service] = new
+	return compareForChange(existing, new)
+}
+
+// call makes a signed request to the Cloud Map JSON API, decoding the
+// response into result if it's non-nil
+func (c *CloudMap) call(endpoint, action string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	creds, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Route53AutoNaming_v20170314."+action)
+	signV4(req, body, creds, c.region, "servicediscovery", time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cloudmap %s returned %s: %s",
+			action, resp.Status, bytes.TrimSpace(respBody))
+	}
+	if result != nil {
+		return json.Unmarshal(respBody, result)
+	}
+	return nil
+}
+
+// credentials returns the AWS credentials from the environment, the ECS
+// task role, or the EC2 instance profile, refreshing them before they expire
+func (c *CloudMap) credentials() (awsCredentials, error) {
+	if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+		return awsCredentials{
+			AccessKeyID:     key,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	c.lock.RLock()
+	creds := c.creds
+	c.lock.RUnlock()
+	if creds.AccessKeyID != "" && time.Now().Add(5*time.Minute).Before(creds.Expiration) {
+		return creds, nil
+	}
+	var err error
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		creds, err = c.ecsCredentials(uri)
+	} else {
+		creds, err = c.instanceCredentials()
+	}
+	if err != nil {
+		return creds, err
+	}
+	c.lock.Lock()
+	c.creds = creds
+	c.lock.Unlock()
+	return creds, nil
+}
+
+// ecsCredentials fetches the ECS task role credentials
+func (c *CloudMap) ecsCredentials(uri string) (awsCredentials, error) {
+	resp, err := c.client.Get(ecsCredentialsHost + uri)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("unable to get ECS task credentials: %v", err)
+	}
+	defer resp.Body.Close()
+	creds, err := decodeRoleCredentials(resp.Body)
+	if err != nil {
+		return creds, fmt.Errorf("unable to parse ECS task credentials: %v", err)
+	}
+	return creds, nil
+}
+
+// instanceCredentials fetches the EC2 instance profile credentials from the
+// instance metadata service, using an IMDSv2 session token
+func (c *CloudMap) instanceCredentials() (awsCredentials, error) {
+	token, err := c.imds("PUT", "/latest/api/token",
+		"X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials: set "+
+			"AWS_ACCESS_KEY_ID or run with an ECS task role or EC2 instance "+
+			"profile (%v)", err)
+	}
+	path := "/latest/meta-data/iam/security-credentials/"
+	roles, err := c.imds("GET", path, "X-aws-ec2-metadata-token", string(token))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("unable to get EC2 instance profile: %v", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials: EC2 instance has no instance profile")
+	}
+	body, err := c.imds("GET", path+role, "X-aws-ec2-metadata-token", string(token))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("unable to get EC2 instance credentials: %v", err)
+	}
+	creds, err := decodeRoleCredentials(bytes.NewReader(body))
+	if err != nil {
+		return creds, fmt.Errorf("unable to parse EC2 instance credentials: %v", err)
+	}
+	return creds, nil
+}
+
+// imds makes a request to the EC2 instance metadata service with a short
+// timeout, so that we fail fast when we're not running in EC2
+func (c *CloudMap) imds(method, path, header, value string) ([]byte, error) {
+	req, err := http.NewRequest(method, imdsHost+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(header, value)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s returned %s", method, path, resp.Status)
+	}
+	return body, nil
+}
+
+// decodeRoleCredentials parses the role credentials document served by
+// both ECS and the EC2 instance metadata service
+func decodeRoleCredentials(r io.Reader) (awsCredentials, error) {
+	var role struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.NewDecoder(r).Decode(&role); err != nil {
+		return awsCredentials{}, err
+	}
+	return awsCredentials{
+		AccessKeyID:     role.AccessKeyID,
+		SecretAccessKey: role.SecretAccessKey,
+		SessionToken:    role.Token,
+		Expiration:      role.Expiration,
+	}, nil
+}
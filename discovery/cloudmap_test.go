@@ -0,0 +1,171 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+// fakeCloudMap records the actions called and serves DiscoverInstances
+// from the instances registered with it
+type fakeCloudMap struct {
+	lock      sync.Mutex
+	actions   []string
+	instances map[string]map[string]interface{}
+	health    map[string]string
+}
+
+func (f *fakeCloudMap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "unsigned", http.StatusForbidden)
+		return
+	}
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"),
+		"Route53AutoNaming_v20170314.")
+	f.actions = append(f.actions, action)
+	var params map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&params)
+	id, _ := params["InstanceId"].(string)
+	switch action {
+	case "RegisterInstance":
+		f.instances[id] = params
+		f.health[id] = "UNHEALTHY"
+	case "DeregisterInstance":
+		delete(f.instances, id)
+	case "UpdateInstanceCustomHealthStatus":
+		f.health[id] = params["Status"].(string)
+	case "DiscoverInstances":
+		instances := []interface{}{}
+		for id, instance := range f.instances {
+			if f.health[id] == "HEALTHY" {
+				instances = append(instances, map[string]interface{}{
+					"InstanceId": id, "Attributes": instance["Attributes"]})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Instances": instances})
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+func TestCloudMapConfig(t *testing.T) {
+	os.Unsetenv("AWS_REGION")
+	_, err := NewCloudMap(map[string]interface{}{"namespace": "local"})
+	assert.Error(t, err, "cloudmap.region must be set if AWS_REGION isn't")
+	_, err = NewCloudMap(map[string]interface{}{"region": "us-east-1"})
+	assert.Error(t, err, "cloudmap.namespace must not be empty")
+}
+
+func TestCloudMapRegistrationAndWatch(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	fake := &fakeCloudMap{
+		instances: make(map[string]map[string]interface{}),
+		health:    make(map[string]string),
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	c, err := NewCloudMap(map[string]interface{}{
+		"region":    "us-east-1",
+		"namespace": "local",
+		"services":  map[string]string{"app": "srv-1234"},
+		"endpoint":  server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &ServiceDefinition{ID: "app-1", Name: "app", Port: 8080,
+		TTL: 10, IPAddress: "10.0.0.1", Consul: c}
+	service.SendHeartbeat()
+	didChange, isHealthy := c.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change after registration")
+	assert.True(t, isHealthy, "expected healthy after registration")
+	entry := c.watchedServices["app"][0].Service
+	assert.Equal(t, entry.Address, "10.0.0.1", "expected address %v but got %v")
+	assert.Equal(t, entry.Port, 8080, "expected port %v but got %v")
+
+	// health status is only sent when it changes
+	service.SendHeartbeat()
+	service.SendHeartbeat()
+	count := 0
+	for _, action := range fake.actions {
+		if action == "UpdateInstanceCustomHealthStatus" {
+			count++
+		}
+	}
+	assert.Equal(t, count, 1, "expected %v health update but got %v")
+
+	service.SendFailure("failed")
+	didChange, isHealthy = c.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change after failure")
+	assert.False(t, isHealthy, "expected unhealthy after failure")
+
+	service.Deregister()
+	_, ok := fake.instances["app-1"]
+	assert.False(t, ok, "expected instance to be deregistered")
+}
+
+func TestCloudMapUnknownService(t *testing.T) {
+	c, _ := NewCloudMap(map[string]interface{}{
+		"region": "us-east-1", "namespace": "local"})
+	service := &ServiceDefinition{ID: "web-1", Name: "web", Consul: c}
+	err := service.registerService()
+	assert.Error(t, err, "no cloudmap.services ID for service web")
+}
+
+func TestCloudMapInstanceCredentials(t *testing.T) {
+	os.Unsetenv("AWS_ACCESS_KEY_ID")
+	os.Unsetenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	imds := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "PUT" && r.URL.Path == "/latest/api/token" {
+				w.Write([]byte("token"))
+				return
+			}
+			if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+				http.Error(w, "no token", http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/latest/meta-data/iam/security-credentials/":
+				w.Write([]byte("app-role\n"))
+			case "/latest/meta-data/iam/security-credentials/app-role":
+				w.Write([]byte(`{"AccessKeyId": "AKIDEXAMPLE",
+					"SecretAccessKey": "secret", "Token": "session",
+					"Expiration": "2099-01-01T00:00:00Z"}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	defer imds.Close()
+	defer func(host string) { imdsHost = host }(imdsHost)
+	imdsHost = imds.URL
+
+	c, _ := NewCloudMap(map[string]interface{}{
+		"region": "us-east-1", "namespace": "local"})
+	creds, err := c.credentials()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, creds.AccessKeyID, "AKIDEXAMPLE", "expected AccessKeyID %q got %q")
+	assert.Equal(t, creds.SessionToken, "session", "expected SessionToken %q got %q")
+
+	imds.Close()
+	creds, err = c.credentials()
+	if err != nil {
+		t.Fatalf("unexpected error with cached credentials: %v", err)
+	}
+	assert.Equal(t, creds.AccessKeyID, "AKIDEXAMPLE", "expected cached AccessKeyID %q got %q")
+}
@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/hashicorp/consul/api"
@@ -9,18 +10,53 @@ import (
 
 func configFromMap(raw map[string]interface{}) (*api.Config, error) {
 	config := &struct {
-		Address string `mapstructure:"address"`
-		Scheme  string `mapstructure:"scheme"`
-		Token   string `mapstructure:"token"`
+		Address   string `mapstructure:"address"`
+		Scheme    string `mapstructure:"scheme"`
+		Token     string `mapstructure:"token"`
+		Namespace string `mapstructure:"namespace"`
+		Partition string `mapstructure:"partition"`
+		TLS       *struct {
+			CAFile             string `mapstructure:"caFile"`
+			CAPath             string `mapstructure:"caPath"`
+			CertFile           string `mapstructure:"certFile"`
+			KeyFile            string `mapstructure:"keyFile"`
+			ServerName         string `mapstructure:"serverName"`
+			InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+		} `mapstructure:"tls"`
+
+		Vault interface{} `mapstructure:"vault"` // see newVaultToken
 	}{}
 	if err := utils.DecodeRaw(raw, config); err != nil {
 		return nil, err
 	}
-	return &api.Config{
-		Address: config.Address,
-		Scheme:  config.Scheme,
-		Token:   config.Token,
-	}, nil
+	consulConfig := &api.Config{
+		Address:   config.Address,
+		Scheme:    config.Scheme,
+		Token:     config.Token,
+		Namespace: config.Namespace,
+		Partition: config.Partition,
+	}
+	if tls := config.TLS; tls != nil {
+		if (tls.CertFile == "") != (tls.KeyFile == "") {
+			return nil, errors.New("consul.tls.certFile and consul.tls.keyFile " +
+				"must be set together")
+		}
+		if consulConfig.Scheme == "" {
+			consulConfig.Scheme = "https"
+		}
+		// unset fields fall back to the CONSUL_CACERT, CONSUL_CAPATH,
+		// CONSUL_CLIENT_CERT, CONSUL_CLIENT_KEY, CONSUL_TLS_SERVER_NAME, and
+		// CONSUL_HTTP_SSL_VERIFY environment variables in api.NewClient
+		consulConfig.TLSConfig = api.TLSConfig{
+			CAFile:             tls.CAFile,
+			CAPath:             tls.CAPath,
+			CertFile:           tls.CertFile,
+			KeyFile:            tls.KeyFile,
+			Address:            tls.ServerName,
+			InsecureSkipVerify: tls.InsecureSkipVerify,
+		}
+	}
+	return consulConfig, nil
 }
 
 func configFromURI(uri string) (*api.Config, error) {
@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// ConnectDefinition registers a Consul Connect sidecar proxy along with a
+// service, and optionally writes the service's Connect leaf certificate
+// to CertDir for a proxy (or the service itself) to use.
+type ConnectDefinition struct {
+	SidecarPort int
+	Upstreams   []api.Upstream
+	CertDir     string
+
+	certRefresh time.Time
+}
+
+// ConnectBackend is implemented by discovery backends that can issue
+// Consul Connect certificates
+type ConnectBackend interface {
+	ConnectCALeaf(serviceName string) (*api.LeafCert, error)
+	ConnectCARoots() ([]string, error)
+}
+
+func (connect *ConnectDefinition) registration() *api.AgentServiceConnect {
+	return &api.AgentServiceConnect{
+		SidecarService: &api.AgentServiceRegistration{
+			Port: connect.SidecarPort,
+			Proxy: &api.AgentServiceConnectProxyConfig{
+				Upstreams: connect.Upstreams,
+			},
+		},
+	}
+}
+
+// refreshCerts writes the leaf certificate, its key, and the CA roots to
+// CertDir if they haven't been written yet or the certificate is past the
+// halfway point of its validity
+func (connect *ConnectDefinition) refreshCerts(backend Backend, serviceName string) error {
+	if connect.CertDir == "" || time.Now().Before(connect.certRefresh) {
+		return nil
+	}
+	var ca ConnectBackend
+	if !Find(backend, &ca) {
+		return fmt.Errorf("discovery backend doesn't support Connect certificates")
+	}
+	leaf, err := ca.ConnectCALeaf(serviceName)
+	if err != nil {
+		return fmt.Errorf("unable to fetch Connect leaf certificate: %v", err)
+	}
+	roots, err := ca.ConnectCARoots()
+	if err != nil {
+		return fmt.Errorf("unable to fetch Connect CA roots: %v", err)
+	}
+	files := []struct {
+		name, data string
+		mode       os.FileMode
+	}{
+		{"cert.pem", leaf.CertPEM, 0644},
+		{"key.pem", leaf.PrivateKeyPEM, 0600},
+		{"ca.pem", strings.Join(roots, "\n"), 0644},
+	}
+	for _, f := range files {
+		if err := writeFileAtomic(filepath.Join(connect.CertDir, f.name),
+			[]byte(f.data), f.mode); err != nil {
+			return err
+		}
+	}
+	validity := leaf.ValidBefore.Sub(leaf.ValidAfter)
+	connect.certRefresh = leaf.ValidAfter.Add(validity / 2)
+	log.Debugf("wrote Connect certificate for %s, valid until %v",
+		serviceName, leaf.ValidBefore)
+	return nil
+}
+
+// writeFileAtomic writes the file via a rename so that readers never see
+// a partially written certificate
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+// fakeConnectBackend issues certificates valid for an hour
+type fakeConnectBackend struct {
+	Backend
+	issued int
+}
+
+func (f *fakeConnectBackend) ConnectCALeaf(serviceName string) (*api.LeafCert, error) {
+	f.issued++
+	now := time.Now()
+	return &api.LeafCert{
+		CertPEM:       "cert-" + serviceName,
+		PrivateKeyPEM: "key-" + serviceName,
+		ValidAfter:    now,
+		ValidBefore:   now.Add(time.Hour),
+	}, nil
+}
+
+func (f *fakeConnectBackend) ConnectCARoots() ([]string, error) {
+	return []string{"root1", "root2"}, nil
+}
+
+func TestConnectRegistration(t *testing.T) {
+	connect := &ConnectDefinition{
+		SidecarPort: 21000,
+		Upstreams:   []api.Upstream{{DestinationName: "db", LocalBindPort: 5432}},
+	}
+	reg := connect.registration()
+	assert.Equal(t, reg.SidecarService.Port, 21000, "expected %v but got %v")
+	assert.Equal(t, reg.SidecarService.Proxy.Upstreams[0].DestinationName, "db",
+		"expected %v but got %v")
+}
+
+func TestConnectRefreshCerts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "containerpilot-connect")
+	defer os.RemoveAll(dir)
+	backend := &fakeConnectBackend{}
+	connect := &ConnectDefinition{CertDir: dir}
+
+	if err := connect.refreshCerts(backend, "app"); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := ioutil.ReadFile(filepath.Join(dir, "cert.pem"))
+	assert.Equal(t, string(cert), "cert-app", "expected %v but got %v")
+	ca, _ := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+	assert.Equal(t, string(ca), "root1\nroot2", "expected %v but got %v")
+	info, _ := os.Stat(filepath.Join(dir, "key.pem"))
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600), "expected %v but got %v")
+
+	// not refreshed until halfway through the validity period
+	connect.refreshCerts(backend, "app")
+	assert.Equal(t, backend.issued, 1, "expected %v certificate but got %v")
+	connect.certRefresh = time.Now().Add(-time.Second)
+	connect.refreshCerts(backend, "app")
+	assert.Equal(t, backend.issued, 2, "expected %v certificates but got %v")
+}
@@ -2,9 +2,12 @@ package discovery
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/hashicorp/consul/api"
@@ -13,20 +16,25 @@ import (
 // Consul wraps the service discovery backend for the Hashicorp Consul client
 // and tracks the state of all watched dependencies.
 type Consul struct {
-	api.Client
+	*api.Client
 	lock            sync.RWMutex
 	watchedServices map[string][]*api.ServiceEntry
+	vault           *vaultToken
 }
 
 // NewConsul creates a new service discovery backend for Consul
 func NewConsul(config interface{}) (*Consul, error) {
 	var consulConfig *api.Config
+	var vault *vaultToken
 	var err error
 	switch t := config.(type) {
 	case string:
 		consulConfig, err = configFromURI(t)
 	case map[string]interface{}:
 		consulConfig, err = configFromMap(t)
+		if err == nil && t["vault"] != nil {
+			vault, err = newVaultToken(t["vault"])
+		}
 	default:
 		return nil, fmt.Errorf("no discovery backend defined")
 	}
@@ -37,53 +45,211 @@ func NewConsul(config interface{}) (*Consul, error) {
 	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
 		consulConfig.Token = token
 	}
+	if vault != nil && consulConfig.Token != "" {
+		return nil, fmt.Errorf("consul.vault can't be used with a consul.token " +
+			"or CONSUL_HTTP_TOKEN")
+	}
 	client, err := api.NewClient(consulConfig)
 	if err != nil {
 		return nil, err
 	}
-	watchedServices := make(map[string][]*api.ServiceEntry)
-	consul := &Consul{*client, sync.RWMutex{}, watchedServices}
+	consul := &Consul{
+		Client:          client,
+		watchedServices: make(map[string][]*api.ServiceEntry),
+		vault:           vault,
+	}
+	if vault != nil {
+		// fetch the token at startup so that a bad Vault config fails early
+		token, err := vault.current(time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("unable to get Consul token from Vault: %v", err)
+		}
+		consul.setToken(token)
+	}
 	return consul, nil
 }
 
+// do calls fn, first updating the Consul token from Vault if one is
+// configured. If Consul rejects the token (ex. because it was revoked or
+// rotated), we fetch a new token and retry once.
+func (c *Consul) do(fn func() error) error {
+	if c.vault == nil {
+		return fn()
+	}
+	token, err := c.vault.current(time.Now())
+	if err != nil {
+		return fmt.Errorf("unable to get Consul token from Vault: %v", err)
+	}
+	c.setToken(token)
+	err = fn()
+	if !isForbidden(err) {
+		return err
+	}
+	log.Infof("Consul rejected the token from Vault, fetching a new token: %v", err)
+	if token, err = c.vault.rotate(time.Now()); err != nil {
+		return fmt.Errorf("unable to get Consul token from Vault: %v", err)
+	}
+	c.setToken(token)
+	return fn()
+}
+
+// isForbidden returns true if the error is a 403 response from Consul.
+// The Consul API client only surfaces the status code in the error text.
+func isForbidden(err error) bool {
+	return err != nil &&
+		strings.HasPrefix(err.Error(), "Unexpected response code: 403")
+}
+
+func (c *Consul) setToken(token string) {
+	c.SetHeaders(http.Header{"X-Consul-Token": []string{token}})
+}
+
 // PassTTL wraps the Consul.Agent's PassTTL method, and is used to set a
 // TTL check to the passing state
 func (c *Consul) PassTTL(name, note string) error {
-	return c.Agent().PassTTL(name, note)
+	return c.do(func() error { return c.Agent().PassTTL(name, note) })
+}
+
+// WarnTTL wraps the Consul.Agent's WarnTTL method, and is used to set a
+// TTL check to the warning state
+func (c *Consul) WarnTTL(name, note string) error {
+	return c.do(func() error { return c.Agent().WarnTTL(name, note) })
+}
+
+// FailTTL wraps the Consul.Agent's FailTTL method, and is used to set a
+// TTL check to the critical state
+func (c *Consul) FailTTL(name, note string) error {
+	return c.do(func() error { return c.Agent().FailTTL(name, note) })
 }
 
 // CheckRegister wraps the Consul.Agent's CheckRegister method,
 // is used to register a new service with the local agent
 func (c *Consul) CheckRegister(check *api.AgentCheckRegistration) error {
-	return c.Agent().CheckRegister(check)
+	return c.do(func() error { return c.Agent().CheckRegister(check) })
 }
 
 // ServiceRegister wraps the Consul.Agent's ServiceRegister method,
 // is used to register a new service with the local agent
 func (c *Consul) ServiceRegister(service *api.AgentServiceRegistration) error {
-	return c.Agent().ServiceRegister(service)
+	return c.do(func() error { return c.Agent().ServiceRegister(service) })
 }
 
 // ServiceDeregister wraps the Consul.Agent's ServiceDeregister method,
 // and is used to deregister a service from the local agent
 func (c *Consul) ServiceDeregister(serviceID string) error {
-	return c.Agent().ServiceDeregister(serviceID)
+	return c.do(func() error { return c.Agent().ServiceDeregister(serviceID) })
+}
+
+// ConnectCALeaf wraps the Consul.Agent's ConnectCALeaf method, and is used
+// to fetch the Connect leaf certificate for a service
+func (c *Consul) ConnectCALeaf(serviceName string) (*api.LeafCert, error) {
+	var leaf *api.LeafCert
+	err := c.do(func() (err error) {
+		leaf, _, err = c.Agent().ConnectCALeaf(serviceName, nil)
+		return err
+	})
+	return leaf, err
+}
+
+// ConnectCARoots wraps the Consul.Agent's ConnectCARoots method, and
+// returns the PEM-encoded Connect CA root certificates
+func (c *Consul) ConnectCARoots() ([]string, error) {
+	var list *api.CARootList
+	err := c.do(func() (err error) {
+		list, _, err = c.Agent().ConnectCARoots(nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	roots := []string{}
+	for _, root := range list.Roots {
+		roots = append(roots, root.RootCertPEM)
+	}
+	return roots, nil
 }
 
 // CheckForUpstreamChanges requests the set of healthy instances of a
 // service from Consul and checks whether there has been a change since
 // the last check.
 func (c *Consul) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
-	instances, meta, err := c.Health().Service(backendName, backendTag, true, nil)
+	return c.CheckForUpstreamChangesFiltered(backendName, backendTag, nil, nil)
+}
+
+// CheckForUpstreamChangesInDatacenters requests the healthy instances of
+// a service in each of the datacenters and checks whether the merged set
+// has changed since the last check. If any datacenter can't be queried,
+// we report no change rather than dropping its instances.
+func (c *Consul) CheckForUpstreamChangesInDatacenters(backendName, backendTag string, datacenters []string) (didChange, isHealthy bool) {
+	return c.CheckForUpstreamChangesFiltered(backendName, backendTag, datacenters, nil)
+}
+
+// CheckForUpstreamChangesFiltered requests the healthy instances of a
+// service in each of the datacenters (or the local datacenter if there
+// are none), and checks whether the set of instances that match the
+// filter has changed since the last check.
+func (c *Consul) CheckForUpstreamChangesFiltered(backendName, backendTag string, datacenters []string, filter *InstanceFilter) (didChange, isHealthy bool) {
+	instances, err := c.healthyInstances(backendName, backendTag, datacenters)
 	if err != nil {
-		log.Warnf("failed to query %v: %s [%v]", backendName, err, meta)
 		return false, false
 	}
+	instances = filter.Filter(instances)
 	isHealthy = len(instances) > 0
-	didChange = c.compareAndSwap(backendName, instances)
+	didChange = c.compareAndSwap(watchKey(backendName, datacenters, filter), instances)
 	return didChange, isHealthy
 }
 
+// healthyInstances requests the healthy instances of a service in each of
+// the datacenters, or the local datacenter if there are none, and merges
+// them
+func (c *Consul) healthyInstances(backendName, backendTag string, datacenters []string) ([]*api.ServiceEntry, error) {
+	if len(datacenters) == 0 {
+		datacenters = []string{""}
+	}
+	merged := []*api.ServiceEntry{}
+	for _, dc := range datacenters {
+		var instances []*api.ServiceEntry
+		var meta *api.QueryMeta
+		err := c.do(func() (err error) {
+			instances, meta, err = c.Health().Service(backendName, backendTag, true,
+				&api.QueryOptions{Datacenter: dc})
+			return err
+		})
+		if err != nil {
+			if dc == "" {
+				log.Warnf("failed to query %v: %s [%v]", backendName, err, meta)
+			} else {
+				log.Warnf("failed to query %v in %v: %s [%v]", backendName, dc, err, meta)
+			}
+			return nil, err
+		}
+		merged = append(merged, instances...)
+	}
+	return merged, nil
+}
+
+// WatchedInstances returns the healthy instances of the service (in the
+// datacenters, if any) that match the filter, as found by the last check
+// for upstream changes
+func (c *Consul) WatchedInstances(backendName string, datacenters []string, filter *InstanceFilter) []*api.ServiceEntry {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.watchedServices[watchKey(backendName, datacenters, filter)]
+}
+
+// watchKey returns the key of the instances of a watched service in
+// watchedServices
+func watchKey(backendName string, datacenters []string, filter *InstanceFilter) string {
+	key := backendName
+	if len(datacenters) > 0 {
+		key += "@" + strings.Join(datacenters, ",")
+	}
+	if filter != nil {
+		key += "?" + filter.String()
+	}
+	return key
+}
+
 // returns true if any addresses for the service changed and updates
 // the internal state
 func (c *Consul) compareAndSwap(service string, new []*api.ServiceEntry) bool {
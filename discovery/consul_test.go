@@ -2,10 +2,13 @@ package discovery
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	consul "github.com/hashicorp/consul/api"
-	"github.com/hashicorp/consul/testutil"
+	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/joyent/containerpilot/tests/assert"
 )
 
@@ -21,6 +24,52 @@ func TestConsulObjectParse(t *testing.T) {
 	}
 }
 
+func TestConsulNamespaceParse(t *testing.T) {
+	cfg, err := configFromMap(map[string]interface{}{
+		"address":   "consul:8500",
+		"namespace": "team-a",
+		"partition": "billing",
+	})
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	assert.Equal(t, cfg.Namespace, "team-a", "expected namespace %q but got %q")
+	assert.Equal(t, cfg.Partition, "billing", "expected partition %q but got %q")
+}
+
+func TestConsulTLSParse(t *testing.T) {
+	cfg, err := configFromMap(map[string]interface{}{
+		"address": "consul:8501",
+		"tls": map[string]interface{}{
+			"caFile":     "/etc/consul/ca.pem",
+			"certFile":   "/etc/consul/client.pem",
+			"keyFile":    "/etc/consul/client-key.pem",
+			"serverName": "localhost",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	assert.Equal(t, cfg.Scheme, "https", "expected scheme %q but got %q")
+	assert.Equal(t, cfg.TLSConfig.CAFile, "/etc/consul/ca.pem", "expected %q but got %q")
+	assert.Equal(t, cfg.TLSConfig.KeyFile, "/etc/consul/client-key.pem",
+		"expected %q but got %q")
+	assert.Equal(t, cfg.TLSConfig.Address, "localhost",
+		"expected server name %q but got %q")
+
+	_, err = configFromMap(map[string]interface{}{
+		"tls": map[string]interface{}{"certFile": "/etc/consul/client.pem"},
+	})
+	assert.Error(t, err, "consul.tls.certFile and consul.tls.keyFile must be set together")
+
+	_, err = NewConsul(map[string]interface{}{
+		"tls": map[string]interface{}{"caFile": "/does/not/exist.pem"},
+	})
+	if err == nil {
+		t.Fatalf("expected error for missing consul.tls.caFile")
+	}
+}
+
 func TestConsulAddressParse(t *testing.T) {
 	// typical valid entries
 	runParseTest(t, "https://consul:8500", "consul:8500", "https")
@@ -78,15 +127,19 @@ func TestCheckForChanges(t *testing.T) {
 /*
 The TestWithConsul suite of tests uses Hashicorp's own testutil for managing
 a Consul server for testing. The 'consul' binary must be in the $PATH
-ref https://github.com/hashicorp/consul/tree/master/testutil
+ref https://github.com/hashicorp/consul/tree/main/sdk/testutil
 */
 
 var testServer *testutil.TestServer
 
 func TestWithConsul(t *testing.T) {
-	testServer = testutil.NewTestServerConfig(t, func(c *testutil.TestServerConfig) {
+	var err error
+	testServer, err = testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
 		c.LogLevel = "err"
 	})
+	if err != nil {
+		t.Skipf("unable to start consul: %v", err)
+	}
 	defer testServer.Stop()
 	t.Run("TestConsulTTLPass", testConsulTTLPass)
 	t.Run("TestConsulReregister", testConsulReregister)
@@ -194,3 +247,48 @@ func generateServiceDefinition(serviceName string, consul *Consul) *ServiceDefin
 		Consul:    consul,
 	}
 }
+
+func TestConsulDatacenters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			dc := r.URL.Query().Get("dc")
+			fmt.Fprintf(w, `[{"Service": {"ID": "app-%s", "Address": "10.0.0.1", "Port": 80}}]`, dc)
+		}))
+	defer server.Close()
+	c, _ := NewConsul(strings.TrimPrefix(server.URL, "http://"))
+
+	didChange, isHealthy := c.CheckForUpstreamChangesInDatacenters(
+		"app", "", []string{"dc1", "dc2"})
+	assert.True(t, didChange, "expected didChange=%v but got %v")
+	assert.True(t, isHealthy, "expected isHealthy=%v but got %v")
+	merged := c.WatchedInstances("app", []string{"dc1", "dc2"}, nil)
+	assert.Equal(t, len(merged), 2, "expected %v merged instances but got %v")
+
+	didChange, _ = c.CheckForUpstreamChangesInDatacenters(
+		"app", "", []string{"dc1", "dc2"})
+	assert.False(t, didChange, "expected didChange=%v but got %v")
+}
+
+func TestConsulFiltered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[
+			{"Service": {"ID": "app-1", "Address": "10.0.0.1", "Port": 80, "Meta": {"version": "v1"}}},
+			{"Service": {"ID": "app-2", "Address": "10.0.0.2", "Port": 80, "Meta": {"version": "v2"}}}]`)
+		}))
+	defer server.Close()
+	c, _ := NewConsul(strings.TrimPrefix(server.URL, "http://"))
+
+	filter, _ := NewInstanceFilter(nil, []string{"version=v2"})
+	didChange, isHealthy := c.CheckForUpstreamChangesFiltered("app", "", nil, filter)
+	assert.True(t, didChange, "expected didChange=%v but got %v")
+	assert.True(t, isHealthy, "expected isHealthy=%v but got %v")
+	instances := c.WatchedInstances("app", nil, filter)
+	assert.Equal(t, len(instances), 1, "expected %v filtered instance but got %v")
+	assert.Equal(t, instances[0].Service.ID, "app-2", "expected %v but got %v")
+
+	filter, _ = NewInstanceFilter(nil, []string{"version=v3"})
+	didChange, isHealthy = c.CheckForUpstreamChangesFiltered("app", "", nil, filter)
+	assert.False(t, didChange, "expected didChange=%v but got %v")
+	assert.False(t, isHealthy, "expected isHealthy=%v but got %v")
+}
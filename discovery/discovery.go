@@ -7,6 +7,27 @@ type Backend interface {
 	CheckForUpstreamChanges(backendName string, backendTag string) (bool, bool)
 	CheckRegister(check *api.AgentCheckRegistration) error
 	PassTTL(checkID, note string) error
+	WarnTTL(checkID, note string) error
+	FailTTL(checkID, note string) error
 	ServiceDeregister(serviceID string) error
 	ServiceRegister(service *api.AgentServiceRegistration) error
 }
+
+// DatacenterBackend is implemented by discovery backends that can watch
+// services in other datacenters
+type DatacenterBackend interface {
+	CheckForUpstreamChangesInDatacenters(backendName, backendTag string, datacenters []string) (bool, bool)
+}
+
+// FilterBackend is implemented by discovery backends that can watch only
+// the instances of a service that match an InstanceFilter
+type FilterBackend interface {
+	CheckForUpstreamChangesFiltered(backendName, backendTag string, datacenters []string, filter *InstanceFilter) (bool, bool)
+}
+
+// InstancesBackend is implemented by discovery backends that can return
+// the healthy instances of a watched service, as found by the last check
+// for upstream changes
+type InstancesBackend interface {
+	WatchedInstances(backendName string, datacenters []string, filter *InstanceFilter) []*api.ServiceEntry
+}
@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// DNS is a service discovery backend for watches only, which resolves
+// services from DNS SRV records (falling back to A/AAAA records) each time
+// the watch polls. Registration is a no-op, so jobs that advertise a
+// service need to be registered by some other means, such as Route53 or
+// CoreDNS service discovery.
+type DNS struct {
+	domain string
+
+	lookupSRV  func(service, proto, name string) (string, []*net.SRV, error)
+	lookupHost func(host string) ([]string, error)
+
+	lock            sync.RWMutex
+	watchedServices map[string][]*api.ServiceEntry
+}
+
+// NewDNS creates a new service discovery backend for DNS. The config is
+// either the domain appended to service names or a map.
+func NewDNS(config interface{}) (*DNS, error) {
+	cfg := &struct {
+		Domain string `mapstructure:"domain"`
+	}{}
+	switch t := config.(type) {
+	case string:
+		cfg.Domain = t
+	case map[string]interface{}:
+		if err := utils.DecodeRaw(t, cfg); err != nil {
+			return nil, fmt.Errorf("dns config parsing error: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("dns config must be a domain or an object")
+	}
+	return &DNS{
+		domain:          strings.Trim(cfg.Domain, "."),
+		lookupSRV:       net.LookupSRV,
+		lookupHost:      net.LookupHost,
+		watchedServices: make(map[string][]*api.ServiceEntry),
+	}, nil
+}
+
+// ServiceRegister is a no-op for DNS
+func (d *DNS) ServiceRegister(service *api.AgentServiceRegistration) error { return nil }
+
+// ServiceDeregister is a no-op for DNS
+func (d *DNS) ServiceDeregister(serviceID string) error { return nil }
+
+// CheckRegister is a no-op for DNS
+func (d *DNS) CheckRegister(check *api.AgentCheckRegistration) error { return nil }
+
+// PassTTL is a no-op for DNS
+func (d *DNS) PassTTL(checkID, note string) error { return nil }
+
+// WarnTTL is a no-op for DNS
+func (d *DNS) WarnTTL(checkID, note string) error { return nil }
+
+// FailTTL is a no-op for DNS
+func (d *DNS) FailTTL(checkID, note string) error { return nil }
+
+// hostname returns the name we resolve for a service. Tags are prepended
+// as a subdomain, as in Consul's DNS interface.
+func (d *DNS) hostname(name, tag string) string {
+	parts := []string{}
+	for _, part := range []string{tag, name, d.domain} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// CheckForUpstreamChanges resolves the service and checks whether the
+// answers have changed since the last check. DNS only serves healthy
+// instances, so any answer is healthy.
+func (d *DNS) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
+	instances, err := d.resolve(d.hostname(backendName, backendTag))
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	isHealthy = len(instances) > 0
+	didChange = d.compareAndSwap(backendName, instances)
+	return didChange, isHealthy
+}
+
+func (d *DNS) resolve(host string) ([]*api.ServiceEntry, error) {
+	instances := []*api.ServiceEntry{}
+	_, srvs, err := d.lookupSRV("", "", host)
+	if err == nil && len(srvs) > 0 {
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			instances = append(instances, &api.ServiceEntry{
+				Service: &api.AgentService{
+					ID:      fmt.Sprintf("%s:%d", target, srv.Port),
+					Address: target,
+					Port:    int(srv.Port),
+				},
+			})
+		}
+		return instances, nil
+	}
+	addrs, err := d.lookupHost(host)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && !dnsErr.Temporary() {
+			// the name doesn't exist, so there are no instances
+			return instances, nil
+		}
+		return nil, err
+	}
+	for _, addr := range addrs {
+		instances = append(instances, &api.ServiceEntry{
+			Service: &api.AgentService{ID: addr, Address: addr},
+		})
+	}
+	return instances, nil
+}
+
+// returns true if any addresses for the service changed and updates
+// the internal state
+func (d *DNS) compareAndSwap(service string, new []*api.ServiceEntry) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	existing := d.watchedServices[service]
+	d.watchedServices[service] = new
+	return compareForChange(existing, new)
+}
@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestDNSHostname(t *testing.T) {
+	d, _ := NewDNS("service.local.")
+	assert.Equal(t, d.hostname("app", ""), "app.service.local",
+		"expected %v but got %v")
+	assert.Equal(t, d.hostname("app", "primary"), "primary.app.service.local",
+		"expected %v but got %v")
+	d, _ = NewDNS(map[string]interface{}{})
+	assert.Equal(t, d.hostname("app", ""), "app", "expected %v but got %v")
+}
+
+func TestDNSCheckForUpstreamChanges(t *testing.T) {
+	d, _ := NewDNS("service.local")
+	srvs := []*net.SRV{}
+	hosts := []string{}
+	var hostErr error
+	d.lookupSRV = func(_, _, name string) (string, []*net.SRV, error) {
+		if len(srvs) == 0 {
+			return "", nil, &net.DNSError{Err: "no such host", Name: name}
+		}
+		return name, srvs, nil
+	}
+	d.lookupHost = func(host string) ([]string, error) {
+		return hosts, hostErr
+	}
+
+	hostErr = &net.DNSError{Err: "no such host", Name: "app.service.local"}
+	didChange, isHealthy := d.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change for missing name")
+	assert.False(t, isHealthy, "expected unhealthy for missing name")
+
+	hostErr = nil
+	hosts = []string{"10.0.0.1"}
+	didChange, isHealthy = d.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change for new A record")
+	assert.True(t, isHealthy, "expected healthy for A record")
+
+	srvs = []*net.SRV{
+		{Target: "app-1.service.local.", Port: 8080},
+		{Target: "app-2.service.local.", Port: 8080},
+	}
+	didChange, _ = d.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change for SRV records")
+	didChange, _ = d.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change for same SRV records")
+
+	srvs = []*net.SRV{}
+	hostErr = errors.New("server misbehaving")
+	didChange, isHealthy = d.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change on lookup error")
+	assert.False(t, isHealthy, "expected unhealthy on lookup error")
+}
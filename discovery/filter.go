@@ -0,0 +1,158 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// InstanceFilter selects the instances of a watched service by their tags
+// and service meta, so that a watch only sees changes to the instances it
+// cares about. An instance must match every expression.
+type InstanceFilter struct {
+	tags []tagMatch
+	meta []metaMatch
+}
+
+// tagMatch requires an instance to have the tag, or not to have it if
+// negated
+type tagMatch struct {
+	tag    string
+	negate bool
+}
+
+// metaMatch compares a key of an instance's service meta
+type metaMatch struct {
+	key    string
+	op     string // one of "=", "!=", "exists", or "!exists"
+	value  string
+	source string // the original expression
+}
+
+// NewInstanceFilter parses the tag expressions, each a tag that instances
+// must have such as "prod" or must not have such as "!canary", and the
+// meta expressions, each one of "key=value", "key!=value", "key" (has the
+// key), or "!key" (doesn't have the key). Returns nil if there are no
+// expressions.
+func NewInstanceFilter(tags, meta []string) (*InstanceFilter, error) {
+	if len(tags) == 0 && len(meta) == 0 {
+		return nil, nil
+	}
+	filter := &InstanceFilter{}
+	for _, expr := range tags {
+		match := tagMatch{tag: expr}
+		if strings.HasPrefix(expr, "!") {
+			match = tagMatch{tag: expr[1:], negate: true}
+		}
+		if match.tag == "" {
+			return nil, fmt.Errorf("invalid tag expression '%s'", expr)
+		}
+		filter.tags = append(filter.tags, match)
+	}
+	for _, expr := range meta {
+		match, err := parseMetaMatch(expr)
+		if err != nil {
+			return nil, err
+		}
+		filter.meta = append(filter.meta, match)
+	}
+	return filter, nil
+}
+
+func parseMetaMatch(expr string) (metaMatch, error) {
+	match := metaMatch{source: expr}
+	switch {
+	case strings.Contains(expr, "!="):
+		parts := strings.SplitN(expr, "!=", 2)
+		match.key, match.op, match.value = parts[0], "!=", parts[1]
+	case strings.Contains(expr, "="):
+		parts := strings.SplitN(expr, "=", 2)
+		match.key, match.op, match.value = parts[0], "=", parts[1]
+	case strings.HasPrefix(expr, "!"):
+		match.key, match.op = expr[1:], "!exists"
+	default:
+		match.key, match.op = expr, "exists"
+	}
+	if strings.TrimSpace(match.key) == "" {
+		return match, fmt.Errorf("invalid meta expression '%s'", expr)
+	}
+	return match, nil
+}
+
+// Match returns true if the instance matches all of the expressions. A
+// nil InstanceFilter matches every instance.
+func (f *InstanceFilter) Match(entry *api.ServiceEntry) bool {
+	if f == nil {
+		return true
+	}
+	var tags []string
+	var meta map[string]string
+	if entry.Service != nil {
+		tags = entry.Service.Tags
+		meta = entry.Service.Meta
+	}
+	for _, match := range f.tags {
+		if hasTag(tags, match.tag) == match.negate {
+			return false
+		}
+	}
+	for _, match := range f.meta {
+		value, ok := meta[match.key]
+		switch match.op {
+		case "=":
+			if !ok || value != match.value {
+				return false
+			}
+		case "!=":
+			if ok && value == match.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Filter returns the instances that match
+func (f *InstanceFilter) Filter(entries []*api.ServiceEntry) []*api.ServiceEntry {
+	if f == nil {
+		return entries
+	}
+	matched := []*api.ServiceEntry{}
+	for _, entry := range entries {
+		if f.Match(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// String returns the expressions in a canonical order, so that watches
+// with the same filter share their watched instances
+func (f *InstanceFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	var exprs []string
+	for _, match := range f.tags {
+		if match.negate {
+			exprs = append(exprs, "tag!"+match.tag)
+		} else {
+			exprs = append(exprs, "tag:"+match.tag)
+		}
+	}
+	for _, match := range f.meta {
+		exprs = append(exprs, "meta:"+match.source)
+	}
+	sort.Strings(exprs)
+	return strings.Join(exprs, ",")
+}
@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func filterEntry(id string, tags []string, meta map[string]string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Service: &api.AgentService{ID: id, Tags: tags, Meta: meta}}
+}
+
+func TestInstanceFilterMatch(t *testing.T) {
+	filter, err := NewInstanceFilter(
+		[]string{"prod", "!draining"},
+		[]string{"version=v2", "canary!=true", "zone", "!legacy"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := []*api.ServiceEntry{
+		filterEntry("match", []string{"prod"},
+			map[string]string{"version": "v2", "zone": "a"}),
+		filterEntry("canary", []string{"prod"},
+			map[string]string{"version": "v2", "zone": "a", "canary": "true"}),
+		filterEntry("old", []string{"prod"},
+			map[string]string{"version": "v1", "zone": "a"}),
+		filterEntry("draining", []string{"prod", "draining"},
+			map[string]string{"version": "v2", "zone": "a"}),
+		filterEntry("nozone", []string{"prod"},
+			map[string]string{"version": "v2"}),
+		filterEntry("legacy", []string{"prod"},
+			map[string]string{"version": "v2", "zone": "a", "legacy": ""}),
+		filterEntry("dev", []string{"dev"},
+			map[string]string{"version": "v2", "zone": "a"}),
+	}
+	matched := filter.Filter(entries)
+	assert.Equal(t, len(matched), 1, "expected %v matching instance but got %v")
+	assert.Equal(t, matched[0].Service.ID, "match", "expected %v but got %v")
+
+	var none *InstanceFilter
+	assert.Equal(t, len(none.Filter(entries)), len(entries),
+		"expected nil filter to match all %v instances but got %v")
+}
+
+func TestInstanceFilterParse(t *testing.T) {
+	filter, err := NewInstanceFilter(nil, nil)
+	if filter != nil || err != nil {
+		t.Fatalf("expected no filter but got %v, %v", filter, err)
+	}
+	_, err = NewInstanceFilter([]string{"!"}, nil)
+	assert.Error(t, err, "invalid tag expression '!'")
+	_, err = NewInstanceFilter(nil, []string{"=v2"})
+	assert.Error(t, err, "invalid meta expression '=v2'")
+
+	// the same expressions in any order are the same filter
+	a, _ := NewInstanceFilter([]string{"prod"}, []string{"version=v2", "!legacy"})
+	b, _ := NewInstanceFilter([]string{"prod"}, []string{"!legacy", "version=v2"})
+	assert.Equal(t, a.String(), b.String(), "expected %v but got %v")
+}
@@ -0,0 +1,285 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// serviceAccountDir is where Kubernetes mounts the pod's service account
+// token, CA certificate, and namespace
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesPrefix namespaces the labels and annotations we write on the
+// pod. The label for each service holds its health status so that watches
+// can use a label selector, and the annotation holds its registration.
+const kubernetesPrefix = "containerpilot.io/"
+
+// Kubernetes is a service discovery backend that registers services by
+// labeling and annotating this container's own pod, and watches for other
+// services by selecting pods with the Kubernetes API.
+type Kubernetes struct {
+	client    *http.Client
+	api       string
+	namespace string
+	pod       string
+
+	lock            sync.RWMutex
+	services        *serviceRecords
+	watchedServices map[string][]*api.ServiceEntry
+}
+
+// NewKubernetes creates a new service discovery backend for Kubernetes.
+// By default it uses the in-cluster service account.
+func NewKubernetes(config interface{}) (*Kubernetes, error) {
+	cfg := &struct {
+		API       string `mapstructure:"api"`
+		Namespace string `mapstructure:"namespace"`
+		Pod       string `mapstructure:"pod"`
+	}{}
+	if err := utils.DecodeRaw(config, cfg); err != nil {
+		return nil, fmt.Errorf("kubernetes config parsing error: %v", err)
+	}
+	if cfg.API == "" {
+		host := os.Getenv("KUBERNETES_SERVICE_HOST")
+		port := os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("kubernetes.api must be set when not " +
+				"running in a Kubernetes cluster")
+		}
+		cfg.API = "https://" + host + ":" + port
+	}
+	if cfg.Namespace == "" {
+		namespace, err := ioutil.ReadFile(
+			filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes.namespace must be set when "+
+				"the service account namespace can't be read: %v", err)
+		}
+		cfg.Namespace = strings.TrimSpace(string(namespace))
+	}
+	if cfg.Pod == "" {
+		// the hostname of a pod is its name unless overridden
+		cfg.Pod = os.Getenv("POD_NAME")
+		if cfg.Pod == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes.pod must be set when "+
+					"the hostname can't be read: %v", err)
+			}
+			cfg.Pod = hostname
+		}
+	}
+
+	transport := &http.Transport{}
+	if ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt")); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &Kubernetes{
+		client:          &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		api:             strings.TrimSuffix(cfg.API, "/"),
+		namespace:       cfg.Namespace,
+		pod:             cfg.Pod,
+		services:        newServiceRecords(),
+		watchedServices: make(map[string][]*api.ServiceEntry),
+	}, nil
+}
+
+// ServiceRegister labels and annotates the pod with the service. The
+// service is critical until its first TTL update.
+func (k *Kubernetes) ServiceRegister(service *api.AgentServiceRegistration) error {
+	record := k.services.register(service)
+	return k.patchService(&record)
+}
+
+// ServiceDeregister removes the service's label and annotation from the pod
+func (k *Kubernetes) ServiceDeregister(serviceID string) error {
+	record, err := k.services.deregister(serviceID)
+	if err != nil {
+		return err
+	}
+	key := kubernetesPrefix + record.Name
+	return k.patchPod(map[string]interface{}{
+		"labels":      map[string]interface{}{key: nil},
+		"annotations": map[string]interface{}{key: nil},
+	})
+}
+
+// CheckRegister records the TTL of the check for a registered service.
+// Kubernetes has no TTL checks of its own, so the expiry is written into
+// the annotation and enforced by the watchers.
+func (k *Kubernetes) CheckRegister(check *api.AgentCheckRegistration) error {
+	return k.services.registerCheck(check)
+}
+
+// PassTTL marks the service passing until its TTL expires
+func (k *Kubernetes) PassTTL(checkID, note string) error {
+	return k.updateTTL(checkID, statusPassing)
+}
+
+// WarnTTL marks the service warning until its TTL expires
+func (k *Kubernetes) WarnTTL(checkID, note string) error {
+	return k.updateTTL(checkID, statusWarning)
+}
+
+// FailTTL marks the service critical
+func (k *Kubernetes) FailTTL(checkID, note string) error {
+	return k.updateTTL(checkID, statusCritical)
+}
+
+func (k *Kubernetes) updateTTL(checkID, status string) error {
+	record, err := k.services.update(checkID, status)
+	if err != nil {
+		return err
+	}
+	return k.patchService(&record)
+}
+
+func (k *Kubernetes) patchService(svc *serviceRecord) error {
+	annotation, err := json.Marshal(svc)
+	if err != nil {
+		return err
+	}
+	key := kubernetesPrefix + svc.Name
+	return k.patchPod(map[string]interface{}{
+		"labels":      map[string]interface{}{key: svc.Status},
+		"annotations": map[string]interface{}{key: string(annotation)},
+	})
+}
+
+// patchPod applies a JSON merge patch to our pod's metadata
+func (k *Kubernetes) patchPod(metadata map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s",
+		url.PathEscape(k.namespace), url.PathEscape(k.pod))
+	req, err := k.newRequest("PATCH", path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	_, err = k.do(req)
+	return err
+}
+
+// kubernetesPodList is the subset of the Kubernetes API PodList we need
+type kubernetesPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name        string            `json:"name"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Status struct {
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// CheckForUpstreamChanges selects the pods where the service is passing
+// and checks whether there has been a change since the last check.
+func (k *Kubernetes) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
+	instances, err := k.passingInstances(backendName, backendTag)
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	isHealthy = len(instances) > 0
+	didChange = k.compareAndSwap(backendName, instances)
+	return didChange, isHealthy
+}
+
+func (k *Kubernetes) passingInstances(name, tag string) ([]*api.ServiceEntry, error) {
+	key := kubernetesPrefix + name
+	query := url.Values{"labelSelector": {key + "=" + statusPassing}}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods?%s",
+		url.PathEscape(k.namespace), query.Encode())
+	req, err := k.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := k.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var pods kubernetesPodList
+	if err := json.Unmarshal(body, &pods); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	instances := []*api.ServiceEntry{}
+	for _, pod := range pods.Items {
+		var svc serviceRecord
+		annotation := pod.Metadata.Annotations[key]
+		if err := json.Unmarshal([]byte(annotation), &svc); err != nil {
+			log.Debugf("ignoring pod %s with invalid %s annotation: %v",
+				pod.Metadata.Name, key, err)
+			continue
+		}
+		// if the pod's ContainerPilot stopped sending heartbeats without
+		// updating the label, the record will have expired
+		if !svc.isPassing(tag, now) {
+			continue
+		}
+		instances = append(instances, svc.entry(pod.Status.PodIP))
+	}
+	return instances, nil
+}
+
+// returns true if any addresses for the service changed and updates
+// the internal state
+func (k *Kubernetes) compareAndSwap(service string, new []*api.ServiceEntry) bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	existing := k.watchedServices[service]
+	k.watchedServices[service] = new
+	return compareForChange(existing, new)
+}
+
+func (k *Kubernetes) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, k.api+path, body)
+	if err != nil {
+		return nil, err
+	}
+	// service account tokens are rotated, so we read it for each request
+	if token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token")); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+func (k *Kubernetes) do(req *http.Request) ([]byte, error) {
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("kubernetes API %s %s returned %s: %s",
+			req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,188 @@
+package discovery
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+// fakePodAPI is a minimal Kubernetes API serving a single namespace. It
+// applies merge patches to pod metadata and filters pods by a single
+// equality label selector.
+type fakePodAPI struct {
+	lock        sync.Mutex
+	labels      map[string]map[string]string
+	annotations map[string]map[string]string
+	podIPs      map[string]string
+}
+
+func newFakePodAPI(pods map[string]string) *fakePodAPI {
+	api := &fakePodAPI{
+		labels:      make(map[string]map[string]string),
+		annotations: make(map[string]map[string]string),
+		podIPs:      pods,
+	}
+	for pod := range pods {
+		api.labels[pod] = make(map[string]string)
+		api.annotations[pod] = make(map[string]string)
+	}
+	return api
+}
+
+func merge(dst map[string]string, src map[string]*string) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+		} else {
+			dst[k] = *v
+		}
+	}
+}
+
+func (f *fakePodAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	prefix := "/api/v1/namespaces/default/pods"
+	switch {
+	case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, prefix+"/"):
+		pod := strings.TrimPrefix(r.URL.Path, prefix+"/")
+		if _, ok := f.podIPs[pod]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var patch struct {
+			Metadata struct {
+				Labels      map[string]*string `json:"labels"`
+				Annotations map[string]*string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		merge(f.labels[pod], patch.Metadata.Labels)
+		merge(f.annotations[pod], patch.Metadata.Annotations)
+		w.Write([]byte("{}"))
+	case r.Method == "GET" && r.URL.Path == prefix:
+		selector := strings.SplitN(r.URL.Query().Get("labelSelector"), "=", 2)
+		items := []interface{}{}
+		for pod, ip := range f.podIPs {
+			if f.labels[pod][selector[0]] != selector[1] {
+				continue
+			}
+			items = append(items, map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": pod, "annotations": f.annotations[pod]},
+				"status": map[string]interface{}{"podIP": ip},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func setupKubernetes(t *testing.T, url, pod string) *Kubernetes {
+	k, err := NewKubernetes(map[string]interface{}{
+		"api": url, "namespace": "default", "pod": pod})
+	if err != nil {
+		t.Fatalf("unable to create kubernetes backend: %v", err)
+	}
+	return k
+}
+
+func TestKubernetesConfig(t *testing.T) {
+	serviceAccountDir = "/nonexistent"
+	_, err := NewKubernetes(map[string]interface{}{})
+	if err == nil {
+		t.Fatal("expected error outside of a cluster without api")
+	}
+	_, err = NewKubernetes(map[string]interface{}{"api": "http://localhost:8001"})
+	if err == nil || !strings.HasPrefix(err.Error(), "kubernetes.namespace must be set") {
+		t.Fatalf("expected error without namespace but got: %v", err)
+	}
+}
+
+func TestKubernetesRegistrationAndWatch(t *testing.T) {
+	serviceAccountDir = "/nonexistent"
+	fake := newFakePodAPI(map[string]string{
+		"app-1": "10.0.0.1", "app-2": "10.0.0.2", "web-1": "10.0.0.3"})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	app1 := setupKubernetes(t, server.URL, "app-1")
+	app2 := setupKubernetes(t, server.URL, "app-2")
+	web := setupKubernetes(t, server.URL, "web-1")
+
+	register := func(k *Kubernetes, id string) {
+		service := &ServiceDefinition{
+			ID: id, Name: "app", Port: 8080, TTL: 10, Consul: k}
+		service.SendHeartbeat()
+	}
+
+	didChange, isHealthy := web.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change before registration")
+	assert.False(t, isHealthy, "expected unhealthy before registration")
+
+	register(app1, "app-1")
+	didChange, isHealthy = web.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change after first registration")
+	assert.True(t, isHealthy, "expected healthy after first registration")
+
+	register(app2, "app-2")
+	didChange, _ = web.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change after second registration")
+	instances := web.watchedServices["app"]
+	assert.Equal(t, len(instances), 2, "expected %v instances but got %v")
+
+	// pod IP is used when the service has no address
+	for _, instance := range instances {
+		if !strings.HasPrefix(instance.Service.Address, "10.0.0.") {
+			t.Fatalf("unexpected address: %v", instance.Service.Address)
+		}
+	}
+
+	didChange, _ = web.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change without updates")
+
+	if err := app1.FailTTL("app-1", "failed"); err != nil {
+		t.Fatal(err)
+	}
+	didChange, isHealthy = web.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change after failed check")
+	assert.True(t, isHealthy, "expected healthy with one passing instance")
+
+	if err := app2.ServiceDeregister("app-2"); err != nil {
+		t.Fatal(err)
+	}
+	_, isHealthy = web.CheckForUpstreamChanges("app", "")
+	assert.False(t, isHealthy, "expected unhealthy after deregistration")
+	_, ok := fake.annotations["app-2"]["containerpilot.io/app"]
+	assert.False(t, ok, "expected annotation to be removed")
+}
+
+func TestKubernetesTTLRequiresCheck(t *testing.T) {
+	serviceAccountDir = "/nonexistent"
+	server := httptest.NewServer(newFakePodAPI(map[string]string{"app-1": "10.0.0.1"}))
+	defer server.Close()
+	k := setupKubernetes(t, server.URL, "app-1")
+
+	err := k.PassTTL("app-1", "ok")
+	assert.Error(t, err, "check app-1 is not registered")
+	k.ServiceRegister(&api.AgentServiceRegistration{ID: "app-1", Name: "app"})
+	err = k.PassTTL("app-1", "ok")
+	assert.Error(t, err, "check app-1 is not registered")
+	k.CheckRegister(&api.AgentCheckRegistration{ID: "app-1", ServiceID: "app-1",
+		AgentServiceCheck: api.AgentServiceCheck{TTL: "10s"}})
+	if err := k.PassTTL("app-1", "ok"); err != nil {
+		t.Fatal(err)
+	}
+}
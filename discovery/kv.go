@@ -0,0 +1,51 @@
+package discovery
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// KVBackend is implemented by discovery backends that have a key/value
+// store that can be watched
+type KVBackend interface {
+	// WatchKV blocks until the key (or keys under the prefix, if the key
+	// ends with "/") change after waitIndex or the wait time elapses, and
+	// returns the values by key along with the index to wait on next.
+	WatchKV(ctx context.Context, key string, waitIndex uint64, wait time.Duration) (map[string]string, uint64, error)
+}
+
+// WatchKV makes a blocking query for a Consul KV key or prefix
+func (c *Consul) WatchKV(ctx context.Context, key string, waitIndex uint64, wait time.Duration) (map[string]string, uint64, error) {
+	opts := (&api.QueryOptions{WaitIndex: waitIndex, WaitTime: wait}).WithContext(ctx)
+	values := make(map[string]string)
+	var meta *api.QueryMeta
+	err := c.do(func() error {
+		if strings.HasSuffix(key, "/") {
+			pairs, m, err := c.KV().List(key, opts)
+			if err != nil {
+				return err
+			}
+			for _, pair := range pairs {
+				values[pair.Key] = string(pair.Value)
+			}
+			meta = m
+			return nil
+		}
+		pair, m, err := c.KV().Get(key, opts)
+		if err != nil {
+			return err
+		}
+		if pair != nil {
+			values[pair.Key] = string(pair.Value)
+		}
+		meta = m
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return values, meta.LastIndex, nil
+}
@@ -0,0 +1,266 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/mdns"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// MDNS is a service discovery backend for edge deployments without a
+// service registry. Services are advertised with mDNS/DNS-SD on the local
+// network while they're healthy, and watches browse for the instances of
+// a service type.
+type MDNS struct {
+	domain  string
+	timeout time.Duration
+	iface   *net.Interface
+
+	services *serviceRecords
+
+	serve func(*mdns.Config) (mdnsServer, error)
+	query func(*mdns.QueryParam) error
+
+	lock            sync.Mutex
+	advertised      map[string]*mdnsAdvertisement // by service ID
+	watchedServices map[string][]*api.ServiceEntry
+}
+
+// mdnsServer is the part of mdns.Server we need, so tests can fake it
+type mdnsServer interface {
+	Shutdown() error
+}
+
+// mdnsAdvertisement is a running responder for a service, which is shut
+// down if the service doesn't update its check within the TTL
+type mdnsAdvertisement struct {
+	server mdnsServer
+	expiry *time.Timer
+}
+
+// NewMDNS creates a new service discovery backend for mDNS
+func NewMDNS(config interface{}) (*MDNS, error) {
+	cfg := &struct {
+		Domain    string `mapstructure:"domain"`
+		Timeout   string `mapstructure:"timeout"`
+		Interface string `mapstructure:"interface"`
+	}{Domain: "local", Timeout: "1s"}
+	if err := utils.DecodeRaw(config, cfg); err != nil {
+		return nil, fmt.Errorf("mdns config parsing error: %v", err)
+	}
+	timeout, err := utils.GetTimeout(cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("mdns.timeout %v", err)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("mdns.timeout must be positive")
+	}
+	var iface *net.Interface
+	if cfg.Interface != "" {
+		iface, err = net.InterfaceByName(cfg.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mdns.interface '%s': %v",
+				cfg.Interface, err)
+		}
+	}
+	return &MDNS{
+		domain:   strings.Trim(cfg.Domain, "."),
+		timeout:  timeout,
+		iface:    iface,
+		services: newServiceRecords(),
+		serve: func(config *mdns.Config) (mdnsServer, error) {
+			return mdns.NewServer(config)
+		},
+		query:           mdns.Query,
+		advertised:      make(map[string]*mdnsAdvertisement),
+		watchedServices: make(map[string][]*api.ServiceEntry),
+	}, nil
+}
+
+// serviceType returns the DNS-SD service type for a service name
+func serviceType(name string) string {
+	return "_" + name + "._tcp"
+}
+
+// ServiceRegister records the service. It isn't advertised until its
+// first passing TTL update.
+func (m *MDNS) ServiceRegister(service *api.AgentServiceRegistration) error {
+	record := m.services.register(service)
+	m.withdraw(record.ID) // re-registration may change the port or tags
+	return nil
+}
+
+// ServiceDeregister stops advertising the service
+func (m *MDNS) ServiceDeregister(serviceID string) error {
+	record, err := m.services.deregister(serviceID)
+	if err != nil {
+		return err
+	}
+	m.withdraw(record.ID)
+	return nil
+}
+
+// CheckRegister records the TTL of the check for a registered service
+func (m *MDNS) CheckRegister(check *api.AgentCheckRegistration) error {
+	return m.services.registerCheck(check)
+}
+
+// PassTTL advertises the service
+func (m *MDNS) PassTTL(checkID, note string) error {
+	return m.updateTTL(checkID, statusPassing)
+}
+
+// WarnTTL keeps advertising the service, as Consul keeps warning services
+// in DNS results
+func (m *MDNS) WarnTTL(checkID, note string) error {
+	return m.updateTTL(checkID, statusWarning)
+}
+
+// FailTTL stops advertising the service
+func (m *MDNS) FailTTL(checkID, note string) error {
+	return m.updateTTL(checkID, statusCritical)
+}
+
+func (m *MDNS) updateTTL(checkID, status string) error {
+	record, err := m.services.update(checkID, status)
+	if err != nil {
+		return err
+	}
+	if status == statusCritical {
+		m.withdraw(record.ID)
+		return nil
+	}
+	return m.advertise(record)
+}
+
+// advertise starts a responder for the service if there isn't one, and
+// (re)starts the timer that withdraws it when the TTL expires
+func (m *MDNS) advertise(record serviceRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if ad, ok := m.advertised[record.ID]; ok {
+		ad.expiry.Reset(record.ttl)
+		return nil
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(record.Address); ip != nil {
+		ips = []net.IP{ip}
+	}
+	zone, err := mdns.NewMDNSService(record.ID, serviceType(record.Name),
+		m.domain+".", "", record.Port, ips, record.Tags)
+	if err != nil {
+		return fmt.Errorf("unable to advertise %s: %v", record.ID, err)
+	}
+	server, err := m.serve(&mdns.Config{Zone: zone, Iface: m.iface})
+	if err != nil {
+		return fmt.Errorf("unable to advertise %s: %v", record.ID, err)
+	}
+	serviceID := record.ID
+	m.advertised[serviceID] = &mdnsAdvertisement{
+		server: server,
+		expiry: time.AfterFunc(record.ttl, func() { m.withdraw(serviceID) }),
+	}
+	return nil
+}
+
+// withdraw shuts down the responder for the service, if any
+func (m *MDNS) withdraw(serviceID string) {
+	m.lock.Lock()
+	ad, ok := m.advertised[serviceID]
+	delete(m.advertised, serviceID)
+	m.lock.Unlock()
+	if !ok {
+		return
+	}
+	ad.expiry.Stop()
+	if err := ad.server.Shutdown(); err != nil {
+		log.Warnf("unable to stop advertising %s: %v", serviceID, err)
+	}
+}
+
+// CheckForUpstreamChanges browses for the instances of a service and
+// checks whether there has been a change since the last check. Services
+// are only advertised while healthy, so any instance is healthy. Tags are
+// matched against the TXT records of the instance.
+func (m *MDNS) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
+	entries, err := m.browse(backendName)
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	instances := []*api.ServiceEntry{}
+	for _, entry := range entries {
+		if backendTag != "" && !hasTag(entry.InfoFields, backendTag) {
+			continue
+		}
+		address := entry.AddrV4
+		if address == nil {
+			address = entry.AddrV6
+		}
+		if address == nil || entry.Port == 0 {
+			continue
+		}
+		instances = append(instances, &api.ServiceEntry{
+			Service: &api.AgentService{
+				ID:      m.instanceID(backendName, entry.Name),
+				Service: backendName,
+				Tags:    entry.InfoFields,
+				Address: address.String(),
+				Port:    entry.Port,
+			},
+		})
+	}
+	isHealthy = len(instances) > 0
+	didChange = m.compareAndSwap(backendName, instances)
+	return didChange, isHealthy
+}
+
+// browse collects the responses to a query for the service type until
+// the timeout
+func (m *MDNS) browse(name string) ([]*mdns.ServiceEntry, error) {
+	ch := make(chan *mdns.ServiceEntry, 32)
+	done := make(chan []*mdns.ServiceEntry)
+	go func() {
+		seen := map[string]bool{}
+		entries := []*mdns.ServiceEntry{}
+		for entry := range ch {
+			if !seen[entry.Name] {
+				seen[entry.Name] = true
+				entries = append(entries, entry)
+			}
+		}
+		done <- entries
+	}()
+	err := m.query(&mdns.QueryParam{
+		Service:   serviceType(name),
+		Domain:    m.domain,
+		Timeout:   m.timeout,
+		Interface: m.iface,
+		Entries:   ch,
+	})
+	close(ch)
+	entries := <-done
+	return entries, err
+}
+
+// instanceID strips the service type and domain from the instance name
+func (m *MDNS) instanceID(name, instance string) string {
+	suffix := "." + serviceType(name) + "." + m.domain + "."
+	return strings.Replace(strings.TrimSuffix(instance, suffix), "\\", "", -1)
+}
+
+// returns true if any addresses for the service changed and updates
+// the internal state
+func (m *MDNS) compareAndSwap(service string, new []*api.ServiceEntry) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	existing := m.watchedServices[service]
+	m.watchedServices[service] = new
+	return compareForChange(existing, new)
+}
@@ -0,0 +1,166 @@
+package discovery
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/mdns"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+type fakeMDNSServer struct {
+	zone *mdns.MDNSService
+
+	lock     sync.Mutex
+	shutdown bool
+}
+
+func (s *fakeMDNSServer) Shutdown() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.shutdown = true
+	return nil
+}
+
+func (s *fakeMDNSServer) isShutdown() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.shutdown
+}
+
+func newTestMDNS(t *testing.T) (*MDNS, *[]*fakeMDNSServer) {
+	m, err := NewMDNS(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	servers := []*fakeMDNSServer{}
+	m.serve = func(config *mdns.Config) (mdnsServer, error) {
+		server := &fakeMDNSServer{zone: config.Zone.(*mdns.MDNSService)}
+		servers = append(servers, server)
+		return server, nil
+	}
+	return m, &servers
+}
+
+func TestMDNSConfigParse(t *testing.T) {
+	m, err := NewMDNS(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, m.domain, "local", "expected domain %v but got %v")
+	assert.Equal(t, m.timeout, time.Second, "expected timeout %v but got %v")
+
+	_, err = NewMDNS(map[string]interface{}{"timeout": "0s"})
+	assert.Error(t, err, "mdns.timeout must be positive")
+	_, err = NewMDNS(map[string]interface{}{"interface": "nonexistent0"})
+	if err == nil {
+		t.Fatalf("expected error for missing interface")
+	}
+}
+
+func TestMDNSAdvertise(t *testing.T) {
+	m, servers := newTestMDNS(t)
+	m.ServiceRegister(&api.AgentServiceRegistration{
+		ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 8000,
+		Tags: []string{"primary"},
+	})
+	m.CheckRegister(&api.AgentCheckRegistration{
+		ID: "app-1", ServiceID: "app-1",
+		AgentServiceCheck: api.AgentServiceCheck{TTL: "10s"},
+	})
+	assert.Equal(t, len(*servers), 0, "expected %v advertisements before passing but got %v")
+
+	if err := m.PassTTL("app-1", "ok"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.WarnTTL("app-1", "ok"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, len(*servers), 1, "expected %v advertisement but got %v")
+	zone := (*servers)[0].zone
+	assert.Equal(t, zone.Service, "_app._tcp", "expected service type %v but got %v")
+	assert.Equal(t, zone.Instance, "app-1", "expected instance %v but got %v")
+	assert.Equal(t, zone.Port, 8000, "expected port %v but got %v")
+	assert.Equal(t, zone.TXT, []string{"primary"}, "expected TXT %v but got %v")
+
+	if err := m.FailTTL("app-1", "failed"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.True(t, (*servers)[0].isShutdown(), "expected advertisement to stop on failure")
+
+	m.PassTTL("app-1", "ok")
+	assert.Equal(t, len(*servers), 2, "expected %v advertisements but got %v")
+	if err := m.ServiceDeregister("app-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.True(t, (*servers)[1].isShutdown(), "expected advertisement to stop on deregister")
+	assert.Error(t, m.PassTTL("app-1", "ok"), "check app-1 is not registered")
+}
+
+func TestMDNSAdvertisementExpires(t *testing.T) {
+	m, servers := newTestMDNS(t)
+	m.ServiceRegister(&api.AgentServiceRegistration{
+		ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 8000,
+	})
+	m.CheckRegister(&api.AgentCheckRegistration{
+		ID: "app-1", ServiceID: "app-1",
+		AgentServiceCheck: api.AgentServiceCheck{TTL: "10ms"},
+	})
+	m.PassTTL("app-1", "ok")
+	time.Sleep(50 * time.Millisecond)
+	m.lock.Lock()
+	advertised := len(m.advertised)
+	m.lock.Unlock()
+	assert.Equal(t, advertised, 0, "expected %v advertisements after TTL but got %v")
+	assert.True(t, (*servers)[0].isShutdown(), "expected advertisement to stop after TTL")
+}
+
+func TestMDNSCheckForUpstreamChanges(t *testing.T) {
+	m, _ := newTestMDNS(t)
+	entries := []*mdns.ServiceEntry{}
+	var queryErr error
+	m.query = func(params *mdns.QueryParam) error {
+		assert.Equal(t, params.Service, "_app._tcp", "expected query for %v but got %v")
+		for _, entry := range entries {
+			params.Entries <- entry
+		}
+		return queryErr
+	}
+
+	didChange, isHealthy := m.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change for no instances")
+	assert.False(t, isHealthy, "expected unhealthy for no instances")
+
+	entries = []*mdns.ServiceEntry{
+		{Name: "app-1._app._tcp.local.", AddrV4: net.ParseIP("10.0.0.1"),
+			Port: 8000, InfoFields: []string{"primary"}},
+		{Name: "app-1._app._tcp.local.", AddrV4: net.ParseIP("10.0.0.1"),
+			Port: 8000, InfoFields: []string{"primary"}}, // repeated response
+		{Name: "app-2._app._tcp.local.", AddrV6: net.ParseIP("fd00::2"),
+			Port: 8000},
+	}
+	didChange, isHealthy = m.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change for new instances")
+	assert.True(t, isHealthy, "expected healthy with instances")
+	assert.Equal(t, len(m.watchedServices["app"]), 2, "expected %v instances but got %v")
+	assert.Equal(t, m.watchedServices["app"][0].Service.ID, "app-1",
+		"expected instance ID %v but got %v")
+
+	didChange, isHealthy = m.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change for same instances")
+	assert.True(t, isHealthy, "expected healthy with instances")
+
+	didChange, isHealthy = m.CheckForUpstreamChanges("app", "primary")
+	assert.True(t, didChange, "expected change when filtering by tag")
+	assert.True(t, isHealthy, "expected healthy with tagged instance")
+	assert.Equal(t, len(m.watchedServices["app"]), 1, "expected %v instance but got %v")
+
+	queryErr = errors.New("no multicast")
+	didChange, isHealthy = m.CheckForUpstreamChanges("app", "primary")
+	assert.False(t, didChange, "expected no change on query error")
+	assert.False(t, isHealthy, "expected unhealthy on query error")
+}
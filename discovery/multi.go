@@ -0,0 +1,107 @@
+package discovery
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Multi is a service discovery backend that fans out registrations and
+// health checks to several backends, such as while migrating between
+// service registries. Watches see the instances of all the backends.
+type Multi []Backend
+
+// NewMulti creates a service discovery backend from several backends
+func NewMulti(backends ...Backend) Multi {
+	return Multi(backends)
+}
+
+// ServiceRegister registers the service with every backend
+func (m Multi) ServiceRegister(service *api.AgentServiceRegistration) error {
+	return m.each(func(b Backend) error { return b.ServiceRegister(service) })
+}
+
+// ServiceDeregister deregisters the service from every backend
+func (m Multi) ServiceDeregister(serviceID string) error {
+	return m.each(func(b Backend) error { return b.ServiceDeregister(serviceID) })
+}
+
+// CheckRegister registers the check with every backend
+func (m Multi) CheckRegister(check *api.AgentCheckRegistration) error {
+	return m.each(func(b Backend) error { return b.CheckRegister(check) })
+}
+
+// PassTTL marks the check passing in every backend
+func (m Multi) PassTTL(checkID, note string) error {
+	return m.each(func(b Backend) error { return b.PassTTL(checkID, note) })
+}
+
+// WarnTTL marks the check warning in every backend
+func (m Multi) WarnTTL(checkID, note string) error {
+	return m.each(func(b Backend) error { return b.WarnTTL(checkID, note) })
+}
+
+// FailTTL marks the check critical in every backend
+func (m Multi) FailTTL(checkID, note string) error {
+	return m.each(func(b Backend) error { return b.FailTTL(checkID, note) })
+}
+
+// CheckForUpstreamChanges checks every backend for changes to the
+// service. The service has changed if it changed in any backend, and is
+// healthy if any backend has a healthy instance.
+func (m Multi) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
+	for _, b := range m {
+		changed, healthy := b.CheckForUpstreamChanges(backendName, backendTag)
+		didChange = didChange || changed
+		isHealthy = isHealthy || healthy
+	}
+	return didChange, isHealthy
+}
+
+// Find sets target, which must point to one of the optional interfaces
+// such as KVBackend, to the backend if it implements the interface. For a
+// Multi it's set to the first of its backends that does, so that features
+// only some registries support are served by that registry. Find returns
+// false if no backend implements the interface.
+func Find(backend Backend, target interface{}) bool {
+	val := reflect.ValueOf(target).Elem()
+	backends := []Backend{backend}
+	if multi, ok := backend.(Multi); ok {
+		backends = multi
+	}
+	for _, b := range backends {
+		if b != nil && reflect.TypeOf(b).Implements(val.Type()) {
+			val.Set(reflect.ValueOf(b))
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops any backends that hold resources, such as plugin processes
+func (m Multi) Close() error {
+	return m.each(func(b Backend) error {
+		if closer, ok := b.(io.Closer); ok {
+			return closer.Close()
+		}
+		return nil
+	})
+}
+
+// each calls fn for every backend, even if an earlier backend fails, so
+// that one unavailable registry doesn't block the others
+func (m Multi) each(fn func(Backend) error) error {
+	errs := []string{}
+	for _, b := range m {
+		if err := fn(b); err != nil {
+			errs = append(errs, fmt.Sprintf("%T: %v", b, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
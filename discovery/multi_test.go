@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+// multiBackend counts registrations and returns fixed watch results
+type multiBackend struct {
+	Backend
+	err              error
+	registered       int
+	changed, healthy bool
+}
+
+func (b *multiBackend) ServiceRegister(service *api.AgentServiceRegistration) error {
+	b.registered++
+	return b.err
+}
+
+func (b *multiBackend) CheckForUpstreamChanges(name, tag string) (bool, bool) {
+	return b.changed, b.healthy
+}
+
+func TestMultiRegister(t *testing.T) {
+	first := &multiBackend{err: errors.New("unavailable")}
+	second := &multiBackend{}
+	multi := NewMulti(first, second)
+	err := multi.ServiceRegister(&api.AgentServiceRegistration{Name: "app"})
+	assert.Error(t, err, "*discovery.multiBackend: unavailable")
+	assert.Equal(t, first.registered, 1, "expected %v registration but got %v")
+	assert.Equal(t, second.registered, 1,
+		"expected %v registration despite the first backend failing but got %v")
+}
+
+func TestMultiCheckForUpstreamChanges(t *testing.T) {
+	first := &multiBackend{changed: true}
+	second := &multiBackend{healthy: true}
+	didChange, isHealthy := NewMulti(first, second).CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected didChange=%v but got %v")
+	assert.True(t, isHealthy, "expected isHealthy=%v but got %v")
+
+	didChange, isHealthy = NewMulti(second, second).CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected didChange=%v but got %v")
+	assert.True(t, isHealthy, "expected isHealthy=%v but got %v")
+}
+
+// kvMultiBackend is a multiBackend that also implements KVBackend
+type kvMultiBackend struct {
+	multiBackend
+}
+
+func (b *kvMultiBackend) WatchKV(ctx context.Context, key string, waitIndex uint64, wait time.Duration) (map[string]string, uint64, error) {
+	return map[string]string{key: "value"}, waitIndex + 1, nil
+}
+
+func TestMultiFind(t *testing.T) {
+	kv := &kvMultiBackend{}
+	var found KVBackend
+	assert.True(t, Find(NewMulti(&multiBackend{}, kv), &found),
+		"expected Find=%v for a member implementing KVBackend but got %v")
+	assert.Equal(t, found, KVBackend(kv), "expected backend %v but got %v")
+
+	var ca ConnectBackend
+	assert.False(t, Find(NewMulti(&multiBackend{}, kv), &ca),
+		"expected Find=%v without a member implementing ConnectBackend but got %v")
+
+	found = nil
+	assert.True(t, Find(kv, &found), "expected Find=%v for a single backend but got %v")
+	assert.Equal(t, found, KVBackend(kv), "expected backend %v but got %v")
+}
@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// DefaultNomadAddress is the Nomad API address if NOMAD_ADDR isn't set
+var DefaultNomadAddress = "http://127.0.0.1:4646"
+
+// Nomad is a service discovery backend for watches on Nomad's native
+// service registry. Nomad services are registered by the Nomad client from
+// the job specification, and the Nomad API doesn't allow other clients to
+// register services or report their health, so registration only logs a
+// warning.
+type Nomad struct {
+	client    *http.Client
+	address   string
+	token     string
+	namespace string
+
+	lock            sync.RWMutex
+	watchedServices map[string][]*api.ServiceEntry
+	warned          map[string]bool // services we've warned can't register
+}
+
+// NewNomad creates a new service discovery backend for Nomad. By default
+// it uses the NOMAD_ADDR, NOMAD_TOKEN, and NOMAD_NAMESPACE environment
+// variables, which include the allocation's workload identity token when
+// the task's identity block sets env = true.
+func NewNomad(config interface{}) (*Nomad, error) {
+	cfg := &struct {
+		Address   string `mapstructure:"address"`
+		Token     string `mapstructure:"token"`
+		Namespace string `mapstructure:"namespace"`
+	}{}
+	if err := utils.DecodeRaw(config, cfg); err != nil {
+		return nil, fmt.Errorf("nomad config parsing error: %v", err)
+	}
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("NOMAD_ADDR")
+	}
+	if cfg.Address == "" {
+		cfg.Address = DefaultNomadAddress
+	}
+	if _, err := url.Parse(cfg.Address); err != nil {
+		return nil, fmt.Errorf("invalid nomad.address '%s': %v", cfg.Address, err)
+	}
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("NOMAD_TOKEN")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = os.Getenv("NOMAD_NAMESPACE")
+	}
+	return &Nomad{
+		client:          &http.Client{Timeout: 10 * time.Second},
+		address:         strings.TrimSuffix(cfg.Address, "/"),
+		token:           cfg.Token,
+		namespace:       cfg.Namespace,
+		watchedServices: make(map[string][]*api.ServiceEntry),
+		warned:          make(map[string]bool),
+	}, nil
+}
+
+// ServiceRegister doesn't register the service, as Nomad doesn't allow it.
+// It logs a warning the first time each service is registered, so that a
+// service that's missing from the job specification isn't a mystery.
+func (n *Nomad) ServiceRegister(service *api.AgentServiceRegistration) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !n.warned[service.Name] {
+		log.Warnf("nomad: service %s isn't registered by ContainerPilot; "+
+			"declare it with provider = \"nomad\" in the job specification",
+			service.Name)
+		n.warned[service.Name] = true
+	}
+	return nil
+}
+
+// ServiceDeregister is a no-op for Nomad
+func (n *Nomad) ServiceDeregister(serviceID string) error { return nil }
+
+// CheckRegister is a no-op for Nomad
+func (n *Nomad) CheckRegister(check *api.AgentCheckRegistration) error { return nil }
+
+// PassTTL is a no-op for Nomad
+func (n *Nomad) PassTTL(checkID, note string) error { return nil }
+
+// WarnTTL is a no-op for Nomad
+func (n *Nomad) WarnTTL(checkID, note string) error { return nil }
+
+// FailTTL is a no-op for Nomad
+func (n *Nomad) FailTTL(checkID, note string) error { return nil }
+
+// nomadServiceRegistration is the subset of the Nomad API's
+// ServiceRegistration that we need
+type nomadServiceRegistration struct {
+	ID          string
+	ServiceName string
+	Tags        []string
+	Address     string
+	Port        int
+}
+
+// CheckForUpstreamChanges lists the registrations of the service and
+// checks whether there has been a change since the last check. Nomad
+// removes the registrations of allocations that stop, and the registry
+// doesn't include check results, so any registration is healthy.
+func (n *Nomad) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
+	registrations, err := n.getService(backendName)
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	instances := []*api.ServiceEntry{}
+	for _, reg := range registrations {
+		if backendTag != "" && !hasTag(reg.Tags, backendTag) {
+			continue
+		}
+		instances = append(instances, &api.ServiceEntry{
+			Service: &api.AgentService{
+				ID:      reg.ID,
+				Service: reg.ServiceName,
+				Tags:    reg.Tags,
+				Address: reg.Address,
+				Port:    reg.Port,
+			},
+		})
+	}
+	isHealthy = len(instances) > 0
+	didChange = n.compareAndSwap(backendName, instances)
+	return didChange, isHealthy
+}
+
+func (n *Nomad) getService(name string) ([]nomadServiceRegistration, error) {
+	query := url.Values{}
+	if n.namespace != "" {
+		query.Set("namespace", n.namespace)
+	}
+	uri := n.address + "/v1/service/" + url.PathEscape(name)
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if n.token != "" {
+		req.Header.Set("X-Nomad-Token", n.token)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nomad API returned %s: %s",
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+	var registrations []nomadServiceRegistration
+	if err := json.Unmarshal(body, &registrations); err != nil {
+		return nil, err
+	}
+	return registrations, nil
+}
+
+// returns true if any addresses for the service changed and updates
+// the internal state
+func (n *Nomad) compareAndSwap(service string, new []*api.ServiceEntry) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	existing := n.watchedServices[service]
+	n.watchedServices[service] = new
+	return compareForChange(existing, new)
+}
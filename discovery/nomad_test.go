@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestNomadServiceRegisterWarns(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stdout)
+
+	n, _ := NewNomad(map[string]interface{}{})
+	for i := 0; i < 2; i++ {
+		err := n.ServiceRegister(&api.AgentServiceRegistration{Name: "app"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, strings.Count(buf.String(), "service app isn't registered"), 1,
+		"expected %v warning but got %v")
+}
+
+func TestNomadCheckForUpstreamChanges(t *testing.T) {
+	registrations := []map[string]interface{}{}
+	var gotToken, gotNamespace string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/service/app" {
+				http.NotFound(w, r)
+				return
+			}
+			gotToken = r.Header.Get("X-Nomad-Token")
+			gotNamespace = r.URL.Query().Get("namespace")
+			json.NewEncoder(w).Encode(registrations)
+		}))
+	defer server.Close()
+
+	n, err := NewNomad(map[string]interface{}{
+		"address": server.URL, "token": "s3cret", "namespace": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	didChange, isHealthy := n.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change without registrations")
+	assert.False(t, isHealthy, "expected unhealthy without registrations")
+	assert.Equal(t, gotToken, "s3cret", "expected token %v but got %v")
+	assert.Equal(t, gotNamespace, "prod", "expected namespace %v but got %v")
+
+	registrations = []map[string]interface{}{
+		{"ID": "_nomad-task-1", "ServiceName": "app", "Address": "10.0.0.1",
+			"Port": 8080, "Tags": []string{"primary"}},
+		{"ID": "_nomad-task-2", "ServiceName": "app", "Address": "10.0.0.2",
+			"Port": 8080},
+	}
+	didChange, isHealthy = n.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change after registration")
+	assert.True(t, isHealthy, "expected healthy after registration")
+	assert.Equal(t, len(n.watchedServices["app"]), 2, "expected %v instances but got %v")
+
+	didChange, _ = n.CheckForUpstreamChanges("app", "primary")
+	assert.True(t, didChange, "expected change when filtering by tag")
+	assert.Equal(t, len(n.watchedServices["app"]), 1, "expected %v instances but got %v")
+
+	_, isHealthy = n.CheckForUpstreamChanges("other", "")
+	assert.False(t, isHealthy, "expected unhealthy on API error")
+}
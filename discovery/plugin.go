@@ -0,0 +1,223 @@
+package discovery
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// Plugin is a service discovery backend implemented by a plugin binary,
+// so that third parties can support other registries without forking
+// ContainerPilot. The plugin is launched on first use and serves the
+// protocol in plugin.proto over gRPC. If the plugin exits it's launched
+// again on the next call, and the services and checks registered so far
+// are registered with the new process.
+type Plugin struct {
+	name   string
+	config interface{}
+
+	launch func() (pluginProcess, pluginRemote, error)
+
+	lock     sync.Mutex
+	process  pluginProcess
+	remote   pluginRemote
+	services map[string]*api.AgentServiceRegistration
+	checks   map[string]*api.AgentCheckRegistration
+}
+
+// pluginProcess is the part of plugin.Client we need, so tests can fake it
+type pluginProcess interface {
+	Exited() bool
+	Kill()
+}
+
+// pluginRemote is the backend served by a plugin process
+type pluginRemote interface {
+	configure(config interface{}) error
+	ServiceRegister(service *api.AgentServiceRegistration) error
+	ServiceDeregister(serviceID string) error
+	CheckRegister(check *api.AgentCheckRegistration) error
+	updateTTL(checkID, note, checkStatus string) error
+	watch(name, tag string) (didChange, isHealthy bool, err error)
+}
+
+// NewPlugin creates a new service discovery backend for a plugin binary
+func NewPlugin(config interface{}) (*Plugin, error) {
+	cfg := &struct {
+		Path   string      `mapstructure:"path"`
+		Args   []string    `mapstructure:"args"`
+		Config interface{} `mapstructure:"config"`
+	}{}
+	if err := utils.DecodeRaw(config, cfg); err != nil {
+		return nil, fmt.Errorf("plugin config parsing error: %v", err)
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("plugin.path must not be empty")
+	}
+	path, err := exec.LookPath(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin.path '%s': %v", cfg.Path, err)
+	}
+	p := &Plugin{
+		name:     filepath.Base(path),
+		config:   cfg.Config,
+		services: make(map[string]*api.AgentServiceRegistration),
+		checks:   make(map[string]*api.AgentCheckRegistration),
+	}
+	p.launch = func() (pluginProcess, pluginRemote, error) {
+		return launchPlugin(p.name, path, cfg.Args)
+	}
+	return p, nil
+}
+
+func launchPlugin(name, path string, args []string) (pluginProcess, pluginRemote, error) {
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  pluginHandshake,
+		Plugins:          plugin.PluginSet{"discovery": &discoveryPlugin{}},
+		Cmd:              exec.Command(path, args...),
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin." + name,
+			Output: log.StandardLogger().Out,
+			Level:  hclog.Info,
+		}),
+	})
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, nil, err
+	}
+	raw, err := rpcClient.Dispense("discovery")
+	if err != nil {
+		client.Kill()
+		return nil, nil, err
+	}
+	return client, raw.(*pluginClient), nil
+}
+
+// connect returns the plugin's backend, launching the plugin if it isn't
+// running. The caller must hold the lock.
+func (p *Plugin) connect() (pluginRemote, error) {
+	if p.process != nil && !p.process.Exited() {
+		return p.remote, nil
+	}
+	if p.process != nil {
+		log.Warnf("discovery plugin %s exited, restarting", p.name)
+		p.process.Kill() // clean up the client's connection
+	}
+	process, remote, err := p.launch()
+	if err != nil {
+		return nil, fmt.Errorf("unable to launch plugin %s: %v", p.name, err)
+	}
+	if err := remote.configure(p.config); err != nil {
+		process.Kill()
+		return nil, fmt.Errorf("unable to configure plugin %s: %v", p.name, err)
+	}
+	// a restarted plugin has lost the registrations of the old process
+	for _, service := range p.services {
+		if err := remote.ServiceRegister(service); err != nil {
+			log.Warnf("discovery plugin %s: %v", p.name, err)
+		}
+	}
+	for _, check := range p.checks {
+		if err := remote.CheckRegister(check); err != nil {
+			log.Warnf("discovery plugin %s: %v", p.name, err)
+		}
+	}
+	p.process, p.remote = process, remote
+	return remote, nil
+}
+
+// call runs fn against the plugin's backend while holding the lock
+func (p *Plugin) call(fn func(pluginRemote) error) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	remote, err := p.connect()
+	if err != nil {
+		return err
+	}
+	return fn(remote)
+}
+
+// ServiceRegister registers the service with the plugin
+func (p *Plugin) ServiceRegister(service *api.AgentServiceRegistration) error {
+	return p.call(func(remote pluginRemote) error {
+		p.services[service.ID] = service
+		return remote.ServiceRegister(service)
+	})
+}
+
+// ServiceDeregister deregisters the service from the plugin
+func (p *Plugin) ServiceDeregister(serviceID string) error {
+	return p.call(func(remote pluginRemote) error {
+		delete(p.services, serviceID)
+		for checkID, check := range p.checks {
+			if check.ServiceID == serviceID {
+				delete(p.checks, checkID)
+			}
+		}
+		return remote.ServiceDeregister(serviceID)
+	})
+}
+
+// CheckRegister registers the check with the plugin
+func (p *Plugin) CheckRegister(check *api.AgentCheckRegistration) error {
+	return p.call(func(remote pluginRemote) error {
+		p.checks[check.ID] = check
+		return remote.CheckRegister(check)
+	})
+}
+
+// PassTTL marks the check passing
+func (p *Plugin) PassTTL(checkID, note string) error {
+	return p.call(func(remote pluginRemote) error {
+		return remote.updateTTL(checkID, note, statusPassing)
+	})
+}
+
+// WarnTTL marks the check warning
+func (p *Plugin) WarnTTL(checkID, note string) error {
+	return p.call(func(remote pluginRemote) error {
+		return remote.updateTTL(checkID, note, statusWarning)
+	})
+}
+
+// FailTTL marks the check critical
+func (p *Plugin) FailTTL(checkID, note string) error {
+	return p.call(func(remote pluginRemote) error {
+		return remote.updateTTL(checkID, note, statusCritical)
+	})
+}
+
+// CheckForUpstreamChanges asks the plugin whether the service has
+// changed since the last check
+func (p *Plugin) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
+	err := p.call(func(remote pluginRemote) error {
+		var err error
+		didChange, isHealthy, err = remote.watch(backendName, backendTag)
+		return err
+	})
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	return didChange, isHealthy
+}
+
+// Close stops the plugin process
+func (p *Plugin) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.process != nil {
+		p.process.Kill()
+		p.process, p.remote = nil, nil
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The gRPC service is described by plugin.proto. The messages are all
+// well-known Struct types, so the service is registered by hand rather
+// than with generated code.
+const pluginServiceName = "containerpilot.discovery.Backend"
+
+// pluginHandshake must match between ContainerPilot and its plugins
+var pluginHandshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "CONTAINERPILOT_DISCOVERY_PLUGIN",
+	MagicCookieValue: "d1sc0very",
+}
+
+// pluginTimeout bounds each call to a plugin
+var pluginTimeout = 10 * time.Second
+
+// ServePlugin serves a discovery backend as a plugin. It's called from
+// the main function of a plugin binary, and newBackend is called with the
+// plugin's config field (which may be nil) when ContainerPilot configures
+// the plugin. ServePlugin doesn't return.
+func ServePlugin(newBackend func(config interface{}) (Backend, error)) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: pluginHandshake,
+		Plugins: plugin.PluginSet{
+			"discovery": &discoveryPlugin{newBackend: newBackend},
+		},
+		GRPCServer: plugin.DefaultGRPCServer,
+	})
+}
+
+// discoveryPlugin implements plugin.GRPCPlugin for both ends of the
+// connection
+type discoveryPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	newBackend func(config interface{}) (Backend, error)
+}
+
+func (p *discoveryPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&pluginServiceDesc, &pluginServer{newBackend: p.newBackend})
+	return nil
+}
+
+func (p *discoveryPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &pluginClient{conn: c}, nil
+}
+
+// pluginService is the handler type of the gRPC service
+type pluginService interface {
+	call(method string, in *structpb.Struct) (*structpb.Struct, error)
+}
+
+var pluginServiceDesc = grpc.ServiceDesc{
+	ServiceName: pluginServiceName,
+	HandlerType: (*pluginService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Configure", Handler: pluginHandler("Configure")},
+		{MethodName: "ServiceRegister", Handler: pluginHandler("ServiceRegister")},
+		{MethodName: "ServiceDeregister", Handler: pluginHandler("ServiceDeregister")},
+		{MethodName: "CheckRegister", Handler: pluginHandler("CheckRegister")},
+		{MethodName: "UpdateTTL", Handler: pluginHandler("UpdateTTL")},
+		{MethodName: "CheckForUpstreamChanges", Handler: pluginHandler("CheckForUpstreamChanges")},
+	},
+	Metadata: "discovery/plugin.proto",
+}
+
+func pluginHandler(method string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := &structpb.Struct{}
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(pluginService).call(method, req.(*structpb.Struct))
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: "/" + pluginServiceName + "/" + method,
+		}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// pluginServer serves the backend created by the plugin's newBackend
+type pluginServer struct {
+	newBackend func(config interface{}) (Backend, error)
+
+	lock    sync.RWMutex
+	backend Backend
+}
+
+type pluginTTLUpdate struct {
+	CheckID string
+	Note    string
+	Status  string
+}
+
+type pluginWatch struct {
+	Name string
+	Tag  string
+}
+
+type pluginWatchResult struct {
+	Changed bool
+	Healthy bool
+}
+
+func (s *pluginServer) call(method string, in *structpb.Struct) (*structpb.Struct, error) {
+	if method == "Configure" {
+		var req struct{ Config interface{} }
+		if err := fromStruct(in, &req); err != nil {
+			return nil, err
+		}
+		backend, err := s.newBackend(req.Config)
+		if err != nil {
+			return nil, err
+		}
+		s.lock.Lock()
+		s.backend = backend
+		s.lock.Unlock()
+		return &structpb.Struct{}, nil
+	}
+	s.lock.RLock()
+	backend := s.backend
+	s.lock.RUnlock()
+	if backend == nil {
+		return nil, fmt.Errorf("plugin is not configured")
+	}
+	var err error
+	switch method {
+	case "ServiceRegister":
+		req := &api.AgentServiceRegistration{}
+		if err = fromStruct(in, req); err == nil {
+			err = backend.ServiceRegister(req)
+		}
+	case "ServiceDeregister":
+		var req struct{ ID string }
+		if err = fromStruct(in, &req); err == nil {
+			err = backend.ServiceDeregister(req.ID)
+		}
+	case "CheckRegister":
+		req := &api.AgentCheckRegistration{}
+		if err = fromStruct(in, req); err == nil {
+			err = backend.CheckRegister(req)
+		}
+	case "UpdateTTL":
+		req := &pluginTTLUpdate{}
+		if err = fromStruct(in, req); err == nil {
+			switch req.Status {
+			case statusPassing:
+				err = backend.PassTTL(req.CheckID, req.Note)
+			case statusWarning:
+				err = backend.WarnTTL(req.CheckID, req.Note)
+			default:
+				err = backend.FailTTL(req.CheckID, req.Note)
+			}
+		}
+	case "CheckForUpstreamChanges":
+		req := &pluginWatch{}
+		if err = fromStruct(in, req); err != nil {
+			return nil, err
+		}
+		changed, healthy := backend.CheckForUpstreamChanges(req.Name, req.Tag)
+		return toStruct(&pluginWatchResult{Changed: changed, Healthy: healthy})
+	default:
+		err = fmt.Errorf("unknown method %s", method)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &structpb.Struct{}, nil
+}
+
+// pluginClient calls a plugin over its gRPC connection
+type pluginClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *pluginClient) invoke(method string, req, result interface{}) error {
+	in, err := toStruct(req)
+	if err != nil {
+		return err
+	}
+	out := &structpb.Struct{}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	err = c.conn.Invoke(ctx, "/"+pluginServiceName+"/"+method, in, out)
+	if err != nil {
+		return fmt.Errorf("%s", status.Convert(err).Message())
+	}
+	if result != nil {
+		return fromStruct(out, result)
+	}
+	return nil
+}
+
+func (c *pluginClient) configure(config interface{}) error {
+	return c.invoke("Configure", map[string]interface{}{"Config": config}, nil)
+}
+
+func (c *pluginClient) ServiceRegister(service *api.AgentServiceRegistration) error {
+	return c.invoke("ServiceRegister", service, nil)
+}
+
+func (c *pluginClient) ServiceDeregister(serviceID string) error {
+	return c.invoke("ServiceDeregister", map[string]string{"ID": serviceID}, nil)
+}
+
+func (c *pluginClient) CheckRegister(check *api.AgentCheckRegistration) error {
+	return c.invoke("CheckRegister", check, nil)
+}
+
+func (c *pluginClient) updateTTL(checkID, note, checkStatus string) error {
+	return c.invoke("UpdateTTL", &pluginTTLUpdate{checkID, note, checkStatus}, nil)
+}
+
+func (c *pluginClient) watch(name, tag string) (didChange, isHealthy bool, err error) {
+	result := &pluginWatchResult{}
+	err = c.invoke("CheckForUpstreamChanges", &pluginWatch{Name: name, Tag: tag}, result)
+	return result.Changed, result.Healthy, err
+}
+
+// toStruct and fromStruct convert between our types and Structs through
+// their JSON encoding
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := &structpb.Struct{}
+	if err := protojson.Unmarshal(body, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func fromStruct(s *structpb.Struct, v interface{}) error {
+	body, err := protojson.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,199 @@
+package discovery
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-plugin"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+// pluginBackend is the backend served by the plugin in tests
+type pluginBackend struct {
+	config    interface{}
+	service   *api.AgentServiceRegistration
+	check     *api.AgentCheckRegistration
+	status    string
+	watchName string
+}
+
+func (b *pluginBackend) ServiceRegister(service *api.AgentServiceRegistration) error {
+	b.service = service
+	return nil
+}
+
+func (b *pluginBackend) ServiceDeregister(serviceID string) error {
+	return errors.New("not registered")
+}
+
+func (b *pluginBackend) CheckRegister(check *api.AgentCheckRegistration) error {
+	b.check = check
+	return nil
+}
+
+func (b *pluginBackend) PassTTL(checkID, note string) error {
+	b.status = statusPassing
+	return nil
+}
+
+func (b *pluginBackend) WarnTTL(checkID, note string) error {
+	b.status = statusWarning
+	return nil
+}
+
+func (b *pluginBackend) FailTTL(checkID, note string) error {
+	b.status = statusCritical
+	return nil
+}
+
+func (b *pluginBackend) CheckForUpstreamChanges(name, tag string) (bool, bool) {
+	b.watchName = name
+	return true, tag == "primary"
+}
+
+func TestPluginConfigParse(t *testing.T) {
+	_, err := NewPlugin(map[string]interface{}{})
+	assert.Error(t, err, "plugin.path must not be empty")
+	_, err = NewPlugin(map[string]interface{}{"path": "/nonexistent/plugin"})
+	if err == nil {
+		t.Fatalf("expected error for missing plugin binary")
+	}
+}
+
+func TestPluginGRPC(t *testing.T) {
+	backend := &pluginBackend{}
+	client, _ := plugin.TestPluginGRPCConn(t, false, map[string]plugin.Plugin{
+		"discovery": &discoveryPlugin{
+			newBackend: func(config interface{}) (Backend, error) {
+				backend.config = config
+				return backend, nil
+			},
+		},
+	})
+	defer client.Close()
+	raw, err := client.Dispense("discovery")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remote := raw.(*pluginClient)
+
+	err = remote.ServiceRegister(&api.AgentServiceRegistration{ID: "app-1"})
+	assert.Error(t, err, "plugin is not configured")
+	err = remote.configure(map[string]interface{}{"url": "http://eureka:8761"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, backend.config,
+		map[string]interface{}{"url": "http://eureka:8761"},
+		"expected config %v but got %v")
+
+	err = remote.ServiceRegister(&api.AgentServiceRegistration{
+		ID: "app-1", Name: "app", Port: 8000, Tags: []string{"primary"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, backend.service.ID, "app-1", "expected service %v but got %v")
+	assert.Equal(t, backend.service.Port, 8000, "expected port %v but got %v")
+	assert.Equal(t, backend.service.Tags, []string{"primary"}, "expected tags %v but got %v")
+
+	remote.CheckRegister(&api.AgentCheckRegistration{
+		ID: "app-1", ServiceID: "app-1",
+		AgentServiceCheck: api.AgentServiceCheck{TTL: "10s"},
+	})
+	assert.Equal(t, backend.check.TTL, "10s", "expected TTL %v but got %v")
+	remote.updateTTL("app-1", "ok", statusWarning)
+	assert.Equal(t, backend.status, statusWarning, "expected status %v but got %v")
+
+	assert.Error(t, remote.ServiceDeregister("app-1"), "not registered")
+
+	didChange, isHealthy, err := remote.watch("upstream", "primary")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, backend.watchName, "upstream", "expected watch on %v but got %v")
+	assert.True(t, didChange, "expected didChange=%v but got %v")
+	assert.True(t, isHealthy, "expected isHealthy=%v but got %v")
+}
+
+// fakePluginProcess and fakePluginRemote stand in for a launched plugin
+type fakePluginProcess struct {
+	exited, killed bool
+}
+
+func (p *fakePluginProcess) Exited() bool { return p.exited }
+func (p *fakePluginProcess) Kill()        { p.killed = true }
+
+type fakePluginRemote struct {
+	configured int
+	services   []string
+	checks     []string
+}
+
+func (r *fakePluginRemote) configure(config interface{}) error {
+	r.configured++
+	return nil
+}
+
+func (r *fakePluginRemote) ServiceRegister(service *api.AgentServiceRegistration) error {
+	r.services = append(r.services, service.ID)
+	return nil
+}
+
+func (r *fakePluginRemote) ServiceDeregister(serviceID string) error { return nil }
+
+func (r *fakePluginRemote) CheckRegister(check *api.AgentCheckRegistration) error {
+	r.checks = append(r.checks, check.ID)
+	return nil
+}
+
+func (r *fakePluginRemote) updateTTL(checkID, note, checkStatus string) error {
+	return nil
+}
+
+func (r *fakePluginRemote) watch(name, tag string) (bool, bool, error) {
+	return false, false, errors.New("unavailable")
+}
+
+func TestPluginRestart(t *testing.T) {
+	processes := []*fakePluginProcess{}
+	remotes := []*fakePluginRemote{}
+	p := &Plugin{
+		name:     "test",
+		services: make(map[string]*api.AgentServiceRegistration),
+		checks:   make(map[string]*api.AgentCheckRegistration),
+		launch: func() (pluginProcess, pluginRemote, error) {
+			process, remote := &fakePluginProcess{}, &fakePluginRemote{}
+			processes = append(processes, process)
+			remotes = append(remotes, remote)
+			return process, remote, nil
+		},
+	}
+	p.ServiceRegister(&api.AgentServiceRegistration{ID: "app-1"})
+	p.CheckRegister(&api.AgentCheckRegistration{ID: "app-1", ServiceID: "app-1"})
+	p.PassTTL("app-1", "ok")
+	assert.Equal(t, len(processes), 1, "expected %v launch but got %v")
+	assert.Equal(t, remotes[0].configured, 1, "expected %v configure but got %v")
+
+	processes[0].exited = true
+	p.PassTTL("app-1", "ok")
+	assert.Equal(t, len(processes), 2, "expected %v launches but got %v")
+	assert.True(t, processes[0].killed, "expected exited plugin to be cleaned up")
+	assert.Equal(t, remotes[1].configured, 1, "expected %v configure but got %v")
+	assert.Equal(t, remotes[1].services, []string{"app-1"},
+		"expected services %v to be registered again but got %v")
+	assert.Equal(t, remotes[1].checks, []string{"app-1"},
+		"expected checks %v to be registered again but got %v")
+
+	p.ServiceDeregister("app-1")
+	processes[1].exited = true
+	didChange, isHealthy := p.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change on plugin error")
+	assert.False(t, isHealthy, "expected unhealthy on plugin error")
+	assert.Equal(t, len(remotes[2].services), 0,
+		"expected %v services after deregistering but got %v")
+
+	p.Close()
+	assert.True(t, processes[2].killed, "expected plugin to be stopped on close")
+}
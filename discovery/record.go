@@ -0,0 +1,144 @@
+package discovery
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Health statuses of a serviceRecord, matching Consul's check statuses
+const (
+	statusPassing  = "passing"
+	statusWarning  = "warning"
+	statusCritical = "critical"
+)
+
+// serviceRecord is the registration of a service for backends that don't
+// have their own service catalog. Those backends have no TTL checks either,
+// so the record carries its own expiry which watchers enforce.
+type serviceRecord struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Address string    `json:"address,omitempty"`
+	Port    int       `json:"port"`
+	Tags    []string  `json:"tags,omitempty"`
+	Status  string    `json:"status"`
+	Expires time.Time `json:"expires"`
+
+	ttl time.Duration
+}
+
+func newServiceRecord(service *api.AgentServiceRegistration) *serviceRecord {
+	return &serviceRecord{
+		ID:      service.ID,
+		Name:    service.Name,
+		Address: service.Address,
+		Port:    service.Port,
+		Tags:    service.Tags,
+		Status:  statusCritical,
+	}
+}
+
+// isPassing returns true if the record is passing, hasn't expired, and
+// has the tag (if any)
+func (r *serviceRecord) isPassing(tag string, now time.Time) bool {
+	if r.Status != statusPassing || r.Expires.Before(now) {
+		return false
+	}
+	return tag == "" || hasTag(r.Tags, tag)
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// entry converts the record to the Consul API type we use to compare
+// instances, using defaultAddress if the record has no address
+func (r *serviceRecord) entry(defaultAddress string) *api.ServiceEntry {
+	address := r.Address
+	if address == "" {
+		address = defaultAddress
+	}
+	return &api.ServiceEntry{
+		Service: &api.AgentService{
+			ID:      r.ID,
+			Service: r.Name,
+			Tags:    r.Tags,
+			Address: address,
+			Port:    r.Port,
+		},
+	}
+}
+
+// serviceRecords tracks the records of the services registered by this
+// ContainerPilot, keyed by service ID. Check IDs are the same as the
+// service IDs.
+type serviceRecords struct {
+	lock    sync.Mutex
+	records map[string]*serviceRecord
+}
+
+func newServiceRecords() *serviceRecords {
+	return &serviceRecords{records: make(map[string]*serviceRecord)}
+}
+
+// register adds or replaces the record for a service, keeping the TTL of
+// any existing check. The service is critical until its first TTL update.
+func (rs *serviceRecords) register(service *api.AgentServiceRegistration) serviceRecord {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	record := newServiceRecord(service)
+	if existing, ok := rs.records[service.ID]; ok {
+		record.ttl = existing.ttl
+	}
+	rs.records[service.ID] = record
+	return *record
+}
+
+func (rs *serviceRecords) deregister(serviceID string) (serviceRecord, error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	record, ok := rs.records[serviceID]
+	if !ok {
+		return serviceRecord{}, fmt.Errorf("service %s is not registered", serviceID)
+	}
+	delete(rs.records, serviceID)
+	return *record, nil
+}
+
+// registerCheck records the TTL of the check for a registered service
+func (rs *serviceRecords) registerCheck(check *api.AgentCheckRegistration) error {
+	ttl, err := time.ParseDuration(check.TTL)
+	if err != nil {
+		return fmt.Errorf("invalid TTL for check %s: %v", check.ID, err)
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	record, ok := rs.records[check.ServiceID]
+	if !ok {
+		return fmt.Errorf("service %s is not registered", check.ServiceID)
+	}
+	record.ttl = ttl
+	return nil
+}
+
+// update sets the status of the service and extends its expiry by the
+// TTL. Like a Consul TTL check, this fails if the check isn't registered.
+func (rs *serviceRecords) update(checkID, status string) (serviceRecord, error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	record, ok := rs.records[checkID]
+	if !ok || record.ttl == 0 {
+		return serviceRecord{}, fmt.Errorf("check %s is not registered", checkID)
+	}
+	record.Status = status
+	record.Expires = time.Now().Add(record.ttl).UTC()
+	return *record, nil
+}
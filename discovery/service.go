@@ -2,6 +2,7 @@ package discovery
 
 import (
 	"fmt"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/hashicorp/consul/api"
@@ -18,6 +19,13 @@ type ServiceDefinition struct {
 	IPAddress                      string
 	EnableTagOverride              bool
 	DeregisterCriticalServiceAfter string
+	Connect                        *ConnectDefinition
+	Meta                           map[string]string
+	TaggedAddresses                map[string]api.ServiceAddress
+	Weights                        *api.AgentWeights
+	Warmup                         *WarmupDefinition
+	Notes                          string
+	Checks                         api.AgentServiceChecks
 	Consul                         Backend
 
 	wasRegistered bool
@@ -31,6 +39,7 @@ func (service *ServiceDefinition) Deregister() {
 // MarkForMaintenance removes the service from Consul.
 func (service *ServiceDefinition) MarkForMaintenance() {
 	log.Debugf("deregistering: %s", service.ID)
+	service.Warmup.reset()
 	if err := service.Consul.ServiceDeregister(service.ID); err != nil {
 		log.Infof("deregistering failed: %s", err)
 	}
@@ -40,51 +49,117 @@ func (service *ServiceDefinition) MarkForMaintenance() {
 // If consul has never seen this service, we register the service and
 // its TTL check.
 func (service *ServiceDefinition) SendHeartbeat() {
+	service.SendPassing("ok")
+}
+
+// SendPassing writes a TTL check status=passing with the note, such as
+// the output of the health check, to the consul store, registering the
+// service and its TTL check if needed.
+func (service *ServiceDefinition) SendPassing(note string) {
+	if service.updateTTL(service.Consul.PassTTL, note) &&
+		service.Warmup.pass(time.Duration(service.TTL)*time.Second, time.Now()) {
+		if service.Warmup.warm() {
+			log.Infof("%s warmed up, raising its weight", service.Name)
+		} else {
+			log.Infof("%s missed its TTL, warming up again", service.Name)
+		}
+		service.updateWeights()
+	}
+}
+
+// SendWarning writes a TTL check status=warning to the consul store,
+// registering the service and its TTL check if needed.
+func (service *ServiceDefinition) SendWarning(note string) {
+	if service.Warmup.reset() {
+		service.updateWeights()
+	}
+	service.updateTTL(service.Consul.WarnTTL, note)
+}
+
+// SendFailure writes a TTL check status=critical to the consul store,
+// registering the service and its TTL check if needed.
+func (service *ServiceDefinition) SendFailure(note string) {
+	if service.Warmup.reset() {
+		service.updateWeights()
+	}
+	service.updateTTL(service.Consul.FailTTL, note)
+}
+
+// updateTTL sends the check update, registering the service and its
+// check if needed, and returns false if the update couldn't be sent
+func (service *ServiceDefinition) updateTTL(update func(string, string) error, note string) bool {
 	if !service.wasRegistered {
 		if err := service.registerService(); err != nil {
 			log.Warnf("service registration failed: %s", err)
-			return
+			return false
 		}
 		service.wasRegistered = true
 	}
-	if err := service.Consul.PassTTL(service.ID, "ok"); err != nil {
+	if service.Connect != nil {
+		if err := service.Connect.refreshCerts(service.Consul, service.Name); err != nil {
+			log.Warnf("%s: %v", service.Name, err)
+		}
+	}
+	if err := update(service.ID, note); err != nil {
 		log.Infof("service not registered: %v", err)
 		if err = service.registerService(); err != nil {
 			log.Warnf("service registration failed: %s", err)
-			return
+			return false
 		}
 		if err = service.registerCheck(); err != nil {
 			log.Warnf("check registration failed: %s", err)
-			return
+			return false
 		}
 		// now that we're ensured we're registered, we can push the
 		// heartbeat again
-		if err := service.Consul.PassTTL(service.ID, "ok"); err != nil {
+		if err := update(service.ID, note); err != nil {
 			log.Errorf("Failed to write heartbeat: %s", err)
+			return false
 		}
 		log.Infof("Service registered: %v", service.Name)
 	}
+	return true
+}
+
+// updateWeights re-registers the service when its warm-up state changes
+func (service *ServiceDefinition) updateWeights() {
+	if !service.wasRegistered {
+		return
+	}
+	if err := service.registerService(); err != nil {
+		log.Warnf("updating weights for %s failed: %s", service.Name, err)
+	}
 }
 
 func (service *ServiceDefinition) registerService() error {
-	return service.Consul.ServiceRegister(
-		&api.AgentServiceRegistration{
-			ID:                service.ID,
-			Name:              service.Name,
-			Tags:              service.Tags,
-			Port:              service.Port,
-			Address:           service.IPAddress,
-			EnableTagOverride: service.EnableTagOverride,
-		},
-	)
+	registration := &api.AgentServiceRegistration{
+		ID:                service.ID,
+		Name:              service.Name,
+		Tags:              service.Tags,
+		Port:              service.Port,
+		Address:           service.IPAddress,
+		EnableTagOverride: service.EnableTagOverride,
+		Meta:              service.Meta,
+		TaggedAddresses:   service.TaggedAddresses,
+		Weights:           service.weights(),
+		Checks:            service.Checks,
+	}
+	if service.Connect != nil {
+		registration.Connect = service.Connect.registration()
+	}
+	return service.Consul.ServiceRegister(registration)
 }
 
 func (service *ServiceDefinition) registerCheck() error {
+	notes := service.Notes
+	if notes == "" {
+		notes = fmt.Sprintf("TTL for %s set by containerpilot", service.Name)
+	}
 	return service.Consul.CheckRegister(
 		&api.AgentCheckRegistration{
 			ID:        service.ID,
 			Name:      service.ID,
-			Notes:     fmt.Sprintf("TTL for %s set by containerpilot", service.Name),
+			Notes:     notes,
 			ServiceID: service.ID,
 			AgentServiceCheck: api.AgentServiceCheck{
 				TTL: fmt.Sprintf("%ds", service.TTL),
@@ -0,0 +1,199 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// vaultToken fetches the Consul ACL token from a Vault secret, such as
+// the credentials of a Consul secrets engine role, and renews or re-reads
+// the secret before its lease expires.
+type vaultToken struct {
+	client    *http.Client
+	address   string
+	path      string
+	field     string
+	token     string // Vault token
+	tokenFile string
+
+	lock      sync.Mutex
+	value     string // Consul token
+	leaseID   string
+	renewable bool
+	refreshAt time.Time
+}
+
+func newVaultToken(raw interface{}) (*vaultToken, error) {
+	cfg := &struct {
+		Address   string `mapstructure:"address"`
+		Path      string `mapstructure:"path"`
+		Field     string `mapstructure:"field"`
+		Token     string `mapstructure:"token"`
+		TokenFile string `mapstructure:"tokenFile"`
+	}{Field: "token"}
+	if err := utils.DecodeRaw(raw, cfg); err != nil {
+		return nil, fmt.Errorf("consul.vault config parsing error: %v", err)
+	}
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("consul.vault.address must be set if VAULT_ADDR isn't")
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("consul.vault.path must not be empty")
+	}
+	if cfg.Token == "" && cfg.TokenFile == "" {
+		cfg.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if cfg.Token == "" && cfg.TokenFile == "" {
+		return nil, fmt.Errorf("consul.vault requires a token or tokenFile " +
+			"if VAULT_TOKEN isn't set")
+	}
+	return &vaultToken{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		address:   strings.TrimSuffix(cfg.Address, "/"),
+		path:      strings.Trim(cfg.Path, "/"),
+		field:     cfg.Field,
+		token:     cfg.Token,
+		tokenFile: cfg.TokenFile,
+	}, nil
+}
+
+// vaultSecret is the subset of a Vault secret response that we need
+type vaultSecret struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+}
+
+// current returns the Consul token, renewing or re-reading the secret if
+// its lease is due to be refreshed
+func (v *vaultToken) current(now time.Time) (string, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.value != "" && (v.refreshAt.IsZero() || now.Before(v.refreshAt)) {
+		return v.value, nil
+	}
+	if v.value != "" && v.renewable {
+		err := v.renew(now)
+		if err == nil {
+			return v.value, nil
+		}
+		log.Warnf("unable to renew Consul token lease, fetching a new token: %v", err)
+	}
+	if err := v.fetch(now); err != nil {
+		return "", err
+	}
+	return v.value, nil
+}
+
+// rotate re-reads the secret, such as after Consul rejects the token
+func (v *vaultToken) rotate(now time.Time) (string, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if err := v.fetch(now); err != nil {
+		return "", err
+	}
+	return v.value, nil
+}
+
+// fetch reads the secret. The caller must hold the lock.
+func (v *vaultToken) fetch(now time.Time) error {
+	secret := &vaultSecret{}
+	if err := v.do("GET", v.path, nil, secret); err != nil {
+		return err
+	}
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested // KV version 2
+	}
+	value, ok := data[v.field].(string)
+	if !ok || value == "" {
+		return fmt.Errorf("vault secret %s has no '%s' field", v.path, v.field)
+	}
+	v.value = value
+	if v.leaseID != "" && v.leaseID != secret.LeaseID {
+		v.revoke(v.leaseID)
+	}
+	v.leaseID = secret.LeaseID
+	v.renewable = secret.Renewable && secret.LeaseID != ""
+	v.setLease(secret.LeaseDuration, now)
+	return nil
+}
+
+// renew extends the secret's lease. The caller must hold the lock.
+func (v *vaultToken) renew(now time.Time) error {
+	body, _ := json.Marshal(map[string]string{"lease_id": v.leaseID})
+	secret := &vaultSecret{}
+	if err := v.do("PUT", "sys/leases/renew", body, secret); err != nil {
+		return err
+	}
+	v.setLease(secret.LeaseDuration, now)
+	return nil
+}
+
+// revoke revokes a lease we no longer use, so that Vault can delete the
+// Consul token it issued. The caller must hold the lock.
+func (v *vaultToken) revoke(leaseID string) {
+	body, _ := json.Marshal(map[string]string{"lease_id": leaseID})
+	if err := v.do("PUT", "sys/leases/revoke", body, nil); err != nil {
+		log.Warnf("unable to revoke Consul token lease %s: %v", leaseID, err)
+	}
+}
+
+// setLease schedules the next refresh at two thirds of the lease, so
+// there's time to fetch a new token if renewal fails. Secrets without a
+// lease are only re-read if Consul rejects the token.
+func (v *vaultToken) setLease(seconds int, now time.Time) {
+	if seconds <= 0 {
+		v.refreshAt = time.Time{}
+		return
+	}
+	v.refreshAt = now.Add(time.Duration(seconds) * time.Second * 2 / 3)
+}
+
+func (v *vaultToken) do(method, path string, body []byte, result interface{}) error {
+	token := v.token
+	if v.tokenFile != "" {
+		// reread each time so that a Vault agent can rotate the token
+		data, err := ioutil.ReadFile(v.tokenFile)
+		if err != nil {
+			return fmt.Errorf("unable to read consul.vault.tokenFile: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	req, err := http.NewRequest(method, v.address+"/v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("vault %s returned %s: %s",
+			path, resp.Status, bytes.TrimSpace(respBody))
+	}
+	if result == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+// fakeVault issues a new Consul token each time the secret is read
+type fakeVault struct {
+	reads, renewals int
+	renewErr        bool
+	revoked         []string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "vault-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/consul/creds/app":
+		f.reads++
+		fmt.Fprintf(w, `{"lease_id": "consul/creds/app/%d", "renewable": true,
+			"lease_duration": 30, "data": {"token": "token-%d"}}`, f.reads, f.reads)
+	case r.Method == "PUT" && r.URL.Path == "/v1/sys/leases/renew":
+		f.renewals++
+		if f.renewErr {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"lease_duration": 30, "renewable": true}`)
+	case r.Method == "PUT" && r.URL.Path == "/v1/sys/leases/revoke":
+		var body struct {
+			LeaseID string `json:"lease_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.revoked = append(f.revoked, body.LeaseID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultTokenLease(t *testing.T) {
+	fake := &fakeVault{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	vault, err := newVaultToken(map[string]interface{}{
+		"address": server.URL, "path": "consul/creds/app", "token": "vault-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, _ := vault.current(now)
+	assert.Equal(t, token, "token-1", "expected %v but got %v")
+
+	token, _ = vault.current(now.Add(10 * time.Second))
+	assert.Equal(t, fake.renewals, 0, "expected %v renewals but got %v")
+
+	// renewed at two thirds of the lease
+	token, _ = vault.current(now.Add(25 * time.Second))
+	assert.Equal(t, token, "token-1", "expected %v but got %v")
+	assert.Equal(t, fake.renewals, 1, "expected %v renewal but got %v")
+
+	// a new token is fetched if the lease can't be renewed
+	fake.renewErr = true
+	token, _ = vault.current(now.Add(50 * time.Second))
+	assert.Equal(t, token, "token-2", "expected %v but got %v")
+}
+
+func TestVaultTokenKV2(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"data": {"data": {"consul": "kv-token"}}}`)
+		}))
+	defer server.Close()
+	vault, _ := newVaultToken(map[string]interface{}{
+		"address": server.URL, "path": "secret/data/consul",
+		"field": "consul", "token": "vault-token"})
+	token, err := vault.current(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, token, "kv-token", "expected %v but got %v")
+}
+
+func TestConsulVaultTokenRotation(t *testing.T) {
+	fake := &fakeVault{}
+	vaultServer := httptest.NewServer(fake)
+	defer vaultServer.Close()
+
+	// only accepts the second token issued by Vault
+	var tokens []string
+	consulServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("X-Consul-Token")
+			tokens = append(tokens, token)
+			if token != "token-2" {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, "ACL not found")
+			}
+		}))
+	defer consulServer.Close()
+
+	consul, err := NewConsul(map[string]interface{}{
+		"address": strings.TrimPrefix(consulServer.URL, "http://"),
+		"vault": map[string]interface{}{
+			"address": vaultServer.URL,
+			"path":    "consul/creds/app",
+			"token":   "vault-token",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consul.PassTTL("app-1", "ok"); err != nil {
+		t.Fatalf("expected retry with rotated token to succeed: %v", err)
+	}
+	assert.Equal(t, strings.Join(tokens, ","), "token-1,token-2",
+		"expected Consul to see tokens %v but got %v")
+	assert.Equal(t, fake.revoked, []string{"consul/creds/app/1"},
+		"expected revoked leases %v but got %v")
+
+	assert.False(t, isForbidden(errors.New(
+		"Unexpected response code: 500 (dial tcp 10.0.0.1:4030)")),
+		"expected 500 error not to be a 403")
+}
+
+func TestConsulVaultConfigErrors(t *testing.T) {
+	_, err := NewConsul(map[string]interface{}{
+		"address": "consul:8500",
+		"token":   "static",
+		"vault": map[string]interface{}{
+			"address": "http://vault:8200", "path": "consul/creds/app",
+			"token": "vault-token"},
+	})
+	assert.Error(t, err, "consul.vault can't be used with a consul.token "+
+		"or CONSUL_HTTP_TOKEN")
+
+	_, err = NewConsul(map[string]interface{}{
+		"vault": map[string]interface{}{"address": "http://vault:8200"},
+	})
+	assert.Error(t, err, "consul.vault.path must not be empty")
+}
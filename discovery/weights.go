@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// WarmupDefinition registers a service with a reduced passing weight until
+// it has sent a number of consecutive passing health checks, so that
+// traffic to a new (or recovered) instance ramps up gradually.
+type WarmupDefinition struct {
+	Weight int
+	Checks int
+
+	passes   int
+	lastPass time.Time
+}
+
+// warm returns true once the service has passed enough health checks
+func (warmup *WarmupDefinition) warm() bool {
+	return warmup == nil || warmup.passes >= warmup.Checks
+}
+
+// pass counts a passing health check and returns true if that changed
+// whether the service is warm, so that its weights must be registered
+// again. A gap longer than the TTL since the last pass means the check
+// went critical in the meantime, so the warm-up starts over.
+func (warmup *WarmupDefinition) pass(ttl time.Duration, now time.Time) bool {
+	if warmup == nil {
+		return false
+	}
+	wasWarm := warmup.warm()
+	if !warmup.lastPass.IsZero() && now.Sub(warmup.lastPass) > ttl {
+		warmup.passes = 0
+	}
+	warmup.lastPass = now
+	warmup.passes++
+	return warmup.warm() != wasWarm
+}
+
+// reset starts the warm-up over and returns true if the service was warm
+func (warmup *WarmupDefinition) reset() bool {
+	if warmup == nil {
+		return false
+	}
+	wasWarm := warmup.warm()
+	warmup.passes = 0
+	warmup.lastPass = time.Time{}
+	return wasWarm
+}
+
+// weights returns the weights to register the service with, given its
+// configured weights and warm-up state
+func (service *ServiceDefinition) weights() *api.AgentWeights {
+	if service.Weights == nil || service.Warmup.warm() {
+		return service.Weights
+	}
+	weights := &api.AgentWeights{
+		Passing: service.Warmup.Weight,
+		Warning: service.Weights.Warning,
+	}
+	if weights.Warning > weights.Passing {
+		weights.Warning = weights.Passing
+	}
+	return weights
+}
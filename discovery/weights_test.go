@@ -0,0 +1,70 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+// weightsBackend records the weights of each service registration
+type weightsBackend struct {
+	Backend
+	registered []api.AgentWeights
+}
+
+func (b *weightsBackend) ServiceRegister(service *api.AgentServiceRegistration) error {
+	b.registered = append(b.registered, *service.Weights)
+	return nil
+}
+
+func (b *weightsBackend) PassTTL(checkID, note string) error { return nil }
+func (b *weightsBackend) WarnTTL(checkID, note string) error { return nil }
+func (b *weightsBackend) FailTTL(checkID, note string) error { return nil }
+
+func TestServiceWarmup(t *testing.T) {
+	backend := &weightsBackend{}
+	service := &ServiceDefinition{
+		ID:      "app-1",
+		Name:    "app",
+		TTL:     5,
+		Weights: &api.AgentWeights{Passing: 10, Warning: 2},
+		Warmup:  &WarmupDefinition{Weight: 1, Checks: 3},
+		Consul:  backend,
+	}
+	service.SendHeartbeat()
+	service.SendHeartbeat()
+	assert.Equal(t, len(backend.registered), 1, "expected %v registration but got %v")
+	assert.Equal(t, backend.registered[0], api.AgentWeights{Passing: 1, Warning: 1},
+		"expected warm-up weights %v but got %v")
+
+	service.SendHeartbeat()
+	assert.Equal(t, len(backend.registered), 2, "expected %v registrations but got %v")
+	assert.Equal(t, backend.registered[1], api.AgentWeights{Passing: 10, Warning: 2},
+		"expected full weights %v but got %v")
+
+	service.SendHeartbeat() // already warm
+	assert.Equal(t, len(backend.registered), 2, "expected %v registrations but got %v")
+
+	service.SendWarning("slow")
+	assert.Equal(t, len(backend.registered), 3, "expected %v registrations but got %v")
+	assert.Equal(t, backend.registered[2], api.AgentWeights{Passing: 1, Warning: 1},
+		"expected warm-up weights %v but got %v")
+}
+
+func TestWarmupResetsAfterTTL(t *testing.T) {
+	warmup := &WarmupDefinition{Weight: 1, Checks: 2}
+	now := time.Now()
+	assert.False(t, warmup.pass(5*time.Second, now), "expected warm=%v but got %v")
+	// the check would have gone critical since the last pass
+	now = now.Add(10 * time.Second)
+	assert.False(t, warmup.pass(5*time.Second, now), "expected warm=%v but got %v")
+	now = now.Add(time.Second)
+	assert.True(t, warmup.pass(5*time.Second, now), "expected warm=%v but got %v")
+
+	// a warm service that misses its TTL goes back to the warm-up weight
+	now = now.Add(10 * time.Second)
+	assert.True(t, warmup.pass(5*time.Second, now), "expected changed=%v but got %v")
+	assert.False(t, warmup.warm(), "expected warm=%v but got %v")
+}
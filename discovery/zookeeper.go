@@ -0,0 +1,290 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/utils"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// DefaultZooKeeperRoot is the znode under which services are registered
+var DefaultZooKeeperRoot = "/containerpilot"
+
+// zkConn is the subset of *zk.Conn that we use
+type zkConn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Delete(path string, version int32) error
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error)
+}
+
+// ZooKeeper is a service discovery backend that registers each service
+// instance as an ephemeral znode at <root>/<service name>/<service ID>, so
+// that instances are removed when our ZooKeeper session ends. Watches use
+// ZooKeeper watchers so that the znodes are only read when they change.
+type ZooKeeper struct {
+	conn     zkConn
+	root     string
+	services *serviceRecords
+
+	lock            sync.Mutex
+	watches         map[string]*zkWatch
+	watchedServices map[string][]*api.ServiceEntry
+}
+
+// zkWatch caches the instances of a watched service. Each znode we've read
+// has a ZooKeeper watcher pending until it changes, and we only reread the
+// znodes whose watchers have fired.
+type zkWatch struct {
+	stale    bool
+	children []string
+	records  map[string]*serviceRecord
+	pending  map[string]bool
+}
+
+// NewZooKeeper creates a new service discovery backend for ZooKeeper. The
+// config is either a comma-separated list of servers or a map.
+func NewZooKeeper(config interface{}) (*ZooKeeper, error) {
+	cfg := &struct {
+		Servers        []string `mapstructure:"servers"`
+		Root           string   `mapstructure:"root"`
+		SessionTimeout string   `mapstructure:"sessionTimeout"`
+	}{Root: DefaultZooKeeperRoot, SessionTimeout: "10s"}
+	switch t := config.(type) {
+	case string:
+		cfg.Servers = strings.Split(t, ",")
+	case map[string]interface{}:
+		if err := utils.DecodeRaw(t, cfg); err != nil {
+			return nil, fmt.Errorf("zookeeper config parsing error: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("zookeeper config must be a list of servers or an object")
+	}
+	if len(cfg.Servers) == 0 || cfg.Servers[0] == "" {
+		return nil, fmt.Errorf("zookeeper.servers must not be empty")
+	}
+	if !strings.HasPrefix(cfg.Root, "/") {
+		return nil, fmt.Errorf("zookeeper.root must be an absolute path: %s", cfg.Root)
+	}
+	timeout, err := utils.GetTimeout(cfg.SessionTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse zookeeper.sessionTimeout '%s': %v",
+			cfg.SessionTimeout, err)
+	}
+	// Connect returns immediately and connects in the background
+	conn, _, err := zk.Connect(cfg.Servers, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newZooKeeper(conn, cfg.Root), nil
+}
+
+func newZooKeeper(conn zkConn, root string) *ZooKeeper {
+	return &ZooKeeper{
+		conn:            conn,
+		root:            path.Clean(root),
+		services:        newServiceRecords(),
+		watches:         make(map[string]*zkWatch),
+		watchedServices: make(map[string][]*api.ServiceEntry),
+	}
+}
+
+// ServiceRegister creates the ephemeral znode for the service. The service
+// is critical until its first TTL update.
+func (z *ZooKeeper) ServiceRegister(service *api.AgentServiceRegistration) error {
+	record := z.services.register(service)
+	return z.put(&record)
+}
+
+// ServiceDeregister removes the service's znode
+func (z *ZooKeeper) ServiceDeregister(serviceID string) error {
+	record, err := z.services.deregister(serviceID)
+	if err != nil {
+		return err
+	}
+	err = z.conn.Delete(z.nodePath(record.Name, record.ID), -1)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+// CheckRegister records the TTL of the check for a registered service.
+// The ephemeral znode is removed if we lose our session, but the TTL is
+// also written into the znode and enforced by the watchers so that a hung
+// ContainerPilot doesn't stay in rotation.
+func (z *ZooKeeper) CheckRegister(check *api.AgentCheckRegistration) error {
+	return z.services.registerCheck(check)
+}
+
+// PassTTL marks the service passing until its TTL expires
+func (z *ZooKeeper) PassTTL(checkID, note string) error {
+	return z.updateTTL(checkID, statusPassing)
+}
+
+// WarnTTL marks the service warning until its TTL expires
+func (z *ZooKeeper) WarnTTL(checkID, note string) error {
+	return z.updateTTL(checkID, statusWarning)
+}
+
+// FailTTL marks the service critical
+func (z *ZooKeeper) FailTTL(checkID, note string) error {
+	return z.updateTTL(checkID, statusCritical)
+}
+
+func (z *ZooKeeper) updateTTL(checkID, status string) error {
+	record, err := z.services.update(checkID, status)
+	if err != nil {
+		return err
+	}
+	return z.put(&record)
+}
+
+func (z *ZooKeeper) servicePath(name string) string {
+	return path.Join(z.root, name)
+}
+
+func (z *ZooKeeper) nodePath(name, id string) string {
+	return path.Join(z.root, name, id)
+}
+
+// put writes the record to its znode, creating it if it doesn't exist
+// (ex. because our session expired)
+func (z *ZooKeeper) put(record *serviceRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	node := z.nodePath(record.Name, record.ID)
+	_, err = z.conn.Set(node, data, -1)
+	if err != zk.ErrNoNode {
+		return err
+	}
+	if err := z.createParents(z.servicePath(record.Name)); err != nil {
+		return err
+	}
+	_, err = z.conn.Create(node, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+	if err == zk.ErrNodeExists {
+		_, err = z.conn.Set(node, data, -1)
+	}
+	return err
+}
+
+// createParents creates the persistent znodes down to dir
+func (z *ZooKeeper) createParents(dir string) error {
+	node := ""
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		node += "/" + part
+		_, err := z.conn.Create(node, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckForUpstreamChanges reads any changed instances of a service from
+// ZooKeeper and checks whether there has been a change since the last
+// check.
+func (z *ZooKeeper) CheckForUpstreamChanges(backendName, backendTag string) (didChange, isHealthy bool) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	records, err := z.refresh(backendName)
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	now := time.Now()
+	instances := []*api.ServiceEntry{}
+	for _, record := range records {
+		if record.isPassing(backendTag, now) {
+			instances = append(instances, record.entry(""))
+		}
+	}
+	isHealthy = len(instances) > 0
+	existing := z.watchedServices[backendName]
+	z.watchedServices[backendName] = instances
+	didChange = compareForChange(existing, instances)
+	return didChange, isHealthy
+}
+
+// refresh rereads the znodes of the service whose watchers have fired
+// and returns all the service's records. The caller must hold the lock.
+func (z *ZooKeeper) refresh(name string) ([]*serviceRecord, error) {
+	w, ok := z.watches[name]
+	if !ok {
+		w = &zkWatch{
+			stale:   true,
+			records: make(map[string]*serviceRecord),
+			pending: make(map[string]bool),
+		}
+		z.watches[name] = w
+	}
+	if w.stale {
+		dir := z.servicePath(name)
+		if !w.pending[dir] {
+			children, _, ch, err := z.conn.ChildrenW(dir)
+			switch {
+			case err == zk.ErrNoNode:
+				// no instances have ever registered, so there's nothing
+				// to watch yet and we'll check again on the next poll
+				return nil, nil
+			case err != nil:
+				return nil, err
+			}
+			w.children = children
+			w.pending[dir] = true
+			go z.wait(name, dir, ch)
+		}
+		current := make(map[string]*serviceRecord)
+		for _, child := range w.children {
+			node := path.Join(dir, child)
+			if w.pending[node] {
+				current[child] = w.records[child]
+				continue
+			}
+			data, _, ch, err := z.conn.GetW(node)
+			if err == zk.ErrNoNode {
+				continue // removed since we listed the children
+			}
+			if err != nil {
+				return nil, err
+			}
+			w.pending[node] = true
+			go z.wait(name, node, ch)
+			record := &serviceRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				log.Debugf("ignoring znode %s with invalid data: %v", node, err)
+			}
+			current[child] = record
+		}
+		w.records = current
+		w.stale = false
+	}
+	records := make([]*serviceRecord, 0, len(w.records))
+	for _, record := range w.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// wait marks the watched service stale when the watcher for the znode at
+// node fires. ZooKeeper watchers only fire once, so the znode will be
+// reread and watched again on the next check.
+func (z *ZooKeeper) wait(name, node string, ch <-chan zk.Event) {
+	<-ch
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if w, ok := z.watches[name]; ok {
+		delete(w.pending, node)
+		w.stale = true
+	}
+}
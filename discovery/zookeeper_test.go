@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests/assert"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// fakeZK is an in-memory ZooKeeper tree with one-shot watchers
+type fakeZK struct {
+	lock     sync.Mutex
+	nodes    map[string][]byte
+	watchers map[string][]chan zk.Event
+	reads    int
+}
+
+func newFakeZK() *fakeZK {
+	return &fakeZK{
+		nodes:    map[string][]byte{"/": nil},
+		watchers: make(map[string][]chan zk.Event),
+	}
+}
+
+func (f *fakeZK) fire(node string) {
+	for _, ch := range f.watchers[node] {
+		ch <- zk.Event{Path: node}
+	}
+	delete(f.watchers, node)
+}
+
+func (f *fakeZK) watch(node string) <-chan zk.Event {
+	ch := make(chan zk.Event, 1)
+	f.watchers[node] = append(f.watchers[node], ch)
+	return ch
+}
+
+func (f *fakeZK) Create(node string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.nodes[node]; ok {
+		return "", zk.ErrNodeExists
+	}
+	if _, ok := f.nodes[path.Dir(node)]; !ok {
+		return "", zk.ErrNoNode
+	}
+	f.nodes[node] = data
+	f.fire(path.Dir(node))
+	return node, nil
+}
+
+func (f *fakeZK) Set(node string, data []byte, version int32) (*zk.Stat, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.nodes[node]; !ok {
+		return nil, zk.ErrNoNode
+	}
+	f.nodes[node] = data
+	f.fire(node)
+	return &zk.Stat{}, nil
+}
+
+func (f *fakeZK) Delete(node string, version int32) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.nodes[node]; !ok {
+		return zk.ErrNoNode
+	}
+	delete(f.nodes, node)
+	f.fire(node)
+	f.fire(path.Dir(node))
+	return nil
+}
+
+func (f *fakeZK) ChildrenW(node string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.nodes[node]; !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	children := []string{}
+	for n := range f.nodes {
+		if n != "/" && path.Dir(n) == node {
+			children = append(children, path.Base(n))
+		}
+	}
+	sort.Strings(children)
+	return children, &zk.Stat{}, f.watch(node), nil
+}
+
+func (f *fakeZK) GetW(node string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	data, ok := f.nodes[node]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	f.reads++
+	return data, &zk.Stat{}, f.watch(node), nil
+}
+
+func (f *fakeZK) getReads() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.reads
+}
+
+// watcher goroutines mark the watch stale asynchronously
+func waitForStale(z *ZooKeeper, name string) {
+	for i := 0; i < 100; i++ {
+		z.lock.Lock()
+		stale := z.watches[name].stale
+		z.lock.Unlock()
+		if stale {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestZooKeeperConfig(t *testing.T) {
+	_, err := NewZooKeeper(map[string]interface{}{})
+	assert.Error(t, err, "zookeeper.servers must not be empty")
+	_, err = NewZooKeeper(map[string]interface{}{
+		"servers": []string{"127.0.0.1:2181"}, "root": "services"})
+	assert.Error(t, err, "zookeeper.root must be an absolute path: services")
+}
+
+func TestZooKeeperRegistrationAndWatch(t *testing.T) {
+	fake := newFakeZK()
+	app := newZooKeeper(fake, "/containerpilot")
+	web := newZooKeeper(fake, "/containerpilot")
+
+	didChange, isHealthy := web.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change before registration")
+	assert.False(t, isHealthy, "expected unhealthy before registration")
+
+	service := &ServiceDefinition{ID: "app-1", Name: "app", Port: 8080,
+		TTL: 10, IPAddress: "10.0.0.1", Consul: app}
+	service.SendHeartbeat()
+	_, ok := fake.nodes["/containerpilot/app/app-1"]
+	assert.True(t, ok, "expected znode to be created")
+
+	didChange, isHealthy = web.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change after registration")
+	assert.True(t, isHealthy, "expected healthy after registration")
+
+	// no znodes are reread until a watcher fires
+	reads := fake.getReads()
+	didChange, _ = web.CheckForUpstreamChanges("app", "")
+	assert.False(t, didChange, "expected no change without updates")
+	assert.Equal(t, fake.getReads(), reads, "expected %v reads but got %v")
+
+	service.SendFailure("failed")
+	waitForStale(web, "app")
+	didChange, isHealthy = web.CheckForUpstreamChanges("app", "")
+	assert.True(t, didChange, "expected change after failure")
+	assert.False(t, isHealthy, "expected unhealthy after failure")
+	assert.Equal(t, fake.getReads(), reads+1, "expected %v reads but got %v")
+
+	service.SendHeartbeat()
+	waitForStale(web, "app")
+	_, isHealthy = web.CheckForUpstreamChanges("app", "")
+	assert.True(t, isHealthy, "expected healthy after recovery")
+
+	service.Deregister()
+	waitForStale(web, "app")
+	_, isHealthy = web.CheckForUpstreamChanges("app", "")
+	assert.False(t, isHealthy, "expected unhealthy after deregistration")
+}
+
+func TestZooKeeperRecreatesEphemeralNode(t *testing.T) {
+	fake := newFakeZK()
+	app := newZooKeeper(fake, "/containerpilot")
+	service := &ServiceDefinition{ID: "app-1", Name: "app", Port: 8080,
+		TTL: 10, Consul: app}
+	service.SendHeartbeat()
+
+	// simulate our session expiring
+	fake.Delete("/containerpilot/app/app-1", -1)
+	service.SendHeartbeat()
+	data := string(fake.nodes["/containerpilot/app/app-1"])
+	assert.True(t, strings.Contains(data, `"status":"passing"`),
+		"expected znode to be recreated")
+}
@@ -12,6 +12,7 @@ type EventBus struct {
 	registry map[Subscriber]bool
 	lock     *sync.RWMutex
 	reload   bool
+	exitCode int
 	done     sync.WaitGroup
 
 	// circular buffer of events
@@ -100,6 +101,7 @@ func (bus *EventBus) Publish(event Event) {
 	bus.lock.Lock()
 	defer bus.lock.Unlock()
 	log.Debugf("event: %v", event)
+	eventsPublished.WithLabelValues(event.Code.String()).Inc()
 	for subscriber := range bus.registry {
 		// sending to an unsubscribed Subscriber shouldn't be a runtime
 		// error, so this is in intentionally allowed to panic here
@@ -116,6 +118,29 @@ func (bus *EventBus) SetReloadFlag() {
 	bus.reload = true
 }
 
+// Reloading returns true if the reload flag has been set, i.e. a reload
+// has been requested but the EventBus hasn't yet been replaced
+func (bus *EventBus) Reloading() bool {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+	return bus.reload
+}
+
+// SetExitCode sets the code that ContainerPilot exits with once the
+// EventBus has shut down
+func (bus *EventBus) SetExitCode(code int) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	bus.exitCode = code
+}
+
+// ExitCode returns the code set by SetExitCode, or 0 if it wasn't set
+func (bus *EventBus) ExitCode() int {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+	return bus.exitCode
+}
+
 // Shutdown asks all Subscribers to halt by sending the GlobalShutdown
 // message. Subscribers are responsible for handling this message.
 func (bus *EventBus) Shutdown() {
@@ -31,10 +31,24 @@ const (
 	Error
 	Quit
 	Metric
-	Startup  // fired once after events are set up and event loop is started
-	Shutdown // fired once after all jobs exit or on receiving SIGTERM
+	Startup        // fired once after events are set up and event loop is started
+	Shutdown       // fired once after all jobs exit or on receiving SIGTERM
+	Start          // sent by the control plane to start a Runner's process
+	Stop           // sent by the control plane to stop a Runner's process
+	Restart        // sent by the control plane to restart a Runner's process
+	PauseCheck     // sent by the control plane to pause a Job's health check
+	ResumeCheck    // sent by the control plane to resume a Job's health check
+	OverrideHealth // sent by the control plane when a Job's health override changes
+	Failed         // emitted when a Job runs out of restart retries
+	Started        // emitted when a Job's process is started
+	Triggered      // published for a custom event via the control plane
+	Flapping       // emitted when a Job's health is held because its check flaps
 )
 
+// CustomPrefix is the prefix of the source of custom events, so that they
+// can't collide with the names of jobs and watches
+const CustomPrefix = "custom."
+
 // global events
 var (
 	GlobalStartup          = Event{Code: Startup, Source: "global"}
@@ -76,6 +90,34 @@ func FromString(codeName string) (EventCode, error) {
 		return Startup, nil
 	case "shutdown":
 		return Shutdown, nil
+	case "failed":
+		return Failed, nil
+	case "started":
+		return Started, nil
+	case "triggered":
+		return Triggered, nil
+	case "flapping":
+		return Flapping, nil
 	}
 	return None, fmt.Errorf("%s is not a valid event code", codeName)
 }
+
+// eventCodeNames are the names accepted by FromString
+var eventCodeNames = []string{
+	"exitSuccess", "exitFailed", "stopping", "stopped", "healthy",
+	"unhealthy", "changed", "timerExpired", "enterMaintenance",
+	"exitMaintenance", "error", "quit", "startup", "shutdown", "failed",
+	"started", "triggered", "flapping",
+}
+
+// Name returns the name of the EventCode as it's written in a
+// configuration, ex. "exitSuccess" rather than "ExitSuccess", or the
+// EventCode's String if it has no such name
+func (code EventCode) Name() string {
+	for _, name := range eventCodeNames {
+		if parsed, _ := FromString(name); parsed == code {
+			return name
+		}
+	}
+	return code.String()
+}
@@ -1,9 +1,11 @@
 package events
 
 import (
+	"context"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestSafeUnsubscribe(t *testing.T) {
@@ -37,6 +39,26 @@ func TestSafeUnsubscribe(t *testing.T) {
 	}
 }
 
+func TestJitteredEventTimer(t *testing.T) {
+	rx := make(chan Event, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	tick := 20 * time.Millisecond
+	start := time.Now()
+	NewJitteredEventTimer(ctx, rx, tick, 0.5, "timer")
+	for i := 0; i < 5; i++ {
+		event := <-rx
+		if event != (Event{Code: TimerExpired, Source: "timer"}) {
+			t.Fatalf("expected timer event but got %v", event)
+		}
+	}
+	cancel()
+	// each interval is between half and all of the tick
+	if elapsed := time.Since(start); elapsed < 5*tick/2 {
+		t.Fatalf("expected timer to fire at most every %v but took %v",
+			tick/2, elapsed)
+	}
+}
+
 /*
 Dummy TestSubscriber as test helpers; need this because we
 don't want a circular reference with the mocks package
@@ -31,11 +31,16 @@ func (evh *EventHandler) Unsubscribe(bus *EventBus, isInternal ...bool) {
 
 // Receive accepts an Event for the EventHandler's receive channel.
 // Embedding struct should use a non-blocking buffered channel but
-// this may be blocking in tests.
+// this may be blocking in tests. A full channel blocks the whole
+// EventBus, so we count those deliveries.
 func (evh *EventHandler) Receive(e Event) {
-	// TODO v3: instrument receives so we can report event throughput
-	// statistics via Prometheus
-	evh.Rx <- e
+	subscriberQueueDepth.Observe(float64(len(evh.Rx)))
+	select {
+	case evh.Rx <- e:
+	default:
+		eventsBlocked.Inc()
+		evh.Rx <- e
+	}
 }
 
 // Quit sends a Quit message to the EventHandler and then synchronously
@@ -0,0 +1,33 @@
+package events
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// metrics about the event loop itself, so that a backed-up event loop is
+// visible on the telemetry endpoint
+var (
+	eventsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "containerpilot",
+		Subsystem: "events",
+		Name:      "published_total",
+		Help:      "Number of events published to the event bus, by event code.",
+	}, []string{"code"})
+
+	subscriberQueueDepth = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "containerpilot",
+		Subsystem: "events",
+		Name:      "queue_depth",
+		Help:      "Number of events already waiting in a subscriber's queue when an event is delivered.",
+		Buckets:   []float64{0, 1, 5, 10, 50, 100, 500, 1000},
+	})
+
+	eventsBlocked = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "containerpilot",
+		Subsystem: "events",
+		Name:      "blocked_total",
+		Help:      "Number of events whose delivery blocked the event bus because a subscriber's queue was full.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(eventsPublished, subscriberQueueDepth, eventsBlocked)
+}
@@ -0,0 +1,38 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEventMetrics(t *testing.T) {
+	published := testutil.ToFloat64(eventsPublished.WithLabelValues("StatusHealthy"))
+	blocked := testutil.ToFloat64(eventsBlocked)
+
+	bus := NewEventBus()
+	evh := &EventHandler{Rx: make(chan Event, 1)}
+	evh.Subscribe(bus)
+	bus.Publish(Event{Code: StatusHealthy, Source: "app"})
+	if got := testutil.ToFloat64(eventsPublished.WithLabelValues("StatusHealthy")); got != published+1 {
+		t.Fatalf("expected %v published events but got %v", published+1, got)
+	}
+	if got := testutil.ToFloat64(eventsBlocked); got != blocked {
+		t.Fatalf("expected no blocked events but got %v", got-blocked)
+	}
+
+	// the subscriber's queue is full, so this publish blocks until we
+	// drain the queue
+	done := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Code: StatusHealthy, Source: "app"})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	<-evh.Rx
+	<-done
+	if got := testutil.ToFloat64(eventsBlocked); got != blocked+1 {
+		t.Fatalf("expected %v blocked events but got %v", blocked+1, got)
+	}
+}
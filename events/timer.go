@@ -2,6 +2,7 @@ package events
 
 import (
 	"context"
+	"math/rand"
 	"time"
 )
 
@@ -58,3 +59,40 @@ func NewEventTimer(
 		}
 	}()
 }
+
+// NewJitteredEventTimer is like NewEventTimer, but each interval is
+// shortened by a random amount up to the jitter fraction of the tick, so
+// that timers started at the same time in many processes don't stay in
+// step with each other
+func NewJitteredEventTimer(
+	ctx context.Context,
+	rx chan Event,
+	tick time.Duration,
+	jitter float64,
+	name string,
+) {
+	if jitter <= 0 {
+		NewEventTimer(ctx, rx, tick, name)
+		return
+	}
+	go func() {
+		// sending the timeout event potentially races with a closing
+		// rx channel, so just recover from the panic and exit
+		defer func() {
+			if r := recover(); r != nil {
+				return
+			}
+		}()
+		for {
+			delay := tick - time.Duration(float64(tick)*jitter*rand.Float64())
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				rx <- Event{Code: TimerExpired, Source: name}
+			}
+		}
+	}()
+}
@@ -0,0 +1,132 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// RestartBackoffConfig configures the delay before a job that exits is
+// restarted, so that a crashing job doesn't restart at a fixed rate
+type RestartBackoffConfig struct {
+	Initial    string  `mapstructure:"initial"`
+	Multiplier float64 `mapstructure:"multiplier"`
+	Max        string  `mapstructure:"max"`
+	Jitter     float64 `mapstructure:"jitter"`
+	MaxRetries int     `mapstructure:"maxRetries"`
+}
+
+// restartBackoff computes the delay before each restart of a job
+type restartBackoff struct {
+	initial    time.Duration
+	multiplier float64
+	max        time.Duration
+	jitter     float64
+	maxRetries int // 0 for unlimited
+
+	random func() float64
+}
+
+func (cfg *Config) validateRestartBackoff() error {
+	if cfg.RestartBackoff == nil {
+		return nil
+	}
+	if cfg.restartLimit == 0 {
+		return fmt.Errorf("job[%s].restartBackoff requires 'restarts'", cfg.Name)
+	}
+	if cfg.freqInterval > 0 || cfg.schedule != nil {
+		return fmt.Errorf("job[%s].restartBackoff can't be used with "+
+			"'when.interval' or 'when.schedule'", cfg.Name)
+	}
+	rb := cfg.RestartBackoff
+	if rb.Initial == "" {
+		rb.Initial = "1s"
+	}
+	if rb.Max == "" {
+		rb.Max = "1m"
+	}
+	if rb.Multiplier == 0 {
+		rb.Multiplier = 2
+	}
+	initial, err := utils.GetTimeout(rb.Initial)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].restartBackoff.initial: %v",
+			cfg.Name, err)
+	}
+	if initial <= 0 {
+		return fmt.Errorf("job[%s].restartBackoff.initial must be positive", cfg.Name)
+	}
+	max, err := utils.GetTimeout(rb.Max)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].restartBackoff.max: %v",
+			cfg.Name, err)
+	}
+	if max < initial {
+		return fmt.Errorf("job[%s].restartBackoff.max '%s' must not be less than initial '%s'",
+			cfg.Name, rb.Max, rb.Initial)
+	}
+	if rb.Multiplier < 1 {
+		return fmt.Errorf("job[%s].restartBackoff.multiplier must be at least 1",
+			cfg.Name)
+	}
+	if rb.Jitter == 0 {
+		rb.Jitter = cfg.jitter
+	}
+	if rb.Jitter < 0 || rb.Jitter > 1 {
+		return fmt.Errorf("job[%s].restartBackoff.jitter must be between 0 and 1",
+			cfg.Name)
+	}
+	if rb.MaxRetries < 0 {
+		return fmt.Errorf("job[%s].restartBackoff.maxRetries must not be negative",
+			cfg.Name)
+	}
+	cfg.restartBackoff = &restartBackoff{
+		initial:    initial,
+		multiplier: rb.Multiplier,
+		max:        max,
+		jitter:     rb.Jitter,
+		maxRetries: rb.MaxRetries,
+		random:     rand.Float64,
+	}
+	return nil
+}
+
+// delay returns the time to wait before the nth consecutive restart,
+// counting from 0. The jitter shortens the delay by a random fraction
+// so that jobs that crash together don't restart together.
+func (rb *restartBackoff) delay(attempt int) time.Duration {
+	delay := float64(rb.initial) * math.Pow(rb.multiplier, float64(attempt))
+	if delay > float64(rb.max) {
+		delay = float64(rb.max)
+	}
+	delay -= delay * rb.jitter * rb.random()
+	return time.Duration(delay)
+}
+
+// scheduleRestart restarts the job after the backoff delay, or marks the
+// job as failed if it has used up its retries. A run that lasts longer
+// than the maximum delay counts as a recovery and resets the backoff.
+func (job *Job) scheduleRestart(ctx context.Context) {
+	rb := job.restartBackoff
+	if !job.runStarted.IsZero() && time.Since(job.runStarted) >= rb.max {
+		job.restartAttempts = 0
+	}
+	if rb.maxRetries > 0 && job.restartAttempts >= rb.maxRetries {
+		log.Errorf("job[%s]: failed after %d restarts", job.Name, job.restartAttempts)
+		job.setState(stateFailed)
+		job.Bus.Publish(events.Event{Code: events.Failed, Source: job.Name})
+		return
+	}
+	delay := rb.delay(job.restartAttempts)
+	job.restartAttempts++
+	job.restartPending = true
+	log.Debugf("job[%s]: restarting in %v", job.Name, delay)
+	events.NewEventTimeout(ctx, job.Rx, delay,
+		fmt.Sprintf("%s.restart-backoff", job.Name))
+}
@@ -0,0 +1,184 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/commands"
+)
+
+// NamedCheckConfig is one of the checks that are combined into the health
+// of a job with 'checks'
+type NamedCheckConfig struct {
+	Name   string           `mapstructure:"name"`
+	Weight int              `mapstructure:"weight"` // 1 if unset
+	Exec   interface{}      `mapstructure:"exec"`
+	HTTP   *HTTPCheckConfig `mapstructure:"http"`
+	TCP    *TCPCheckConfig  `mapstructure:"tcp"`
+	GRPC   *GRPCCheckConfig `mapstructure:"grpc"`
+}
+
+// compositeCheck runs each of its checks at the same time, and passes if
+// the total weight of the checks that passed is at least the weight it
+// requires
+type compositeCheck struct {
+	checks  []*namedCheck
+	require int
+}
+
+type namedCheck struct {
+	name   string
+	weight int
+	check  checkFunc
+}
+
+func (cfg *Config) validateCompositeCheck(timeout time.Duration) error {
+	composite := &compositeCheck{}
+	seen := map[string]bool{}
+	total := 0
+	for _, raw := range cfg.Health.Checks {
+		if raw == nil || raw.Name == "" {
+			return fmt.Errorf("job[%s].health.checks must each have a 'name'",
+				cfg.Name)
+		}
+		field := fmt.Sprintf("health.checks[%s]", raw.Name)
+		if seen[raw.Name] {
+			return fmt.Errorf("job[%s].health.checks has '%s' more than once",
+				cfg.Name, raw.Name)
+		}
+		seen[raw.Name] = true
+		if raw.Weight < 0 {
+			return fmt.Errorf("job[%s].%s.weight can't be negative",
+				cfg.Name, field)
+		}
+		named := &namedCheck{name: raw.Name, weight: raw.Weight}
+		if named.weight == 0 {
+			named.weight = 1
+		}
+		total += named.weight
+
+		kinds := 0
+		for _, set := range []bool{raw.Exec != nil, raw.HTTP != nil,
+			raw.TCP != nil, raw.GRPC != nil} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return fmt.Errorf(
+				"job[%s].%s must have one of 'exec', 'http', 'tcp', or 'grpc'",
+				cfg.Name, field)
+		}
+		check, err := cfg.newCheck(field, raw.HTTP, raw.TCP, raw.GRPC)
+		if err != nil {
+			return err
+		}
+		if raw.Exec != nil {
+			checkName := "check." + cfg.Name + "." + raw.Name
+			cmd, err := commands.NewCommand(raw.Exec, 0,
+				log.Fields{"check": checkName})
+			if err != nil {
+				return fmt.Errorf("unable to create job[%s].%s.exec: %v",
+					cfg.Name, field, err)
+			}
+			cmd.Name = checkName
+			cmd.Logs = commands.NewLogBuffer(checkOutputSize)
+			cfg.compositeExecs = append(cfg.compositeExecs, cmd)
+			check = func(ctx context.Context) error { return runCheckExec(ctx, cmd) }
+		}
+		named.check = check
+		composite.checks = append(composite.checks, named)
+	}
+	require, err := parseRequire(cfg.Health.Require, total)
+	if err != nil {
+		return fmt.Errorf("job[%s].health.require must be 'all', 'any', or a weight between 1 and %d",
+			cfg.Name, total)
+	}
+	composite.require = require
+	cfg.healthCheckProbe = cfg.newProbe(timeout, composite.check)
+	return nil
+}
+
+// parseRequire returns the total weight of checks that have to pass for
+// 'all', 'any', or a weight. All of the checks are required by default.
+func parseRequire(raw interface{}, total int) (int, error) {
+	switch require := raw.(type) {
+	case nil:
+		return total, nil
+	case string:
+		switch require {
+		case "all":
+			return total, nil
+		case "any":
+			return 1, nil
+		}
+		if weight, err := strconv.Atoi(require); err == nil {
+			return parseRequire(weight, total)
+		}
+	case int:
+		return parseRequire(float64(require), total)
+	case int64:
+		return parseRequire(float64(require), total)
+	case float64:
+		if require >= 1 && require <= float64(total) &&
+			require == math.Trunc(require) {
+			return int(require), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid require '%v'", raw)
+}
+
+// check runs all of the checks and returns an error that explains each
+// failure if not enough of them passed
+func (composite *compositeCheck) check(ctx context.Context) error {
+	errs := make([]error, len(composite.checks))
+	var wg sync.WaitGroup
+	for i, named := range composite.checks {
+		wg.Add(1)
+		go func(i int, named *namedCheck) {
+			defer wg.Done()
+			errs[i] = named.check(ctx)
+		}(i, named)
+	}
+	wg.Wait()
+
+	passed := 0
+	var failures []string
+	for i, named := range composite.checks {
+		if errs[i] != nil {
+			failures = append(failures,
+				fmt.Sprintf("%s: %v", named.name, errs[i]))
+			continue
+		}
+		passed += named.weight
+	}
+	if passed < composite.require {
+		return fmt.Errorf("passed weight %d of %d required (%s)",
+			passed, composite.require, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// runCheckExec runs the exec of one of a composite health check's checks
+// and waits for it to exit. It's run like the job's health check exec is,
+// so the process is killed along with its process group if the check
+// times out, and its last output is included in the error if it fails.
+func runCheckExec(ctx context.Context, cmd *commands.Command) error {
+	cmd.Logs.Reset()
+	err := cmd.RunAndWait(ctx)
+	if err == nil {
+		return nil
+	}
+	// the failure is already reported under the name of the check
+	msg := strings.TrimPrefix(err.Error(), cmd.Name+": ")
+	if lines := cmd.Logs.Lines(0); len(lines) > 0 {
+		return fmt.Errorf("%s: %s", msg, strings.Join(lines, " "))
+	}
+	return fmt.Errorf("%s", msg)
+}
@@ -2,11 +2,15 @@ package jobs
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
 	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
@@ -15,50 +19,116 @@ import (
 
 const taskMinDuration = time.Millisecond
 
+// Consul restricts service meta keys to this format, and reserves the
+// "consul-" prefix for its own use
+var validMetaKey = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,128}$`)
+
 // Config holds the configuration for service discovery data
 type Config struct {
-	Name string      `mapstructure:"name"`
-	Exec interface{} `mapstructure:"exec"`
+	Name    string      `mapstructure:"name"`
+	Exec    interface{} `mapstructure:"exec"`
+	Enabled interface{} `mapstructure:"enabled"` // usually a template expression
 
 	// service discovery
-	Port              int           `mapstructure:"port"`
-	Interfaces        interface{}   `mapstructure:"interfaces"`
-	Tags              []string      `mapstructure:"tags"`
-	ConsulExtras      *ConsulExtras `mapstructure:"consul"`
+	Port              int              `mapstructure:"port"`
+	Interfaces        interface{}      `mapstructure:"interfaces"`
+	Tags              []string         `mapstructure:"tags"`
+	ConsulExtras      *ConsulExtras    `mapstructure:"consul"`
+	Services          []*ServiceConfig `mapstructure:"services"`
 	serviceDefinition *discovery.ServiceDefinition
+	extraServices     []*discovery.ServiceDefinition
 
 	// health checking
 	Health            *HealthConfig `mapstructure:"health"`
 	healthCheckExec   *commands.Command
+	healthCheckProbe  *probe
 	heartbeatInterval time.Duration
 	ttl               int
+	thresholds        checkThresholds
+	compositeExecs    []*commands.Command
+	flapping          *flapDetector
 
 	// timeouts and restarts
 	ExecTimeout     string      `mapstructure:"timeout"`
 	Restarts        interface{} `mapstructure:"restarts"`
 	StopTimeout     string      `mapstructure:"stopTimeout"`
+	StopSignal      string      `mapstructure:"stopSignal"`
+	StopGracePeriod string      `mapstructure:"stopGracePeriod"`
+	KillGroup       *bool       `mapstructure:"killProcessGroup"`
+	Jitter          float64     `mapstructure:"jitter"`
+	Main            bool        `mapstructure:"main"` // exit with the job's exit code
 	execTimeout     time.Duration
 	exec            *commands.Command
 	stoppingTimeout time.Duration
 	restartLimit    int
 	freqInterval    time.Duration
+	jitter          float64
+
+	// outcomes of the exit codes of the job's process
+	ExitCodes     map[string]string `mapstructure:"exitCodes"`
+	terminalCodes map[int]bool
+
+	// user, environment, and directory of the job's processes
+	User    string            `mapstructure:"user"`
+	Group   string            `mapstructure:"group"`
+	Env     map[string]string `mapstructure:"env"`
+	EnvFile interface{}       `mapstructure:"envFile"`
+	Workdir string            `mapstructure:"workdir"`
+	Umask   string            `mapstructure:"umask"`
+	home    string            // of the user, set as HOME under the env
+
+	// commands run before the job's process is asked to stop and after
+	// it exits
+	PreStop  *HookConfig `mapstructure:"preStop"`
+	PostStop *HookConfig `mapstructure:"postStop"`
+
+	// capturing the output of the job's process
+	LogFile *LogFileConfig `mapstructure:"logFile"`
+	Output  *OutputConfig  `mapstructure:"output"`
+
+	// resource limits of the job's process
+	Rlimits map[string]interface{} `mapstructure:"rlimits"`
+	Cgroup  *CgroupConfig          `mapstructure:"cgroup"`
+
+	// delaying restarts of a crashing job
+	RestartBackoff *RestartBackoffConfig `mapstructure:"restartBackoff"`
+	restartBackoff *restartBackoff
 
 	// related jobs and frequency
 	When              *WhenConfig `mapstructure:"when"`
 	whenEvent         events.Event
+	whenConditions    []events.Event
 	whenTimeout       time.Duration
 	whenStartsLimit   int
 	stoppingWaitEvent events.Event
+	schedule          *schedule
+	overlap           string
+	lastRunFile       string
+
+	// pushing metrics from short-lived jobs
+	Pushgateway *PushgatewayConfig `mapstructure:"pushgateway"`
+	pushgateway *pushgateway
+
+	// the job's raw configuration, compared on reload
+	definition interface{}
 }
 
 // WhenConfig determines when a Job runs (dependencies on other Jobs,
 // Watches, or frequency timers)
 type WhenConfig struct {
-	Frequency string `mapstructure:"interval"`
-	Source    string `mapstructure:"source"`
-	Once      string `mapstructure:"once"`
-	Each      string `mapstructure:"each"`
-	Timeout   string `mapstructure:"timeout"`
+	Frequency string        `mapstructure:"interval"`
+	Source    string        `mapstructure:"source"`
+	Once      string        `mapstructure:"once"`
+	Each      string        `mapstructure:"each"`
+	Timeout   string        `mapstructure:"timeout"`
+	All       []*WhenConfig `mapstructure:"all"` // start once all have happened
+	Schedule  string        `mapstructure:"schedule"`
+	Timezone  string        `mapstructure:"timezone"`
+
+	// runs of 'interval' and 'schedule' jobs
+	Overlap     string `mapstructure:"overlap"`
+	CatchUp     bool   `mapstructure:"catchUp"`
+	LastRunFile string `mapstructure:"lastRunFile"`
 }
 
 // HealthConfig configures the Job's health checks
@@ -67,12 +137,99 @@ type HealthConfig struct {
 	CheckTimeout string      `mapstructure:"timeout"`
 	Heartbeat    int         `mapstructure:"interval"` // time in seconds
 	TTL          int         `mapstructure:"ttl"`      // time in seconds
+
+	// checks made in place of running the 'exec'
+	HTTP *HTTPCheckConfig `mapstructure:"http"`
+	TCP  *TCPCheckConfig  `mapstructure:"tcp"`
+	GRPC *GRPCCheckConfig `mapstructure:"grpc"`
+
+	// checks combined into the job's health in place of a single check
+	Checks  []*NamedCheckConfig `mapstructure:"checks"`
+	Require interface{}         `mapstructure:"require"` // 'all', 'any', or a weight
+
+	// consecutive results needed to change the job's health, 1 if unset,
+	// and the time after the job starts during which failures don't count
+	FailuresBeforeCritical int    `mapstructure:"failuresBeforeCritical"`
+	SuccessesBeforePassing int    `mapstructure:"successesBeforePassing"`
+	InitialDelay           string `mapstructure:"initialDelay"`
+
+	// holding the job's health steady while its check flaps
+	Flapping *FlappingConfig `mapstructure:"flapping"`
+}
+
+// ServiceConfig is an additional service registered for a job, such as
+// for an admin port. Its health tracks the job's health check.
+type ServiceConfig struct {
+	Name         string        `mapstructure:"name"`
+	Port         int           `mapstructure:"port"`
+	Tags         []string      `mapstructure:"tags"`
+	ConsulExtras *ConsulExtras `mapstructure:"consul"`
 }
 
 // ConsulExtras handles additional Consul configuration.
 type ConsulExtras struct {
-	EnableTagOverride              bool   `mapstructure:"enableTagOverride"`
-	DeregisterCriticalServiceAfter string `mapstructure:"deregisterCriticalServiceAfter"`
+	EnableTagOverride              bool           `mapstructure:"enableTagOverride"`
+	DeregisterCriticalServiceAfter string         `mapstructure:"deregisterCriticalServiceAfter"`
+	Connect                        *ConnectConfig `mapstructure:"connect"`
+
+	Meta            map[string]string              `mapstructure:"meta"`
+	TaggedAddresses map[string]TaggedAddressConfig `mapstructure:"taggedAddresses"`
+
+	Weights *WeightsConfig `mapstructure:"weights"`
+	Warmup  *WarmupConfig  `mapstructure:"warmup"`
+
+	Notes  string         `mapstructure:"notes"`
+	Checks []*CheckConfig `mapstructure:"checks"`
+}
+
+// CheckConfig is an HTTP or TCP check that the Consul agent runs for the
+// service, in addition to ContainerPilot's TTL check
+type CheckConfig struct {
+	Name          string `mapstructure:"name"`
+	HTTP          string `mapstructure:"http"`
+	Method        string `mapstructure:"method"`
+	TCP           string `mapstructure:"tcp"`
+	Interval      string `mapstructure:"interval"`
+	Timeout       string `mapstructure:"timeout"`
+	Notes         string `mapstructure:"notes"`
+	TLSSkipVerify bool   `mapstructure:"tlsSkipVerify"`
+}
+
+// WeightsConfig sets the weights of the service in DNS SRV responses and
+// prepared queries when it's passing or warning
+type WeightsConfig struct {
+	Passing int `mapstructure:"passing"`
+	Warning int `mapstructure:"warning"`
+}
+
+// WarmupConfig registers the service with a lower passing weight until it
+// has passed a number of consecutive health checks
+type WarmupConfig struct {
+	Weight int `mapstructure:"weight"`
+	Checks int `mapstructure:"checks"`
+}
+
+// TaggedAddressConfig is an additional address for the service, such as
+// its "wan" address
+type TaggedAddressConfig struct {
+	Address    string      `mapstructure:"address"`
+	Interfaces interface{} `mapstructure:"interfaces"`
+	Port       int         `mapstructure:"port"`
+}
+
+// ConnectConfig registers a Consul Connect sidecar proxy for the service
+type ConnectConfig struct {
+	SidecarPort int              `mapstructure:"sidecarPort"`
+	Upstreams   []UpstreamConfig `mapstructure:"upstreams"`
+	CertDir     string           `mapstructure:"certDir"`
+}
+
+// UpstreamConfig is a service that the Connect sidecar proxy exposes to
+// the job on a local port
+type UpstreamConfig struct {
+	Name       string `mapstructure:"name"`
+	LocalPort  int    `mapstructure:"localPort"`
+	Datacenter string `mapstructure:"datacenter"`
 }
 
 // NewConfigs parses json config into a validated slice of Configs
@@ -81,17 +238,47 @@ func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
 	if raw == nil {
 		return jobs, nil
 	}
-	if err := utils.DecodeRaw(raw, &jobs); err != nil {
+	raw, err := expandInstances(raw)
+	if err != nil {
+		return nil, err
+	}
+	var decoded []*Config
+	if err := utils.DecodeRaw(raw, &decoded); err != nil {
 		return nil, fmt.Errorf("job configuration error: %v", err)
 	}
+	for i, job := range decoded {
+		job.definition = raw[i]
+		enabled, err := job.isEnabled()
+		if err != nil {
+			return nil, err
+		}
+		if !enabled {
+			log.Debugf("job[%s] is not enabled", job.Name)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
 	stopDependencies := make(map[string]string)
+	var mainJob string
 	for _, job := range jobs {
 		if err := job.Validate(disc); err != nil {
 			return nil, err
 		}
+		if job.Main {
+			if mainJob != "" {
+				return nil, fmt.Errorf("job[%s] can't be 'main' because job[%s] already is",
+					job.Name, mainJob)
+			}
+			mainJob = job.Name
+		}
 		if job.whenEvent.Code == events.Stopping {
 			stopDependencies[job.whenEvent.Source] = job.Name
 		}
+		for _, condition := range job.whenConditions {
+			if condition.Code == events.Stopping {
+				stopDependencies[condition.Source] = job.Name
+			}
+		}
 	}
 	// set up any dependencies on "stopping" events
 	for _, job := range jobs {
@@ -116,15 +303,54 @@ func (cfg *Config) Validate(disc discovery.Backend) error {
 	if err := cfg.validateWhen(); err != nil {
 		return err
 	}
+	if err := cfg.validateOverlap(); err != nil {
+		return err
+	}
 	if err := cfg.validateStoppingTimeout(); err != nil {
 		return err
 	}
 	if err := cfg.validateRestarts(); err != nil {
 		return err
 	}
+	if err := cfg.validateJitter(); err != nil {
+		return err
+	}
+	if err := cfg.validateRestartBackoff(); err != nil {
+		return err
+	}
 	if err := cfg.validateExec(); err != nil {
 		return err
 	}
+	if err := cfg.validateMain(); err != nil {
+		return err
+	}
+	if err := cfg.validateExitCodes(); err != nil {
+		return err
+	}
+	if err := cfg.validatePushgateway(); err != nil {
+		return err
+	}
+	if err := cfg.validateUser(); err != nil {
+		return err
+	}
+	if err := cfg.validateEnv(); err != nil {
+		return err
+	}
+	if err := cfg.validateWorkdir(); err != nil {
+		return err
+	}
+	if err := cfg.validateHooks(); err != nil {
+		return err
+	}
+	if err := cfg.validateLogFile(); err != nil {
+		return err
+	}
+	if err := cfg.validateOutput(); err != nil {
+		return err
+	}
+	if err := cfg.validateLimits(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -132,12 +358,36 @@ func (cfg *Config) setStopping(name string) {
 	cfg.stoppingWaitEvent = events.Event{events.Stopped, name}
 }
 
+// StartEvents returns the events that start the job, all of which must
+// happen if there's more than one, and whether the job starts only once.
+// A job that's started by its schedule has no events.
+func (cfg *Config) StartEvents() ([]events.Event, bool) {
+	once := cfg.whenStartsLimit == 1
+	if len(cfg.whenConditions) > 0 {
+		return cfg.whenConditions, once
+	}
+	if cfg.whenEvent == events.NonEvent {
+		return nil, once
+	}
+	return []events.Event{cfg.whenEvent}, once
+}
+
+// StopsAfter returns the name of the job that must stop before this job
+// stops, because it starts when this job is stopping, or "" if none does
+func (cfg *Config) StopsAfter() string {
+	return cfg.stoppingWaitEvent.Source
+}
+
 func (cfg *Config) validateDiscovery(disc discovery.Backend) error {
 	// setting up discovery requires the TTL from the health check first
 	if err := cfg.validateHealthCheck(); err != nil {
 		return err
 	}
 	// if port isn't set then we won't do any discovery for this job
+	if cfg.Port == 0 && len(cfg.Services) > 0 {
+		return fmt.Errorf("job[%s].services requires the job to have a port",
+			cfg.Name)
+	}
 	if cfg.Port == 0 || disc == nil {
 		return nil
 	}
@@ -154,6 +404,15 @@ func (cfg *Config) validateWhen() error {
 		return nil
 	}
 
+	if cfg.When.Timezone != "" && cfg.When.Schedule == "" {
+		return fmt.Errorf("job[%s].when.timezone requires 'schedule'", cfg.Name)
+	}
+	if cfg.When.Schedule != "" {
+		return cfg.validateSchedule()
+	}
+	if len(cfg.When.All) > 0 {
+		return cfg.validateWhenAll()
+	}
 	if (cfg.When.Frequency != "" && cfg.When.Once != "") ||
 		(cfg.When.Frequency != "" && cfg.When.Each != "") ||
 		(cfg.When.Once != "" && cfg.When.Each != "") {
@@ -196,6 +455,11 @@ func (cfg *Config) validateWhenEvent() error {
 	if cfg.When.Once != "" {
 		eventCode, err = events.FromString(cfg.When.Once)
 		cfg.whenStartsLimit = 1
+	} else if cfg.When.Each == "" &&
+		strings.HasPrefix(cfg.When.Source, events.CustomPrefix) {
+		// custom events only ever have one code
+		eventCode = events.Triggered
+		cfg.whenStartsLimit = unlimited
 	} else {
 		eventCode, err = events.FromString(cfg.When.Each)
 		cfg.whenStartsLimit = unlimited
@@ -208,6 +472,40 @@ func (cfg *Config) validateWhenEvent() error {
 	return nil
 }
 
+// validateWhenAll parses the conditions that must all happen before the
+// job starts. The job starts only once, so each condition uses 'once'.
+func (cfg *Config) validateWhenAll() error {
+	when := cfg.When
+	if when.Frequency != "" || when.Source != "" || when.Once != "" || when.Each != "" {
+		return fmt.Errorf("job[%s].when.all can't be combined with "+
+			"'interval', 'source', 'once', or 'each'", cfg.Name)
+	}
+	whenTimeout, err := utils.GetTimeout(when.Timeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].when.timeout: %v",
+			cfg.Name, err)
+	}
+	cfg.whenTimeout = whenTimeout
+	for i, condition := range when.All {
+		if condition == nil || condition.Source == "" || condition.Once == "" ||
+			condition.Frequency != "" || condition.Each != "" ||
+			condition.Timeout != "" || len(condition.All) > 0 {
+			return fmt.Errorf("job[%s].when.all[%d] must have only 'source' and 'once'",
+				cfg.Name, i)
+		}
+		eventCode, err := events.FromString(condition.Once)
+		if err != nil {
+			return fmt.Errorf("unable to parse job[%s].when.all[%d].once: %v",
+				cfg.Name, i, err)
+		}
+		cfg.whenConditions = append(cfg.whenConditions,
+			events.Event{Code: eventCode, Source: condition.Source})
+	}
+	cfg.whenEvent = events.NonEvent
+	cfg.whenStartsLimit = 1
+	return nil
+}
+
 func (cfg *Config) validateStoppingTimeout() error {
 	stoppingTimeout, err := utils.GetTimeout(cfg.StopTimeout)
 	if err != nil {
@@ -249,8 +547,65 @@ func (cfg *Config) validateExec() error {
 			cfg.Name = cmd.Exec
 		}
 		cmd.Name = cfg.Name
+		cmd.Logs = commands.NewLogBuffer(commands.DefaultLogBufferSize)
 		cfg.exec = cmd
 	}
+	return cfg.validateStopSignal()
+}
+
+// validateStopSignal configures how the job's process is asked to stop
+// before it's killed
+func (cfg *Config) validateStopSignal() error {
+	if cfg.KillGroup != nil && cfg.exec != nil {
+		cfg.exec.KillProcessGroup = *cfg.KillGroup
+	}
+	if cfg.StopSignal == "" {
+		if cfg.StopGracePeriod != "" {
+			return fmt.Errorf("job[%s].stopGracePeriod requires 'stopSignal'", cfg.Name)
+		}
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].stopSignal requires 'exec'", cfg.Name)
+	}
+	sig, err := commands.ParseSignal(cfg.StopSignal)
+	if err != nil {
+		return fmt.Errorf("job[%s].stopSignal: %v", cfg.Name, err)
+	}
+	if cfg.StopGracePeriod == "" {
+		cfg.StopGracePeriod = "10s"
+	}
+	grace, err := utils.GetTimeout(cfg.StopGracePeriod)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].stopGracePeriod '%s': %v",
+			cfg.Name, cfg.StopGracePeriod, err)
+	}
+	if grace <= 0 {
+		return fmt.Errorf("job[%s].stopGracePeriod must be positive", cfg.Name)
+	}
+	cfg.exec.StopSignal = sig
+	cfg.exec.StopGracePeriod = grace
+	return nil
+}
+
+// validateUser sets the user and group that the job's exec and health
+// check run as
+func (cfg *Config) validateUser() error {
+	if cfg.User == "" && cfg.Group == "" {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].user and group require 'exec'", cfg.Name)
+	}
+	cred, home, err := commands.LookupCredential(cfg.User, cfg.Group)
+	if err != nil {
+		return fmt.Errorf("job[%s].user: %v", cfg.Name, err)
+	}
+	cfg.home = home
+	cfg.exec.Credential = cred
+	for _, cmd := range cfg.checkCommands() {
+		cmd.Credential = cred
+	}
 	return nil
 }
 
@@ -283,6 +638,38 @@ func (cfg *Config) validateHealthCheck() error {
 		checkTimeout = cfg.execTimeout
 	}
 
+	if err := cfg.validateThresholds(); err != nil {
+		return err
+	}
+	if err := cfg.validateFlapping(); err != nil {
+		return err
+	}
+
+	checks := 0
+	for _, set := range []bool{cfg.Health.CheckExec != nil,
+		cfg.Health.HTTP != nil, cfg.Health.TCP != nil, cfg.Health.GRPC != nil,
+		len(cfg.Health.Checks) > 0} {
+		if set {
+			checks++
+		}
+	}
+	if checks > 1 {
+		return fmt.Errorf(
+			"job[%s].health can have only one of 'exec', 'http', 'tcp', 'grpc', or 'checks'",
+			cfg.Name)
+	}
+	check, err := cfg.newCheck("health",
+		cfg.Health.HTTP, cfg.Health.TCP, cfg.Health.GRPC)
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		cfg.healthCheckProbe = cfg.newProbe(checkTimeout, check)
+		return nil
+	}
+	if len(cfg.Health.Checks) > 0 {
+		return cfg.validateCompositeCheck(checkTimeout)
+	}
 	if cfg.Health.CheckExec != nil {
 		// the telemetry service won't have a health check
 		checkName := "check." + cfg.Name
@@ -293,11 +680,82 @@ func (cfg *Config) validateHealthCheck() error {
 				cfg.Name, err)
 		}
 		cmd.Name = checkName
+		cmd.Logs = commands.NewLogBuffer(checkOutputSize)
 		cfg.healthCheckExec = cmd
 	}
 	return nil
 }
 
+// checkCommands returns the health check exec and the execs of the checks
+// of a composite health check, which run as the job's process does
+func (cfg *Config) checkCommands() []*commands.Command {
+	cmds := cfg.compositeExecs
+	if cfg.healthCheckExec != nil {
+		cmds = append([]*commands.Command{cfg.healthCheckExec}, cmds...)
+	}
+	return cmds
+}
+
+// hasHealthCheck returns true if the Config has a health check exec or a
+// health check made by ContainerPilot itself
+func (cfg *Config) hasHealthCheck() bool {
+	return cfg.healthCheckExec != nil || cfg.healthCheckProbe != nil
+}
+
+// isEnabled parses the 'enabled' field, which is usually a template
+// expression that renders to true or false. Jobs are enabled by default,
+// and an empty value (such as an unset environment variable) disables
+// the job.
+func (cfg *Config) isEnabled() (bool, error) {
+	switch enabled := cfg.Enabled.(type) {
+	case nil:
+		return true, nil
+	case bool:
+		return enabled, nil
+	case string:
+		enabled = strings.TrimSpace(enabled)
+		if enabled == "" {
+			return false, nil
+		}
+		if value, err := strconv.ParseBool(enabled); err == nil {
+			return value, nil
+		}
+	}
+	return false, fmt.Errorf("job[%s].enabled must be true or false but got '%v'",
+		cfg.Name, cfg.Enabled)
+}
+
+// validateMain ensures that the main job runs its process only once, as
+// ContainerPilot exits as soon as the process does
+func (cfg *Config) validateMain() error {
+	if !cfg.Main {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].main requires 'exec'", cfg.Name)
+	}
+	if cfg.restartLimit != 0 {
+		return fmt.Errorf("job[%s].main can't be used with 'restarts'", cfg.Name)
+	}
+	if cfg.freqInterval > 0 || cfg.schedule != nil || cfg.whenStartsLimit == unlimited {
+		return fmt.Errorf("job[%s].main can't be used with 'when.interval', "+
+			"'when.schedule', or 'when.each'", cfg.Name)
+	}
+	return nil
+}
+
+// validateJitter converts the 'jitter' percentage into the fraction by
+// which the Job's interval timers and restart delays are randomly
+// shortened
+func (cfg *Config) validateJitter() error {
+	if cfg.Jitter < 0 || cfg.Jitter > 100 {
+		return fmt.Errorf("job[%s].jitter must be a percentage between 0 and 100",
+			cfg.Name)
+	}
+	cfg.jitter = cfg.Jitter / 100
+	return nil
+}
+
 func (cfg *Config) validateRestarts() error {
 
 	// defaults if omitted
@@ -352,35 +810,274 @@ func (cfg *Config) addDiscoveryConfig(disc discovery.Backend) error {
 		return err
 	}
 	hostname, _ := os.Hostname()
-	id := fmt.Sprintf("%s-%s", cfg.Name, hostname)
 
+	primary := &ServiceConfig{
+		Name:         cfg.Name,
+		Port:         cfg.Port,
+		Tags:         cfg.Tags,
+		ConsulExtras: cfg.ConsulExtras,
+	}
+	cfg.serviceDefinition, err = primary.definition(ipAddress, hostname, cfg.ttl, disc)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{cfg.Name: true}
+	cfg.extraServices = nil
+	for _, svc := range cfg.Services {
+		if err := utils.ValidateServiceName(svc.Name); err != nil {
+			return fmt.Errorf("job[%s].services: %v", cfg.Name, err)
+		}
+		if names[svc.Name] {
+			return fmt.Errorf("job[%s].services: service '%s' is registered "+
+				"more than once", cfg.Name, svc.Name)
+		}
+		names[svc.Name] = true
+		if svc.Port <= 0 {
+			return fmt.Errorf("job[%s].services: service '%s' must have a port",
+				cfg.Name, svc.Name)
+		}
+		service, err := svc.definition(ipAddress, hostname, cfg.ttl, disc)
+		if err != nil {
+			return err
+		}
+		cfg.extraServices = append(cfg.extraServices, service)
+	}
+	return nil
+}
+
+// definition validates the service's config and converts it to the
+// discovery.ServiceDefinition
+func (svc *ServiceConfig) definition(ipAddress, hostname string, ttl int, disc discovery.Backend) (*discovery.ServiceDefinition, error) {
 	var (
 		enableTagOverride bool
 		deregAfter        string
+		connect           *discovery.ConnectDefinition
+		meta              map[string]string
+		taggedAddresses   map[string]api.ServiceAddress
+		weights           *api.AgentWeights
+		warmup            *discovery.WarmupDefinition
+		notes             string
+		checks            api.AgentServiceChecks
 	)
 
-	if cfg.ConsulExtras != nil {
-		deregAfter = cfg.ConsulExtras.DeregisterCriticalServiceAfter
-		_, err := time.ParseDuration(deregAfter)
-		if err != nil {
-			return fmt.Errorf(
-				"unable to parse job[%s].consul.deregisterCriticalServiceAfter: %s",
-				cfg.Name, err)
+	if extras := svc.ConsulExtras; extras != nil {
+		deregAfter = extras.DeregisterCriticalServiceAfter
+		if deregAfter != "" {
+			if _, err := time.ParseDuration(deregAfter); err != nil {
+				return nil, fmt.Errorf(
+					"unable to parse job[%s].consul.deregisterCriticalServiceAfter: %s",
+					svc.Name, err)
+			}
+		}
+		var err error
+		enableTagOverride = extras.EnableTagOverride
+		notes = extras.Notes
+		if connect, err = extras.Connect.definition(svc.Name, disc); err != nil {
+			return nil, err
+		}
+		if meta, err = extras.meta(svc.Name); err != nil {
+			return nil, err
+		}
+		if taggedAddresses, err = extras.taggedAddresses(svc.Name, svc.Port); err != nil {
+			return nil, err
+		}
+		if weights, warmup, err = extras.weights(svc.Name); err != nil {
+			return nil, err
+		}
+		for i, check := range extras.Checks {
+			agentCheck, err := check.definition(svc.Name, i, ipAddress, svc.Port)
+			if err != nil {
+				return nil, err
+			}
+			agentCheck.DeregisterCriticalServiceAfter = deregAfter
+			checks = append(checks, agentCheck)
 		}
-		enableTagOverride = cfg.ConsulExtras.EnableTagOverride
 	}
-	cfg.serviceDefinition = &discovery.ServiceDefinition{
-		ID:                             id,
-		Name:                           cfg.Name,
-		Port:                           cfg.Port,
-		TTL:                            cfg.ttl,
-		Tags:                           cfg.Tags,
+	return &discovery.ServiceDefinition{
+		ID:                             fmt.Sprintf("%s-%s", svc.Name, hostname),
+		Name:                           svc.Name,
+		Port:                           svc.Port,
+		TTL:                            ttl,
+		Tags:                           svc.Tags,
 		IPAddress:                      ipAddress,
 		DeregisterCriticalServiceAfter: deregAfter,
 		EnableTagOverride:              enableTagOverride,
+		Connect:                        connect,
+		Meta:                           meta,
+		TaggedAddresses:                taggedAddresses,
+		Weights:                        weights,
+		Warmup:                         warmup,
+		Notes:                          notes,
+		Checks:                         checks,
 		Consul:                         disc,
+	}, nil
+}
+
+// definition validates the Connect config and converts it to the
+// discovery.ConnectDefinition, or returns nil if Connect isn't configured
+func (connect *ConnectConfig) definition(name string, disc discovery.Backend) (*discovery.ConnectDefinition, error) {
+	if connect == nil {
+		return nil, nil
 	}
-	return nil
+	if connect.SidecarPort < 0 {
+		return nil, fmt.Errorf("job[%s].consul.connect.sidecarPort must be positive",
+			name)
+	}
+	if connect.CertDir != "" {
+		var ca discovery.ConnectBackend
+		if !discovery.Find(disc, &ca) {
+			return nil, fmt.Errorf("job[%s].consul.connect.certDir requires "+
+				"the Consul discovery backend", name)
+		}
+	}
+	def := &discovery.ConnectDefinition{
+		SidecarPort: connect.SidecarPort,
+		CertDir:     connect.CertDir,
+	}
+	for _, upstream := range connect.Upstreams {
+		if upstream.Name == "" || upstream.LocalPort <= 0 {
+			return nil, fmt.Errorf("job[%s].consul.connect.upstreams must "+
+				"each have a name and a localPort", name)
+		}
+		def.Upstreams = append(def.Upstreams, api.Upstream{
+			DestinationName: upstream.Name,
+			LocalBindPort:   upstream.LocalPort,
+			Datacenter:      upstream.Datacenter,
+		})
+	}
+	return def, nil
+}
+
+// meta validates the service meta keys against Consul's restrictions
+func (extras *ConsulExtras) meta(name string) (map[string]string, error) {
+	if len(extras.Meta) > 64 {
+		return nil, fmt.Errorf("job[%s].consul.meta may have at most 64 keys", name)
+	}
+	for key, value := range extras.Meta {
+		if !validMetaKey.MatchString(key) || strings.HasPrefix(key, "consul-") {
+			return nil, fmt.Errorf("job[%s].consul.meta key '%s' must be "+
+				"alphanumeric with dashes or underscores and must not start "+
+				"with 'consul-'", name, key)
+		}
+		if len(value) > 512 {
+			return nil, fmt.Errorf("job[%s].consul.meta value for '%s' "+
+				"must be at most 512 characters", name, key)
+		}
+	}
+	return extras.Meta, nil
+}
+
+// taggedAddresses converts the tagged addresses, which use the service's
+// port if they don't set one
+func (extras *ConsulExtras) taggedAddresses(name string, port int) (map[string]api.ServiceAddress, error) {
+	if len(extras.TaggedAddresses) == 0 {
+		return nil, nil
+	}
+	addresses := make(map[string]api.ServiceAddress)
+	for tag, addr := range extras.TaggedAddresses {
+		if (addr.Address == "") == (addr.Interfaces == nil) {
+			return nil, fmt.Errorf("job[%s].consul.taggedAddresses.%s must "+
+				"have one of 'address' or 'interfaces'", name, tag)
+		}
+		if addr.Interfaces != nil {
+			ip, err := utils.IPFromInterfaces(addr.Interfaces)
+			if err != nil {
+				return nil, fmt.Errorf("job[%s].consul.taggedAddresses.%s: %v",
+					name, tag, err)
+			}
+			addr.Address = ip
+		}
+		if addr.Port < 0 || addr.Port > 65535 {
+			return nil, fmt.Errorf("job[%s].consul.taggedAddresses.%s has "+
+				"invalid port %d", name, tag, addr.Port)
+		}
+		if addr.Port == 0 {
+			addr.Port = port
+		}
+		addresses[tag] = api.ServiceAddress{Address: addr.Address, Port: addr.Port}
+	}
+	return addresses, nil
+}
+
+// weights validates the service weights and warm-up. Consul's default
+// weights are 1, so a warm-up requires a higher passing weight.
+func (extras *ConsulExtras) weights(name string) (*api.AgentWeights, *discovery.WarmupDefinition, error) {
+	if extras.Weights == nil && extras.Warmup == nil {
+		return nil, nil, nil
+	}
+	weights := &api.AgentWeights{Passing: 1, Warning: 1}
+	if extras.Weights != nil {
+		if extras.Weights.Passing < 0 || extras.Weights.Warning < 0 {
+			return nil, nil, fmt.Errorf("job[%s].consul.weights must be positive", name)
+		}
+		if extras.Weights.Passing > 0 {
+			weights.Passing = extras.Weights.Passing
+		}
+		if extras.Weights.Warning > 0 {
+			weights.Warning = extras.Weights.Warning
+		}
+	}
+	if extras.Warmup == nil {
+		return weights, nil, nil
+	}
+	if extras.Warmup.Checks < 1 {
+		return nil, nil, fmt.Errorf("job[%s].consul.warmup.checks must be "+
+			"at least 1", name)
+	}
+	if extras.Warmup.Weight < 1 || extras.Warmup.Weight >= weights.Passing {
+		return nil, nil, fmt.Errorf("job[%s].consul.warmup.weight must be "+
+			"at least 1 and less than the passing weight (%d)",
+			name, weights.Passing)
+	}
+	return weights, &discovery.WarmupDefinition{
+		Weight: extras.Warmup.Weight,
+		Checks: extras.Warmup.Checks,
+	}, nil
+}
+
+// definition validates the check and converts it to the Consul check
+// definition. An HTTP path or a TCP ":port" is completed with the service's
+// IP address (and port, for HTTP), because the check runs on the agent.
+func (check *CheckConfig) definition(name string, i int, ipAddress string, port int) (*api.AgentServiceCheck, error) {
+	if (check.HTTP == "") == (check.TCP == "") {
+		return nil, fmt.Errorf("job[%s].consul.checks[%d] must have one of "+
+			"'http' or 'tcp'", name, i)
+	}
+	if check.Interval == "" {
+		return nil, fmt.Errorf("job[%s].consul.checks[%d].interval must be set",
+			name, i)
+	}
+	for field, value := range map[string]string{
+		"interval": check.Interval, "timeout": check.Timeout} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("unable to parse job[%s].consul.checks[%d].%s: %v",
+				name, i, field, err)
+		}
+	}
+	agentCheck := &api.AgentServiceCheck{
+		Name:          check.Name,
+		HTTP:          check.HTTP,
+		Method:        check.Method,
+		TCP:           check.TCP,
+		Interval:      check.Interval,
+		Timeout:       check.Timeout,
+		Notes:         check.Notes,
+		TLSSkipVerify: check.TLSSkipVerify,
+	}
+	if strings.HasPrefix(check.HTTP, "/") {
+		agentCheck.HTTP = fmt.Sprintf("http://%s%s",
+			net.JoinHostPort(ipAddress, strconv.Itoa(port)), check.HTTP)
+	}
+	if strings.HasPrefix(check.TCP, ":") {
+		agentCheck.TCP = net.JoinHostPort(ipAddress, check.TCP[1:])
+	}
+	if agentCheck.Name == "" {
+		agentCheck.Name = fmt.Sprintf("%s check %d", name, i+1)
+	}
+	return agentCheck, nil
 }
 
 // String implements the stdlib fmt.Stringer interface for pretty-printing
@@ -3,9 +3,14 @@ package jobs
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/events"
 	"github.com/joyent/containerpilot/tests"
 	"github.com/joyent/containerpilot/tests/assert"
@@ -155,6 +160,240 @@ func TestJobConfigConsulExtras(t *testing.T) {
 
 }
 
+func TestJobConfigConsulConnect(t *testing.T) {
+	cfg := `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			connect: {sidecarPort: 21000, upstreams: [
+				{name: "db", localPort: 5432, datacenter: "dc2"}]}}}]`
+	jobs, err := NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	connect := jobs[0].serviceDefinition.Connect
+	assert.Equal(t, connect.SidecarPort, 21000, "expected %v but got %v")
+	assert.Equal(t, connect.Upstreams[0].DestinationName, "db", "expected %v but got %v")
+	assert.Equal(t, connect.Upstreams[0].LocalBindPort, 5432, "expected %v but got %v")
+	assert.Equal(t, connect.Upstreams[0].Datacenter, "dc2", "expected %v but got %v")
+
+	cfg = `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			connect: {upstreams: [{name: "db"}]}}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[serviceA].consul.connect.upstreams must "+
+		"each have a name and a localPort")
+
+	cfg = `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			connect: {certDir: "/etc/tls"}}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[serviceA].consul.connect.certDir requires "+
+		"the Consul discovery backend")
+}
+
+func TestJobConfigConsulMeta(t *testing.T) {
+	cfg := `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			meta: {version: "1.2.3", "fabio-urlprefix": "/app"},
+			taggedAddresses: {
+				wan: {address: "203.0.113.10"},
+				lan_ipv6: {address: "::1", port: 9090}}}}]`
+	jobs, err := NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	service := jobs[0].serviceDefinition
+	assert.Equal(t, service.Meta["version"], "1.2.3", "expected %v but got %v")
+	assert.Equal(t, service.Meta["fabio-urlprefix"], "/app", "expected %v but got %v")
+	assert.Equal(t, service.TaggedAddresses["wan"].Address, "203.0.113.10",
+		"expected %v but got %v")
+	assert.Equal(t, service.TaggedAddresses["wan"].Port, 8080,
+		"expected wan port to default to %v but got %v")
+	assert.Equal(t, service.TaggedAddresses["lan_ipv6"].Port, 9090,
+		"expected %v but got %v")
+
+	cfg = `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			meta: {"consul-version": "1"}}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[serviceA].consul.meta key 'consul-version' "+
+		"must be alphanumeric with dashes or underscores and must not "+
+		"start with 'consul-'")
+
+	cfg = `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			taggedAddresses: {wan: {port: 80}}}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[serviceA].consul.taggedAddresses.wan must "+
+		"have one of 'address' or 'interfaces'")
+
+	cfg = `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {taggedAddresses: {
+			lan_ipv4: {interfaces: ["static:192.168.1.100"]},
+			lan_ipv6: {interfaces: "static:fd00::10"}}}}]`
+	jobs, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	service = jobs[0].serviceDefinition
+	assert.Equal(t, service.TaggedAddresses["lan_ipv4"].Address, "192.168.1.100",
+		"expected %v but got %v")
+	assert.Equal(t, service.TaggedAddresses["lan_ipv6"].Address, "fd00::10",
+		"expected %v but got %v")
+}
+
+func TestJobConfigConsulWeights(t *testing.T) {
+	cfg := `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			weights: {passing: 10}, warmup: {weight: 2, checks: 5}}}]`
+	jobs, err := NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	service := jobs[0].serviceDefinition
+	assert.Equal(t, service.Weights.Passing, 10, "expected %v but got %v")
+	assert.Equal(t, service.Weights.Warning, 1, "expected default %v but got %v")
+	assert.Equal(t, service.Warmup.Weight, 2, "expected %v but got %v")
+	assert.Equal(t, service.Warmup.Checks, 5, "expected %v but got %v")
+
+	cfg = `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			warmup: {weight: 1, checks: 5}}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[serviceA].consul.warmup.weight must be at "+
+		"least 1 and less than the passing weight (1)")
+
+	cfg = `[{name: "serviceA", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {deregisterCriticalServiceAfter: "10m",
+			weights: {passing: 10}, warmup: {weight: 1}}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[serviceA].consul.warmup.checks must be at least 1")
+}
+
+func TestJobConfigServices(t *testing.T) {
+	cfg := `[{name: "app", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		services: [{name: "app-admin", port: 9090, tags: ["admin"],
+			consul: {deregisterCriticalServiceAfter: "10m",
+				meta: {role: "admin"}}}]}]`
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := NewJob(cfgs[0])
+	services := job.services()
+	assert.Equal(t, len(services), 2, "expected %v services but got %v")
+	admin := services[1]
+	hostname, _ := os.Hostname()
+	assert.Equal(t, admin.ID, "app-admin-"+hostname, "expected ID %v but got %v")
+	assert.Equal(t, admin.Port, 9090, "expected port %v but got %v")
+	assert.Equal(t, admin.TTL, 5, "expected TTL %v but got %v")
+	assert.Equal(t, admin.Tags[0], "admin", "expected tag %v but got %v")
+	assert.Equal(t, admin.Meta["role"], "admin", "expected meta %v but got %v")
+	assert.Equal(t, admin.IPAddress, services[0].IPAddress,
+		"expected IP %v but got %v")
+
+	cfg = `[{name: "app", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		services: [{name: "app", port: 9090}]}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[app].services: service 'app' is registered more than once")
+
+	cfg = `[{name: "app", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		services: [{name: "app-admin"}]}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[app].services: service 'app-admin' must have a port")
+
+	cfg = `[{name: "app", health: {interval: 1, ttl: 5},
+		services: [{name: "app-admin", port: 9090}]}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[app].services requires the job to have a port")
+}
+
+func TestJobConfigConsulChecks(t *testing.T) {
+	cfg := `[{name: "app", port: 8080, interfaces: "static:192.168.1.100",
+		health: {interval: 1, ttl: 5},
+		consul: {notes: "heartbeat from the app's health check",
+			deregisterCriticalServiceAfter: "10m",
+			checks: [
+				{http: "/health", interval: "10s", timeout: "1s"},
+				{name: "admin", tcp: ":9090", interval: "30s"},
+				{tcp: "db.example.com:5432", interval: "30s"}]}}]`
+	jobs, err := NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	service := jobs[0].serviceDefinition
+	assert.Equal(t, service.Notes, "heartbeat from the app's health check",
+		"expected notes %q but got %q")
+	checks := service.Checks
+	assert.Equal(t, len(checks), 3, "expected %v checks but got %v")
+	assert.Equal(t, checks[0].HTTP, "http://192.168.1.100:8080/health",
+		"expected %v but got %v")
+	assert.Equal(t, checks[0].Name, "app check 1", "expected %v but got %v")
+	assert.Equal(t, checks[0].DeregisterCriticalServiceAfter, "10m",
+		"expected %v but got %v")
+	assert.Equal(t, checks[1].TCP, "192.168.1.100:9090", "expected %v but got %v")
+	assert.Equal(t, checks[1].Name, "admin", "expected %v but got %v")
+	assert.Equal(t, checks[2].TCP, "db.example.com:5432", "expected %v but got %v")
+
+	// deregisterCriticalServiceAfter is optional, but each check needs
+	// exactly one of http or tcp
+	cfg = `[{name: "app", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {checks: [{http: "/health", tcp: ":8080", interval: "10s"}]}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	assert.Error(t, err, "job[app].consul.checks[0] must have one of 'http' or 'tcp'")
+
+	cfg = `[{name: "app", port: 8080, interfaces: "inet",
+		health: {interval: 1, ttl: 5},
+		consul: {checks: [{http: "/health", interval: "10s", timeout: "x"}]}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), noop)
+	if err == nil || !strings.HasPrefix(err.Error(),
+		"unable to parse job[app].consul.checks[0].timeout") {
+		t.Fatalf("expected timeout parsing error but got %v", err)
+	}
+}
+
+func TestJobConfigPushgateway(t *testing.T) {
+	cfg := `[{name: "backup", exec: "/bin/backup.sh", when: {interval: "1h"},
+		pushgateway: {url: "http://pushgateway:9091", instance: "db-1",
+			metrics: ["backup_bytes"]}}]`
+	jobs, err := NewConfigs(tests.DecodeRawToSlice(cfg), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pg := jobs[0].pushgateway
+	assert.Equal(t, pg.job, "backup", "expected job %v but got %v")
+	assert.Equal(t, pg.instance, "db-1", "expected instance %v but got %v")
+	assert.True(t, pg.metrics["backup_bytes"], "expected backup_bytes to be pushed")
+
+	hostname, _ := os.Hostname()
+	cfg = `[{name: "backup", exec: "/bin/backup.sh",
+		pushgateway: {url: "http://pushgateway:9091"}}]`
+	jobs, _ = NewConfigs(tests.DecodeRawToSlice(cfg), nil)
+	assert.Equal(t, jobs[0].pushgateway.instance, hostname,
+		"expected default instance %v but got %v")
+
+	cfg = `[{name: "backup", pushgateway: {url: "http://pushgateway:9091"}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), nil)
+	assert.Error(t, err, "job[backup].pushgateway requires 'exec'")
+
+	cfg = `[{name: "backup", exec: "/bin/backup.sh", pushgateway: {url: "pushgateway"}}]`
+	_, err = NewConfigs(tests.DecodeRawToSlice(cfg), nil)
+	assert.Error(t, err, "job[backup].pushgateway.url 'pushgateway' must be a URL")
+}
+
 func TestJobConfigSmokeTest(t *testing.T) {
 	data, _ := ioutil.ReadFile(fmt.Sprintf("./testdata/%s.json5", t.Name()))
 	testCfg := tests.DecodeRawToSlice(string(data))
@@ -314,6 +553,133 @@ func TestJobConfigValidateFrequency(t *testing.T) {
 		"expected execTimeout '%v' to equal interval '%v'")
 }
 
+func TestJobConfigValidateWhenAll(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(
+		`[{name: "app", exec: "/bin/app", when: {source: "db", once: "healthy",
+		  all: [{source: "cache", once: "healthy"}]}}]`,
+		"job[app].when.all can't be combined with 'interval', 'source', 'once', or 'each'")
+	expectErr(
+		`[{name: "app", exec: "/bin/app", when: {all: [{source: "db", each: "healthy"}]}}]`,
+		"job[app].when.all[0] must have only 'source' and 'once'")
+	expectErr(
+		`[{name: "app", exec: "/bin/app", when: {all: [{source: "db", once: "healthy"}, {once: "healthy"}]}}]`,
+		"job[app].when.all[1] must have only 'source' and 'once'")
+	expectErr(
+		`[{name: "app", exec: "/bin/app", when: {all: [{source: "db", once: "xx"}]}}]`,
+		"unable to parse job[app].when.all[0].once: xx is not a valid event code")
+
+	testCfg := tests.DecodeRawToSlice(`[
+	{name: "app", exec: "/bin/app", when: {timeout: "10s", all: [
+	  {source: "db", once: "healthy"},
+	  {source: "migrations", once: "exitSuccess"},
+	  {source: "proxy", once: "stopping"}]}},
+	{name: "proxy", exec: "/bin/proxy"}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := cfgs[0]
+	assert.Equal(t, cfg.whenConditions, []events.Event{
+		{events.StatusHealthy, "db"},
+		{events.ExitSuccess, "migrations"},
+		{events.Stopping, "proxy"},
+	}, "expected conditions %v but got %v")
+	assert.Equal(t, cfg.whenEvent, events.NonEvent, "expected whenEvent %v but got %v")
+	assert.Equal(t, cfg.whenStartsLimit, 1, "expected whenStartsLimit %v but got %v")
+	assert.Equal(t, cfg.whenTimeout, 10*time.Second, "expected whenTimeout %v but got %v")
+	assert.Equal(t, cfgs[1].stoppingWaitEvent, events.Event{events.Stopped, "app"},
+		"expected proxy to wait for %v but got %v")
+}
+
+func TestJobConfigValidateWhenCustom(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{name: "warm", exec: "/bin/warm", when: {source: "custom.cache-warm"}},
+	{name: "reindex", exec: "/bin/reindex", when: {source: "custom.reindex", once: "triggered"}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].whenEvent, events.Event{events.Triggered, "custom.cache-warm"},
+		"expected whenEvent %v but got %v")
+	assert.Equal(t, cfgs[0].whenStartsLimit, unlimited, "expected whenStartsLimit %v but got %v")
+	assert.Equal(t, cfgs[1].whenEvent, events.Event{events.Triggered, "custom.reindex"},
+		"expected whenEvent %v but got %v")
+	assert.Equal(t, cfgs[1].whenStartsLimit, 1, "expected whenStartsLimit %v but got %v")
+}
+
+func TestJobConfigValidateSchedule(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(
+		`[{name: "nightly", exec: "/bin/nightly", when: {schedule: "cron(30 2 * * *)", interval: "1h"}}]`,
+		"job[nightly].when.schedule can't be combined with 'interval', 'source', 'once', 'each', or 'all'")
+	expectErr(
+		`[{name: "nightly", exec: "/bin/nightly", when: {schedule: "30 2 * * *"}}]`,
+		"unable to parse job[nightly].when.schedule '30 2 * * *': must have the form 'cron(<expression>)'")
+	expectErr(
+		`[{name: "nightly", exec: "/bin/nightly", when: {schedule: "cron(30 2 * *)"}}]`,
+		"unable to parse job[nightly].when.schedule 'cron(30 2 * *)': Expected exactly 5 fields, found 4: 30 2 * *")
+	expectErr(
+		`[{name: "nightly", exec: "/bin/nightly", when: {schedule: "cron(30 2 * * *)", timezone: "Nowhere/Special"}}]`,
+		"unable to parse job[nightly].when.schedule 'cron(30 2 * * *)': unknown time zone Nowhere/Special")
+	expectErr(
+		`[{name: "nightly", exec: "/bin/nightly", when: {interval: "1h", timezone: "UTC"}}]`,
+		"job[nightly].when.timezone requires 'schedule'")
+
+	testCfg := tests.DecodeRawToSlice(`[{name: "nightly", exec: "/bin/nightly",
+		when: {schedule: "cron(30 2 * * *)", timezone: "UTC"}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := cfgs[0]
+	assert.Equal(t, cfg.whenEvent, events.NonEvent, "expected whenEvent %v but got %v")
+	assert.Equal(t, cfg.execTimeout, time.Duration(0), "expected timeout %v but got %v")
+	now := time.Date(2017, 3, 1, 3, 0, 0, 0, time.UTC)
+	assert.Equal(t, cfg.schedule.next(now).Equal(time.Date(2017, 3, 2, 2, 30, 0, 0, time.UTC)),
+		true, "expected next run tomorrow: %v but got %v")
+}
+
+func TestJobConfigValidateOverlap(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(
+		`[{name: "backup", exec: "/bin/backup", when: {interval: "1h", overlap: "wait"}}]`,
+		"job[backup].when.overlap must be 'skip', 'queue', or 'restart'")
+	expectErr(
+		`[{name: "backup", exec: "/bin/backup", when: {source: "db", once: "healthy", overlap: "queue"}}]`,
+		"job[backup].when.overlap requires 'interval' or 'schedule'")
+	expectErr(
+		`[{name: "backup", exec: "/bin/backup", when: {interval: "1h", catchUp: true, lastRunFile: "/data/last-run"}}]`,
+		"job[backup].when.catchUp requires 'schedule'")
+	expectErr(
+		`[{name: "backup", exec: "/bin/backup", when: {schedule: "cron(30 2 * * *)", catchUp: true}}]`,
+		"job[backup].when.catchUp and 'lastRunFile' must be set together")
+
+	testCfg := tests.DecodeRawToSlice(`[
+	{name: "backup", exec: "/bin/backup", when: {schedule: "cron(30 2 * * *)",
+	 overlap: "queue", catchUp: true, lastRunFile: "/data/last-run"}},
+	{name: "report", exec: "/bin/report", when: {interval: "1h"}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].overlap, "queue", "expected overlap %v but got %v")
+	assert.Equal(t, cfgs[0].lastRunFile, "/data/last-run", "expected lastRunFile %v but got %v")
+	assert.Equal(t, cfgs[1].overlap, "skip", "expected overlap %v but got %v")
+}
+
 func TestJobConfigValidateExec(t *testing.T) {
 
 	testCfg := tests.DecodeRawToSlice(`[
@@ -409,6 +775,515 @@ func TestJobConfigValidateRestarts(t *testing.T) {
 	assert.Equal(t, cfg[6].restartLimit, 0, expectMsg)
 }
 
+func TestJobConfigValidateRestartBackoff(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", exec: "/bin/app", restartBackoff: {}}]`,
+		"job[app].restartBackoff requires 'restarts'")
+	expectErr(`[{name: "app", exec: "/bin/app", restarts: "unlimited",
+		when: {interval: "1s"}, restartBackoff: {}}]`,
+		"job[app].restartBackoff can't be used with 'when.interval' or 'when.schedule'")
+	expectErr(`[{name: "app", exec: "/bin/app", restarts: "unlimited",
+		restartBackoff: {initial: "10s", max: "1s"}}]`,
+		"job[app].restartBackoff.max '1s' must not be less than initial '10s'")
+	expectErr(`[{name: "app", exec: "/bin/app", restarts: "unlimited",
+		restartBackoff: {multiplier: 0.5}}]`,
+		"job[app].restartBackoff.multiplier must be at least 1")
+	expectErr(`[{name: "app", exec: "/bin/app", restarts: "unlimited",
+		restartBackoff: {jitter: 2}}]`,
+		"job[app].restartBackoff.jitter must be between 0 and 1")
+	expectErr(`[{name: "app", exec: "/bin/app", restarts: "unlimited",
+		restartBackoff: {maxRetries: -1}}]`,
+		"job[app].restartBackoff.maxRetries must not be negative")
+
+	testCfg := tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		restarts: "unlimited", restartBackoff: {jitter: 0.2, maxRetries: 5}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rb := cfgs[0].restartBackoff
+	assert.Equal(t, rb.initial, time.Second, "expected initial %v but got %v")
+	assert.Equal(t, rb.max, time.Minute, "expected max %v but got %v")
+	assert.Equal(t, rb.multiplier, 2.0, "expected multiplier %v but got %v")
+	assert.Equal(t, rb.jitter, 0.2, "expected jitter %v but got %v")
+	assert.Equal(t, rb.maxRetries, 5, "expected maxRetries %v but got %v")
+}
+
+func TestJobConfigValidateJitter(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{name: "app", exec: "/bin/app", jitter: 10, restarts: "unlimited",
+	 restartBackoff: {}, health: {exec: "/bin/check", interval: 5, ttl: 10}},
+	{name: "cron", exec: "/bin/cron", jitter: 10, restarts: "unlimited",
+	 restartBackoff: {jitter: 0.5}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].jitter, 0.1, "expected jitter %v but got %v")
+	assert.Equal(t, cfgs[0].restartBackoff.jitter, 0.1,
+		"expected restartBackoff.jitter %v but got %v")
+	assert.Equal(t, cfgs[1].restartBackoff.jitter, 0.5,
+		"expected restartBackoff.jitter %v but got %v")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "app", exec: "/bin/app", jitter: 150}]`), nil)
+	assert.Error(t, err, "job[app].jitter must be a percentage between 0 and 100")
+}
+
+func TestJobConfigValidateStopSignal(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "db", exec: "/bin/db", stopGracePeriod: "60s"}]`,
+		"job[db].stopGracePeriod requires 'stopSignal'")
+	expectErr(`[{name: "db", exec: "/bin/db", stopSignal: "SIGBOGUS"}]`,
+		"job[db].stopSignal: unsupported signal 'SIGBOGUS'")
+	expectErr(`[{name: "db", exec: "/bin/db", stopSignal: "INT", stopGracePeriod: "-1s"}]`,
+		"job[db].stopGracePeriod must be positive")
+
+	testCfg := tests.DecodeRawToSlice(`[
+	{name: "db", exec: "/bin/db", stopSignal: "SIGINT", stopGracePeriod: "60s",
+	 killProcessGroup: false},
+	{name: "app", exec: "/bin/app", stopSignal: "SIGTERM"},
+	{name: "task", exec: "/bin/task"}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db, app, task := cfgs[0].exec, cfgs[1].exec, cfgs[2].exec
+	assert.Equal(t, db.StopSignal, syscall.SIGINT, "expected signal %v but got %v")
+	assert.Equal(t, db.StopGracePeriod, time.Minute, "expected grace period %v but got %v")
+	assert.False(t, db.KillProcessGroup, "expected killProcessGroup %v but got %v")
+	assert.Equal(t, app.StopGracePeriod, 10*time.Second, "expected grace period %v but got %v")
+	assert.True(t, app.KillProcessGroup, "expected killProcessGroup %v but got %v")
+	assert.Equal(t, task.StopSignal, syscall.Signal(0), "expected signal %v but got %v")
+}
+
+func TestJobConfigValidateUser(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", exec: "/bin/app", user: "nosuchuser"}]`,
+		"job[app].user: unknown user 'nosuchuser'")
+	expectErr(`[{name: "app", user: "root"}]`,
+		"job[app].user and group require 'exec'")
+
+	testCfg := tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		user: "root", group: "100", health: {exec: "/bin/check", interval: 1, ttl: 5}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cred := cfgs[0].exec.Credential
+	assert.Equal(t, [2]uint32{cred.Uid, cred.Gid}, [2]uint32{0, 100},
+		"expected exec credential %v but got %v")
+	assert.Equal(t, cfgs[0].healthCheckExec.Credential, cred,
+		"expected health check credential %v but got %v")
+	assert.Equal(t, cfgs[0].exec.Env, []string{"HOME=/root"},
+		"expected exec env %v but got %v")
+
+	// the job's env overrides the user's HOME
+	testCfg = tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		user: "root", env: {HOME: "/srv/app"}}]`)
+	cfgs, err = NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].exec.Env, []string{"HOME=/root", "HOME=/srv/app"},
+		"expected exec env %v but got %v")
+}
+
+func TestJobConfigValidateEnv(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "env")
+	defer os.RemoveAll(dir)
+	first := dir + "/first.env"
+	second := dir + "/second.env"
+	invalid := dir + "/invalid.env"
+	ioutil.WriteFile(first, []byte("# comment\nA=1\n\nB=\"quoted\"\n"), 0644)
+	ioutil.WriteFile(second, []byte("A=2\n"), 0644)
+	ioutil.WriteFile(invalid, []byte("A=1\nexport\n"), 0644)
+
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", env: {A: "1"}}]`,
+		"job[app].env and envFile require 'exec'")
+	expectErr(fmt.Sprintf(`[{name: "app", exec: "/bin/app", envFile: "%s"}]`, invalid),
+		fmt.Sprintf("job[app].envFile: %s:2: expected KEY=VALUE", invalid))
+	expectErr(`[{name: "app", exec: "/bin/app", envFile: "/nonexistent.env"}]`,
+		"job[app].envFile: open /nonexistent.env: no such file or directory")
+
+	testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{name: "app", exec: "/bin/app",
+		envFile: ["%s", "%s"], env: {C: 3, A: "4"},
+		health: {exec: "/bin/check", interval: 1, ttl: 5}}]`, first, second))
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"A=1", `B="quoted"`, "A=2", "A=4", "C=3"}
+	assert.Equal(t, cfgs[0].exec.Env, expected, "expected exec env %v but got %v")
+	assert.Equal(t, cfgs[0].healthCheckExec.Env, expected,
+		"expected health check env %v but got %v")
+}
+
+func TestJobConfigValidateWorkdir(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", workdir: "/srv/app"}]`,
+		"job[app].workdir and umask require 'exec'")
+	expectErr(`[{name: "app", exec: "/bin/app", umask: "089"}]`,
+		"job[app].umask '089' must be an octal mode such as '022'")
+	expectErr(`[{name: "app", exec: "/bin/app", umask: "1777"}]`,
+		"job[app].umask '1777' must be an octal mode such as '022'")
+
+	testCfg := tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		workdir: "/srv/app", umask: "027"}, {name: "task", exec: "/bin/task"}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].exec.Dir, "/srv/app", "expected workdir %v but got %v")
+	assert.Equal(t, *cfgs[0].exec.Umask, 027, "expected umask %o but got %o")
+	assert.True(t, cfgs[1].exec.Umask == nil, "expected no umask: %v but got %v")
+}
+
+func TestJobConfigValidateLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", rlimits: {nofile: 1024}}]`,
+		"job[app].rlimits and cgroup require 'exec'")
+	expectErr(`[{name: "app", exec: "/bin/app", rlimits: {stack: 1024}}]`,
+		"job[app].rlimits: unsupported limit 'stack'")
+	expectErr(`[{name: "app", exec: "/bin/app", cgroup: {}}]`,
+		"job[app].cgroup requires 'cpus' or 'memory'")
+	expectErr(`[{name: "app", exec: "/bin/app", cgroup: {memory: "lots"}}]`,
+		"job[app].cgroup.memory 'lots' must be a size such as '256m'")
+
+	testCfg := tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		rlimits: {nofile: "1024:4096", core: 0},
+		cgroup: {cpus: 0.5, memory: "256m"}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exec := cfgs[0].exec
+	assert.Equal(t, len(exec.Rlimits), 2, "expected %v rlimits but got %v")
+	assert.Equal(t, exec.Rlimits[0].Name, "core", "expected rlimit %v but got %v")
+	assert.Equal(t, exec.Rlimits[1].Soft, uint64(1024), "expected soft limit %v but got %v")
+	assert.Equal(t, exec.Rlimits[1].Hard, uint64(4096), "expected hard limit %v but got %v")
+	assert.Equal(t, exec.Cgroup.CPUMax, "50000 100000", "expected cpu.max %v but got %v")
+	assert.Equal(t, exec.Cgroup.MemoryMax, "268435456", "expected memory.max %v but got %v")
+}
+
+func TestJobGroups(t *testing.T) {
+	newGroups := func(jobsRaw, groupsRaw string) ([]*Config, error) {
+		cfgs, err := NewConfigs(tests.DecodeRawToSlice(jobsRaw), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = NewGroups(tests.DecodeRawToSlice(groupsRaw), cfgs)
+		return cfgs, err
+	}
+	jobsRaw := `[
+	{name: "db", exec: "/bin/db", health: {exec: "/bin/check", interval: 1, ttl: 5}},
+	{name: "migrate", exec: "/bin/migrate"},
+	{name: "app", exec: "/bin/app", stopTimeout: "5s"},
+	{name: "task", exec: "/bin/task", when: {source: "db", once: "healthy"}}]`
+
+	expectErr := func(groupsRaw, errMsg string) {
+		_, err := newGroups(jobsRaw, groupsRaw)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{jobs: ["db"]}]`, "group must have a name")
+	expectErr(`[{name: "pipeline"}]`, "group[pipeline].jobs must not be empty")
+	expectErr(`[{name: "pipeline", jobs: ["db", "cache"]}]`,
+		"group[pipeline] job 'cache' does not exist")
+	expectErr(`[{name: "pipeline", jobs: ["db", "task"]}]`,
+		"job[task] is in group[pipeline] so it can't have 'when'")
+	expectErr(`[{name: "first", jobs: ["db", "app"]}, {name: "second", jobs: ["migrate", "app"]}]`,
+		"job[app] can't be in both group[first] and group[second]")
+
+	cfgs, err := newGroups(jobsRaw,
+		`[{name: "pipeline", jobs: ["db", "migrate", "app"], timeout: "60s"}]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db, migrate, app := cfgs[0], cfgs[1], cfgs[2]
+	assert.Equal(t, db.whenEvent, events.GlobalStartup, "expected db to start on %v but got %v")
+	assert.Equal(t, migrate.whenEvent, events.Event{events.StatusHealthy, "db"},
+		"expected migrate to start on %v but got %v")
+	assert.Equal(t, app.whenEvent, events.Event{events.Started, "migrate"},
+		"expected app to start on %v but got %v")
+	assert.Equal(t, app.whenTimeout, time.Minute, "expected app when.timeout %v but got %v")
+
+	assert.Equal(t, db.stoppingWaitEvent, events.Event{events.Stopped, "migrate"},
+		"expected db to stop after %v but got %v")
+	assert.Equal(t, migrate.stoppingWaitEvent, events.Event{events.Stopped, "app"},
+		"expected migrate to stop after %v but got %v")
+	assert.Equal(t, migrate.stoppingTimeout, time.Minute,
+		"expected migrate stopTimeout %v but got %v")
+	assert.Equal(t, app.stoppingWaitEvent, events.NonEvent,
+		"expected app to stop first but it waits for %v")
+}
+
+func TestJobConfigValidateHooks(t *testing.T) {
+	expectErr := func(raw, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(raw), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", health: {exec: "/bin/check", interval: 1, ttl: 5},
+		preStop: {exec: "/bin/drain"}}]`,
+		"job[app].preStop and postStop require 'exec'")
+	expectErr(`[{name: "app", exec: "/bin/app", preStop: {exec: ""}}]`,
+		"unable to create job[app].preStop.exec: received zero-length argument")
+	expectErr(`[{name: "app", exec: "/bin/app", postStop: {exec: "/bin/cleanup", timeout: "-1s"}}]`,
+		"job[app].postStop.timeout must be positive")
+
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		env: {MODE: "prod"}, workdir: "/srv",
+		preStop: {exec: "/bin/drain", timeout: "30s"},
+		postStop: {exec: "/bin/cleanup"}}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	preStop, postStop := cfgs[0].exec.PreStop, cfgs[0].exec.PostStop
+	assert.Equal(t, preStop.Name, "app.preStop", "expected hook name %v but got %v")
+	assert.Equal(t, preStop.Timeout, 30*time.Second, "expected preStop timeout %v but got %v")
+	assert.Equal(t, postStop.Timeout, defaultHookTimeout, "expected postStop timeout %v but got %v")
+	assert.Equal(t, postStop.Env, []string{"MODE=prod"}, "expected hook env %v but got %v")
+	assert.Equal(t, postStop.Dir, "/srv", "expected hook workdir %v but got %v")
+}
+
+func TestJobConfigValidateLogFile(t *testing.T) {
+	expectErr := func(raw, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(raw), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", health: {exec: "/bin/check", interval: 1, ttl: 5},
+		logFile: {path: "/var/log/app.log"}}]`,
+		"job[app].logFile requires 'exec'")
+	expectErr(`[{name: "app", exec: "/bin/app", logFile: {path: "app.log"}}]`,
+		"job[app].logFile.path must be an absolute path")
+	expectErr(`[{name: "app", exec: "/bin/app", logFile: {path: "/var/log/app.log", maxSize: "lots"}}]`,
+		"job[app].logFile.maxSize 'lots' must be a size such as '100m'")
+	expectErr(`[{name: "app", exec: "/bin/app", logFile: {path: "/var/log/app.log", interval: "-1h"}}]`,
+		"job[app].logFile.interval must be positive")
+	expectErr(`[{name: "app", exec: "/bin/app", logFile: {path: "/var/log/app.log", maxBackups: -1}}]`,
+		"job[app].logFile.maxBackups must be positive")
+
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		logFile: {path: "/var/log/app.log", interval: "24h", maxBackups: 3, compress: true}}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].LogFile.MaxSize, defaultLogFileSize,
+		"expected default maxSize %v but got %v")
+	assert.Equal(t, cfgs[0].LogFile.MaxBackups, 3, "expected maxBackups %v but got %v")
+
+	cfgs, err = NewConfigs(tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		logFile: {path: "/var/log/app.log"}}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].LogFile.MaxBackups, defaultLogFileBackups,
+		"expected default maxBackups %v but got %v")
+}
+
+func TestJobConfigValidateOutput(t *testing.T) {
+	expectErr := func(raw, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(raw), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", exec: "/bin/app", output: {raw: true, fields: ["job"]}}]`,
+		"job[app].output.fields can't be used with 'raw'")
+	expectErr(`[{name: "app", exec: "/bin/app", output: {fields: ["job", "host"]}}]`,
+		"job[app].output.fields has unknown field 'host'")
+
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+		health: {exec: "/bin/check", interval: 1, ttl: 5},
+		output: {prefix: "[app] ", fields: ["job", "stream"]}}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].exec.Output.Prefix, "[app] ", "expected prefix %q but got %q")
+	assert.Equal(t, cfgs[0].healthCheckExec.Output, cfgs[0].exec.Output,
+		"expected health check output %v but got %v")
+}
+
+func TestJobConfigValidateMain(t *testing.T) {
+	expectErr := func(raw, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(raw), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "batch", main: true, health: {exec: "/bin/check", interval: 1, ttl: 5}}]`,
+		"job[batch].main requires 'exec'")
+	expectErr(`[{name: "batch", exec: "/bin/batch", main: true, restarts: 1}]`,
+		"job[batch].main can't be used with 'restarts'")
+	expectErr(`[{name: "batch", exec: "/bin/batch", main: true, when: {interval: "1h"}}]`,
+		"job[batch].main can't be used with 'when.interval', 'when.schedule', or 'when.each'")
+	expectErr(`[{name: "first", exec: "/bin/first", main: true},
+		{name: "second", exec: "/bin/second", main: true}]`,
+		"job[second] can't be 'main' because job[first] already is")
+
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "batch", exec: "/bin/batch", main: true, restarts: "never"}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.True(t, cfgs[0].Main, "expected job to be main")
+}
+
+func TestJobConfigEnabled(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[
+	{name: "web", exec: "/bin/web", enabled: true},
+	{name: "worker", exec: "/bin/worker", enabled: false},
+	{name: "cron", exec: "/bin/cron", enabled: "false"},
+	{name: "api", exec: "/bin/api", enabled: " true "},
+	{name: "unset", exec: "/bin/unset", enabled: ""},
+	{name: "main", exec: "/bin/main", main: true},
+	{name: "main", exec: "/bin/other", main: true, enabled: "false"}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, cfg := range cfgs {
+		names = append(names, cfg.Name)
+	}
+	assert.Equal(t, names, []string{"web", "api", "main"},
+		"expected enabled jobs %v but got %v")
+	assert.Equal(t, cfgs[2].Exec, "/bin/main", "expected exec %v but got %v")
+
+	// disabled jobs aren't validated
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "worker", exec: "/bin/worker", restarts: "bogus", enabled: false}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "worker", exec: "/bin/worker", enabled: "worker"}]`), nil)
+	assert.Error(t, err, "job[worker].enabled must be true or false but got 'worker'")
+}
+
+func TestJobConfigInstances(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[
+	{name: "worker", exec: "/bin/worker {instance}", instances: ["high", "low"],
+	 port: 8000, health: {exec: "/bin/check", interval: 1, ttl: 5},
+	 env: {QUEUE: "{instance}", SHARD: "{index}"}},
+	{name: "shard-{index}", exec: "/bin/shard", instances: 2},
+	{name: "app", exec: "/bin/app"}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, cfg := range cfgs {
+		names = append(names, cfg.Name)
+	}
+	assert.Equal(t, names,
+		[]string{"worker-high", "worker-low", "shard-0", "shard-1", "app"},
+		"expected jobs %v but got %v")
+	low := cfgs[1]
+	assert.Equal(t, low.exec.Args, []string{"low"}, "expected args %v but got %v")
+	assert.Equal(t, low.Port, 8001, "expected port %v but got %v")
+	assert.Equal(t, low.Env, map[string]string{"QUEUE": "low", "SHARD": "1"},
+		"expected env %v but got %v")
+
+	expectErr := func(raw, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(raw), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "worker", exec: "/bin/worker", instances: 0}]`,
+		"job[worker].instances must be a count or a list of names")
+	expectErr(`[{name: "worker", exec: "/bin/worker", instances: 1.5}]`,
+		"job[worker].instances must be a count or a list of names")
+	expectErr(`[{name: "worker", exec: "/bin/worker", instances: [{}]}]`,
+		"job[worker].instances must be a count or a list of names")
+	expectErr(`[{name: "worker", exec: "/bin/worker", instances: ["a", "a"]}]`,
+		"job[worker].instances has 'a' more than once")
+}
+
+func TestJobConfigExitCodes(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{name: "sync", exec: "rsync",
+	 exitCodes: {"24": "success", "1": "failure", "64-78": "terminal"}}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := cfgs[0]
+	if !cfg.exec.ExitCodes[24] || cfg.exec.ExitCodes[1] || cfg.exec.ExitCodes[70] {
+		t.Fatalf("expected only 24 to be a success but got %v", cfg.exec.ExitCodes)
+	}
+	if !cfg.terminalCodes[64] || !cfg.terminalCodes[78] || len(cfg.terminalCodes) != 15 {
+		t.Fatalf("expected 64-78 to be terminal but got %v", cfg.terminalCodes)
+	}
+
+	expectErr := func(raw, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(raw), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "sync", exitCodes: {"24": "success"}}]`,
+		"job[sync].exitCodes requires 'exec'")
+	expectErr(`[{name: "sync", exec: "rsync", exitCodes: {"256": "success"}}]`,
+		"job[sync].exitCodes '256' must be an exit code or a range such as '64-78'")
+	expectErr(`[{name: "sync", exec: "rsync", exitCodes: {"78-64": "success"}}]`,
+		"job[sync].exitCodes '78-64' must be an exit code or a range such as '64-78'")
+	expectErr(`[{name: "sync", exec: "rsync", exitCodes: {"24": "ignore"}}]`,
+		"job[sync].exitCodes '24' must be 'success', 'failure', or 'terminal'")
+	expectErr(`[{name: "sync", exec: "rsync",
+	 exitCodes: {"24": "success", "20-30": "terminal"}}]`,
+		"job[sync].exitCodes has more than one outcome for 24")
+}
+
+func TestJobExecConcurrency(t *testing.T) {
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[
+	{name: "app", exec: "/bin/app", health: {exec: "/bin/check", interval: 1, ttl: 5}},
+	{name: "reload", exec: "/bin/reload", when: {source: "watch.db", each: "changed"}},
+	{name: "backup", exec: "/bin/backup", when: {interval: "1h"}}]`), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = NewGroups(tests.DecodeRawToSlice(
+		`[{name: "web", jobs: ["app"], execConcurrency: -1}]`), cfgs)
+	assert.Error(t, err, "group[web].execConcurrency must be positive")
+
+	_, err = NewGroups(tests.DecodeRawToSlice(
+		`[{name: "web", jobs: ["app"], execConcurrency: 2}]`), cfgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	global := commands.NewLimiter("global", 4)
+	LimitExecs(cfgs, global)
+	app, reload, backup := cfgs[0], cfgs[1], cfgs[2]
+	assert.Equal(t, len(app.healthCheckExec.Limiters), 2,
+		"expected %d limiters for app's health check but got %d")
+	assert.Equal(t, app.healthCheckExec.Limiters[1], global,
+		"expected global limiter %v after the group's but got %v")
+	assert.Equal(t, len(app.exec.Limiters), 0,
+		"expected %d limiters for long-running app but got %d")
+	assert.Equal(t, len(reload.exec.Limiters), 1,
+		"expected %d limiters for reload task but got %d")
+	assert.Equal(t, len(backup.exec.Limiters), 1,
+		"expected %d limiters for backup task but got %d")
+}
+
 func TestHealthChecksConfigError(t *testing.T) {
 
 	expectErr := func(test, errMsg string) {
@@ -430,6 +1305,204 @@ func TestHealthChecksConfigError(t *testing.T) {
 		"could not parse job[myName].health.timeout 'xx': time: invalid duration xx")
 }
 
+func TestHTTPCheckConfig(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "web", exec: "/bin/web",
+	 health: {interval: 5, ttl: 10, http: {url: "http://localhost:8080/health",
+	 status: [200, 204], body: "ok|ready"}}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	probe := cfgs[0].healthCheckProbe
+	assert.Equal(t, probe.name, "check.web", "expected name %v but got %v")
+	assert.Equal(t, probe.timeout, 5*time.Second, "expected timeout %v but got %v")
+	assert.True(t, cfgs[0].healthCheckExec == nil, "expected no health check exec")
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "web", health: {interval: 1, ttl: 5,
+	 exec: "/bin/check", http: {url: "http://localhost/"}}}]`,
+		"job[web].health can have only one of 'exec', 'http', 'tcp', 'grpc', or 'checks'")
+	expectErr(`[{name: "web", health: {interval: 1, ttl: 5, http: {url: "localhost/"}}}]`,
+		"job[web].health.http.url must be an http or https URL")
+	expectErr(`[{name: "web", health: {interval: 1, ttl: 5,
+	 http: {url: "http://localhost/", status: [2000]}}}]`,
+		"job[web].health.http.status '2000' is not an HTTP status code")
+	expectErr(`[{name: "web", health: {interval: 1, ttl: 5,
+	 http: {url: "http://localhost/", body: "("}}}]`,
+		"unable to parse job[web].health.http.body: error parsing regexp: missing closing ): `(`")
+}
+
+func TestCheckThresholdsConfig(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{name: "web", exec: "/bin/web", health: {exec: "/bin/check", interval: 5,
+	 ttl: 10, failuresBeforeCritical: 3, successesBeforePassing: 2}},
+	{name: "db", exec: "/bin/db", health: {exec: "/bin/check", interval: 5, ttl: 10}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].thresholds,
+		checkThresholds{failuresBeforeCritical: 3, successesBeforePassing: 2},
+		"expected thresholds %v but got %v")
+	assert.Equal(t, cfgs[1].thresholds,
+		checkThresholds{failuresBeforeCritical: 1, successesBeforePassing: 1},
+		"expected default thresholds %v but got %v")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(`[{name: "web", health: {
+	 exec: "/bin/check", interval: 5, ttl: 10, failuresBeforeCritical: -1}}]`), nil)
+	assert.Error(t, err, "job[web].health.failuresBeforeCritical can't be negative")
+}
+
+func TestCheckInitialDelayConfig(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "web", exec: "/bin/web",
+	 health: {exec: "/bin/check", interval: 5, ttl: 10, initialDelay: "90s"}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].thresholds.initialDelay, 90*time.Second,
+		"expected initial delay %v but got %v")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(`[{name: "web", health: {
+	 exec: "/bin/check", interval: 5, ttl: 10, initialDelay: "soon"}}]`), nil)
+	if err == nil || !strings.HasPrefix(err.Error(),
+		"could not parse job[web].health.initialDelay 'soon'") {
+		t.Fatalf("expected initialDelay parse error but got %v", err)
+	}
+}
+
+func TestCompositeCheckConfig(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "app", exec: "/bin/app",
+	 env: {QUEUE: "orders"}, health: {interval: 5, ttl: 10, require: 2, checks: [
+	  {name: "api", http: {url: "http://localhost:8080/health"}},
+	  {name: "lag", exec: "/bin/check-lag", weight: 2}]}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].healthCheckProbe.name, "check.app",
+		"expected name %v but got %v")
+	assert.Equal(t, cfgs[0].compositeExecs[0].Env, []string{"QUEUE=orders"},
+		"expected check exec env %v but got %v")
+
+	expectRequire := func(require string, total, expected int) {
+		raw := tests.DecodeRaw(require)
+		got, err := parseRequire(raw, total)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", require, err)
+		}
+		assert.Equal(t, got, expected, "expected require %v but got %v")
+	}
+	expectRequire(`"all"`, 3, 3)
+	expectRequire(`"any"`, 3, 1)
+	expectRequire(`2`, 3, 2)
+	expectRequire(`"2"`, 3, 2)
+	got, err := parseRequire(2, 3)
+	assert.Equal(t, got, 2, "expected require %v but got %v")
+	assert.Equal(t, err, nil, "expected no error but got %v")
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "app", health: {interval: 1, ttl: 5, exec: "/bin/check",
+	 checks: [{name: "api", exec: "/bin/check"}]}}]`,
+		"job[app].health can have only one of 'exec', 'http', 'tcp', 'grpc', or 'checks'")
+	expectErr(`[{name: "app", health: {interval: 1, ttl: 5,
+	 checks: [{exec: "/bin/check"}]}}]`,
+		"job[app].health.checks must each have a 'name'")
+	expectErr(`[{name: "app", health: {interval: 1, ttl: 5,
+	 checks: [{name: "api", exec: "/bin/check"}, {name: "api", exec: "/bin/check"}]}}]`,
+		"job[app].health.checks has 'api' more than once")
+	expectErr(`[{name: "app", health: {interval: 1, ttl: 5,
+	 checks: [{name: "api", exec: "/bin/check", tcp: {address: "localhost:80"}}]}}]`,
+		"job[app].health.checks[api] must have one of 'exec', 'http', 'tcp', or 'grpc'")
+	expectErr(`[{name: "app", health: {interval: 1, ttl: 5,
+	 checks: [{name: "api", tcp: {address: "localhost"}}]}}]`,
+		"job[app].health.checks[api].tcp.address must be a host:port")
+	expectErr(`[{name: "app", health: {interval: 1, ttl: 5, require: 3,
+	 checks: [{name: "api", exec: "/bin/check"}, {name: "lag", exec: "/bin/check"}]}}]`,
+		"job[app].health.require must be 'all', 'any', or a weight between 1 and 2")
+}
+
+func TestCheckFlappingConfig(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "web", exec: "/bin/web",
+	 health: {exec: "/bin/check", interval: 5, ttl: 10,
+	 flapping: {transitions: 4, window: "10m"}}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfgs[0].flapping,
+		&flapDetector{transitions: 4, window: 10 * time.Minute},
+		"expected flapping detector %v but got %v")
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "web", health: {exec: "/bin/check", interval: 5, ttl: 10,
+	 flapping: {window: "10m"}}}]`,
+		"job[web].health.flapping.transitions must be > 0")
+	expectErr(`[{name: "web", health: {exec: "/bin/check", interval: 5, ttl: 10,
+	 flapping: {transitions: 4}}}]`,
+		"job[web].health.flapping.window must be > 0")
+}
+
+func TestTCPCheckConfig(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "db", exec: "/bin/db",
+	 health: {interval: 5, ttl: 10, tcp: {address: "localhost:5432", tls: true}}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	probe := cfgs[0].healthCheckProbe
+	assert.Equal(t, probe.name, "check.db", "expected name %v but got %v")
+	assert.Equal(t, probe.timeout, 5*time.Second, "expected timeout %v but got %v")
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "db", health: {interval: 1, ttl: 5,
+	 http: {url: "http://localhost/"}, tcp: {address: "localhost:5432"}}}]`,
+		"job[db].health can have only one of 'exec', 'http', 'tcp', 'grpc', or 'checks'")
+	expectErr(`[{name: "db", health: {interval: 1, ttl: 5, tcp: {address: "localhost"}}}]`,
+		"job[db].health.tcp.address must be a host:port")
+	expectErr(`[{name: "db", health: {interval: 1, ttl: 5,
+	 tcp: {address: "localhost:5432", serverName: "db.example.com"}}}]`,
+		"job[db].health.tcp.serverName and insecureSkipVerify require 'tls'")
+}
+
+func TestGRPCCheckConfig(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{name: "api", exec: "/bin/api",
+	 health: {interval: 5, ttl: 10, timeout: "2s",
+	 grpc: {address: "localhost:9090", service: "api.v1.Orders"}}}]`)
+	cfgs, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	probe := cfgs[0].healthCheckProbe
+	assert.Equal(t, probe.name, "check.api", "expected name %v but got %v")
+	assert.Equal(t, probe.timeout, 2*time.Second, "expected timeout %v but got %v")
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), nil)
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{name: "api", health: {interval: 1, ttl: 5,
+	 exec: "/bin/check", grpc: {address: "localhost:9090"}}}]`,
+		"job[api].health can have only one of 'exec', 'http', 'tcp', 'grpc', or 'checks'")
+	expectErr(`[{name: "api", health: {interval: 1, ttl: 5, grpc: {address: ":"}}}]`,
+		"job[api].health.grpc.address must be a host:port")
+	expectErr(`[{name: "api", health: {interval: 1, ttl: 5,
+	 grpc: {address: "localhost:9090", insecureSkipVerify: true}}}]`,
+		"job[api].health.grpc.serverName and insecureSkipVerify require 'tls'")
+}
+
 // ---------------------------------------------------------------------
 // helpers
 
@@ -0,0 +1,111 @@
+package jobs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// validateEnv reads the job's env files and merges its env over them, so
+// that the job's exec and health check run with these variables set over
+// ContainerPilot's own environment. Files are read in order and later
+// files override earlier ones. The HOME of the job's user comes first,
+// so that either can override it.
+func (cfg *Config) validateEnv() error {
+	if len(cfg.Env) == 0 && cfg.EnvFile == nil && cfg.home == "" {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].env and envFile require 'exec'", cfg.Name)
+	}
+	paths, err := utils.ToStringArray(cfg.EnvFile)
+	if err != nil {
+		return fmt.Errorf("job[%s].envFile must be a string or an array of strings",
+			cfg.Name)
+	}
+	env := []string{}
+	if cfg.home != "" {
+		env = append(env, "HOME="+cfg.home)
+	}
+	for _, path := range paths {
+		fileEnv, err := readEnvFile(path)
+		if err != nil {
+			return fmt.Errorf("job[%s].envFile: %v", cfg.Name, err)
+		}
+		env = append(env, fileEnv...)
+	}
+	keys := make([]string, 0, len(cfg.Env))
+	for key := range cfg.Env {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("job[%s].env has invalid name '%s'", cfg.Name, key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+cfg.Env[key])
+	}
+	cfg.exec.Env = env
+	for _, cmd := range cfg.checkCommands() {
+		cmd.Env = env
+	}
+	return nil
+}
+
+// readEnvFile reads a file of KEY=VALUE lines, in the same format as
+// Docker's --env-file. Blank lines and lines starting with '#' are
+// ignored, and values are taken as-is without removing quotes.
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	env := []string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Index(line, "=") < 1 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		env = append(env, line)
+	}
+	return env, scanner.Err()
+}
+
+// validateWorkdir sets the working directory and umask of the job's exec
+// and health check. The umask is an octal string such as "027".
+func (cfg *Config) validateWorkdir() error {
+	if cfg.Workdir == "" && cfg.Umask == "" {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].workdir and umask require 'exec'", cfg.Name)
+	}
+	var umask *int
+	if cfg.Umask != "" {
+		mask, err := strconv.ParseUint(cfg.Umask, 8, 32)
+		if err != nil || mask > 0777 {
+			return fmt.Errorf("job[%s].umask '%s' must be an octal mode such as '022'",
+				cfg.Name, cfg.Umask)
+		}
+		m := int(mask)
+		umask = &m
+	}
+	for _, cmd := range append([]*commands.Command{cfg.exec}, cfg.checkCommands()...) {
+		if cmd != nil {
+			cmd.Dir = cfg.Workdir
+			cmd.Umask = umask
+		}
+	}
+	return nil
+}
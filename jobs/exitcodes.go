@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// outcomes of an exit code in 'exitCodes'
+const (
+	exitSuccess  = "success"
+	exitFailure  = "failure"
+	exitTerminal = "terminal" // a failure after which the job isn't run again
+)
+
+// validateExitCodes parses 'exitCodes', which maps exit codes or ranges
+// of exit codes such as "64-78" to their outcome, so that a process that
+// exits with a benign code isn't treated as a failure or restarted.
+func (cfg *Config) validateExitCodes() error {
+	if len(cfg.ExitCodes) == 0 {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].exitCodes requires 'exec'", cfg.Name)
+	}
+	success := make(map[int]bool)
+	terminal := make(map[int]bool)
+	for key, outcome := range cfg.ExitCodes {
+		first, last, err := parseExitCodes(key)
+		if err != nil {
+			return fmt.Errorf("job[%s].exitCodes '%s' must be an exit code "+
+				"or a range such as '64-78'", cfg.Name, key)
+		}
+		switch outcome {
+		case exitSuccess, exitFailure, exitTerminal:
+		default:
+			return fmt.Errorf("job[%s].exitCodes '%s' must be '%s', '%s', or '%s'",
+				cfg.Name, key, exitSuccess, exitFailure, exitTerminal)
+		}
+		for code := first; code <= last; code++ {
+			if _, ok := success[code]; ok {
+				return fmt.Errorf("job[%s].exitCodes has more than one outcome for %d",
+					cfg.Name, code)
+			}
+			success[code] = outcome == exitSuccess
+			if outcome == exitTerminal {
+				terminal[code] = true
+			}
+		}
+	}
+	cfg.exec.ExitCodes = success
+	cfg.terminalCodes = terminal
+	return nil
+}
+
+// parseExitCodes returns the first and last exit code of an exit code or
+// a range of exit codes
+func parseExitCodes(key string) (int, int, error) {
+	parts := strings.SplitN(key, "-", 2)
+	first, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	last := first
+	if len(parts) == 2 {
+		last, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if first < 0 || last > 255 || first > last {
+		return 0, 0, fmt.Errorf("invalid range")
+	}
+	return first, last, nil
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// FlappingConfig configures holding a job's health steady while its
+// health check flaps between passing and failing
+type FlappingConfig struct {
+	Transitions int    `mapstructure:"transitions"`
+	Window      string `mapstructure:"window"`
+}
+
+// flapDetector tracks the changes of a job's health. The job is flapping
+// once its health changes more than the allowed number of transitions
+// within the window, and stops flapping once its health has been steady
+// for a whole window.
+type flapDetector struct {
+	transitions int
+	window      time.Duration
+
+	last     jobStatus   // the health the check results would give
+	changes  []time.Time // changes of that health within the window
+	flapping bool
+}
+
+func (cfg *Config) validateFlapping() error {
+	flapping := cfg.Health.Flapping
+	if flapping == nil {
+		return nil
+	}
+	if flapping.Transitions < 1 {
+		return fmt.Errorf("job[%s].health.flapping.transitions must be > 0",
+			cfg.Name)
+	}
+	window, err := utils.GetTimeout(flapping.Window)
+	if err != nil {
+		return fmt.Errorf("could not parse job[%s].health.flapping.window '%s': %v",
+			cfg.Name, flapping.Window, err)
+	}
+	if window <= 0 {
+		return fmt.Errorf("job[%s].health.flapping.window must be > 0", cfg.Name)
+	}
+	cfg.flapping = &flapDetector{transitions: flapping.Transitions, window: window}
+	return nil
+}
+
+// observe records the health that the latest check result gives, and
+// returns true if the job is flapping and whether that just started or
+// stopped
+func (f *flapDetector) observe(status jobStatus, now time.Time) (flapping, changed bool) {
+	if status == statusUnknown {
+		return f.flapping, false // not enough results yet
+	}
+	if f.last != statusUnknown && status != f.last {
+		f.changes = append(f.changes, now)
+	}
+	f.last = status
+	cutoff := now.Add(-f.window)
+	for len(f.changes) > 0 && !f.changes[0].After(cutoff) {
+		f.changes = f.changes[1:]
+	}
+	switch {
+	case !f.flapping && len(f.changes) > f.transitions:
+		f.flapping = true
+		return true, true
+	case f.flapping && len(f.changes) == 0:
+		f.flapping = false
+		return false, true
+	}
+	return f.flapping, false
+}
+
+// current returns the health that the check results give while the job
+// is flapping, or the job's health otherwise
+func (f *flapDetector) current(status jobStatus) jobStatus {
+	if f != nil && f.flapping {
+		return f.last
+	}
+	return status
+}
+
+// dampen holds the Job's health steady while it's flapping, so that
+// dependents in this and other containers don't see every change. It
+// returns true if the Job's health is being held rather than changed to
+// the next health.
+func (job *Job) dampen(next jobStatus, result *CheckResult) bool {
+	if job.flapping == nil {
+		return false
+	}
+	flapping, changed := job.flapping.observe(next, time.Now())
+	name := job.checkName()
+	if !flapping {
+		if changed {
+			log.Infof("%s stopped flapping", name)
+			checkFlapping.WithLabelValues(name).Set(0)
+		}
+		return false
+	}
+	held := job.getStatus()
+	if changed {
+		log.Warnf("%s is flapping, holding its health until it's steady for %v",
+			name, job.flapping.window)
+		checkFlapping.WithLabelValues(name).Set(1)
+		job.Bus.Publish(events.Event{events.Flapping, job.Name})
+	}
+	note := fmt.Sprintf("flapping, health held: %s", result.note())
+	for _, service := range job.services() {
+		switch held {
+		case statusHealthy:
+			service.SendPassing(note)
+		case statusUnhealthy:
+			service.SendFailure(note)
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// GroupConfig orders the startup and shutdown of related jobs. Each job
+// in the group starts once the job before it is healthy (or has started,
+// if it has no health check), and stops only after the job after it has
+// stopped. An optional concurrency limit caps how many of the jobs'
+// health checks and tasks run at the same time.
+type GroupConfig struct {
+	Name            string   `mapstructure:"name"`
+	Jobs            []string `mapstructure:"jobs"`
+	Timeout         string   `mapstructure:"timeout"`
+	ExecConcurrency int      `mapstructure:"execConcurrency"`
+
+	timeout time.Duration
+}
+
+// NewGroups parses the groups config and sets the start and stop
+// dependencies of the grouped jobs, which must already be validated
+func NewGroups(raw []interface{}, cfgs []*Config) ([]*GroupConfig, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var groups []*GroupConfig
+	if err := utils.DecodeRaw(raw, &groups); err != nil {
+		return nil, fmt.Errorf("group configuration error: %v", err)
+	}
+	byName := map[string]*Config{}
+	for _, cfg := range cfgs {
+		byName[cfg.Name] = cfg
+	}
+	grouped := map[string]string{}
+	for _, group := range groups {
+		if err := group.Validate(byName, grouped); err != nil {
+			return nil, err
+		}
+		group.apply(byName)
+	}
+	return groups, nil
+}
+
+// Validate ensures that a GroupConfig meets all constraints
+func (group *GroupConfig) Validate(byName map[string]*Config, grouped map[string]string) error {
+	if group.Name == "" {
+		return fmt.Errorf("group must have a name")
+	}
+	if len(group.Jobs) == 0 {
+		return fmt.Errorf("group[%s].jobs must not be empty", group.Name)
+	}
+	timeout, err := utils.GetTimeout(group.Timeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse group[%s].timeout: %v", group.Name, err)
+	}
+	group.timeout = timeout
+	if group.ExecConcurrency < 0 {
+		return fmt.Errorf("group[%s].execConcurrency must be positive", group.Name)
+	}
+	for i, name := range group.Jobs {
+		cfg, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("group[%s] job '%s' does not exist", group.Name, name)
+		}
+		if other, ok := grouped[name]; ok {
+			return fmt.Errorf("job[%s] can't be in both group[%s] and group[%s]",
+				name, other, group.Name)
+		}
+		grouped[name] = group.Name
+		if i == 0 {
+			continue // the first job starts on its own terms
+		}
+		if !cfg.When.isEmpty() {
+			return fmt.Errorf("job[%s] is in group[%s] so it can't have 'when'",
+				name, group.Name)
+		}
+		prev := byName[group.Jobs[i-1]]
+		if prev.exec == nil && !prev.hasHealthCheck() {
+			return fmt.Errorf("group[%s] job '%s' must have 'exec' or 'health' for '%s' to wait on",
+				group.Name, prev.Name, name)
+		}
+	}
+	return nil
+}
+
+// apply makes each job wait for the one before it to start, and for the
+// one after it to stop
+func (group *GroupConfig) apply(byName map[string]*Config) {
+	limiter := commands.NewLimiter(
+		fmt.Sprintf("group[%s]", group.Name), group.ExecConcurrency)
+	for i, name := range group.Jobs {
+		cfg := byName[name]
+		cfg.addLimiter(limiter)
+		if i > 0 {
+			prev := byName[group.Jobs[i-1]]
+			code := events.Started
+			if prev.hasHealthCheck() {
+				code = events.StatusHealthy
+			}
+			cfg.whenEvent = events.Event{Code: code, Source: prev.Name}
+			cfg.whenStartsLimit = 1
+			cfg.whenTimeout = group.timeout
+		}
+		if i < len(group.Jobs)-1 {
+			cfg.setStopping(group.Jobs[i+1])
+			if group.timeout > cfg.stoppingTimeout {
+				cfg.stoppingTimeout = group.timeout
+			}
+		}
+	}
+}
+
+// isEmpty returns true if the job's config didn't set any 'when' fields
+func (when *WhenConfig) isEmpty() bool {
+	return when.Frequency == "" && when.Source == "" && when.Once == "" &&
+		when.Each == "" && when.Timeout == "" && len(when.All) == 0 &&
+		when.Schedule == "" && when.Timezone == ""
+}
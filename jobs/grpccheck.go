@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// GRPCCheckConfig configures a health check that calls the standard gRPC
+// health checking protocol instead of running a process
+type GRPCCheckConfig struct {
+	Address            string `mapstructure:"address"` // host:port
+	Service            string `mapstructure:"service"` // empty for the server
+	TLS                bool   `mapstructure:"tls"`
+	ServerName         string `mapstructure:"serverName"`
+	InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+}
+
+// grpcCheck passes if the grpc.health.v1.Health/Check RPC reports that
+// its service is SERVING
+type grpcCheck struct {
+	address string
+	service string
+	creds   credentials.TransportCredentials
+}
+
+// newGRPCCheck validates the grpc check at the field of the job's
+// configuration, such as "health.grpc", and returns the check
+func (cfg *Config) newGRPCCheck(field string, check *GRPCCheckConfig) (checkFunc, error) {
+	tlsConfig, err := cfg.validateCheckAddress(field, check.Address,
+		check.TLS, check.ServerName, check.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	grpcCheck := &grpcCheck{
+		address: check.Address,
+		service: check.Service,
+		creds:   creds,
+	}
+	return grpcCheck.check, nil
+}
+
+// check connects for each check rather than holding a connection open,
+// so that a check doesn't pass on a connection to a server that has
+// since been replaced
+func (check *grpcCheck) check(ctx context.Context) error {
+	conn, err := grpc.NewClient(check.address,
+		grpc.WithTransportCredentials(check.creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx,
+		&healthpb.HealthCheckRequest{Service: check.service})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service '%s' is %s", check.service, resp.GetStatus())
+	}
+	return nil
+}
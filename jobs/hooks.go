@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// defaultHookTimeout is how long a hook may run if it has no timeout,
+// so that a stuck hook can't hold up stopping the job forever
+const defaultHookTimeout = 10 * time.Second
+
+// HookConfig configures a command that runs before the job's process is
+// asked to stop, or after it exits
+type HookConfig struct {
+	Exec    interface{} `mapstructure:"exec"`
+	Timeout string      `mapstructure:"timeout"`
+}
+
+// validateHooks creates the job's preStop and postStop hooks, which run
+// with the same user, environment, and directory as the job's exec
+func (cfg *Config) validateHooks() error {
+	if cfg.PreStop == nil && cfg.PostStop == nil {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].preStop and postStop require 'exec'", cfg.Name)
+	}
+	preStop, err := cfg.PreStop.newCommand(cfg, "preStop")
+	if err != nil {
+		return err
+	}
+	postStop, err := cfg.PostStop.newCommand(cfg, "postStop")
+	if err != nil {
+		return err
+	}
+	cfg.exec.PreStop = preStop
+	cfg.exec.PostStop = postStop
+	return nil
+}
+
+func (hook *HookConfig) newCommand(cfg *Config, name string) (*commands.Command, error) {
+	if hook == nil {
+		return nil, nil
+	}
+	timeout := defaultHookTimeout
+	if hook.Timeout != "" {
+		t, err := utils.GetTimeout(hook.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse job[%s].%s.timeout: %v",
+				cfg.Name, name, err)
+		}
+		if t <= 0 {
+			return nil, fmt.Errorf("job[%s].%s.timeout must be positive",
+				cfg.Name, name)
+		}
+		timeout = t
+	}
+	cmd, err := commands.NewCommand(hook.Exec, timeout,
+		log.Fields{"job": cfg.Name, "hook": name})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create job[%s].%s.exec: %v",
+			cfg.Name, name, err)
+	}
+	cmd.Name = fmt.Sprintf("%s.%s", cfg.Name, name)
+	cmd.Credential = cfg.exec.Credential
+	cmd.Env = cfg.exec.Env
+	cmd.Dir = cfg.exec.Dir
+	cmd.Umask = cfg.exec.Umask
+	return cmd, nil
+}
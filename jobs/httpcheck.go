@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// maxCheckBody is the most of a response body that an HTTP health check
+// reads to match against its 'body'
+const maxCheckBody = 1 << 20
+
+// HTTPCheckConfig configures a health check that makes an HTTP GET request
+// instead of running a process
+type HTTPCheckConfig struct {
+	URL                string `mapstructure:"url"`
+	Status             []int  `mapstructure:"status"` // defaults to any 2xx
+	Body               string `mapstructure:"body"`   // regular expression
+	InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+}
+
+// httpCheck passes if a GET request to its URL returns one of the expected
+// status codes, and a body that matches its regular expression if it has
+// one
+type httpCheck struct {
+	url    string
+	status map[int]bool
+	body   *regexp.Regexp
+	client *http.Client
+}
+
+// newHTTPCheck validates the http check at the field of the job's
+// configuration, such as "health.http", and returns the check
+func (cfg *Config) newHTTPCheck(field string, check *HTTPCheckConfig) (checkFunc, error) {
+	target, err := url.Parse(check.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") ||
+		target.Host == "" {
+		return nil, fmt.Errorf("job[%s].%s.url must be an http or https URL",
+			cfg.Name, field)
+	}
+	var status map[int]bool
+	if len(check.Status) > 0 {
+		status = make(map[int]bool, len(check.Status))
+		for _, code := range check.Status {
+			if code < 100 || code > 599 {
+				return nil, fmt.Errorf("job[%s].%s.status '%d' is not an HTTP status code",
+					cfg.Name, field, code)
+			}
+			status[code] = true
+		}
+	}
+	var body *regexp.Regexp
+	if check.Body != "" {
+		body, err = regexp.Compile(check.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse job[%s].%s.body: %v",
+				cfg.Name, field, err)
+		}
+	}
+	httpCheck := &httpCheck{
+		url:    check.URL,
+		status: status,
+		body:   body,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: check.InsecureSkipVerify,
+				},
+			},
+		},
+	}
+	return httpCheck.check, nil
+}
+
+func (check *httpCheck) check(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, check.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := check.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body := io.LimitReader(resp.Body, maxCheckBody)
+	if !check.statusOK(resp.StatusCode) {
+		io.Copy(ioutil.Discard, body)
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if check.body != nil && !check.body.Match(data) {
+		return fmt.Errorf("body doesn't match '%s'", check.body)
+	}
+	return nil
+}
+
+func (check *httpCheck) statusOK(code int) bool {
+	if check.status == nil {
+		return code >= 200 && code < 300
+	}
+	return check.status[code]
+}
@@ -0,0 +1,116 @@
+package jobs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// placeholders in a job definition with 'instances' that are replaced
+// with the value and position of each instance
+const (
+	instancePlaceholder = "{instance}"
+	indexPlaceholder    = "{index}"
+)
+
+// expandInstances replaces each raw job definition that has 'instances'
+// with a definition for each instance. 'instances' is either a count, in
+// which case each instance is its index, or a list of names such as the
+// queue that each instance works on. The placeholders are replaced in
+// every string of the definition, the name has "-{instance}" appended if
+// it doesn't use a placeholder, and the port of each instance is offset
+// by its index.
+func expandInstances(raw []interface{}) ([]interface{}, error) {
+	var expanded []interface{}
+	for _, definition := range raw {
+		job, ok := definition.(map[string]interface{})
+		if !ok || job["instances"] == nil {
+			expanded = append(expanded, definition)
+			continue
+		}
+		name, _ := job["name"].(string)
+		instances, err := parseInstances(name, job["instances"])
+		if err != nil {
+			return nil, err
+		}
+		template := make(map[string]interface{}, len(job))
+		for key, val := range job {
+			template[key] = val
+		}
+		delete(template, "instances")
+		if !strings.Contains(name, instancePlaceholder) &&
+			!strings.Contains(name, indexPlaceholder) {
+			template["name"] = name + "-" + instancePlaceholder
+		}
+		for i, instance := range instances {
+			replacer := strings.NewReplacer(
+				instancePlaceholder, instance, indexPlaceholder, strconv.Itoa(i))
+			job := substitute(template, replacer).(map[string]interface{})
+			if port, ok := job["port"].(float64); ok && port > 0 {
+				job["port"] = port + float64(i)
+			}
+			expanded = append(expanded, job)
+		}
+	}
+	return expanded, nil
+}
+
+// parseInstances returns the value of each instance from the count or
+// list of names in 'instances'
+func parseInstances(name string, raw interface{}) ([]string, error) {
+	var instances []string
+	switch raw := raw.(type) {
+	case float64:
+		if raw < 1 || raw != math.Trunc(raw) {
+			break
+		}
+		for i := 0; i < int(raw); i++ {
+			instances = append(instances, strconv.Itoa(i))
+		}
+	case []interface{}:
+		seen := make(map[string]bool, len(raw))
+		for _, val := range raw {
+			switch val.(type) {
+			case string, float64:
+			default:
+				return nil, fmt.Errorf(
+					"job[%s].instances must be a count or a list of names", name)
+			}
+			instance := fmt.Sprintf("%v", val)
+			if seen[instance] {
+				return nil, fmt.Errorf("job[%s].instances has '%s' more than once",
+					name, instance)
+			}
+			seen[instance] = true
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf(
+			"job[%s].instances must be a count or a list of names", name)
+	}
+	return instances, nil
+}
+
+// substitute returns a copy of the raw value with the replacer applied to
+// each of its strings
+func substitute(raw interface{}, replacer *strings.Replacer) interface{} {
+	switch raw := raw.(type) {
+	case string:
+		return replacer.Replace(raw)
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(raw))
+		for key, val := range raw {
+			copied[key] = substitute(val, replacer)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(raw))
+		for i, val := range raw {
+			copied[i] = substitute(val, replacer)
+		}
+		return copied
+	}
+	return raw
+}
@@ -20,8 +20,8 @@ const (
 
 // go:generate stringer -type jobStatus
 
-// note: this num may end up being public so we can use it in the status
-// endpoint, but let's leave it as unexported until that API has been decided
+// note: this enum is reported as a string via Report in the status
+// endpoint, so it can remain unexported
 type jobStatus int
 
 // jobStatus enum
@@ -38,17 +38,23 @@ type Job struct {
 	exec *commands.Command
 
 	// service health and discovery
-	Status          jobStatus
-	statusLock      *sync.RWMutex
-	Service         *discovery.ServiceDefinition
-	healthCheckExec *commands.Command
-	healthCheckName string
+	Status           jobStatus
+	statusLock       sync.RWMutex
+	Service          *discovery.ServiceDefinition
+	extraServices    []*discovery.ServiceDefinition
+	healthCheckExec  *commands.Command
+	healthCheckProbe *probe
+	checkStarted     time.Time
+	lastCheck        *CheckResult // guarded by statusLock
 
 	// starting events
 	startEvent   events.Event
 	startTimeout time.Duration
 	startsRemain int
 
+	// conditions that must all be met to start, and whether each is met
+	startConditions map[events.Event]bool
+
 	// stopping events
 	stoppingWaitEvent events.Event
 	stoppingTimeout   time.Duration
@@ -58,6 +64,54 @@ type Job struct {
 	restartLimit   int
 	restartsRemain int
 	frequency      time.Duration
+	schedule       *schedule
+	overlap        string       // what to do if a run is due while the last one runs
+	runQueued      bool         // run again once the running process exits
+	lastRunFile    string       // records scheduled runs, so missed runs catch up
+	jitter         float64      // fraction of the timers to randomly shorten
+	terminalCodes  map[int]bool // exit codes after which the job isn't run again
+
+	// ContainerPilot exits with the exit code of the main job
+	main bool
+
+	// delayed restarts
+	restartBackoff  *restartBackoff
+	restartAttempts int  // consecutive restarts since the last recovery
+	restartPending  bool // waiting out the backoff delay
+
+	// process state, guarded by statusLock
+	state    processState
+	restarts int
+	done     bool // the event loop has exited
+
+	// the Job's raw configuration, compared on reload
+	definition interface{}
+
+	// stop and restart requests from the control plane
+	stopRequested    bool
+	restartRequested bool
+
+	// the interval and schedule timers of a periodic job, which are
+	// canceled while the job is stopped via the control plane
+	timersCtx     context.Context
+	cancelTimers  context.CancelFunc
+	timersStopped bool
+
+	// health check paused by the control plane
+	checkPaused bool
+
+	// consecutive health check results needed to change the job's health,
+	// and holding the job's health while its check flaps
+	thresholds checkThresholds
+	flapping   *flapDetector
+
+	// health outcome forced by the control plane, guarded by statusLock
+	override        HealthOverride
+	overrideExpires time.Time
+
+	// pushing the results of each run
+	pushgateway *pushgateway
+	runStarted  time.Time
 
 	events.EventHandler // Event handling
 }
@@ -69,7 +123,11 @@ func NewJob(cfg *Config) *Job {
 		exec:              cfg.exec,
 		heartbeat:         cfg.heartbeatInterval,
 		Service:           cfg.serviceDefinition,
+		extraServices:     cfg.extraServices,
 		healthCheckExec:   cfg.healthCheckExec,
+		healthCheckProbe:  cfg.healthCheckProbe,
+		thresholds:        cfg.thresholds,
+		flapping:          cfg.flapping,
 		startEvent:        cfg.whenEvent,
 		startTimeout:      cfg.whenTimeout,
 		startsRemain:      cfg.whenStartsLimit,
@@ -78,9 +136,23 @@ func NewJob(cfg *Config) *Job {
 		restartLimit:      cfg.restartLimit,
 		restartsRemain:    cfg.restartLimit,
 		frequency:         cfg.freqInterval,
+		schedule:          cfg.schedule,
+		overlap:           cfg.overlap,
+		lastRunFile:       cfg.lastRunFile,
+		jitter:            cfg.jitter,
+		terminalCodes:     cfg.terminalCodes,
+		restartBackoff:    cfg.restartBackoff,
+		pushgateway:       cfg.pushgateway,
+		main:              cfg.Main,
+		definition:        cfg.definition,
+	}
+	if len(cfg.whenConditions) > 0 {
+		job.startConditions = make(map[events.Event]bool)
+		for _, condition := range cfg.whenConditions {
+			job.startConditions[condition] = false
+		}
 	}
 	job.Rx = make(chan events.Event, eventBufferSize)
-	job.statusLock = &sync.RWMutex{}
 	if job.Name == "containerpilot" {
 		// right now this hardcodes the telemetry service to
 		// be always "healthy", but maybe we want to have it verify itself
@@ -100,15 +172,23 @@ func FromConfigs(cfgs []*Config) []*Job {
 	return jobs
 }
 
-// SendHeartbeat sends a heartbeat for this Job's service
+// SendHeartbeat sends a heartbeat for this Job's services
 func (job *Job) SendHeartbeat() {
-	if job.Service != nil {
-		job.Service.SendHeartbeat()
+	for _, service := range job.services() {
+		service.SendHeartbeat()
+	}
+}
+
+// services returns all of the Job's service registrations
+func (job *Job) services() []*discovery.ServiceDefinition {
+	if job.Service == nil {
+		return nil
 	}
+	return append([]*discovery.ServiceDefinition{job.Service}, job.extraServices...)
 }
 
-// note: this method may end up being public so we can use it in the status
-// endpoint, but let's leave it as unexported until that API has been decided
+// note: the status endpoint uses Report rather than this method so that
+// the jobStatus enum can remain unexported
 func (job *Job) getStatus() jobStatus {
 	job.statusLock.RLock()
 	defer job.statusLock.RUnlock()
@@ -121,35 +201,72 @@ func (job *Job) setStatus(status jobStatus) {
 	job.Status = status
 }
 
-// MarkForMaintenance marks this Job's service for maintenance
+// MarkForMaintenance marks this Job's services for maintenance
 func (job *Job) MarkForMaintenance() {
 	job.setStatus(statusMaintenance)
-	if job.Service != nil {
-		job.Service.MarkForMaintenance()
+	for _, service := range job.services() {
+		service.MarkForMaintenance()
 	}
 }
 
-// Deregister will deregister this instance of Job's service
+// Deregister will deregister this instance of Job's services
 func (job *Job) Deregister() {
-	if job.Service != nil {
-		job.Service.Deregister()
+	for _, service := range job.services() {
+		service.Deregister()
 	}
 }
 
 // HealthCheck runs the Job's health check executable
 func (job *Job) HealthCheck(ctx context.Context) {
 	if job.healthCheckExec != nil {
+		job.checkStarted = time.Now()
+		job.healthCheckExec.Logs.Reset()
 		job.healthCheckExec.Run(ctx, job.Bus)
+	} else if job.healthCheckProbe != nil {
+		job.checkStarted = time.Now()
+		job.healthCheckProbe.Run(ctx, job.Bus)
+	}
+}
+
+// HasHealthCheck returns true if the Job has a health check exec or a
+// health check made by ContainerPilot itself
+func (job *Job) HasHealthCheck() bool {
+	return job.checkName() != ""
+}
+
+// checkName returns the name of the Job's health check, which is the
+// source of the events published by each run, or "" if it has none
+func (job *Job) checkName() string {
+	if job.healthCheckExec != nil {
+		return job.healthCheckExec.Name
+	}
+	if job.healthCheckProbe != nil {
+		return job.healthCheckProbe.name
 	}
+	return ""
 }
 
 // StartJob runs the Job's executable
 func (job *Job) StartJob(ctx context.Context) {
 	if job.exec != nil {
+		job.setState(stateRunning)
+		job.runStarted = time.Now()
+		job.thresholds.startGracePeriod()
 		job.exec.Run(ctx, job.Bus)
+		job.Bus.Publish(events.Event{Code: events.Started, Source: job.Name})
 	}
 }
 
+// restartJob runs the Job's executable again, consuming one of the
+// remaining restarts
+func (job *Job) restartJob(ctx context.Context) {
+	job.restartsRemain--
+	job.statusLock.Lock()
+	job.restarts++
+	job.statusLock.Unlock()
+	job.StartJob(ctx)
+}
+
 // Kill sends SIGTERM to the Job's executable, if any
 func (job *Job) Kill() {
 	if job.exec != nil {
@@ -161,18 +278,53 @@ func (job *Job) Kill() {
 	}
 }
 
+// stopProcess stops the Job's running process without stopping the
+// Job's event loop. The process gets its stop signal and grace period,
+// so this doesn't block the event loop while we wait for it to exit.
+func (job *Job) stopProcess() {
+	if job.exec != nil {
+		go job.exec.Stop()
+	}
+}
+
+// ShutdownTimeout is how long a Job with a stop signal or preStop hook
+// may take to stop after ContainerPilot starts shutting down: the time it
+// waits for the jobs that depend on its stopping event, plus the timeout
+// of its preStop hook and the grace period of its process. It's 0 for
+// Jobs that are killed right away.
+func (job *Job) ShutdownTimeout() time.Duration {
+	if job.exec == nil {
+		return 0
+	}
+	var timeout time.Duration
+	if job.exec.PreStop != nil {
+		timeout += job.exec.PreStop.Timeout
+	}
+	if job.exec.StopSignal != 0 {
+		timeout += job.exec.StopGracePeriod
+	}
+	if timeout == 0 {
+		return 0
+	}
+	return job.stoppingTimeout + timeout
+}
+
 // Run executes the event loop for the Job
 func (job *Job) Run(bus *events.EventBus) {
 	job.Subscribe(bus)
 	job.Bus = bus
 	ctx, cancel := context.WithCancel(context.Background())
 
-	if job.frequency > 0 {
-		events.NewEventTimer(ctx, job.Rx, job.frequency,
-			fmt.Sprintf("%s.run-every", job.Name))
+	job.startTimers(ctx)
+	if job.schedule != nil {
+		if job.missedRun() {
+			events.NewEventTimeout(ctx, job.Rx, 0,
+				fmt.Sprintf("%s.catch-up", job.Name))
+		}
 	}
 	if job.heartbeat > 0 {
-		events.NewEventTimer(ctx, job.Rx, job.heartbeat,
+		job.thresholds.startGracePeriod()
+		events.NewJitteredEventTimer(ctx, job.Rx, job.heartbeat, job.jitter,
 			fmt.Sprintf("%s.heartbeat", job.Name))
 	}
 	if job.startTimeout > 0 {
@@ -203,15 +355,28 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) bool {
 	runEverySource := fmt.Sprintf("%s.run-every", job.Name)
 	heartbeatSource := fmt.Sprintf("%s.heartbeat", job.Name)
 	startTimeoutSource := fmt.Sprintf("%s.wait-timeout", job.Name)
-	var healthCheckName string
-	if job.healthCheckExec != nil {
-		healthCheckName = job.healthCheckExec.Name
+	restartBackoffSource := fmt.Sprintf("%s.restart-backoff", job.Name)
+	scheduleSource := fmt.Sprintf("%s.schedule", job.Name)
+	catchUpSource := fmt.Sprintf("%s.catch-up", job.Name)
+	healthCheckName := job.checkName()
+
+	// a job that's already running ignores its conditions being met
+	// again, rather than starting a second time once it exits
+	if job.updateStartConditions(event) && job.getState() != stateRunning {
+		return job.startOnEvent(ctx)
 	}
 
 	switch event {
 	case events.Event{events.TimerExpired, heartbeatSource}:
 		if job.getStatus() != statusMaintenance {
-			if job.healthCheckExec != nil {
+			if override := job.getHealthOverride(); override != OverrideNone {
+				job.applyHealthOverride(override)
+			} else if job.checkPaused {
+				// hold the last health reported before the pause
+				if job.getStatus() == statusHealthy {
+					job.SendHeartbeat()
+				}
+			} else if job.HasHealthCheck() {
 				job.HealthCheck(ctx)
 			} else if job.Service != nil {
 				// this is the case for non-checked but advertised
@@ -224,42 +389,128 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) bool {
 			Code: events.TimerExpired, Source: job.Name})
 		job.Rx <- events.Event{Code: events.Quit, Source: job.Name}
 	case events.Event{events.TimerExpired, runEverySource}:
-		if !job.restartPermitted() {
-			log.Debugf("interval expired but restart not permitted: %v",
-				job.Name)
-			return true
+		if job.timersStopped {
+			break // fired just before the job was stopped
 		}
-		job.restartsRemain--
-		job.StartJob(ctx)
-	case events.Event{events.ExitFailed, healthCheckName}:
+		return job.runPeriodic(ctx)
+	case events.Event{events.TimerExpired, scheduleSource}:
+		if job.timersStopped {
+			break
+		}
+		job.scheduleNextRun(job.timersContext(ctx))
+		return job.runPeriodic(ctx)
+	case events.Event{events.TimerExpired, catchUpSource}:
+		return job.runPeriodic(ctx)
+	case events.Event{events.TimerExpired, restartBackoffSource}:
+		if job.restartPending {
+			job.restartPending = false
+			job.restartJob(ctx)
+		}
+	case events.Event{events.OverrideHealth, job.Name}:
 		if job.getStatus() != statusMaintenance {
-			job.setStatus(statusUnhealthy)
-			job.Bus.Publish(events.Event{events.StatusUnhealthy, job.Name})
+			job.applyHealthOverride(job.getHealthOverride())
+		}
+	case events.Event{events.ExitFailed, healthCheckName}:
+		job.recordCheck(false)
+		result := job.saveCheckResult(false)
+		if job.getStatus() != statusMaintenance && !job.checkPaused &&
+			job.getHealthOverride() == OverrideNone {
+			job.applyCheckResult(result)
 		}
 	case events.Event{events.ExitSuccess, healthCheckName}:
-		if job.getStatus() != statusMaintenance {
-			job.setStatus(statusHealthy)
-			job.Bus.Publish(events.Event{events.StatusHealthy, job.Name})
-			job.SendHeartbeat()
+		job.recordCheck(true)
+		result := job.saveCheckResult(true)
+		if job.getStatus() != statusMaintenance && !job.checkPaused &&
+			job.getHealthOverride() == OverrideNone {
+			job.applyCheckResult(result)
 		}
 	case
 		events.Event{events.Quit, job.Name},
 		events.QuitByClose,
 		events.GlobalShutdown:
 		return true
-	case events.GlobalEnterMaintenance:
+	case
+		events.GlobalEnterMaintenance,
+		events.Event{events.EnterMaintenance, job.Name}:
 		job.MarkForMaintenance()
-	case events.GlobalExitMaintenance:
+	case
+		events.GlobalExitMaintenance,
+		events.Event{events.ExitMaintenance, job.Name}:
 		job.setStatus(statusUnknown)
+	case events.Event{events.PauseCheck, job.Name}:
+		job.checkPaused = true
+	case events.Event{events.ResumeCheck, job.Name}:
+		job.checkPaused = false
+	case events.Event{events.Start, job.Name}:
+		job.stopRequested = false
+		job.restartPending = false
+		job.restartAttempts = 0
+		job.startTimers(ctx)
+		if job.getState() != stateRunning {
+			job.StartJob(ctx)
+		}
+	case events.Event{events.Stop, job.Name}:
+		job.stopTimers()
+		if job.getState() == stateRunning {
+			job.stopRequested = true
+			job.stopProcess()
+		} else if job.restartPending || job.frequency > 0 || job.schedule != nil {
+			job.restartPending = false
+			job.runQueued = false
+			job.setState(stateStopped)
+		}
+	case events.Event{events.Restart, job.Name}:
+		job.stopRequested = false
+		job.restartPending = false
+		job.restartAttempts = 0
+		job.startTimers(ctx)
+		if job.getState() == stateRunning {
+			job.restartRequested = true
+			job.stopProcess()
+		} else {
+			job.StartJob(ctx)
+		}
 	case
 		events.Event{events.ExitSuccess, job.Name},
 		events.Event{events.ExitFailed, job.Name}:
-		if job.frequency > 0 {
+		job.setState(stateWaiting)
+		job.pushRun()
+		if job.restartRequested {
+			job.restartRequested = false
+			job.StartJob(ctx)
+			break
+		}
+		if job.stopRequested {
+			// a job stopped via the control plane stays stopped until
+			// it's started again, regardless of its restart policy
+			job.stopRequested = false
+			job.runQueued = false
+			job.setState(stateStopped)
+			break
+		}
+		if job.main {
+			status := job.exec.ExitStatus()
+			log.Infof("main job %s exited with %d, shutting down", job.Name, status)
+			job.Bus.SetExitCode(status)
+			job.Bus.Shutdown()
+			return true
+		}
+		if job.exitedTerminally() {
+			return true
+		}
+		if job.frequency > 0 || job.schedule != nil {
+			if job.runQueued {
+				job.runQueued = false
+				return job.runPeriodic(ctx)
+			}
 			break // periodic jobs ignore previous events
 		}
 		if job.restartPermitted() {
-			job.restartsRemain--
-			job.StartJob(ctx)
+			if job.restartBackoff != nil {
+				job.scheduleRestart(ctx)
+				break
+			}
+			job.restartJob(ctx)
 			break
 		}
 		if job.startsRemain != 0 {
@@ -268,19 +519,110 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) bool {
 		log.Debugf("job exited but restart not permitted: %v", job.Name)
 		return true
 	case job.startEvent:
-		if job.startsRemain == 0 {
-			return true
-		}
-		if job.startsRemain != unlimited {
-			// if we have unlimited restarts we want to make sure we don't
-			// decrement forever and then wrap-around
-			job.startsRemain--
-		}
-		job.StartJob(ctx)
+		return job.startOnEvent(ctx)
 	}
 	return false
 }
 
+// startOnEvent starts the job when its `when` event fires or all of its
+// `when` conditions are met. It returns true if the job has no starts
+// remaining, so that it quits.
+func (job *Job) startOnEvent(ctx context.Context) bool {
+	if job.startsRemain == 0 {
+		return true
+	}
+	if job.startsRemain != unlimited {
+		// if we have unlimited restarts we want to make sure we don't
+		// decrement forever and then wrap-around
+		job.startsRemain--
+	}
+	job.StartJob(ctx)
+	return false
+}
+
+// startTimers starts the interval or schedule timer of a periodic job,
+// unless it's already running
+func (job *Job) startTimers(ctx context.Context) {
+	job.timersStopped = false
+	if job.cancelTimers != nil || (job.frequency == 0 && job.schedule == nil) {
+		return
+	}
+	job.timersCtx, job.cancelTimers = context.WithCancel(ctx)
+	if job.frequency > 0 {
+		events.NewJitteredEventTimer(job.timersCtx, job.Rx, job.frequency,
+			job.jitter, fmt.Sprintf("%s.run-every", job.Name))
+	}
+	if job.schedule != nil {
+		job.scheduleNextRun(job.timersCtx)
+	}
+}
+
+// stopTimers stops the interval or schedule timer of a periodic job, so
+// that a job stopped via the control plane isn't run again until it's
+// started again
+func (job *Job) stopTimers() {
+	job.timersStopped = true
+	if job.cancelTimers != nil {
+		job.cancelTimers()
+		job.cancelTimers = nil
+		job.timersCtx = nil
+	}
+}
+
+// timersContext returns the context of the periodic timers, or ctx if
+// they haven't been started
+func (job *Job) timersContext(ctx context.Context) context.Context {
+	if job.timersCtx == nil {
+		return ctx
+	}
+	return job.timersCtx
+}
+
+// exitedTerminally returns true if the Job's process exited with one of
+// its terminal exit codes, after which the Job isn't run again
+func (job *Job) exitedTerminally() bool {
+	if len(job.terminalCodes) == 0 {
+		return false
+	}
+	code := job.exec.ExitCode()
+	if !job.terminalCodes[code] {
+		return false
+	}
+	log.Infof("job %s exited with %d, not running it again", job.Name, code)
+	return true
+}
+
+// updateStartConditions marks the start condition for the event as met
+// and returns true if this completes the set. A health event resets the
+// condition for the opposite health of the same job, so that a condition
+// like 'healthy db' is only met while db is healthy.
+func (job *Job) updateStartConditions(event events.Event) bool {
+	if len(job.startConditions) == 0 {
+		return false
+	}
+	var opposite events.Event
+	switch event.Code {
+	case events.StatusHealthy:
+		opposite = events.Event{Code: events.StatusUnhealthy, Source: event.Source}
+	case events.StatusUnhealthy:
+		opposite = events.Event{Code: events.StatusHealthy, Source: event.Source}
+	}
+	if _, ok := job.startConditions[opposite]; ok {
+		job.startConditions[opposite] = false
+	}
+	met, ok := job.startConditions[event]
+	if !ok || met {
+		return false
+	}
+	job.startConditions[event] = true
+	for _, met := range job.startConditions {
+		if !met {
+			return false
+		}
+	}
+	return true
+}
+
 func (job *Job) restartPermitted() bool {
 	if job.restartLimit == unlimited || job.restartsRemain > 0 {
 		return true
@@ -312,12 +654,39 @@ func (job *Job) cleanup(ctx context.Context, cancel context.CancelFunc) {
 		}
 	}
 	cancel()
+	job.waitForExit()
 	job.exec.CloseLogs()
 	job.Deregister()         // deregister from Consul
 	job.Unsubscribe(job.Bus) // deregister from events
+	job.statusLock.Lock()
+	job.state = stateStopped
+	job.done = true
+	job.statusLock.Unlock()
 	job.Bus.Publish(events.Event{Code: events.Stopped, Source: job.Name})
 }
 
+// waitForExit waits for the Job's process to be stopped and for its
+// postStop hook to finish. Events are drained meanwhile so that nothing
+// publishing to the Job blocks on it.
+func (job *Job) waitForExit() {
+	exited := make(chan struct{})
+	go func() {
+		job.exec.Wait()
+		close(exited)
+	}()
+	rx := job.Rx
+	for {
+		select {
+		case <-exited:
+			return
+		case _, ok := <-rx:
+			if !ok {
+				rx = nil
+			}
+		}
+	}
+}
+
 // String implements the stdlib fmt.Stringer interface for pretty-printing
 func (job *Job) String() string {
 	return "jobs.Job[" + job.Name + "]"
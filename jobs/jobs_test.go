@@ -1,12 +1,29 @@
 package jobs
 
 import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
 	"github.com/joyent/containerpilot/tests/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func TestJobRunSafeClose(t *testing.T) {
@@ -29,7 +46,10 @@ func TestJobRunSafeClose(t *testing.T) {
 
 	expected := []events.Event{
 		events.GlobalStartup,
+		events.Event{events.Started, "myjob"},
 		events.Event{events.Stopping, "myjob"},
+		events.Event{events.ExitFailed, "myjob"},
+		events.Event{events.Error, "myjob: signal: killed"},
 		events.Event{events.Stopped, "myjob"},
 	}
 	if !reflect.DeepEqual(expected, results) {
@@ -101,6 +121,11 @@ func TestJobRunRestarts(t *testing.T) {
 		if got != expected {
 			t.Fatalf("expected %d restarts but got %d\n%v", expected, got, results)
 		}
+		report := job.Report()
+		if report.Restarts != expected-1 || report.State != "stopped" {
+			t.Fatalf("expected %d restarts and 'stopped' but got %+v",
+				expected-1, report)
+		}
 	}
 	runRestartsTest(3, 4)
 	runRestartsTest("1", 2)
@@ -109,6 +134,183 @@ func TestJobRunRestarts(t *testing.T) {
 	runRestartsTest(nil, 1)
 }
 
+// A Job that exits with a terminal exit code isn't restarted
+func TestJobRunExitCodes(t *testing.T) {
+	bus := events.NewEventBus()
+	cfg := &Config{
+		Name:            "myjob",
+		whenEvent:       events.GlobalStartup,
+		whenStartsLimit: 1,
+		Exec:            []string{"sh", "-c", "exit 3"},
+		Restarts:        "unlimited",
+		ExitCodes:       map[string]string{"3": "terminal"},
+	}
+	if err := cfg.Validate(noop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := NewJob(cfg)
+	job.Run(bus)
+	job.Bus.Publish(events.GlobalStartup)
+	time.Sleep(100 * time.Millisecond)
+	bus.Wait()
+
+	exitFailed := events.Event{Code: events.ExitFailed, Source: "myjob"}
+	var got = 0
+	results := bus.DebugEvents()
+	for _, result := range results {
+		if result == exitFailed {
+			got++
+		}
+	}
+	if got != 1 {
+		t.Fatalf("expected the job to run once but got %v", results)
+	}
+	if report := job.Report(); report.Restarts != 0 || report.State != "stopped" {
+		t.Fatalf("expected no restarts and 'stopped' but got %+v", report)
+	}
+}
+
+// When the main Job exits, all Jobs shut down with its exit code
+func TestJobRunMain(t *testing.T) {
+	bus := events.NewEventBus()
+	mainCfg := &Config{Name: "batch", Exec: []string{"sh", "-c", "exit 3"}, Main: true}
+	mainCfg.Validate(noop)
+	otherCfg := &Config{Name: "sidecar", Exec: "sleep 10"}
+	otherCfg.Validate(noop)
+	mainJob, other := NewJob(mainCfg), NewJob(otherCfg)
+	mainJob.Run(bus)
+	other.Run(bus)
+	bus.Publish(events.GlobalStartup)
+
+	done := make(chan struct{})
+	go func() {
+		bus.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected jobs to shut down when the main job exited")
+	}
+	if code := bus.ExitCode(); code != 3 {
+		t.Fatalf("expected exit code 3 but got %d", code)
+	}
+	results := bus.DebugEvents()
+	stopped := events.Event{Code: events.Stopped, Source: "sidecar"}
+	for _, result := range results {
+		if result == stopped {
+			return
+		}
+	}
+	t.Fatalf("expected sidecar to be stopped but got %v", results)
+}
+
+func TestJobRunControlActions(t *testing.T) {
+	bus := events.NewEventBus()
+	cfg := &Config{Name: "myjob", Exec: "sleep 10"}
+	cfg.Validate(noop)
+	job := NewJob(cfg)
+	job.Run(bus)
+	bus.Publish(events.GlobalStartup)
+
+	expectState := func(action, expected string) {
+		time.Sleep(100 * time.Millisecond)
+		if got := job.Report().State; got != expected {
+			t.Fatalf("expected '%s' after %s but got '%s'", expected, action, got)
+		}
+	}
+	expectState("startup", "running")
+	bus.Publish(events.Event{events.Stop, "myjob"})
+	expectState("stop", "stopped")
+	bus.Publish(events.Event{events.Start, "myjob"})
+	expectState("start", "running")
+	bus.Publish(events.Event{events.Restart, "myjob"})
+	expectState("restart", "running")
+	bus.Publish(events.Event{events.Stop, "otherjob"})
+	expectState("stop of another job", "running")
+
+	job.Quit()
+	bus.Wait()
+	got := map[events.Event]int{}
+	for _, result := range bus.DebugEvents() {
+		got[result]++
+	}
+	// one exit from stop, one from restart; neither consumes a restart
+	exitFailed := events.Event{Code: events.ExitFailed, Source: "myjob"}
+	if got[exitFailed] != 2 || job.Report().Restarts != 0 {
+		t.Fatalf("expected 2 exits and no restarts but got: %v", got)
+	}
+}
+
+func TestJobRunPeriodicStop(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "periodicstop")
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+	countRuns := func() int {
+		data, _ := ioutil.ReadFile(runs)
+		return len(data)
+	}
+
+	bus := events.NewEventBus()
+	cfg := &Config{Name: "myjob",
+		Exec: []interface{}{"sh", "-c", "printf x >> " + runs},
+		When: &WhenConfig{Frequency: "100ms"}, Restarts: "unlimited"}
+	if err := cfg.Validate(noop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := NewJob(cfg)
+	job.Run(bus)
+	bus.Publish(events.GlobalStartup)
+	time.Sleep(250 * time.Millisecond)
+	bus.Publish(events.Event{events.Stop, "myjob"})
+	time.Sleep(100 * time.Millisecond)
+
+	stopped := countRuns()
+	time.Sleep(350 * time.Millisecond)
+	if got := countRuns(); got != stopped {
+		t.Fatalf("expected no runs while stopped but got %d more", got-stopped)
+	}
+	if got := job.Report().State; got != "stopped" {
+		t.Fatalf("expected 'stopped' but got '%s'", got)
+	}
+	bus.Publish(events.Event{events.Start, "myjob"})
+	time.Sleep(350 * time.Millisecond)
+	job.Quit()
+	bus.Wait()
+	if got := countRuns(); got < stopped+2 {
+		t.Fatalf("expected runs to resume after start but got %d", got-stopped)
+	}
+}
+
+func TestJobSignal(t *testing.T) {
+	bus := events.NewEventBus()
+	cfg := &Config{Name: "myjob", Exec: "sleep 10", Restarts: "never"}
+	cfg.Validate(noop)
+	job := NewJob(cfg)
+	if err := job.Signal(syscall.SIGTERM); err == nil {
+		t.Fatalf("expected error signalling a job that isn't running")
+	}
+	job.Run(bus)
+	bus.Publish(events.GlobalStartup)
+	time.Sleep(100 * time.Millisecond)
+	if err := job.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	job.Quit()
+	bus.Wait()
+	exitFailed := events.Event{Code: events.ExitFailed, Source: "myjob"}
+	got := 0
+	for _, result := range bus.DebugEvents() {
+		if result == exitFailed {
+			got++
+		}
+	}
+	if got != 1 {
+		t.Fatalf("expected job to exit after signal but got %v", bus.DebugEvents())
+	}
+}
+
 func TestJobRunPeriodic(t *testing.T) {
 	bus := events.NewEventBus()
 
@@ -146,6 +348,33 @@ func TestJobRunPeriodic(t *testing.T) {
 	}
 }
 
+// A scheduled job with catchUp runs at startup if it missed a run while
+// ContainerPilot wasn't running
+func TestJobScheduleCatchUp(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "catchup")
+	defer os.RemoveAll(dir)
+	sched, _ := parseSchedule("cron(30 2 * * *)", "UTC")
+	job := &Job{
+		Name:        "nightly",
+		schedule:    sched,
+		lastRunFile: filepath.Join(dir, "last-run"),
+	}
+	if job.missedRun() {
+		t.Fatalf("expected no missed run without a recorded run")
+	}
+	if _, err := os.Stat(job.lastRunFile); err != nil {
+		t.Fatalf("expected the current time to be recorded: %v", err)
+	}
+	if job.missedRun() {
+		t.Fatalf("expected no missed run since the recorded run")
+	}
+	lastRun := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	ioutil.WriteFile(job.lastRunFile, []byte(lastRun), 0644)
+	if !job.missedRun() {
+		t.Fatalf("expected a missed run since %v", lastRun)
+	}
+}
+
 func TestJobMaintenance(t *testing.T) {
 
 	testFunc := func(t *testing.T, startingState jobStatus, event events.Event) jobStatus {
@@ -191,6 +420,27 @@ func TestJobMaintenance(t *testing.T) {
 			"expected job in '%v' status after exiting maintenance but got '%v'")
 	})
 
+	t.Run("enter service maintenance", func(t *testing.T) {
+		status := testFunc(t, statusUnknown,
+			events.Event{events.EnterMaintenance, "myjob"})
+		assert.Equal(t, status, statusMaintenance,
+			"expected job in '%v' status after entering maintenance but got '%v'")
+	})
+
+	t.Run("other service maintenance no change", func(t *testing.T) {
+		status := testFunc(t, statusUnknown,
+			events.Event{events.EnterMaintenance, "otherjob"})
+		assert.Equal(t, status, statusUnknown,
+			"expected job in '%v' status after another job entered maintenance but got '%v'")
+	})
+
+	t.Run("exit service maintenance", func(t *testing.T) {
+		status := testFunc(t, statusMaintenance,
+			events.Event{events.ExitMaintenance, "myjob"})
+		assert.Equal(t, status, statusUnknown,
+			"expected job in '%v' status after exiting maintenance but got '%v'")
+	})
+
 	t.Run("now healthy", func(t *testing.T) {
 		status := testFunc(t, statusUnknown,
 			events.Event{events.ExitSuccess, "check.myjob"})
@@ -199,6 +449,24 @@ func TestJobMaintenance(t *testing.T) {
 	})
 }
 
+func TestJobPauseCheck(t *testing.T) {
+	job := &Job{Name: "myjob", healthCheckExec: testCheckExec("check.myjob")}
+	job.Bus = events.NewEventBus()
+	job.setStatus(statusHealthy)
+
+	job.processEvent(nil, events.Event{events.PauseCheck, "myjob"})
+	assert.True(t, job.checkPaused, "expected check to be paused")
+	job.processEvent(nil, events.Event{events.ExitFailed, "check.myjob"})
+	assert.Equal(t, job.getStatus(), statusHealthy,
+		"expected job in '%v' status after failed check while paused but got '%v'")
+
+	job.processEvent(nil, events.Event{events.ResumeCheck, "myjob"})
+	assert.False(t, job.checkPaused, "expected check to be resumed")
+	job.processEvent(nil, events.Event{events.ExitFailed, "check.myjob"})
+	assert.Equal(t, job.getStatus(), statusUnhealthy,
+		"expected job in '%v' status after failed check once resumed but got '%v'")
+}
+
 func TestJobProcessEvent(t *testing.T) {
 
 	t.Run("start each startEvent", func(t *testing.T) {
@@ -288,4 +556,593 @@ func TestJobProcessEvent(t *testing.T) {
 		assert.True(t, got, "processEvent returned %v after 2nd exit, expected %v")
 	})
 
+	t.Run("skip, queue, or restart an overlapping run", func(t *testing.T) {
+		// when: {
+		//   interval: "1s",
+		//   overlap: "skip"|"queue"|"restart"
+		// },
+		// restarts: "unlimited"
+		for _, overlap := range []string{"skip", "queue", "restart"} {
+			job := &Job{
+				Name:           "testJob",
+				startEvent:     events.GlobalStartup,
+				restartLimit:   unlimited,
+				restartsRemain: unlimited,
+				frequency:      time.Second,
+				overlap:        overlap,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			job.setState(stateRunning)
+			got := job.processEvent(ctx, events.Event{events.TimerExpired, "testJob.run-every"})
+			assert.False(t, got, "processEvent returned %v while running, expected %v")
+			assert.Equal(t, job.runQueued, overlap != "skip",
+				"expected queued run %v but got %v")
+			got = job.processEvent(ctx, events.Event{events.ExitSuccess, "testJob"})
+			assert.False(t, got, "processEvent returned %v after exit, expected %v")
+			expected := 0
+			if overlap != "skip" {
+				expected = 1
+			}
+			assert.Equal(t, job.Report().Restarts, expected, "expected %v runs but got %v")
+		}
+	})
+
+	t.Run("start on schedule, with 1 restart", func(t *testing.T) {
+		// when: {
+		//   schedule: "cron(* * * * *)"
+		// },
+		// restarts: 1
+		sched, _ := parseSchedule("cron(* * * * *)", "")
+		job := &Job{
+			Name:           "testJob",
+			startEvent:     events.NonEvent,
+			startsRemain:   1,
+			restartLimit:   1,
+			restartsRemain: 1,
+			schedule:       sched,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		scheduled := events.Event{events.TimerExpired, "testJob.schedule"}
+
+		got := job.processEvent(ctx, scheduled)
+		assert.False(t, got, "processEvent returned %v after 1st scheduled run, expected %v")
+		got = job.processEvent(ctx, events.Event{events.ExitSuccess, "testJob"})
+		assert.False(t, got, "processEvent returned %v after 1st exit, expected %v")
+
+		got = job.processEvent(ctx, scheduled)
+		assert.False(t, got, "processEvent returned %v after 2nd scheduled run, expected %v")
+		got = job.processEvent(ctx, events.Event{events.ExitSuccess, "testJob"})
+		assert.False(t, got, "processEvent returned %v after 2nd exit, expected %v")
+
+		got = job.processEvent(ctx, scheduled)
+		assert.True(t, got, "processEvent returned %v after 3rd scheduled run, expected %v")
+	})
+
+	t.Run("start once all conditions are met", func(t *testing.T) {
+		// when: {
+		//   all: [
+		//     {source: "db", once: "healthy"},
+		//     {source: "migrations", once: "exitSuccess"}
+		//   ]
+		// }
+		job := &Job{
+			Name:         "testJob",
+			startEvent:   events.NonEvent,
+			startsRemain: 1,
+			startConditions: map[events.Event]bool{
+				{events.StatusHealthy, "db"}:       false,
+				{events.ExitSuccess, "migrations"}: false,
+			},
+		}
+		job.processEvent(nil, events.Event{events.StatusHealthy, "db"})
+		job.processEvent(nil, events.Event{events.StatusUnhealthy, "db"})
+		job.processEvent(nil, events.Event{events.ExitSuccess, "migrations"})
+		assert.Equal(t, job.startsRemain, 1,
+			"expected %v starts remaining after db became unhealthy, got %v")
+
+		got := job.processEvent(nil, events.Event{events.StatusHealthy, "db"})
+		assert.False(t, got, "processEvent returned %v after all conditions, expected %v")
+		assert.Equal(t, job.startsRemain, 0,
+			"expected %v starts remaining after all conditions, got %v")
+
+		// a running job ignores the conditions being met again
+		job.setState(stateRunning)
+		got = job.processEvent(nil, events.Event{events.StatusUnhealthy, "db"})
+		assert.False(t, got, "processEvent returned %v after db unhealthy, expected %v")
+		got = job.processEvent(nil, events.Event{events.StatusHealthy, "db"})
+		assert.False(t, got, "processEvent returned %v while running, expected %v")
+		assert.Equal(t, job.startsRemain, 0,
+			"expected %v starts remaining while running, got %v")
+
+		// once it has exited, it quits like a job whose `once` event
+		// fires again
+		job.setState(stateStopped)
+		job.processEvent(nil, events.Event{events.StatusUnhealthy, "db"})
+		got = job.processEvent(nil, events.Event{events.StatusHealthy, "db"})
+		assert.True(t, got, "processEvent returned %v after db healthy again, expected %v")
+	})
+
+}
+
+func TestJobHealthOverride(t *testing.T) {
+	job := &Job{Name: "myjob", healthCheckExec: testCheckExec("check.myjob")}
+	job.Bus = events.NewEventBus()
+
+	job.SetHealthOverride(OverrideFail, 0)
+	job.processEvent(nil, events.Event{events.OverrideHealth, "myjob"})
+	assert.Equal(t, job.getStatus(), statusUnhealthy,
+		"expected job in '%v' status after override but got '%v'")
+
+	// health check results are ignored while the override is in effect
+	job.processEvent(nil, events.Event{events.ExitSuccess, "check.myjob"})
+	assert.Equal(t, job.getStatus(), statusUnhealthy,
+		"expected job in '%v' status after passing check but got '%v'")
+
+	job.SetHealthOverride(OverridePass, time.Millisecond)
+	job.processEvent(nil, events.Event{events.OverrideHealth, "myjob"})
+	assert.Equal(t, job.getStatus(), statusHealthy,
+		"expected job in '%v' status after override but got '%v'")
+	assert.Equal(t, job.Report().HealthOverride, "pass",
+		"expected override '%v' but got '%v'")
+
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, job.Report().HealthOverride, "",
+		"expected override '%v' after ttl but got '%v'")
+	job.processEvent(nil, events.Event{events.ExitFailed, "check.myjob"})
+	assert.Equal(t, job.getStatus(), statusUnhealthy,
+		"expected job in '%v' status after override expired but got '%v'")
+
+	_, err := ParseHealthOverride("sick")
+	assert.Error(t, err,
+		"invalid health override 'sick': accepts 'pass', 'warn', 'fail', or 'clear'")
+}
+
+func TestJobPushgateway(t *testing.T) {
+	type push struct {
+		path    string
+		metrics map[string]float64
+	}
+	pushes := make(chan push, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Method, "POST", "expected method %v but got %v")
+		p := push{path: r.URL.Path, metrics: map[string]float64{}}
+		decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			family := &dto.MetricFamily{}
+			if err := decoder.Decode(family); err != nil {
+				break
+			}
+			p.metrics[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+		}
+		pushes <- p
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	bus := events.NewEventBus()
+	cfg := &Config{Name: "batch", Exec: []string{"sh", "-c", "exit 3"},
+		Pushgateway: &PushgatewayConfig{URL: server.URL, Instance: "host-1"}}
+	if err := cfg.Validate(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := NewJob(cfg)
+	job.Run(bus)
+	bus.Publish(events.GlobalStartup)
+
+	var got push
+	select {
+	case got = <-pushes:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("job didn't push to the pushgateway")
+	}
+	job.Quit()
+	bus.Wait()
+	assert.Equal(t, got.path, "/metrics/job/batch/instance/host-1",
+		"expected push to %v but got %v")
+	assert.Equal(t, got.metrics["containerpilot_job_exit_code"], 3.0,
+		"expected exit code %v but got %v")
+	_, ok := got.metrics["containerpilot_job_duration_seconds"]
+	assert.True(t, ok, "expected duration to be pushed")
+	_, ok = got.metrics["containerpilot_job_last_success_timestamp_seconds"]
+	assert.False(t, ok, "expected no success timestamp for a failed run")
+}
+
+func TestJobHTTPHealthCheck(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte("status: ready"))
+		}))
+	defer server.Close()
+
+	newCheck := func(body string) *probe {
+		cfg := &Config{Name: "web", Health: &HealthConfig{
+			Heartbeat: 1, TTL: 5,
+			HTTP:      &HTTPCheckConfig{URL: server.URL, Body: body},
+		}}
+		if err := cfg.Validate(noop); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return cfg.healthCheckProbe
+	}
+	passed := events.Event{events.ExitSuccess, "check.web"}
+	failed := events.Event{events.ExitFailed, "check.web"}
+	if got := runtestProbe(newCheck("ready")); got[passed] != 1 {
+		t.Fatalf("expected check to pass but got %v", got)
+	}
+	status = http.StatusServiceUnavailable
+	if got := runtestProbe(newCheck("ready")); got[failed] != 1 {
+		t.Fatalf("expected check to fail on status but got %v", got)
+	}
+	status = http.StatusOK
+	if got := runtestProbe(newCheck("healthy")); got[failed] != 1 {
+		t.Fatalf("expected check to fail on body but got %v", got)
+	}
+}
+
+func TestJobTCPHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	address := ln.Addr().String()
+	newCheck := func() *probe {
+		cfg := &Config{Name: "db", Health: &HealthConfig{
+			Heartbeat: 1, TTL: 5,
+			TCP:       &TCPCheckConfig{Address: address},
+		}}
+		if err := cfg.Validate(noop); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return cfg.healthCheckProbe
+	}
+	passed := events.Event{events.ExitSuccess, "check.db"}
+	failed := events.Event{events.ExitFailed, "check.db"}
+	if got := runtestProbe(newCheck()); got[passed] != 1 {
+		t.Fatalf("expected check to pass but got %v", got)
+	}
+	ln.Close()
+	if got := runtestProbe(newCheck()); got[failed] != 1 {
+		t.Fatalf("expected check to fail once the port is closed but got %v", got)
+	}
+}
+
+func TestJobGRPCHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server := grpc.NewServer()
+	health := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, health)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	health.SetServingStatus("api.v1.Orders", healthpb.HealthCheckResponse_SERVING)
+	cfg := &Config{Name: "api", Health: &HealthConfig{
+		Heartbeat: 1, TTL: 5,
+		GRPC: &GRPCCheckConfig{
+			Address: ln.Addr().String(), Service: "api.v1.Orders"},
+	}}
+	if err := cfg.Validate(noop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	passed := events.Event{events.ExitSuccess, "check.api"}
+	failed := events.Event{events.ExitFailed, "check.api"}
+	if got := runtestProbe(cfg.healthCheckProbe); got[passed] != 1 {
+		t.Fatalf("expected check to pass but got %v", got)
+	}
+	health.SetServingStatus("api.v1.Orders", healthpb.HealthCheckResponse_NOT_SERVING)
+	if got := runtestProbe(cfg.healthCheckProbe); got[failed] != 1 {
+		t.Fatalf("expected check to fail when not serving but got %v", got)
+	}
+}
+
+// testCheckExec creates a health check exec that's never run, for tests
+// that publish its events themselves
+func testCheckExec(name string) *commands.Command {
+	cmd, _ := commands.NewCommand("true", time.Duration(0), nil)
+	cmd.Name = name
+	return cmd
+}
+
+func TestJobCompositeHealthCheck(t *testing.T) {
+	newCheck := func(require interface{}) *probe {
+		cfg := &Config{Name: "app", Health: &HealthConfig{
+			Heartbeat: 1, TTL: 5, Require: require,
+			Checks: []*NamedCheckConfig{
+				{Name: "api", Exec: "true"},
+				{Name: "lag", Exec: []interface{}{
+					"sh", "-c", "echo lag is 5000; exit 1"}},
+			},
+		}}
+		if err := cfg.Validate(noop); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return cfg.healthCheckProbe
+	}
+	passed := events.Event{events.ExitSuccess, "check.app"}
+	failed := events.Event{events.ExitFailed, "check.app"}
+
+	check := newCheck("all")
+	if got := runtestProbe(check); got[failed] != 1 {
+		t.Fatalf("expected 'all' check to fail but got %v", got)
+	}
+	assert.Equal(t, check.lastOutput(),
+		"passed weight 1 of 2 required (lag: exit status 1: lag is 5000)",
+		"expected output %q but got %q")
+	if got := runtestProbe(newCheck("any")); got[passed] != 1 {
+		t.Fatalf("expected 'any' check to pass but got %v", got)
+	}
+
+	// the execs of the checks run like the job's health check exec does
+	cfg := &Config{Name: "app", Exec: "true", Umask: "077", Health: &HealthConfig{
+		Heartbeat: 1, TTL: 5,
+		Checks: []*NamedCheckConfig{{Name: "umask", Exec: []interface{}{
+			"sh", "-c", `test "$(umask)" = 0077`}}},
+	}}
+	if err := cfg.Validate(noop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := runtestProbe(cfg.healthCheckProbe); got[passed] != 1 {
+		t.Fatalf("expected check to run with the job's umask but got %v", got)
+	}
+}
+
+// runtestProbe runs the probe once and counts the events it publishes
+func runtestProbe(check *probe) map[events.Event]int {
+	bus := events.NewEventBus()
+	check.Run(context.Background(), bus)
+	time.Sleep(100 * time.Millisecond)
+	bus.Wait()
+	got := map[events.Event]int{}
+	for _, result := range bus.DebugEvents() {
+		got[result]++
+	}
+	return got
+}
+
+func TestJobCheckResult(t *testing.T) {
+	cfg := &Config{Name: "db", Health: &HealthConfig{
+		Heartbeat: 1, TTL: 5,
+		CheckExec: []interface{}{"sh", "-c", "echo connection refused; exit 2"},
+	}}
+	if err := cfg.Validate(noop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := NewJob(cfg)
+	job.Bus = events.NewEventBus()
+	assert.True(t, job.LastCheck() == nil, "expected no result before the check runs")
+
+	job.HealthCheck(context.Background())
+	time.Sleep(100 * time.Millisecond)
+	job.Bus.Wait()
+	job.processEvent(nil, events.Event{events.ExitFailed, "check.db"})
+	result := job.LastCheck()
+	if result == nil || result.Passed || result.ExitCode == nil {
+		t.Fatalf("expected failed result with exit code but got %+v", result)
+	}
+	assert.Equal(t, *result.ExitCode, 2, "expected exit code %v but got %v")
+	assert.Equal(t, result.Output, "connection refused", "expected output %q but got %q")
+	assert.Equal(t, result.note(), "exit status 2: connection refused",
+		"expected note %q but got %q")
+	assert.Equal(t, job.Report().LastCheck, result, "expected report %v but got %v")
+
+	passed := &CheckResult{Passed: true}
+	assert.Equal(t, passed.note(), "ok", "expected note %q but got %q")
+}
+
+func TestJobCheckThresholds(t *testing.T) {
+	job := &Job{Name: "myjob", healthCheckExec: testCheckExec("check.myjob"),
+		thresholds: checkThresholds{failuresBeforeCritical: 2, successesBeforePassing: 3}}
+	job.Bus = events.NewEventBus()
+	job.setStatus(statusHealthy)
+	passed := events.Event{events.ExitSuccess, "check.myjob"}
+	failed := events.Event{events.ExitFailed, "check.myjob"}
+	expectStatus := func(status jobStatus, msg string) {
+		assert.Equal(t, job.getStatus(), status, "expected '%v' but got '%v' "+msg)
+	}
+
+	job.processEvent(nil, failed)
+	expectStatus(statusHealthy, "after a single failure")
+	job.processEvent(nil, passed)
+	job.processEvent(nil, failed)
+	expectStatus(statusHealthy, "after a pass resets the failures")
+	job.processEvent(nil, failed)
+	expectStatus(statusUnhealthy, "after consecutive failures")
+
+	job.processEvent(nil, passed)
+	job.processEvent(nil, passed)
+	expectStatus(statusUnhealthy, "before enough passes")
+	job.processEvent(nil, passed)
+	expectStatus(statusHealthy, "after consecutive passes")
+}
+
+func TestJobCheckInitialDelay(t *testing.T) {
+	job := &Job{Name: "myjob", healthCheckExec: testCheckExec("check.myjob"),
+		thresholds: checkThresholds{initialDelay: time.Hour}}
+	job.Bus = events.NewEventBus()
+	job.thresholds.startGracePeriod()
+
+	job.processEvent(nil, events.Event{events.ExitFailed, "check.myjob"})
+	assert.Equal(t, job.getStatus(), statusUnknown,
+		"expected '%v' status after failure during initial delay but got '%v'")
+	job.processEvent(nil, events.Event{events.ExitSuccess, "check.myjob"})
+	assert.Equal(t, job.getStatus(), statusHealthy,
+		"expected '%v' status after pass during initial delay but got '%v'")
+
+	job.thresholds.graceUntil = time.Now()
+	job.processEvent(nil, events.Event{events.ExitFailed, "check.myjob"})
+	assert.Equal(t, job.getStatus(), statusUnhealthy,
+		"expected '%v' status after failure once delay ends but got '%v'")
+}
+
+func TestJobCheckFlapping(t *testing.T) {
+	job := &Job{Name: "myjob", healthCheckExec: testCheckExec("check.myjob"),
+		flapping: &flapDetector{transitions: 2, window: time.Hour}}
+	job.Bus = events.NewEventBus()
+	passed := events.Event{events.ExitSuccess, "check.myjob"}
+	failed := events.Event{events.ExitFailed, "check.myjob"}
+
+	for _, result := range []events.Event{passed, failed, passed} {
+		job.processEvent(nil, result)
+	}
+	assert.Equal(t, job.getStatus(), statusHealthy,
+		"expected '%v' status before flapping but got '%v'")
+	job.processEvent(nil, failed)
+	job.processEvent(nil, passed)
+	job.processEvent(nil, failed)
+	assert.True(t, job.flapping.flapping, "expected check to be flapping")
+	assert.Equal(t, job.getStatus(), statusHealthy,
+		"expected '%v' status held while flapping but got '%v'")
+	job.Bus.Wait()
+	flaps := 0
+	for _, event := range job.Bus.DebugEvents() {
+		if event == (events.Event{events.Flapping, "myjob"}) {
+			flaps++
+		}
+	}
+	assert.Equal(t, flaps, 1, "expected %v flapping event but got %v")
+
+	// the health has been steady for the whole window
+	for i := range job.flapping.changes {
+		job.flapping.changes[i] = time.Now().Add(-2 * time.Hour)
+	}
+	job.processEvent(nil, failed)
+	assert.False(t, job.flapping.flapping, "expected check to stop flapping")
+	assert.Equal(t, job.getStatus(), statusUnhealthy,
+		"expected '%v' status once steady but got '%v'")
+}
+
+func TestJobCheckMetrics(t *testing.T) {
+	job := &Job{Name: "metered", healthCheckExec: testCheckExec("check.metered")}
+	job.Bus = events.NewEventBus()
+
+	job.checkStarted = time.Now().Add(-2 * time.Second)
+	job.processEvent(nil, events.Event{events.ExitSuccess, "check.metered"})
+	job.processEvent(nil, events.Event{events.ExitFailed, "check.metered"})
+	job.processEvent(nil, events.Event{events.ExitFailed, "check.metered"})
+
+	assert.Equal(t,
+		testutil.ToFloat64(checkResults.WithLabelValues("check.metered", "pass")), 1.0,
+		"expected %v passed checks but got %v")
+	assert.Equal(t,
+		testutil.ToFloat64(checkResults.WithLabelValues("check.metered", "fail")), 2.0,
+		"expected %v failed checks but got %v")
+	m := &dto.Metric{}
+	checkDuration.WithLabelValues("check.metered").(prometheus.Histogram).Write(m)
+	assert.Equal(t, m.GetHistogram().GetSampleCount(), uint64(1),
+		"expected %v timed check but got %v")
+	assert.True(t, m.GetHistogram().GetSampleSum() >= 2,
+		"expected check duration to be at least 2s")
+}
+
+func TestJobRestartBackoff(t *testing.T) {
+	rb := &restartBackoff{
+		initial:    time.Millisecond,
+		multiplier: 2,
+		max:        5 * time.Millisecond,
+		jitter:     0.5,
+		maxRetries: 2,
+		random:     func() float64 { return 0 },
+	}
+	assert.Equal(t, rb.delay(0), time.Millisecond, "expected delay %v but got %v")
+	assert.Equal(t, rb.delay(2), 4*time.Millisecond, "expected delay %v but got %v")
+	assert.Equal(t, rb.delay(5), 5*time.Millisecond, "expected delay %v but got %v")
+	rb.random = func() float64 { return 1 }
+	assert.Equal(t, rb.delay(1), time.Millisecond, "expected delay %v but got %v")
+	rb.random = func() float64 { return 0 }
+
+	job := &Job{
+		Name:           "myjob",
+		restartLimit:   unlimited,
+		restartsRemain: unlimited,
+		restartBackoff: rb,
+	}
+	job.Rx = make(chan events.Event, eventBufferSize)
+	job.Bus = events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	expectRestart := func() {
+		job.processEvent(ctx, events.Event{events.ExitFailed, "myjob"})
+		assert.True(t, job.restartPending, "expected restart pending %v but got %v")
+		select {
+		case event := <-job.Rx:
+			assert.Equal(t, event,
+				events.Event{events.TimerExpired, "myjob.restart-backoff"},
+				"expected %v but got %v")
+			job.processEvent(ctx, event)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for restart")
+		}
+		assert.False(t, job.restartPending, "expected restart pending %v but got %v")
+	}
+	expectRestart()
+	expectRestart()
+	assert.Equal(t, job.Report().Restarts, 2, "expected %v restarts but got %v")
+
+	job.processEvent(ctx, events.Event{events.ExitFailed, "myjob"})
+	assert.False(t, job.restartPending, "expected restart pending %v but got %v")
+	assert.Equal(t, job.Report().State, "failed", "expected state %v but got %v")
+
+	// starting the job from the control plane resets the backoff
+	job.processEvent(ctx, events.Event{events.Start, "myjob"})
+	assert.Equal(t, job.restartAttempts, 0, "expected %v attempts but got %v")
+}
+
+func TestJobsReload(t *testing.T) {
+	newJobs := func(raw string) []*Config {
+		cfgs, err := NewConfigs(tests.DecodeRawToSlice(raw), noop)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return cfgs
+	}
+	names := func(jobList []*Job) []string {
+		names := []string{}
+		for _, job := range jobList {
+			names = append(names, job.Name)
+		}
+		return names
+	}
+	running := FromConfigs(newJobs(`[
+	{name: "setup", exec: "true"},
+	{name: "db", exec: "db", when: {source: "setup", once: "exitSuccess"}},
+	{name: "app", exec: "app", env: {A: "1"}},
+	{name: "drain", exec: "drain", when: {source: "app", once: "stopping"}},
+	{name: "old", exec: "old"}]`))
+	running[0].done = true // setup has already run
+
+	jobList, stop, start := Reload(running, newJobs(`[
+	{name: "setup", exec: "true"},
+	{name: "db", exec: "db", when: {source: "setup", once: "exitSuccess"}},
+	{name: "app", exec: "app", env: {A: "2"}},
+	{name: "drain", exec: "drain", when: {source: "app", once: "stopping"}},
+	{name: "new", exec: "new"}]`))
+	assert.Equal(t, names(jobList), []string{"setup", "db", "app", "drain", "new"},
+		"expected jobs %v but got %v")
+	// drain is stopped along with app, which waits for it to stop
+	assert.Equal(t, names(stop), []string{"app", "drain", "old"},
+		"expected to stop %v but got %v")
+	assert.Equal(t, names(start), []string{"app", "drain", "new"},
+		"expected to start %v but got %v")
+	if jobList[0] != running[0] || jobList[1] != running[1] {
+		t.Fatalf("expected unchanged jobs to keep running")
+	}
+
+	// db waits for setup, which has already run, so it runs again too
+	_, stop, start = Reload(running, newJobs(`[
+	{name: "setup", exec: "true"},
+	{name: "db", exec: "db --new", when: {source: "setup", once: "exitSuccess"}},
+	{name: "app", exec: "app", env: {A: "1"}},
+	{name: "drain", exec: "drain", when: {source: "app", once: "stopping"}},
+	{name: "old", exec: "old"}]`))
+	assert.Equal(t, names(stop), []string{"setup", "db"},
+		"expected to stop %v but got %v")
+	assert.Equal(t, names(start), []string{"setup", "db"},
+		"expected to start %v but got %v")
 }
@@ -0,0 +1,123 @@
+package jobs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joyent/containerpilot/commands"
+)
+
+// CgroupConfig configures the cgroup v2 limits of a job's process
+type CgroupConfig struct {
+	CPUs   float64 `mapstructure:"cpus"`
+	Memory string  `mapstructure:"memory"`
+}
+
+// cpuPeriod is the period of cpu.max in microseconds
+const cpuPeriod = 100000
+
+func (cfg *Config) validateLimits() error {
+	if len(cfg.Rlimits) == 0 && cfg.Cgroup == nil {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].rlimits and cgroup require 'exec'", cfg.Name)
+	}
+	names := make([]string, 0, len(cfg.Rlimits))
+	for name := range cfg.Rlimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rlimit, err := commands.ParseRlimit(name, cfg.Rlimits[name])
+		if err != nil {
+			return fmt.Errorf("job[%s].rlimits: %v", cfg.Name, err)
+		}
+		cfg.exec.Rlimits = append(cfg.exec.Rlimits, rlimit)
+	}
+	if cfg.Cgroup == nil {
+		return nil
+	}
+	if strings.Contains(cfg.Name, "/") {
+		return fmt.Errorf("job[%s].cgroup requires a name without '/'", cfg.Name)
+	}
+	if cfg.Cgroup.CPUs == 0 && cfg.Cgroup.Memory == "" {
+		return fmt.Errorf("job[%s].cgroup requires 'cpus' or 'memory'", cfg.Name)
+	}
+	cgroup := &commands.Cgroup{Name: cfg.Name}
+	if cfg.Cgroup.CPUs < 0 {
+		return fmt.Errorf("job[%s].cgroup.cpus must be positive", cfg.Name)
+	}
+	if cfg.Cgroup.CPUs > 0 {
+		quota := int(cfg.Cgroup.CPUs * cpuPeriod)
+		if quota < 1000 {
+			quota = 1000 // the kernel's minimum
+		}
+		cgroup.CPUMax = fmt.Sprintf("%d %d", quota, cpuPeriod)
+	}
+	if cfg.Cgroup.Memory != "" {
+		memory, err := parseMemory(cfg.Cgroup.Memory)
+		if err != nil {
+			return fmt.Errorf("job[%s].cgroup.memory '%s' must be a size such as '256m'",
+				cfg.Name, cfg.Cgroup.Memory)
+		}
+		cgroup.MemoryMax = strconv.FormatUint(memory, 10)
+	}
+	cfg.exec.Cgroup = cgroup
+	return nil
+}
+
+// parseMemory parses a size in bytes with an optional binary suffix of
+// k, m, g, or t
+func parseMemory(size string) (uint64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	multiplier := uint64(1)
+	if size != "" {
+		switch size[len(size)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			size = size[:len(size)-1]
+		}
+	}
+	value, err := strconv.ParseUint(size, 10, 64)
+	if err != nil || value == 0 {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return value * multiplier, nil
+}
+
+// LimitExecs limits the health checks and tasks of the jobs with the
+// limiter, after any limiter of the job's group
+func LimitExecs(cfgs []*Config, limiter *commands.Limiter) {
+	for _, cfg := range cfgs {
+		cfg.addLimiter(limiter)
+	}
+}
+
+// addLimiter limits the job's health checks, and its exec if the job is a
+// task that runs over and over, such as on an interval or on each change
+// of a watch. Long-running processes would hold their turn forever, so
+// they're never limited.
+func (cfg *Config) addLimiter(limiter *commands.Limiter) {
+	if limiter == nil {
+		return
+	}
+	for _, cmd := range cfg.checkCommands() {
+		cmd.Limiters = append(cmd.Limiters, limiter)
+	}
+	isTask := cfg.freqInterval > 0 || cfg.schedule != nil ||
+		cfg.whenStartsLimit == unlimited
+	if cfg.exec != nil && isTask {
+		cfg.exec.Limiters = append(cfg.exec.Limiters, limiter)
+	}
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// defaultLogFileSize is the size at which a log file is rotated if the
+// config doesn't give one
+const defaultLogFileSize = "100m"
+
+// defaultLogFileBackups is the number of rotated files kept if the config
+// doesn't give one, so that a noisy job can't fill the disk
+const defaultLogFileBackups = 10
+
+// LogFileConfig writes the output of the job's exec to a file instead of
+// ContainerPilot's own log, rotating it by size and optionally by time
+type LogFileConfig struct {
+	Path       string `mapstructure:"path"`
+	MaxSize    string `mapstructure:"maxSize"`
+	Interval   string `mapstructure:"interval"`
+	MaxBackups int    `mapstructure:"maxBackups"`
+	Compress   bool   `mapstructure:"compress"`
+	Tee        bool   `mapstructure:"tee"` // also write to our own log
+}
+
+func (cfg *Config) validateLogFile() error {
+	logFile := cfg.LogFile
+	if logFile == nil {
+		return nil
+	}
+	if cfg.exec == nil {
+		return fmt.Errorf("job[%s].logFile requires 'exec'", cfg.Name)
+	}
+	if logFile.Path == "" || !filepath.IsAbs(logFile.Path) {
+		return fmt.Errorf("job[%s].logFile.path must be an absolute path", cfg.Name)
+	}
+	if logFile.MaxSize == "" {
+		logFile.MaxSize = defaultLogFileSize
+	}
+	size, err := parseMemory(logFile.MaxSize)
+	if err != nil {
+		return fmt.Errorf("job[%s].logFile.maxSize '%s' must be a size such as '100m'",
+			cfg.Name, logFile.MaxSize)
+	}
+	// the file is rotated in whole megabytes
+	megabytes := int((size + 1<<20 - 1) >> 20)
+	interval, err := utils.GetTimeout(logFile.Interval)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].logFile.interval: %v", cfg.Name, err)
+	}
+	if interval < 0 {
+		return fmt.Errorf("job[%s].logFile.interval must be positive", cfg.Name)
+	}
+	if logFile.MaxBackups < 0 {
+		return fmt.Errorf("job[%s].logFile.maxBackups must be positive", cfg.Name)
+	}
+	if logFile.MaxBackups == 0 {
+		logFile.MaxBackups = defaultLogFileBackups
+	}
+	cfg.exec.LogTo(commands.NewLogFile(logFile.Path, megabytes, interval,
+		logFile.MaxBackups, logFile.Compress), logFile.Tee)
+	return nil
+}
+
+// OutputConfig configures how each line of the output of the job's exec
+// and health check is written to ContainerPilot's own log
+type OutputConfig struct {
+	Raw    bool     `mapstructure:"raw"`
+	Prefix string   `mapstructure:"prefix"`
+	Fields []string `mapstructure:"fields"`
+}
+
+func (cfg *Config) validateOutput() error {
+	if cfg.Output == nil {
+		return nil
+	}
+	if cfg.exec == nil && len(cfg.checkCommands()) == 0 {
+		return fmt.Errorf("job[%s].output requires 'exec' or 'health'", cfg.Name)
+	}
+	if cfg.Output.Raw && len(cfg.Output.Fields) > 0 {
+		return fmt.Errorf("job[%s].output.fields can't be used with 'raw'", cfg.Name)
+	}
+	for _, field := range cfg.Output.Fields {
+		if !isOutputField(field) {
+			return fmt.Errorf("job[%s].output.fields has unknown field '%s'", cfg.Name, field)
+		}
+	}
+	output := &commands.Output{
+		Raw:    cfg.Output.Raw,
+		Prefix: cfg.Output.Prefix,
+		Fields: cfg.Output.Fields,
+	}
+	for _, cmd := range append([]*commands.Command{cfg.exec}, cfg.checkCommands()...) {
+		if cmd != nil {
+			cmd.Output = output
+			if cmd.PreStop != nil {
+				cmd.PreStop.Output = output
+			}
+			if cmd.PostStop != nil {
+				cmd.PostStop.Output = output
+			}
+		}
+	}
+	return nil
+}
+
+func isOutputField(field string) bool {
+	for _, f := range commands.OutputFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics recorded for every health check, so that health trends are
+// visible on the telemetry endpoint without instrumenting the checks
+var (
+	checkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "containerpilot",
+		Subsystem: "check",
+		Name:      "duration_seconds",
+		Help:      "Duration of health check runs, by check name.",
+	}, []string{"check"})
+
+	checkResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "containerpilot",
+		Subsystem: "check",
+		Name:      "results_total",
+		Help:      "Number of health check runs, by check name and result.",
+	}, []string{"check", "result"})
+
+	checkFlapping = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "containerpilot",
+		Subsystem: "check",
+		Name:      "flapping",
+		Help:      "Whether the health of the job is held because its check is flapping, by check name.",
+	}, []string{"check"})
+)
+
+func init() {
+	prometheus.MustRegister(checkDuration, checkResults, checkFlapping)
+}
+
+// recordCheck records the outcome of the health check run that just
+// finished. Runs that we didn't see start aren't timed.
+func (job *Job) recordCheck(passed bool) {
+	name := job.checkName()
+	if name == "" {
+		return
+	}
+	result := "fail"
+	if passed {
+		result = "pass"
+	}
+	checkResults.WithLabelValues(name, result).Inc()
+	if !job.checkStarted.IsZero() {
+		checkDuration.WithLabelValues(name).Observe(
+			time.Since(job.checkStarted).Seconds())
+		job.checkStarted = time.Time{}
+	}
+}
@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+// HealthOverride is a health outcome forced on a Job via the control plane,
+// which takes the place of its health check until it expires or is cleared.
+type HealthOverride string
+
+// HealthOverride values
+const (
+	OverrideNone HealthOverride = ""
+	OverridePass HealthOverride = "pass"
+	OverrideWarn HealthOverride = "warn"
+	OverrideFail HealthOverride = "fail"
+)
+
+// ParseHealthOverride validates the name of a HealthOverride. The name
+// "clear" removes any existing override.
+func ParseHealthOverride(name string) (HealthOverride, error) {
+	switch name {
+	case "pass", "warn", "fail":
+		return HealthOverride(name), nil
+	case "clear":
+		return OverrideNone, nil
+	}
+	return OverrideNone, fmt.Errorf(
+		"invalid health override '%s': accepts 'pass', 'warn', 'fail', or 'clear'",
+		name)
+}
+
+// SetHealthOverride forces the health of the Job until the ttl expires, or
+// indefinitely if the ttl is zero. The override takes effect when the Job
+// receives the OverrideHealth event.
+func (job *Job) SetHealthOverride(override HealthOverride, ttl time.Duration) {
+	job.statusLock.Lock()
+	defer job.statusLock.Unlock()
+	job.override = override
+	job.overrideExpires = time.Time{}
+	if override != OverrideNone && ttl > 0 {
+		job.overrideExpires = time.Now().Add(ttl)
+	}
+}
+
+// getHealthOverride returns the current override, clearing it first if
+// it has expired
+func (job *Job) getHealthOverride() HealthOverride {
+	job.statusLock.Lock()
+	defer job.statusLock.Unlock()
+	if !job.overrideExpires.IsZero() && time.Now().After(job.overrideExpires) {
+		job.override = OverrideNone
+		job.overrideExpires = time.Time{}
+	}
+	return job.override
+}
+
+// applyHealthOverride reports the overridden health to discovery and
+// publishes the resulting status just as a health check would
+func (job *Job) applyHealthOverride(override HealthOverride) {
+	note := fmt.Sprintf("health set to '%s' via containerpilot control plane",
+		override)
+	switch override {
+	case OverridePass:
+		job.setStatus(statusHealthy)
+		job.Bus.Publish(events.Event{events.StatusHealthy, job.Name})
+		job.SendHeartbeat()
+	case OverrideWarn:
+		// a warning still receives traffic in Consul's default configuration
+		job.setStatus(statusHealthy)
+		job.Bus.Publish(events.Event{events.StatusHealthy, job.Name})
+		for _, service := range job.services() {
+			service.SendWarning(note)
+		}
+	case OverrideFail:
+		job.setStatus(statusUnhealthy)
+		job.Bus.Publish(events.Event{events.StatusUnhealthy, job.Name})
+		for _, service := range job.services() {
+			service.SendFailure(note)
+		}
+	}
+}
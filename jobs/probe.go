@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/events"
+)
+
+// checkFunc makes a health check, returning an error if it failed
+type checkFunc func(ctx context.Context) error
+
+// probe is a health check that ContainerPilot makes itself, such as an
+// HTTP request, rather than by running a process. Like a health check
+// exec, each run publishes an ExitSuccess or ExitFailed event with the
+// probe's name as the source.
+type probe struct {
+	name    string
+	timeout time.Duration
+	check   checkFunc
+	running int32 // accessed atomically, set while a check is in flight
+
+	output     string // the error of the last check, if it failed
+	outputLock sync.Mutex
+}
+
+// newProbe creates the probe for a Config's health check. A check that
+// never completes would keep the probe from ever running again, so the
+// timeout defaults to the interval.
+func (cfg *Config) newProbe(timeout time.Duration, check checkFunc) *probe {
+	if timeout == 0 {
+		timeout = cfg.heartbeatInterval
+	}
+	return &probe{name: "check." + cfg.Name, timeout: timeout, check: check}
+}
+
+// newCheck validates whichever of the http, tcp, or grpc checks is set at
+// the field of the job's configuration, such as "health", and returns the
+// check, or nil if none are set
+func (cfg *Config) newCheck(field string, http *HTTPCheckConfig,
+	tcp *TCPCheckConfig, grpc *GRPCCheckConfig) (checkFunc, error) {
+	switch {
+	case http != nil:
+		return cfg.newHTTPCheck(field+".http", http)
+	case tcp != nil:
+		return cfg.newTCPCheck(field+".tcp", tcp)
+	case grpc != nil:
+		return cfg.newGRPCCheck(field+".grpc", grpc)
+	}
+	return nil, nil
+}
+
+// Run makes the check in a goroutine and publishes its outcome. A run is
+// skipped if the previous check hasn't finished yet.
+func (p *probe) Run(ctx context.Context, bus *events.EventBus) {
+	if !atomic.CompareAndSwapInt32(&p.running, 0, 1) {
+		log.Debugf("%s still running, skipping", p.name)
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&p.running, 0)
+		ctx, cancel := context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+		err := p.check(ctx)
+		p.setOutput(err)
+		if err != nil {
+			log.Errorf("%s failed: %v", p.name, err)
+			bus.Publish(events.Event{events.ExitFailed, p.name})
+			bus.Publish(events.Event{events.Error,
+				fmt.Sprintf("%s: %v", p.name, err)})
+			return
+		}
+		log.Debugf("%s passed", p.name)
+		bus.Publish(events.Event{events.ExitSuccess, p.name})
+	}()
+}
+
+func (p *probe) setOutput(err error) {
+	p.outputLock.Lock()
+	defer p.outputLock.Unlock()
+	p.output = ""
+	if err != nil {
+		p.output = err.Error()
+	}
+}
+
+// lastOutput returns the error of the last check, or "" if it passed
+func (p *probe) lastOutput() string {
+	p.outputLock.Lock()
+	defer p.outputLock.Unlock()
+	return p.output
+}
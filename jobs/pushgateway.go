@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// PushgatewayConfig configures pushing the results of each run of a job
+// to a Prometheus Pushgateway, for jobs that exit before they can be
+// scraped
+type PushgatewayConfig struct {
+	URL      string   `mapstructure:"url"`
+	Instance string   `mapstructure:"instance"`
+	Metrics  []string `mapstructure:"metrics"`
+}
+
+// pushgateway pushes the metrics of a job's runs, grouped by the job name
+// and instance
+type pushgateway struct {
+	url      string
+	job      string
+	instance string
+	metrics  map[string]bool // telemetry metrics to include
+
+	client   *http.Client
+	gatherer prometheus.Gatherer
+}
+
+func (cfg *Config) validatePushgateway() error {
+	if cfg.Pushgateway == nil {
+		return nil
+	}
+	if cfg.Exec == nil {
+		return fmt.Errorf("job[%s].pushgateway requires 'exec'", cfg.Name)
+	}
+	pg := cfg.Pushgateway
+	endpoint, err := url.Parse(pg.URL)
+	if pg.URL == "" || err != nil || endpoint.Host == "" {
+		return fmt.Errorf("job[%s].pushgateway.url '%s' must be a URL",
+			cfg.Name, pg.URL)
+	}
+	if pg.Instance == "" {
+		pg.Instance, _ = os.Hostname()
+	}
+	if strings.Contains(pg.Instance, "/") {
+		return fmt.Errorf("job[%s].pushgateway.instance '%s' must not contain '/'",
+			cfg.Name, pg.Instance)
+	}
+	metrics := map[string]bool{}
+	for _, name := range pg.Metrics {
+		metrics[name] = true
+	}
+	cfg.pushgateway = &pushgateway{
+		url:      pg.URL,
+		job:      cfg.Name,
+		instance: pg.Instance,
+		metrics:  metrics,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		gatherer: prometheus.DefaultGatherer,
+	}
+	return nil
+}
+
+// push sends the duration and exit code of a run, along with the
+// configured telemetry metrics. Pushes use POST so that the timestamp of
+// the last successful run isn't replaced by a failed run.
+func (pg *pushgateway) push(duration time.Duration, exitCode int, now time.Time) error {
+	registry := prometheus.NewRegistry()
+	gauge := func(name, help string, value float64) {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "containerpilot",
+			Subsystem: "job",
+			Name:      name,
+			Help:      help,
+		})
+		g.Set(value)
+		registry.MustRegister(g)
+	}
+	gauge("duration_seconds", "Duration of the last run of the job.",
+		duration.Seconds())
+	gauge("exit_code", "Exit code of the last run of the job.",
+		float64(exitCode))
+	gauge("last_completion_timestamp_seconds",
+		"Time the last run of the job finished.", float64(now.Unix()))
+	if exitCode == 0 {
+		gauge("last_success_timestamp_seconds",
+			"Time the last successful run of the job finished.",
+			float64(now.Unix()))
+	}
+	return push.New(pg.url, pg.job).
+		Client(pg.client).
+		Grouping("instance", pg.instance).
+		Gatherer(prometheus.Gatherers{registry, prometheus.GathererFunc(pg.gather)}).
+		Add()
+}
+
+// gather returns the telemetry metrics to include with the push
+func (pg *pushgateway) gather() ([]*dto.MetricFamily, error) {
+	if len(pg.metrics) == 0 {
+		return nil, nil
+	}
+	families, err := pg.gatherer.Gather()
+	selected := []*dto.MetricFamily{}
+	for _, family := range families {
+		if pg.metrics[family.GetName()] {
+			selected = append(selected, family)
+		}
+	}
+	return selected, err
+}
+
+// pushRun pushes the results of the run that just finished, if the job
+// pushes to a Pushgateway. This blocks the job's event loop so that a
+// container doesn't exit before the push of its last run completes.
+func (job *Job) pushRun() {
+	if job.pushgateway == nil || job.runStarted.IsZero() {
+		return
+	}
+	now := time.Now()
+	err := job.pushgateway.push(now.Sub(job.runStarted), job.exec.ExitCode(), now)
+	if err != nil {
+		log.Warnf("job[%s]: unable to push metrics: %v", job.Name, err)
+	}
+}
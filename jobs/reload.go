@@ -0,0 +1,136 @@
+package jobs
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+// Reload compares the running Jobs with the Configs of a reloaded
+// configuration. It returns the Jobs of the reloaded configuration, the
+// running Jobs that have to be stopped, and the new Jobs that have to be
+// started in their place. A Job whose definition hasn't changed keeps
+// running, unless it has to be restarted along with another Job:
+//
+//   - a Job that waits for another Job to stop before stopping itself is
+//     stopped along with that Job, so that it doesn't wait forever
+//   - a Job that's started waits for the events in its 'when', so a Job
+//     it waits for that has already finished, or has already started if
+//     it waits for that, has to run again too
+func Reload(running []*Job, cfgs []*Config) (jobs, stop, start []*Job) {
+	runningByName := make(map[string]*Job, len(running))
+	for _, job := range running {
+		runningByName[job.Name] = job
+	}
+	cfgsByName := make(map[string]*Config, len(cfgs))
+	restart := make(map[string]bool)
+	for _, cfg := range cfgs {
+		cfgsByName[cfg.Name] = cfg
+		job, ok := runningByName[cfg.Name]
+		if !ok || !job.sameDefinition(cfg) {
+			restart[cfg.Name] = true
+		}
+	}
+	for name := range runningByName {
+		if _, ok := cfgsByName[name]; !ok {
+			restart[name] = true
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		needs := func(name string) {
+			if _, ok := runningByName[name]; ok && !restart[name] {
+				restart[name] = true
+				changed = true
+			}
+		}
+		for name := range restart {
+			if job, ok := runningByName[name]; ok &&
+				job.stoppingWaitEvent.Code == events.Stopped {
+				needs(job.stoppingWaitEvent.Source)
+			}
+			cfg, ok := cfgsByName[name]
+			if !ok {
+				continue
+			}
+			for _, event := range cfg.startEvents() {
+				if dep, ok := runningByName[event.Source]; ok &&
+					dep.hasRun(event.Code) {
+					needs(dep.Name)
+				}
+			}
+		}
+	}
+
+	for _, job := range running {
+		if restart[job.Name] {
+			stop = append(stop, job)
+		}
+	}
+	for _, cfg := range cfgs {
+		if !restart[cfg.Name] {
+			jobs = append(jobs, runningByName[cfg.Name])
+			continue
+		}
+		job := NewJob(cfg)
+		jobs = append(jobs, job)
+		start = append(start, job)
+	}
+	return jobs, stop, start
+}
+
+// QuitJobs stops each of the Jobs and blocks until they've all stopped.
+// The Jobs are stopped at the same time so that any that wait for another
+// to stop can do so.
+func QuitJobs(jobs []*Job) {
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		if job.Done() {
+			continue
+		}
+		wg.Add(1)
+		go func(job *Job) {
+			defer wg.Done()
+			job.Quit()
+		}(job)
+	}
+	wg.Wait()
+}
+
+// sameDefinition returns true if the Job was created from the same
+// configuration as the Config, including the contents of any envFile
+func (job *Job) sameDefinition(cfg *Config) bool {
+	if !reflect.DeepEqual(job.definition, cfg.definition) ||
+		job.stoppingWaitEvent != cfg.stoppingWaitEvent {
+		return false
+	}
+	if job.exec == nil || cfg.exec == nil {
+		return job.exec == nil && cfg.exec == nil
+	}
+	return reflect.DeepEqual(job.exec.Env, cfg.exec.Env)
+}
+
+// startEvents returns the events that a Job for the Config waits for
+// before it starts
+func (cfg *Config) startEvents() []events.Event {
+	return append([]events.Event{cfg.whenEvent}, cfg.whenConditions...)
+}
+
+// hasRun returns true if the Job won't publish an event with the code
+// again without being restarted, because its event loop has exited or
+// it's already running its process for a Started event
+func (job *Job) hasRun(code events.EventCode) bool {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	return job.done || (code == events.Started && job.state == stateRunning)
+}
+
+// Done returns true if the Job's event loop has exited, after which the
+// Job doesn't handle any more events, such as those of the control plane
+func (job *Job) Done() bool {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	return job.done
+}
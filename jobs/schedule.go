@@ -0,0 +1,181 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/events"
+	"github.com/robfig/cron"
+)
+
+// policies for an 'interval' or 'schedule' run that's due while the
+// previous run is still running
+const (
+	overlapSkip    = "skip"    // don't run this time
+	overlapQueue   = "queue"   // run once the previous run exits
+	overlapRestart = "restart" // stop the previous run and run again
+)
+
+// schedule runs a job at wall-clock times given by a cron expression
+type schedule struct {
+	spec     cron.Schedule
+	location *time.Location
+}
+
+// parseSchedule parses a schedule of the form "cron(30 2 * * *)", using
+// the standard 5-field cron format, in the named timezone or local time
+func parseSchedule(expr, timezone string) (*schedule, error) {
+	if !strings.HasPrefix(expr, "cron(") || !strings.HasSuffix(expr, ")") {
+		return nil, fmt.Errorf("must have the form 'cron(<expression>)'")
+	}
+	spec, err := cron.ParseStandard(strings.TrimSpace(expr[5 : len(expr)-1]))
+	if err != nil {
+		return nil, err
+	}
+	location := time.Local
+	if timezone != "" {
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &schedule{spec: spec, location: location}, nil
+}
+
+// next returns the first scheduled time after now
+func (s *schedule) next(now time.Time) time.Time {
+	return s.spec.Next(now.In(s.location))
+}
+
+func (cfg *Config) validateSchedule() error {
+	when := cfg.When
+	if when.Frequency != "" || when.Source != "" || when.Once != "" ||
+		when.Each != "" || len(when.All) > 0 {
+		return fmt.Errorf("job[%s].when.schedule can't be combined with "+
+			"'interval', 'source', 'once', 'each', or 'all'", cfg.Name)
+	}
+	sched, err := parseSchedule(when.Schedule, when.Timezone)
+	if err != nil {
+		return fmt.Errorf("unable to parse job[%s].when.schedule '%s': %v",
+			cfg.Name, when.Schedule, err)
+	}
+	cfg.schedule = sched
+	cfg.whenTimeout = time.Duration(0)
+	cfg.whenEvent = events.NonEvent
+	cfg.whenStartsLimit = 1
+	return nil
+}
+
+// scheduleNextRun sets a timer for the job's next scheduled run
+func (job *Job) scheduleNextRun(ctx context.Context) {
+	now := time.Now()
+	events.NewEventTimeout(ctx, job.Rx, job.schedule.next(now).Sub(now),
+		fmt.Sprintf("%s.schedule", job.Name))
+}
+
+func (cfg *Config) validateOverlap() error {
+	when := cfg.When
+	cfg.overlap = when.Overlap
+	switch when.Overlap {
+	case "":
+		cfg.overlap = overlapSkip
+	case overlapSkip, overlapQueue, overlapRestart:
+		if cfg.freqInterval == 0 && cfg.schedule == nil {
+			return fmt.Errorf("job[%s].when.overlap requires 'interval' or 'schedule'",
+				cfg.Name)
+		}
+	default:
+		return fmt.Errorf("job[%s].when.overlap must be '%s', '%s', or '%s'",
+			cfg.Name, overlapSkip, overlapQueue, overlapRestart)
+	}
+	if when.CatchUp && cfg.schedule == nil {
+		return fmt.Errorf("job[%s].when.catchUp requires 'schedule'", cfg.Name)
+	}
+	if when.CatchUp != (when.LastRunFile != "") {
+		return fmt.Errorf("job[%s].when.catchUp and 'lastRunFile' must be set together",
+			cfg.Name)
+	}
+	cfg.lastRunFile = when.LastRunFile
+	return nil
+}
+
+// runPeriodic runs an 'interval' or 'schedule' job when it's due. If the
+// previous run is still running, the job's overlap policy decides whether
+// this run is skipped, queued, or replaces the previous run. It returns
+// true if the job has used up its runs.
+func (job *Job) runPeriodic(ctx context.Context) bool {
+	if job.getState() == stateRunning {
+		switch job.overlap {
+		case overlapQueue:
+			job.runQueued = true
+		case overlapRestart:
+			log.Warnf("job[%s]: stopping previous run, which is still running",
+				job.Name)
+			job.runQueued = true
+			job.stopProcess()
+		default:
+			log.Warnf("job[%s]: skipping run, previous run still running",
+				job.Name)
+		}
+		return false
+	}
+	if job.schedule != nil && job.startsRemain != 0 {
+		job.startsRemain--
+		job.recordRun()
+		job.StartJob(ctx)
+		return false
+	}
+	if !job.restartPermitted() {
+		log.Debugf("job[%s]: run is due but restart not permitted", job.Name)
+		return true
+	}
+	job.recordRun()
+	job.restartJob(ctx)
+	return false
+}
+
+// recordRun writes the time of a scheduled run to the job's lastRunFile,
+// if it has one
+func (job *Job) recordRun() {
+	if job.lastRunFile == "" {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := ioutil.WriteFile(job.lastRunFile, []byte(now+"\n"), 0644); err != nil {
+		log.Warnf("job[%s]: unable to record run: %v", job.Name, err)
+	}
+}
+
+// missedRun returns true if the job has missed a scheduled run since the
+// last run recorded in its lastRunFile, such as while the container was
+// down. If no run has been recorded yet, it records the current time so
+// that runs missed from now on are caught up.
+func (job *Job) missedRun() bool {
+	if job.lastRunFile == "" {
+		return false
+	}
+	data, err := ioutil.ReadFile(job.lastRunFile)
+	if os.IsNotExist(err) {
+		job.recordRun()
+		return false
+	}
+	if err != nil {
+		log.Warnf("job[%s]: unable to read last run: %v", job.Name, err)
+		return false
+	}
+	last, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		log.Warnf("job[%s]: unable to parse last run: %v", job.Name, err)
+		return false
+	}
+	if job.schedule.next(last).After(time.Now()) {
+		return false
+	}
+	log.Infof("job[%s]: running scheduled run missed since %v", job.Name, last)
+	return true
+}
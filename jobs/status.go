@@ -0,0 +1,160 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processState tracks the lifecycle of the Job's process for reporting
+// via the control plane's status endpoint.
+type processState int
+
+// processState enum
+const (
+	stateWaiting processState = iota
+	stateRunning
+	stateStopped
+	stateFailed
+)
+
+func (s processState) String() string {
+	switch s {
+	case stateRunning:
+		return "running"
+	case stateStopped:
+		return "stopped"
+	case stateFailed:
+		return "failed"
+	}
+	return "waiting"
+}
+
+// Report is a point-in-time summary of a Job's state, health, and
+// restarts that can be serialized for the control plane.
+type Report struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	ExitCode int    `json:"exitCode"`
+	Restarts int    `json:"restarts"`
+	Health   string `json:"health"`
+
+	HealthOverride string       `json:"healthOverride,omitempty"`
+	LastCheck      *CheckResult `json:"lastCheck,omitempty"`
+}
+
+// Report returns a summary of the current state of the Job
+func (job *Job) Report() Report {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	report := Report{
+		Name:     job.Name,
+		State:    job.state.String(),
+		Restarts: job.restarts,
+		// "statusHealthy" -> "healthy"
+		Health: strings.ToLower(strings.TrimPrefix(job.Status.String(), "status")),
+	}
+	if job.exec != nil {
+		report.ExitCode = job.exec.ExitCode()
+	}
+	if job.overrideExpires.IsZero() || time.Now().Before(job.overrideExpires) {
+		report.HealthOverride = string(job.override)
+	}
+	report.LastCheck = job.lastCheck
+	return report
+}
+
+// checkOutputSize is the number of bytes of a health check exec's output
+// that we keep, which is also the most output Consul keeps for a check by
+// default
+const checkOutputSize = 4 * 1024
+
+// CheckResult is the outcome of the most recent run of a Job's health
+// check, so that a failing check can be explained via the control plane
+// and in the note of the job's TTL check in discovery.
+type CheckResult struct {
+	Passed   bool      `json:"passed"`
+	ExitCode *int      `json:"exitCode,omitempty"` // health check exec only
+	Output   string    `json:"output"`
+	Time     time.Time `json:"time"`
+}
+
+// note returns the note sent to discovery along with the result
+func (result *CheckResult) note() string {
+	output := strings.TrimSpace(result.Output)
+	switch {
+	case result.Passed && output == "":
+		return "ok"
+	case result.Passed || result.ExitCode == nil:
+		return output
+	case output == "":
+		return fmt.Sprintf("exit status %d", *result.ExitCode)
+	}
+	return fmt.Sprintf("exit status %d: %s", *result.ExitCode, output)
+}
+
+// saveCheckResult keeps the outcome and output of the health check run
+// that just finished, and returns it
+func (job *Job) saveCheckResult(passed bool) *CheckResult {
+	result := &CheckResult{Passed: passed, Time: time.Now()}
+	if job.healthCheckExec != nil {
+		code := job.healthCheckExec.ExitCode()
+		result.ExitCode = &code
+		if job.healthCheckExec.Logs != nil {
+			result.Output = strings.Join(job.healthCheckExec.Logs.Lines(0), "\n")
+		}
+	} else if job.healthCheckProbe != nil {
+		result.Output = job.healthCheckProbe.lastOutput()
+	}
+	job.statusLock.Lock()
+	defer job.statusLock.Unlock()
+	job.lastCheck = result
+	return result
+}
+
+// LastCheck returns the outcome of the most recent run of the Job's
+// health check, or nil if it hasn't run yet
+func (job *Job) LastCheck() *CheckResult {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	return job.lastCheck
+}
+
+// Pid returns the process ID of the Job's process while it's running,
+// or 0 if it isn't running
+func (job *Job) Pid() int {
+	if job.exec == nil {
+		return 0
+	}
+	return job.exec.Pid()
+}
+
+func (job *Job) getState() processState {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	return job.state
+}
+
+func (job *Job) setState(state processState) {
+	job.statusLock.Lock()
+	defer job.statusLock.Unlock()
+	job.state = state
+}
+
+// Logs returns up to the last n lines of output from the Job's process,
+// or all the lines we have if n is less than 1
+func (job *Job) Logs(n int) []string {
+	if job.exec == nil || job.exec.Logs == nil {
+		return []string{}
+	}
+	return job.exec.Logs.Lines(n)
+}
+
+// Signal sends a signal to the Job's process if it's running
+func (job *Job) Signal(sig syscall.Signal) error {
+	if job.exec == nil || job.getState() != stateRunning {
+		return fmt.Errorf("job %s is not running", job.Name)
+	}
+	return job.exec.Signal(sig)
+}
@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+)
+
+// TCPCheckConfig configures a health check that connects to a TCP port,
+// and optionally makes a TLS handshake, instead of running a process
+type TCPCheckConfig struct {
+	Address            string `mapstructure:"address"` // host:port
+	TLS                bool   `mapstructure:"tls"`
+	ServerName         string `mapstructure:"serverName"` // for SNI
+	InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+}
+
+// tcpCheck passes if a connection to its address can be made, and if it
+// has a TLS config, if the TLS handshake succeeds
+type tcpCheck struct {
+	address string
+	tls     *tls.Config
+}
+
+// newTCPCheck validates the tcp check at the field of the job's
+// configuration, such as "health.tcp", and returns the check
+func (cfg *Config) newTCPCheck(field string, check *TCPCheckConfig) (checkFunc, error) {
+	tlsConfig, err := cfg.validateCheckAddress(field, check.Address,
+		check.TLS, check.ServerName, check.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	tcpCheck := &tcpCheck{address: check.Address, tls: tlsConfig}
+	return tcpCheck.check, nil
+}
+
+// validateCheckAddress validates the host:port and TLS fields shared by
+// the tcp and grpc health checks, and returns the TLS config for the
+// check or nil if it doesn't use TLS. The server name defaults to the
+// host of the address.
+func (cfg *Config) validateCheckAddress(field, address string, useTLS bool,
+	serverName string, insecureSkipVerify bool) (*tls.Config, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || port == "" {
+		return nil, fmt.Errorf("job[%s].%s.address must be a host:port",
+			cfg.Name, field)
+	}
+	if !useTLS {
+		if serverName != "" || insecureSkipVerify {
+			return nil, fmt.Errorf(
+				"job[%s].%s.serverName and insecureSkipVerify require 'tls'",
+				cfg.Name, field)
+		}
+		return nil, nil
+	}
+	if serverName == "" {
+		serverName = host
+	}
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+	}, nil
+}
+
+func (check *tcpCheck) check(ctx context.Context) error {
+	dialer := &net.Dialer{}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", check.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if check.tls == nil {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return tls.Client(conn, check.tls).Handshake()
+}
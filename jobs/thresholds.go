@@ -0,0 +1,132 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// checkThresholds counts the consecutive results of a health check, so
+// that a single failure doesn't mark a healthy job critical and a single
+// pass doesn't mark a flapping job healthy. Failures during the initial
+// delay after the job starts aren't counted at all, so that a job that's
+// slow to warm up isn't marked critical.
+type checkThresholds struct {
+	failuresBeforeCritical int
+	successesBeforePassing int
+	failures               int // consecutive failed checks
+	successes              int // consecutive passed checks
+
+	initialDelay time.Duration
+	graceUntil   time.Time
+}
+
+func (cfg *Config) validateThresholds() error {
+	failures := cfg.Health.FailuresBeforeCritical
+	successes := cfg.Health.SuccessesBeforePassing
+	if failures < 0 {
+		return fmt.Errorf("job[%s].health.failuresBeforeCritical can't be negative",
+			cfg.Name)
+	}
+	if successes < 0 {
+		return fmt.Errorf("job[%s].health.successesBeforePassing can't be negative",
+			cfg.Name)
+	}
+	// a single result is enough by default
+	if failures == 0 {
+		failures = 1
+	}
+	if successes == 0 {
+		successes = 1
+	}
+	initialDelay, err := utils.GetTimeout(cfg.Health.InitialDelay)
+	if err != nil {
+		return fmt.Errorf("could not parse job[%s].health.initialDelay '%s': %v",
+			cfg.Name, cfg.Health.InitialDelay, err)
+	}
+	cfg.thresholds = checkThresholds{
+		failuresBeforeCritical: failures,
+		successesBeforePassing: successes,
+		initialDelay:           initialDelay,
+	}
+	return nil
+}
+
+// startGracePeriod starts the initial delay, if any, when the job starts
+func (t *checkThresholds) startGracePeriod() {
+	if t.initialDelay > 0 {
+		t.graceUntil = time.Now().Add(t.initialDelay)
+	}
+}
+
+// inGracePeriod returns true if the job started less than the initial
+// delay ago
+func (t *checkThresholds) inGracePeriod() bool {
+	return time.Now().Before(t.graceUntil)
+}
+
+// record counts the result and returns the number of results like it in
+// a row, and the number needed to change the job's health
+func (t *checkThresholds) record(passed bool) (count, needed int) {
+	if passed {
+		t.failures = 0
+		t.successes++
+		return t.successes, t.successesBeforePassing
+	}
+	t.successes = 0
+	t.failures++
+	return t.failures, t.failuresBeforeCritical
+}
+
+// applyCheckResult changes the health of the Job to match the result of
+// its health check once the result has been seen enough times in a row.
+// Until then the Job holds its health, and discovery is told how many of
+// the results have been seen.
+func (job *Job) applyCheckResult(result *CheckResult) {
+	if !result.Passed && job.thresholds.inGracePeriod() {
+		log.Debugf("%s: ignoring failed health check during initial delay",
+			job.Name)
+		return
+	}
+	status := job.flapping.current(job.getStatus())
+	count, needed := job.thresholds.record(result.Passed)
+	next := status
+	switch {
+	case result.Passed && (status == statusHealthy || count >= needed):
+		next = statusHealthy
+	case !result.Passed && (status == statusUnhealthy || count >= needed):
+		next = statusUnhealthy
+	}
+	if job.dampen(next, result) {
+		return
+	}
+	switch {
+	case result.Passed && next == statusHealthy:
+		job.setStatus(statusHealthy)
+		job.Bus.Publish(events.Event{events.StatusHealthy, job.Name})
+		for _, service := range job.services() {
+			service.SendPassing(result.note())
+		}
+	case !result.Passed && next == statusUnhealthy:
+		job.setStatus(statusUnhealthy)
+		job.Bus.Publish(events.Event{events.StatusUnhealthy, job.Name})
+		for _, service := range job.services() {
+			service.SendFailure(result.note())
+		}
+	case next == statusHealthy:
+		note := fmt.Sprintf("%s (failure %d of %d before critical)",
+			result.note(), count, needed)
+		for _, service := range job.services() {
+			service.SendPassing(note)
+		}
+	case next == statusUnhealthy:
+		note := fmt.Sprintf("%s (success %d of %d before passing)",
+			result.note(), count, needed)
+		for _, service := range job.services() {
+			service.SendFailure(note)
+		}
+	}
+}
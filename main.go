@@ -1,7 +1,10 @@
 package main // import "github.com/joyent/containerpilot"
 
 import (
+	"math/rand"
+	"os"
 	"runtime"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/joyent/containerpilot/core"
@@ -13,9 +16,13 @@ func main() {
 	// contention on the main application
 	runtime.GOMAXPROCS(1)
 
+	// jitter has to differ between containers started from the same image
+	rand.Seed(time.Now().UnixNano())
+
 	app, configErr := core.LoadApp()
 	if configErr != nil {
 		log.Fatal(configErr)
 	}
-	app.Run() // Blocks forever
+	app.Run() // Blocks until shutdown
+	os.Exit(app.ExitCode())
 }
@@ -1,32 +1,46 @@
 package subcommands
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/joyent/containerpilot/client"
 	"github.com/joyent/containerpilot/config"
+	"github.com/joyent/containerpilot/control"
 )
 
 // Subcommand provides a simple object for storing a configured HTTPClient.
 type Subcommand struct {
-	client *client.HTTPClient
+	client     *client.HTTPClient
+	healthFile string
 }
 
 // Init initializes the configuration of a Subcommand function and the
 // HTTPClient which they utilize for control plane interaction.
 func Init(configFlag string) (*Subcommand, error) {
-	cfg, err := config.LoadConfig(configFlag)
+	cfg, err := config.LoadControlConfig(configFlag)
 	if err != nil {
 		return nil, err
 	}
 
-	httpclient, err := client.NewHTTPClient(cfg.Control.SocketPath)
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		tlsConfig = cfg.TLS.ClientConfig(cfg.SocketPath)
+	}
+	httpclient, err := client.NewHTTPClient(
+		cfg.SocketType, cfg.SocketPath, tlsConfig, cfg.Token)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Subcommand{
-		httpclient,
+		client:     httpclient,
+		healthFile: cfg.HealthFile,
 	}, nil
 }
 
@@ -81,3 +95,97 @@ func (s Subcommand) SendMetric(metrics map[string]string) error {
 
 	return nil
 }
+
+// SendStatus fires a GetStatus request through the HTTPClient and writes
+// the state of each job to w as a table.
+func (s Subcommand) SendStatus(w io.Writer) error {
+	status, err := s.client.GetStatus()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tHEALTH\tRESTARTS\tEXIT CODE")
+	for _, job := range status.Jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n",
+			job.Name, job.State, job.Health, job.Restarts, job.ExitCode)
+	}
+	return tw.Flush()
+}
+
+// CheckHealth reads the health file written by a running ContainerPilot
+// and returns an error if it isn't healthy or the file is stale. This
+// doesn't use the control socket or the configuration, so that it's cheap
+// enough for a Docker HEALTHCHECK to run often.
+func CheckHealth(path string, w io.Writer) error {
+	state, err := control.ReadHealthFile(path)
+	if err != nil {
+		return err
+	}
+	if err := state.Check(time.Now()); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "healthy")
+	return nil
+}
+
+// Run dispatches the positional subcommand in args (for example "status"
+// or "env set KEY=VAL") through the HTTPClient, writing any output to w.
+func (s Subcommand) Run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no subcommand given")
+	}
+	switch args[0] {
+	case "status":
+		return s.SendStatus(w)
+	case "reload":
+		return s.SendReload()
+	case "check":
+		path := s.healthFile
+		if len(args) == 2 {
+			path = args[1]
+		}
+		if len(args) > 2 || path == "" {
+			return fmt.Errorf("usage: check [PATH] (or set control.healthFile)")
+		}
+		return CheckHealth(path, w)
+	case "maintenance":
+		if len(args) != 2 || (args[1] != "enable" && args[1] != "disable") {
+			return fmt.Errorf("usage: maintenance enable|disable")
+		}
+		return s.SendMaintenance(args[1])
+	case "env":
+		if len(args) < 3 || args[1] != "set" {
+			return fmt.Errorf("usage: env set KEY=VAL [KEY=VAL ...]")
+		}
+		env, err := ParsePairs(args[2:])
+		if err != nil {
+			return err
+		}
+		return s.SendEnviron(env)
+	case "metric":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: metric NAME=VALUE [NAME=VALUE ...]")
+		}
+		metrics, err := ParsePairs(args[1:])
+		if err != nil {
+			return err
+		}
+		return s.SendMetric(metrics)
+	}
+	return fmt.Errorf("unknown subcommand '%s'", args[0])
+}
+
+// ParsePairs parses arguments in the format 'key=value' into a map. Values
+// may contain '=' but keys may not be empty.
+func ParsePairs(args []string) (map[string]string, error) {
+	pairs := make(map[string]string, len(args))
+	for _, arg := range args {
+		pair := strings.SplitN(arg, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf(
+				"argument '%v' was not in the format 'key=value'", arg)
+		}
+		pairs[pair[0]] = pair[1]
+	}
+	return pairs, nil
+}
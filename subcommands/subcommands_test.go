@@ -0,0 +1,72 @@
+package subcommands
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestParsePairs(t *testing.T) {
+	pairs, err := ParsePairs([]string{"KEY=VAL", "URL=http://x?a=b", "EMPTY="})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"KEY": "VAL", "URL": "http://x?a=b", "EMPTY": ""}
+	assert.Equal(t, pairs, expected, "expected %v but got %v")
+
+	_, err = ParsePairs([]string{"KEY"})
+	assert.Error(t, err, "argument 'KEY' was not in the format 'key=value'")
+	_, err = ParsePairs([]string{"=VAL"})
+	assert.Error(t, err, "argument '=VAL' was not in the format 'key=value'")
+}
+
+func TestRunUsage(t *testing.T) {
+	s := Subcommand{}
+	for args, expected := range map[string]string{
+		"":                "no subcommand given",
+		"restart":         "unknown subcommand 'restart'",
+		"env":             "usage: env set KEY=VAL [KEY=VAL ...]",
+		"env get KEY":     "usage: env set KEY=VAL [KEY=VAL ...]",
+		"metric":          "usage: metric NAME=VALUE [NAME=VALUE ...]",
+		"maintenance foo": "usage: maintenance enable|disable",
+		"check":           "usage: check [PATH] (or set control.healthFile)",
+		"check a b":       "usage: check [PATH] (or set control.healthFile)",
+	} {
+		err := s.Run(splitArgs(args), nil)
+		assert.Error(t, err, expected)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "checkhealth")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "containerpilot.health")
+	expires := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	ioutil.WriteFile(path, []byte(fmt.Sprintf(`{"healthy": true, "expires": "%s",
+	  "jobs": [{"name": "app", "health": "healthy"}]}`, expires)), 0644)
+
+	var out bytes.Buffer
+	if err := CheckHealth(path, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, out.String(), "healthy\n", "expected %q but got %q")
+
+	if err := CheckHealth(filepath.Join(dir, "missing"), &out); err == nil {
+		t.Fatalf("expected error for a missing health file")
+	}
+}
+
+func splitArgs(args string) []string {
+	if args == "" {
+		return nil
+	}
+	return strings.Fields(args)
+}
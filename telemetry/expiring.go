@@ -0,0 +1,80 @@
+package telemetry
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// expiringCollector wraps a metric's collector so that series that haven't
+// been recorded within the TTL are left out of the exposition, rather
+// than reporting a frozen value after their sensor stops
+type expiringCollector struct {
+	prometheus.Collector
+	ttl        time.Duration
+	labelNames []string
+
+	lock    sync.Mutex
+	updated map[string]time.Time // by series key
+	now     func() time.Time
+}
+
+func newExpiringCollector(collector prometheus.Collector, ttl time.Duration, labelNames []string) *expiringCollector {
+	return &expiringCollector{
+		Collector:  collector,
+		ttl:        ttl,
+		labelNames: labelNames,
+		updated:    map[string]time.Time{},
+		now:        time.Now,
+	}
+}
+
+// seriesKey identifies a series by the values of its dynamic labels, as
+// the constant labels are the same for every series of the metric
+func (c *expiringCollector) seriesKey(labels map[string]string) string {
+	names := append([]string{}, c.labelNames...)
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = name + "=" + labels[name]
+	}
+	return strings.Join(values, ",")
+}
+
+// touch marks a series as recorded
+func (c *expiringCollector) touch(labels map[string]string) {
+	key := c.seriesKey(labels)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.updated[key] = c.now()
+}
+
+// Collect implements prometheus.Collector, skipping expired series
+func (c *expiringCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collector.Collect(metrics)
+		close(metrics)
+	}()
+	for metric := range metrics {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			continue
+		}
+		labels := map[string]string{}
+		for _, pair := range m.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		updated, ok := c.updated[c.seriesKey(labels)]
+		if ok && now.Sub(updated) < c.ttl {
+			ch <- metric
+		}
+	}
+}
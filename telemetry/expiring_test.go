@@ -0,0 +1,57 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests/assert"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricTTL(t *testing.T) {
+	cfg := &MetricConfig{
+		Name:       "TestMetricTTL",
+		Type:       "gauge",
+		LabelNames: []string{"shard"},
+		TTL:        "30s",
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer prometheus.Unregister(cfg.expiring)
+	now := time.Unix(1000, 0)
+	cfg.expiring.now = func() time.Time { return now }
+	metric := NewMetric(cfg)
+
+	series := func() []string {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(cfg.expiring)
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		shards := []string{}
+		for _, family := range families {
+			for _, m := range family.GetMetric() {
+				shards = append(shards, m.GetLabel()[0].GetValue())
+			}
+		}
+		return shards
+	}
+
+	metric.record("1", map[string]string{"shard": "a"})
+	now = now.Add(20 * time.Second)
+	metric.record("2", map[string]string{"shard": "b"})
+	assert.Equal(t, series(), []string{"a", "b"}, "expected series %v but got %v")
+
+	now = now.Add(20 * time.Second)
+	assert.Equal(t, series(), []string{"b"}, "expected series %v after TTL but got %v")
+
+	metric.record("3", map[string]string{"shard": "a"})
+	assert.Equal(t, series(), []string{"a", "b"}, "expected series %v after update but got %v")
+}
+
+func TestMetricConfigTTL(t *testing.T) {
+	cfg := &MetricConfig{Name: "bad_ttl", Type: "gauge", TTL: "-1s"}
+	assert.Error(t, cfg.Validate(), "metric __bad_ttl: ttl must be positive")
+}
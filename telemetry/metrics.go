@@ -2,6 +2,7 @@ package telemetry
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -27,9 +28,13 @@ const (
 
 // Metric manages state of periodic metrics.
 type Metric struct {
-	Name      string
-	Type      MetricType
-	collector prometheus.Collector
+	Name       string
+	Type       MetricType
+	collector  prometheus.Collector
+	labelNames []string // labels set by each recorded value, if any
+	expiring   *expiringCollector
+	tags       []string
+	sink       *statsdSink
 
 	events.EventHandler // Event handling
 }
@@ -37,14 +42,19 @@ type Metric struct {
 // NewMetric creates a Metric from a validated MetricConfig
 func NewMetric(cfg *MetricConfig) *Metric {
 	metric := &Metric{
-		Name:      cfg.fullName,
-		Type:      cfg.metricType,
-		collector: cfg.collector,
+		Name:       cfg.fullName,
+		Type:       cfg.metricType,
+		collector:  cfg.collector,
+		labelNames: cfg.LabelNames,
+		expiring:   cfg.expiring,
+		tags:       cfg.Tags,
 	}
 	metric.Rx = make(chan events.Event, eventBufferSize)
 	return metric
 }
 
+// processMetric parses a Metric event in the format name|value or
+// name|value|key=val,key=val
 func (metric *Metric) processMetric(event string) {
 	measurement := strings.Split(event, "|")
 	if len(measurement) < 2 {
@@ -53,30 +63,99 @@ func (metric *Metric) processMetric(event string) {
 	}
 	metricKey := measurement[0]
 	metricVal := measurement[1]
-	if metric.Name == metricKey {
-		metric.record(metricVal)
+	if metric.Name != metricKey {
+		return
+	}
+	labels := map[string]string{}
+	if len(measurement) > 2 && measurement[2] != "" {
+		for _, pair := range strings.Split(measurement[2], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				log.Errorf("metric: invalid label format: %v", event)
+				return
+			}
+			labels[kv[0]] = kv[1]
+		}
 	}
+	metric.record(metricVal, labels)
 }
 
-func (metric *Metric) record(metricValue string) {
-	if val, err := strconv.ParseFloat(
-		strings.TrimSpace(metricValue), 64); err != nil {
+func (metric *Metric) record(metricValue string, labels map[string]string) {
+	val, err := strconv.ParseFloat(strings.TrimSpace(metricValue), 64)
+	if err != nil {
 		log.Errorf("metric produced non-numeric value: %v: %v", metricValue, err)
-	} else {
-		// we should use a type switch here but the prometheus collector
-		// implementations are themselves interfaces and not structs,
-		// so that doesn't work.
-		switch metric.Type {
-		case Counter:
-			metric.collector.(prometheus.Counter).Add(val)
-		case Gauge:
-			metric.collector.(prometheus.Gauge).Set(val)
-		case Histogram:
-			metric.collector.(prometheus.Histogram).Observe(val)
-		case Summary:
-			metric.collector.(prometheus.Summary).Observe(val)
+		return
+	}
+	collector, err := metric.labeled(labels)
+	if err != nil {
+		log.Errorf("metric %s: %v", metric.Name, err)
+		return
+	}
+	// we should use a type switch here but the prometheus collector
+	// implementations are themselves interfaces and not structs,
+	// so that doesn't work.
+	switch metric.Type {
+	case Counter:
+		collector.(prometheus.Counter).Add(val)
+	case Gauge:
+		collector.(prometheus.Gauge).Set(val)
+	case Histogram, Summary:
+		collector.(prometheus.Observer).Observe(val)
+	}
+	if metric.expiring != nil {
+		metric.expiring.touch(labels)
+	}
+	if metric.sink != nil {
+		metric.sink.send(metric.Name, metric.Type, val, metric.sinkTags(labels))
+	}
+}
+
+// labeled returns the collector for the labels of a recorded value.
+// Labels are ignored for a metric without labelNames, and any of its
+// labelNames that aren't set are recorded as empty.
+func (metric *Metric) labeled(labels map[string]string) (interface{}, error) {
+	if len(metric.labelNames) == 0 {
+		return metric.collector, nil
+	}
+	values := prometheus.Labels{}
+	for _, name := range metric.labelNames {
+		values[name] = labels[name]
+	}
+	for name := range labels {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("unknown label '%s'", name)
+		}
+	}
+	var (
+		collector interface{}
+		err       error
+	)
+	switch vec := metric.collector.(type) {
+	case *prometheus.CounterVec:
+		collector, err = vec.GetMetricWith(values)
+	case *prometheus.GaugeVec:
+		collector, err = vec.GetMetricWith(values)
+	case *prometheus.HistogramVec:
+		collector, err = vec.GetMetricWith(values)
+	case *prometheus.SummaryVec:
+		collector, err = vec.GetMetricWith(values)
+	}
+	return collector, err
+}
+
+// sinkTags adds the labels of a recorded value to the metric's tags,
+// in the key:value format used by DogStatsD
+func (metric *Metric) sinkTags(labels map[string]string) []string {
+	if len(labels) == 0 || len(metric.labelNames) == 0 {
+		return metric.tags
+	}
+	tags := append([]string{}, metric.tags...)
+	for _, name := range metric.labelNames {
+		if val, ok := labels[name]; ok {
+			tags = append(tags, name+":"+val)
 		}
 	}
+	return tags
 }
 
 // Run executes the event loop for the Metric
@@ -2,6 +2,8 @@ package telemetry
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/joyent/containerpilot/utils"
@@ -16,9 +18,27 @@ type MetricConfig struct {
 	Help      string `mapstructure:"help"` // help string returned by API
 	Type      string `mapstructure:"type"`
 
+	// Buckets are the upper bounds of a histogram's buckets, and
+	// Objectives map a summary's quantiles to their allowed error
+	Buckets    []float64          `mapstructure:"buckets"`
+	Objectives map[string]float64 `mapstructure:"objectives"`
+
+	// Labels are constant for every value of the metric, whereas the
+	// values of LabelNames are set by each recorded value
+	Labels     map[string]string `mapstructure:"labels"`
+	LabelNames []string          `mapstructure:"labelNames"`
+
+	// Tags are only sent to DogStatsD
+	Tags []string `mapstructure:"tags"`
+
+	// TTL is how long a value is reported after it's recorded
+	TTL string `mapstructure:"ttl"`
+
 	fullName   string // combined name
 	metricType MetricType
+	objectives map[float64]float64
 	collector  prometheus.Collector
+	expiring   *expiringCollector // wraps collector if there's a TTL
 }
 
 // NewMetricConfigs creates new metrics from a raw config
@@ -39,6 +59,22 @@ func NewMetricConfigs(raw []interface{}) ([]*MetricConfig, error) {
 func (cfg *MetricConfig) Validate() error {
 
 	cfg.fullName = strings.Join([]string{cfg.Namespace, cfg.Subsystem, cfg.Name}, "_")
+	if err := cfg.validateBuckets(); err != nil {
+		return err
+	}
+	if err := cfg.validateObjectives(); err != nil {
+		return err
+	}
+	if err := cfg.validateLabels(); err != nil {
+		return err
+	}
+	ttl, err := utils.GetTimeout(cfg.TTL)
+	if err != nil {
+		return fmt.Errorf("metric %s: ttl %v", cfg.fullName, err)
+	}
+	if ttl < 0 {
+		return fmt.Errorf("metric %s: ttl must be positive", cfg.fullName)
+	}
 
 	// the prometheus client lib's API here is baffling... they don't expose
 	// an interface or embed their Opts type in each of the Opts "subtypes",
@@ -46,45 +82,154 @@ func (cfg *MetricConfig) Validate() error {
 	switch cfg.Type {
 	case "counter":
 		cfg.metricType = Counter
-		cfg.collector = prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: cfg.Namespace,
-			Subsystem: cfg.Subsystem,
-			Name:      cfg.Name,
-			Help:      cfg.Help,
-		})
+		opts := prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        cfg.Name,
+			Help:        cfg.Help,
+			ConstLabels: cfg.Labels,
+		}
+		if len(cfg.LabelNames) > 0 {
+			cfg.collector = prometheus.NewCounterVec(opts, cfg.LabelNames)
+		} else {
+			cfg.collector = prometheus.NewCounter(opts)
+		}
 	case "gauge":
 		cfg.metricType = Gauge
-		cfg.collector = prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: cfg.Namespace,
-			Subsystem: cfg.Subsystem,
-			Name:      cfg.Name,
-			Help:      cfg.Help,
-		})
+		opts := prometheus.GaugeOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        cfg.Name,
+			Help:        cfg.Help,
+			ConstLabels: cfg.Labels,
+		}
+		if len(cfg.LabelNames) > 0 {
+			cfg.collector = prometheus.NewGaugeVec(opts, cfg.LabelNames)
+		} else {
+			cfg.collector = prometheus.NewGauge(opts)
+		}
 	case "histogram":
 		cfg.metricType = Histogram
-		cfg.collector = prometheus.NewHistogram(prometheus.HistogramOpts{
-			Namespace: cfg.Namespace,
-			Subsystem: cfg.Subsystem,
-			Name:      cfg.Name,
-			Help:      cfg.Help,
-		})
+		opts := prometheus.HistogramOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        cfg.Name,
+			Help:        cfg.Help,
+			ConstLabels: cfg.Labels,
+			Buckets:     cfg.Buckets,
+		}
+		if len(cfg.LabelNames) > 0 {
+			cfg.collector = prometheus.NewHistogramVec(opts, cfg.LabelNames)
+		} else {
+			cfg.collector = prometheus.NewHistogram(opts)
+		}
 	case "summary":
 		cfg.metricType = Summary
-		cfg.collector = prometheus.NewSummary(prometheus.SummaryOpts{
-			Namespace: cfg.Namespace,
-			Subsystem: cfg.Subsystem,
-			Name:      cfg.Name,
-			Help:      cfg.Help,
-		})
+		opts := prometheus.SummaryOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        cfg.Name,
+			Help:        cfg.Help,
+			ConstLabels: cfg.Labels,
+			Objectives:  cfg.objectives,
+		}
+		if len(cfg.LabelNames) > 0 {
+			cfg.collector = prometheus.NewSummaryVec(opts, cfg.LabelNames)
+		} else {
+			cfg.collector = prometheus.NewSummary(opts)
+		}
 	default:
 		return fmt.Errorf("invalid metric type: %s", cfg.Type)
 	}
+	registered := cfg.collector
+	if ttl > 0 {
+		cfg.expiring = newExpiringCollector(cfg.collector, ttl, cfg.LabelNames)
+		registered = cfg.expiring
+	}
 	// we're going to unregister before every attempt to register
 	// so that we can reload config
-	prometheus.Unregister(cfg.collector)
-	if err := prometheus.Register(cfg.collector); err != nil {
+	prometheus.Unregister(registered)
+	if err := prometheus.Register(registered); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// validateBuckets checks the histogram buckets, as the prometheus client
+// panics if they aren't in increasing order. Leaving them empty uses the
+// client's default buckets.
+func (cfg *MetricConfig) validateBuckets() error {
+	if len(cfg.Buckets) == 0 {
+		return nil
+	}
+	if cfg.Type != "histogram" {
+		return fmt.Errorf("metric %s: buckets are only valid for histograms",
+			cfg.fullName)
+	}
+	for i := 1; i < len(cfg.Buckets); i++ {
+		if cfg.Buckets[i] <= cfg.Buckets[i-1] {
+			return fmt.Errorf("metric %s: buckets must be in increasing order: %v",
+				cfg.fullName, cfg.Buckets)
+		}
+	}
+	return nil
+}
+
+// validateObjectives parses the summary quantiles, which are map keys and
+// so are strings in the config. Leaving them empty uses the client's
+// default objectives.
+func (cfg *MetricConfig) validateObjectives() error {
+	if len(cfg.Objectives) == 0 {
+		return nil
+	}
+	if cfg.Type != "summary" {
+		return fmt.Errorf("metric %s: objectives are only valid for summaries",
+			cfg.fullName)
+	}
+	// sort the keys so that errors are deterministic
+	keys := make([]string, 0, len(cfg.Objectives))
+	for key := range cfg.Objectives {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	cfg.objectives = make(map[float64]float64, len(keys))
+	for _, key := range keys {
+		quantile, err := strconv.ParseFloat(key, 64)
+		if err != nil || quantile < 0 || quantile > 1 {
+			return fmt.Errorf("metric %s: objective quantile '%s' must be between 0 and 1",
+				cfg.fullName, key)
+		}
+		tolerance := cfg.Objectives[key]
+		if tolerance < 0 || tolerance > 1 {
+			return fmt.Errorf("metric %s: objective error '%v' for quantile %s must be between 0 and 1",
+				cfg.fullName, tolerance, key)
+		}
+		cfg.objectives[quantile] = tolerance
+	}
+	return nil
+}
+
+// validateLabels checks for the labels that the prometheus client reserves
+// for histograms and summaries, as it panics rather than returning an
+// error for them. Other invalid label names are caught on registration.
+func (cfg *MetricConfig) validateLabels() error {
+	var reserved string
+	switch cfg.Type {
+	case "histogram":
+		reserved = "le"
+	case "summary":
+		reserved = "quantile"
+	default:
+		return nil
+	}
+	_, isReserved := cfg.Labels[reserved]
+	for _, name := range cfg.LabelNames {
+		isReserved = isReserved || name == reserved
+	}
+	if isReserved {
+		return fmt.Errorf("metric %s: label '%s' is reserved for %s metrics",
+			cfg.fullName, reserved, cfg.Type)
+	}
+	return nil
+}
@@ -5,7 +5,9 @@ import (
 	"testing"
 
 	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/assert"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestMetricConfigParse(t *testing.T) {
@@ -86,3 +88,63 @@ func TestMetricConfigPartialName(t *testing.T) {
 		t.Fatalf("incorrect collector; expected Counter but got %v", metrics[0].collector)
 	}
 }
+
+func TestMetricConfigBucketsObjectives(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+{ name: "telemetry_metrics_buckets", type: "histogram", buckets: [0.005, 0.01, 0.025] },
+{ name: "telemetry_metrics_objectives", type: "summary", objectives: {"0.5": 0.05, "0.99": 0.001} }]`)
+	metrics, err := NewMetricConfigs(testCfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	histogram := metrics[0].collector.(prometheus.Histogram)
+	histogram.Observe(0.008)
+	m := &dto.Metric{}
+	histogram.Write(m)
+	buckets := m.GetHistogram().GetBucket()
+	assert.Equal(t, len(buckets), 3, "expected %v buckets but got %v")
+	assert.Equal(t, buckets[1].GetUpperBound(), 0.01, "expected bound %v but got %v")
+	assert.Equal(t, buckets[1].GetCumulativeCount(), uint64(1), "expected count %v but got %v")
+	assert.Equal(t, metrics[1].objectives, map[float64]float64{0.5: 0.05, 0.99: 0.001},
+		"expected objectives %v but got %v")
+
+	expectErr := func(raw, errMsg string) {
+		_, err := NewMetricConfigs(tests.DecodeRawToSlice(raw))
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{ name: "bad_buckets", type: "histogram", buckets: [0.1, 0.01] }]`,
+		"metric __bad_buckets: buckets must be in increasing order: [0.1 0.01]")
+	expectErr(`[{ name: "bad_buckets", type: "summary", buckets: [0.1] }]`,
+		"metric __bad_buckets: buckets are only valid for histograms")
+	expectErr(`[{ name: "bad_objectives", type: "histogram", objectives: {"0.5": 0.05} }]`,
+		"metric __bad_objectives: objectives are only valid for summaries")
+	expectErr(`[{ name: "bad_objectives", type: "summary", objectives: {"p99": 0.05} }]`,
+		"metric __bad_objectives: objective quantile 'p99' must be between 0 and 1")
+	expectErr(`[{ name: "bad_objectives", type: "summary", objectives: {"0.9": 2} }]`,
+		"metric __bad_objectives: objective error '2' for quantile 0.9 must be between 0 and 1")
+}
+
+func TestMetricConfigLabels(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+{ name: "telemetry_metrics_labels", type: "histogram",
+  labels: { env: "prod" }, labelNames: ["route"] }]`)
+	metrics, err := NewMetricConfigs(testCfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := metrics[0].collector.(*prometheus.HistogramVec); !ok {
+		t.Fatalf("incorrect collector; expected HistogramVec but got %v", metrics[0].collector)
+	}
+
+	_, err = NewMetricConfigs(tests.DecodeRawToSlice(
+		`[{ name: "bad_labels", type: "histogram", labelNames: ["le"] }]`))
+	assert.Error(t, err, "metric __bad_labels: label 'le' is reserved for histogram metrics")
+	_, err = NewMetricConfigs(tests.DecodeRawToSlice(
+		`[{ name: "bad_labels", type: "summary", labels: { quantile: "x" } }]`))
+	assert.Error(t, err, "metric __bad_labels: label 'quantile' is reserved for summary metrics")
+	_, err = NewMetricConfigs(tests.DecodeRawToSlice(
+		`[{ name: "bad_labels", type: "counter", labelNames: ["not-valid"] }]`))
+	if err == nil {
+		t.Fatalf("expected error for invalid label name")
+	}
+}
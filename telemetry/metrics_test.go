@@ -114,7 +114,7 @@ func TestMetricRecordCounter(t *testing.T) {
 		})}
 	prometheus.MustRegister(metric.collector)
 	testFunc := func(input, expected string) bool {
-		metric.record(input)
+		metric.record(input, nil)
 		resp := getFromTestServer(t, testServer)
 		return strings.Count(resp, expected) == 1
 	}
@@ -144,7 +144,7 @@ func TestMetricRecordGauge(t *testing.T) {
 	prometheus.MustRegister(metric.collector)
 
 	testFunc := func(input, expected string) bool {
-		metric.record(input)
+		metric.record(input, nil)
 		resp := getFromTestServer(t, testServer)
 		return strings.Count(resp, expected) == 1
 	}
@@ -176,7 +176,7 @@ func TestMetricRecordHistogram(t *testing.T) {
 	patt := `telemetry_metrics_TestMetricRecordHistogram_bucket{le="([\.0-9|\+Inf]*)"} ([1-9])`
 
 	testFunc := func(input string, expected [][]string) bool {
-		metric.record(input)
+		metric.record(input, nil)
 		resp := getFromTestServer(t, testServer)
 		return checkBuckets(resp, patt, expected)
 	}
@@ -214,7 +214,7 @@ func TestMetricRecordSummary(t *testing.T) {
 	t.Run("record ok", func(t *testing.T) {
 		// need a bunch of metrics to make quantiles make any sense
 		for i := 1; i <= 10; i++ {
-			metric.record(fmt.Sprintf("%v", i))
+			metric.record(fmt.Sprintf("%v", i), nil)
 		}
 		resp := getFromTestServer(t, testServer)
 		expected := [][]string{{"0.5", "5"}, {"0.9", "9"}, {"0.99", "10"}}
@@ -224,7 +224,7 @@ func TestMetricRecordSummary(t *testing.T) {
 	t.Run("record update", func(t *testing.T) {
 		for i := 1; i <= 5; i++ {
 			// add a new record for each one in the bottom half
-			metric.record(fmt.Sprintf("%v", i))
+			metric.record(fmt.Sprintf("%v", i), nil)
 		}
 		resp := getFromTestServer(t, testServer)
 		expected := [][]string{{"0.5", "4"}, {"0.9", "9"}, {"0.99", "10"}}
@@ -271,3 +271,43 @@ func getFromTestServer(t *testing.T, testServer *httptest.Server) string {
 	}
 	return ""
 }
+
+func TestMetricLabels(t *testing.T) {
+	testServer := httptest.NewServer(prometheus.UninstrumentedHandler())
+	defer testServer.Close()
+	cfg := &MetricConfig{
+		Namespace:  "telemetry",
+		Subsystem:  "metrics",
+		Name:       "TestMetricLabels",
+		Help:       "help",
+		Type:       "counter",
+		Labels:     map[string]string{"env": "prod"},
+		LabelNames: []string{"route", "code"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	metric := NewMetric(cfg)
+	metric.processMetric("telemetry_metrics_TestMetricLabels|2|code=200,route=/a")
+	metric.processMetric("telemetry_metrics_TestMetricLabels|3|code=200,route=/a")
+	metric.processMetric("telemetry_metrics_TestMetricLabels|1|route=/b")
+	metric.processMetric("telemetry_metrics_TestMetricLabels|1|shard=x")
+	metric.processMetric("telemetry_metrics_TestMetricLabels|1|route")
+
+	resp := getFromTestServer(t, testServer)
+	assert.Equal(t, strings.Count(resp,
+		`telemetry_metrics_TestMetricLabels{code="200",env="prod",route="/a"} 5`), 1,
+		"failed to get match for labeled metric in response")
+	assert.Equal(t, strings.Count(resp,
+		`telemetry_metrics_TestMetricLabels{code="",env="prod",route="/b"} 1`), 1,
+		"failed to get match for metric with missing label in response")
+	assert.Equal(t, strings.Count(resp, "telemetry_metrics_TestMetricLabels{"), 2,
+		"expected %v series but got %v")
+
+	sink := &statsdSink{dogstatsd: true}
+	metric.tags = []string{"service:app"}
+	assert.Equal(t, sink.format(metric.Name, metric.Type, 1,
+		metric.sinkTags(map[string]string{"route": "/a"})),
+		"telemetry_metrics_TestMetricLabels:1|c|#service:app,route:/a",
+		"expected statsd line %v but got %v")
+}
@@ -0,0 +1,380 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPConfig configures pushing metrics to an OpenTelemetry collector,
+// for containers that don't live long enough to be scraped
+type OTLPConfig struct {
+	Endpoint    string            `mapstructure:"endpoint"`
+	Protocol    string            `mapstructure:"protocol"`
+	Headers     map[string]string `mapstructure:"headers"`
+	Interval    string            `mapstructure:"interval"`
+	Insecure    bool              `mapstructure:"insecure"`
+	ServiceName string            `mapstructure:"serviceName"`
+
+	interval time.Duration
+}
+
+// NewOTLPConfig parses and validates the telemetry.otlp config. The
+// endpoint, protocol, headers, and service name default to the standard
+// OTEL_* environment variables.
+func NewOTLPConfig(raw interface{}) (*OTLPConfig, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	cfg := &OTLPConfig{Interval: "10s"}
+	if err := utils.DecodeRaw(raw, cfg); err != nil {
+		return nil, fmt.Errorf("otlp configuration error: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate sets defaults and checks the endpoint and interval
+func (cfg *OTLPConfig) Validate() error {
+	if cfg.Protocol == "" {
+		cfg.Protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	switch cfg.Protocol {
+	case "", "grpc":
+		cfg.Protocol = "grpc"
+	case "http", "http/protobuf":
+		cfg.Protocol = "http/protobuf"
+	default:
+		return fmt.Errorf("invalid otlp.protocol '%s': must be 'grpc' or "+
+			"'http/protobuf'", cfg.Protocol)
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "http://localhost:4317"
+		if cfg.Protocol == "http/protobuf" {
+			cfg.Endpoint = "http://localhost:4318"
+		}
+	}
+	if cfg.Protocol == "http/protobuf" {
+		endpoint, err := url.Parse(cfg.Endpoint)
+		if err != nil || endpoint.Host == "" {
+			return fmt.Errorf("invalid otlp.endpoint '%s': must be a URL", cfg.Endpoint)
+		}
+		if strings.Trim(endpoint.Path, "/") == "" {
+			endpoint.Path = "/v1/metrics"
+			cfg.Endpoint = endpoint.String()
+		}
+	}
+	if cfg.Headers == nil {
+		cfg.Headers = parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = os.Getenv("OTEL_SERVICE_NAME")
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "containerpilot"
+	}
+	interval, err := utils.GetTimeout(cfg.Interval)
+	if err != nil {
+		return fmt.Errorf("otlp.interval %v", err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("otlp.interval must be positive")
+	}
+	cfg.interval = interval
+	return nil
+}
+
+// parseOTLPHeaders parses the "key1=value1,key2=value2" format of the
+// OTEL_EXPORTER_OTLP_HEADERS environment variable
+func parseOTLPHeaders(env string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(env, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value, err := url.QueryUnescape(strings.TrimSpace(parts[1]))
+		if key == "" || err != nil {
+			continue
+		}
+		headers[key] = value
+	}
+	return headers
+}
+
+// otlpExporter periodically pushes the metrics in the Prometheus
+// registry, including the Go runtime metrics, to the collector
+type otlpExporter struct {
+	cfg      *OTLPConfig
+	gatherer prometheus.Gatherer
+	start    time.Time
+	resource *resourcepb.Resource
+
+	send  func(context.Context, *colmetricspb.ExportMetricsServiceRequest) error
+	close func()
+}
+
+func newOTLPExporter(cfg *OTLPConfig) (*otlpExporter, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	hostname, _ := os.Hostname()
+	e := &otlpExporter{
+		cfg:      cfg,
+		gatherer: prometheus.DefaultGatherer,
+		start:    time.Now(),
+		resource: &resourcepb.Resource{
+			Attributes: []*commonpb.KeyValue{
+				otlpAttribute("service.name", cfg.ServiceName),
+				otlpAttribute("host.name", hostname),
+			},
+		},
+	}
+	if cfg.Protocol == "http/protobuf" {
+		e.send = e.sendHTTP(&http.Client{Timeout: 10 * time.Second})
+		e.close = func() {}
+		return e, nil
+	}
+	send, conn, err := e.sendGRPC()
+	if err != nil {
+		return nil, err
+	}
+	e.send = send
+	e.close = func() { conn.Close() }
+	return e, nil
+}
+
+func (e *otlpExporter) sendHTTP(client *http.Client) func(context.Context, *colmetricspb.ExportMetricsServiceRequest) error {
+	return func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+		body, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+		httpReq, err := http.NewRequest("POST", e.cfg.Endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpReq = httpReq.WithContext(ctx)
+		httpReq.Header.Set("Content-Type", "application/x-protobuf")
+		for key, value := range e.cfg.Headers {
+			httpReq.Header.Set(key, value)
+		}
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("collector returned %s: %s",
+				resp.Status, bytes.TrimSpace(respBody))
+		}
+		return nil
+	}
+}
+
+// sendGRPC connects to the collector. An endpoint with an http:// scheme
+// or the insecure option disables TLS.
+func (e *otlpExporter) sendGRPC() (func(context.Context, *colmetricspb.ExportMetricsServiceRequest) error, *grpc.ClientConn, error) {
+	target := e.cfg.Endpoint
+	creds := credentials.NewTLS(&tls.Config{})
+	switch {
+	case strings.HasPrefix(target, "http://"):
+		target = strings.TrimPrefix(target, "http://")
+		creds = insecure.NewCredentials()
+	case strings.HasPrefix(target, "https://"):
+		target = strings.TrimPrefix(target, "https://")
+	}
+	if e.cfg.Insecure {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient(strings.TrimSuffix(target, "/"),
+		grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid otlp.endpoint '%s': %v", e.cfg.Endpoint, err)
+	}
+	client := colmetricspb.NewMetricsServiceClient(conn)
+	send := func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+		if len(e.cfg.Headers) > 0 {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.cfg.Headers))
+		}
+		_, err := client.Export(ctx, req)
+		return err
+	}
+	return send, conn, nil
+}
+
+// export gathers the metrics and pushes them to the collector
+func (e *otlpExporter) export() {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		log.Warnf("otlp: unable to gather metrics: %v", err)
+	}
+	req := e.request(families, time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.send(ctx, req); err != nil {
+		log.Warnf("otlp: unable to export metrics to %s: %v", e.cfg.Endpoint, err)
+	}
+}
+
+// request converts the Prometheus metric families into an OTLP export
+// request. Counters, histograms, and summaries are cumulative since the
+// exporter started.
+func (e *otlpExporter) request(families []*dto.MetricFamily, now time.Time) *colmetricspb.ExportMetricsServiceRequest {
+	start := uint64(e.start.UnixNano())
+	ts := uint64(now.UnixNano())
+	metrics := []*metricspb.Metric{}
+	for _, family := range families {
+		metric := &metricspb.Metric{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+		}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}
+			for _, m := range family.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, otlpNumber(
+					m, start, ts, m.GetCounter().GetValue()))
+			}
+			metric.Data = &metricspb.Metric_Sum{Sum: sum}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricspb.Gauge{}
+			for _, m := range family.GetMetric() {
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, otlpNumber(m, 0, ts, value))
+			}
+			metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_HISTOGRAM:
+			histogram := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, m := range family.GetMetric() {
+				histogram.DataPoints = append(histogram.DataPoints,
+					otlpHistogram(m, start, ts))
+			}
+			metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+		case dto.MetricType_SUMMARY:
+			summary := &metricspb.Summary{}
+			for _, m := range family.GetMetric() {
+				point := &metricspb.SummaryDataPoint{
+					Attributes:        otlpLabels(m),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					Count:             m.GetSummary().GetSampleCount(),
+					Sum:               m.GetSummary().GetSampleSum(),
+				}
+				for _, q := range m.GetSummary().GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues,
+						&metricspb.SummaryDataPoint_ValueAtQuantile{
+							Quantile: q.GetQuantile(),
+							Value:    q.GetValue(),
+						})
+				}
+				summary.DataPoints = append(summary.DataPoints, point)
+			}
+			metric.Data = &metricspb.Metric_Summary{Summary: summary}
+		default:
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: e.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: "containerpilot"},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+func otlpNumber(m *dto.Metric, start, ts uint64, value float64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:        otlpLabels(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+// otlpHistogram converts Prometheus' cumulative bucket counts into the
+// per-bucket counts that OTLP uses, with an implicit overflow bucket
+func otlpHistogram(m *dto.Metric, start, ts uint64) *metricspb.HistogramDataPoint {
+	h := m.GetHistogram()
+	sum := h.GetSampleSum()
+	point := &metricspb.HistogramDataPoint{
+		Attributes:        otlpLabels(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		Count:             h.GetSampleCount(),
+		Sum:               &sum,
+	}
+	var previous uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts,
+			bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+	return point
+}
+
+func otlpLabels(m *dto.Metric) []*commonpb.KeyValue {
+	attributes := []*commonpb.KeyValue{}
+	for _, label := range m.GetLabel() {
+		attributes = append(attributes, otlpAttribute(label.GetName(), label.GetValue()))
+	}
+	return attributes
+}
+
+func otlpAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key: key,
+		Value: &commonpb.AnyValue{
+			Value: &commonpb.AnyValue_StringValue{StringValue: value},
+		},
+	}
+}
@@ -0,0 +1,148 @@
+package telemetry
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPConfig(t *testing.T) {
+	cfg, err := NewOTLPConfig(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfg.Protocol, "grpc", "expected protocol %v but got %v")
+	assert.Equal(t, cfg.Endpoint, "http://localhost:4317", "expected endpoint %v but got %v")
+	assert.Equal(t, cfg.interval, 10*time.Second, "expected interval %v but got %v")
+	assert.Equal(t, cfg.ServiceName, "containerpilot", "expected service name %v but got %v")
+
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret,x-team=a%20b")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+	cfg, _ = NewOTLPConfig(map[string]interface{}{
+		"protocol": "http", "endpoint": "https://otel.example.com", "interval": "30s"})
+	assert.Equal(t, cfg.Protocol, "http/protobuf", "expected protocol %v but got %v")
+	assert.Equal(t, cfg.Endpoint, "https://otel.example.com/v1/metrics",
+		"expected endpoint %v but got %v")
+	assert.Equal(t, cfg.Headers, map[string]string{"api-key": "secret", "x-team": "a b"},
+		"expected headers %v but got %v")
+
+	_, err = NewOTLPConfig(map[string]interface{}{"protocol": "thrift"})
+	assert.Error(t, err, "invalid otlp.protocol 'thrift': must be 'grpc' or 'http/protobuf'")
+	_, err = NewOTLPConfig(map[string]interface{}{"protocol": "http", "endpoint": "otel"})
+	assert.Error(t, err, "invalid otlp.endpoint 'otel': must be a URL")
+	_, err = NewOTLPConfig(map[string]interface{}{"interval": "0s"})
+	assert.Error(t, err, "otlp.interval must be positive")
+}
+
+func TestOTLPRequest(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "jobs_total"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "latency", Buckets: []float64{1, 5}})
+	registry.MustRegister(counter, histogram)
+	counter.Add(3)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+	histogram.Observe(10)
+
+	e := &otlpExporter{gatherer: registry, start: time.Unix(100, 0)}
+	families, _ := registry.Gather()
+	req := e.request(families, time.Unix(200, 0))
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Equal(t, len(metrics), 2, "expected %v metrics but got %v")
+
+	assert.Equal(t, metrics[0].Name, "jobs_total", "expected metric %v but got %v")
+	sum := metrics[0].GetSum()
+	assert.True(t, sum.IsMonotonic, "expected counter to be monotonic")
+	assert.Equal(t, sum.DataPoints[0].GetAsDouble(), 3.0, "expected value %v but got %v")
+	assert.Equal(t, sum.DataPoints[0].StartTimeUnixNano, uint64(100*time.Second),
+		"expected start time %v but got %v")
+
+	assert.Equal(t, metrics[1].Name, "latency", "expected metric %v but got %v")
+	point := metrics[1].GetHistogram().DataPoints[0]
+	assert.Equal(t, point.Count, uint64(3), "expected count %v but got %v")
+	assert.Equal(t, point.ExplicitBounds, []float64{1, 5}, "expected bounds %v but got %v")
+	assert.Equal(t, point.BucketCounts, []uint64{1, 1, 1}, "expected buckets %v but got %v")
+}
+
+func TestOTLPExportHTTP(t *testing.T) {
+	received := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/v1/metrics", "expected path %v but got %v")
+		assert.Equal(t, r.Header.Get("Api-Key"), "secret", "expected header %v but got %v")
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &colmetricspb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		received <- req
+	}))
+	defer server.Close()
+	cfg, _ := NewOTLPConfig(map[string]interface{}{
+		"protocol": "http/protobuf",
+		"endpoint": server.URL,
+		"headers":  map[string]string{"api-key": "secret"},
+	})
+	e, err := newOTLPExporter(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e.export()
+	req := <-received
+	resource := req.ResourceMetrics[0].Resource.Attributes
+	assert.Equal(t, resource[0].Value.GetStringValue(), "containerpilot",
+		"expected service.name %v but got %v")
+}
+
+type fakeCollector struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	received chan metadata.MD
+}
+
+func (c *fakeCollector) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.received <- md
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestOTLPExportGRPC(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	collector := &fakeCollector{received: make(chan metadata.MD, 1)}
+	server := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(server, collector)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	cfg, _ := NewOTLPConfig(map[string]interface{}{
+		"endpoint": ln.Addr().String(),
+		"insecure": true,
+		"headers":  map[string]string{"api-key": "secret"},
+	})
+	e, err := newOTLPExporter(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer e.close()
+	e.export()
+	select {
+	case md := <-collector.received:
+		assert.Equal(t, md.Get("api-key"), []string{"secret"}, "expected header %v but got %v")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collector didn't receive metrics")
+	}
+}
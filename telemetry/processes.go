@@ -0,0 +1,107 @@
+package telemetry
+
+import (
+	"sync"
+
+	"github.com/joyent/containerpilot/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// monitoredJob is the part of a Job that the processes collector reads
+type monitoredJob interface {
+	Pid() int
+	Report() jobs.Report
+}
+
+// processCollector exposes the resource usage of each job's process, read
+// from /proc at scrape time, along with the job's restart count
+type processCollector struct {
+	jobs     []monitoredJob
+	jobsLock sync.RWMutex
+	fs       procfs.FS
+
+	cpu      *prometheus.Desc
+	rss      *prometheus.Desc
+	fds      *prometheus.Desc
+	restarts *prometheus.Desc
+}
+
+func newProcessCollector(jobList []monitoredJob, fs procfs.FS) *processCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName("containerpilot", "job", name),
+			help, []string{"job"}, nil)
+	}
+	return &processCollector{
+		jobs:     jobList,
+		fs:       fs,
+		cpu:      desc("cpu_seconds_total", "User and system CPU time of the job's process in seconds."),
+		rss:      desc("resident_memory_bytes", "Resident memory of the job's process in bytes."),
+		fds:      desc("open_fds", "Number of open file descriptors of the job's process."),
+		restarts: desc("restarts_total", "Number of times the job's process has been restarted."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *processCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cpu
+	ch <- c.rss
+	ch <- c.fds
+	ch <- c.restarts
+}
+
+// Collect implements prometheus.Collector. Jobs that aren't running only
+// report their restarts, and a process that exits while we're reading it
+// is skipped rather than failing the whole scrape.
+func (c *processCollector) Collect(ch chan<- prometheus.Metric) {
+	c.jobsLock.RLock()
+	jobList := c.jobs
+	c.jobsLock.RUnlock()
+	for _, job := range jobList {
+		report := job.Report()
+		ch <- prometheus.MustNewConstMetric(c.restarts,
+			prometheus.CounterValue, float64(report.Restarts), report.Name)
+
+		pid := job.Pid()
+		if pid == 0 {
+			continue
+		}
+		proc, err := c.fs.NewProc(pid)
+		if err != nil {
+			continue
+		}
+		if stat, err := proc.NewStat(); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.cpu,
+				prometheus.CounterValue, stat.CPUTime(), report.Name)
+			ch <- prometheus.MustNewConstMetric(c.rss,
+				prometheus.GaugeValue, float64(stat.ResidentMemory()), report.Name)
+		}
+		if fds, err := proc.FileDescriptorsLen(); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.fds,
+				prometheus.GaugeValue, float64(fds), report.Name)
+		}
+	}
+}
+
+// MonitorJobs exposes the resource usage of the jobs' processes on the
+// telemetry endpoint while the telemetry server is running. Calling it
+// again replaces the jobs, such as after a reload that changed them.
+func (t *Telemetry) MonitorJobs(jobList []*jobs.Job) {
+	monitored := make([]monitoredJob, len(jobList))
+	for i, job := range jobList {
+		monitored[i] = job
+	}
+	if t.processes != nil {
+		t.processes.jobsLock.Lock()
+		t.processes.jobs = monitored
+		t.processes.jobsLock.Unlock()
+		return
+	}
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		// no /proc, which is expected outside of Linux
+		return
+	}
+	t.processes = newProcessCollector(monitored, fs)
+}
@@ -0,0 +1,79 @@
+package telemetry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joyent/containerpilot/jobs"
+	"github.com/joyent/containerpilot/tests/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+type fakeJob struct {
+	pid    int
+	report jobs.Report
+}
+
+func (j *fakeJob) Pid() int            { return j.pid }
+func (j *fakeJob) Report() jobs.Report { return j.report }
+
+// writeFakeProc writes the /proc files for a process with 150 ticks of
+// user time, 50 ticks of system time, 10 pages of RSS, and 3 open files
+func writeFakeProc(t *testing.T, root string, pid string) {
+	dir := filepath.Join(root, pid)
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stat := pid + " (app) S 1 " + pid + " " + pid +
+		" 0 -1 0 0 0 0 0 150 50 0 0 20 0 1 0 100 1000 10 0\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, fd := range []string{"0", "1", "2"} {
+		os.Symlink("/dev/null", filepath.Join(dir, "fd", fd))
+	}
+}
+
+func TestProcessCollector(t *testing.T) {
+	root, _ := ioutil.TempDir("", "proc")
+	defer os.RemoveAll(root)
+	writeFakeProc(t, root, "42")
+	fs, err := procfs.NewFS(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newProcessCollector([]monitoredJob{
+		&fakeJob{pid: 42, report: jobs.Report{Name: "app", Restarts: 2}},
+		&fakeJob{pid: 0, report: jobs.Report{Name: "stopped"}},
+		&fakeJob{pid: 43, report: jobs.Report{Name: "exited", Restarts: 1}},
+	}, fs))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			name := family.GetName() + "/" + m.GetLabel()[0].GetValue()
+			if m.GetCounter() != nil {
+				values[name] = m.GetCounter().GetValue()
+			} else {
+				values[name] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	assert.Equal(t, values, map[string]float64{
+		"containerpilot_job_cpu_seconds_total/app":     2,
+		"containerpilot_job_resident_memory_bytes/app": float64(10 * os.Getpagesize()),
+		"containerpilot_job_open_fds/app":              3,
+		"containerpilot_job_restarts_total/app":        2,
+		"containerpilot_job_restarts_total/stopped":    0,
+		"containerpilot_job_restarts_total/exited":     1,
+	}, "expected metrics %v but got %v")
+}
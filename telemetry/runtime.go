@@ -0,0 +1,22 @@
+package telemetry
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// the prometheus client registers these by default, so we unregister
+// them to turn them off and register them again if a reload turns them
+// back on. Collectors are identified by their descriptions, so new
+// collectors can be used for both.
+func setRuntimeMetrics(enabled bool) {
+	collectors := []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	}
+	for _, collector := range collectors {
+		if enabled {
+			// the only error is that it's already registered
+			prometheus.Register(collector)
+		} else {
+			prometheus.Unregister(collector)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// SensorConfig configures a command that's run on an interval and prints
+// metrics in the Prometheus text format, which are merged into the
+// telemetry endpoint
+type SensorConfig struct {
+	Name     string      `mapstructure:"name"`
+	Exec     interface{} `mapstructure:"exec"`
+	Metrics  []string    `mapstructure:"metrics"`
+	Interval string      `mapstructure:"interval"`
+	Timeout  string      `mapstructure:"timeout"`
+
+	exec     *commands.Command
+	metrics  map[string]bool
+	interval time.Duration
+	timeout  time.Duration
+}
+
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// reservedPrefixes are those of ContainerPilot's own metrics and of the
+// Go runtime and process collectors
+var reservedPrefixes = []string{"containerpilot_", "go_", "process_"}
+
+// NewSensorConfigs parses and validates the telemetry.sensors config
+func NewSensorConfigs(raw []interface{}) ([]*SensorConfig, error) {
+	var sensors []*SensorConfig
+	if err := utils.DecodeRaw(raw, &sensors); err != nil {
+		return nil, fmt.Errorf("sensor configuration error: %v", err)
+	}
+	names := map[string]bool{}
+	reportedBy := map[string]string{}
+	for _, sensor := range sensors {
+		if err := sensor.Validate(); err != nil {
+			return nil, err
+		}
+		if names[sensor.Name] {
+			return nil, fmt.Errorf("sensor[%s] is defined more than once", sensor.Name)
+		}
+		names[sensor.Name] = true
+		for _, metric := range sensor.Metrics {
+			if other, ok := reportedBy[metric]; ok {
+				return nil, fmt.Errorf("sensor[%s].metrics: %s is also reported "+
+					"by sensor[%s]", sensor.Name, metric, other)
+			}
+			reportedBy[metric] = sensor.Name
+		}
+	}
+	return sensors, nil
+}
+
+// Validate parses the exec, the metrics, and the interval and timeout.
+// The timeout defaults to the interval so that runs don't overlap.
+func (cfg *SensorConfig) Validate() error {
+	if cfg.Name == "" {
+		return fmt.Errorf("sensor must have a name")
+	}
+	cmd, err := commands.NewCommand(cfg.Exec, 0, log.Fields{"sensor": cfg.Name})
+	if err != nil {
+		return fmt.Errorf("sensor[%s].exec is invalid: %v", cfg.Name, err)
+	}
+	cmd.Name = "sensor." + cfg.Name
+	cfg.exec = cmd
+	if err := cfg.validateMetrics(); err != nil {
+		return err
+	}
+	if cfg.Interval == "" {
+		cfg.Interval = "15s"
+	}
+	interval, err := utils.GetTimeout(cfg.Interval)
+	if err != nil {
+		return fmt.Errorf("sensor[%s].interval %v", cfg.Name, err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("sensor[%s].interval must be positive", cfg.Name)
+	}
+	cfg.interval = interval
+	timeout, err := utils.GetTimeout(cfg.Timeout)
+	if err != nil {
+		return fmt.Errorf("sensor[%s].timeout %v", cfg.Name, err)
+	}
+	if timeout < 0 {
+		return fmt.Errorf("sensor[%s].timeout must be positive", cfg.Name)
+	}
+	if timeout == 0 {
+		timeout = interval
+	}
+	cfg.timeout = timeout
+	cfg.exec.Timeout = timeout
+	return nil
+}
+
+// validateMetrics checks the names of the metrics that the sensor reports.
+// They're listed up front so that a sensor can't collide with other metrics
+// and break scrapes of the telemetry endpoint.
+func (cfg *SensorConfig) validateMetrics() error {
+	if len(cfg.Metrics) == 0 {
+		return fmt.Errorf("sensor[%s].metrics must list the metrics it reports", cfg.Name)
+	}
+	cfg.metrics = map[string]bool{}
+	for _, name := range cfg.Metrics {
+		if !metricNameRe.MatchString(name) {
+			return fmt.Errorf("sensor[%s].metrics: %s is not a valid metric name",
+				cfg.Name, name)
+		}
+		for _, prefix := range reservedPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return fmt.Errorf("sensor[%s].metrics: %s uses the reserved "+
+					"prefix '%s'", cfg.Name, name, prefix)
+			}
+		}
+		if cfg.metrics[name] {
+			return fmt.Errorf("sensor[%s].metrics: %s is listed more than once",
+				cfg.Name, name)
+		}
+		cfg.metrics[name] = true
+	}
+	return nil
+}
+
+// sensor runs its command on an interval and exposes the metrics from
+// its last successful run
+type sensor struct {
+	cfg *SensorConfig
+
+	lock    sync.RWMutex
+	metrics []prometheus.Metric
+}
+
+func newSensor(cfg *SensorConfig) *sensor {
+	return &sensor{cfg: cfg}
+}
+
+// sensorCollector exposes the metrics of the running sensors. It's an
+// unchecked collector, as we don't know the labels of the metrics that
+// the commands will print until they run. Unchecked collectors can't be
+// unregistered, so we register one for the process and swap its sensors
+// on reload. The metric names are checked at config time and each run's
+// output is checked before it's swapped in, so a scrape can't fail.
+type sensorCollector struct {
+	lock    sync.RWMutex
+	owner   *Telemetry
+	sensors []*sensor
+}
+
+var runningSensors = &sensorCollector{}
+
+func init() {
+	prometheus.MustRegister(runningSensors)
+}
+
+func (c *sensorCollector) set(owner *Telemetry, sensors []*sensor) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.owner = owner
+	c.sensors = sensors
+}
+
+// release removes the sensors of a stopped Telemetry, unless a reloaded
+// Telemetry has already replaced them
+func (c *sensorCollector) release(owner *Telemetry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.owner == owner {
+		c.owner = nil
+		c.sensors = nil
+	}
+}
+
+// Describe implements prometheus.Collector. Sending no descriptions
+// registers this as an unchecked collector.
+func (c *sensorCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (c *sensorCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, s := range c.sensors {
+		s.collect(ch)
+	}
+}
+
+// run executes the command immediately and then on each interval until
+// the context is canceled
+func (s *sensor) run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.interval)
+	defer ticker.Stop()
+	for {
+		s.update(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update runs the command once. If it fails, the sensor's metrics are
+// removed rather than left frozen at their last values.
+func (s *sensor) update(ctx context.Context) {
+	metrics, err := s.execute(ctx)
+	if err != nil {
+		log.Warnf("sensor[%s]: %v", s.cfg.Name, err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.metrics = metrics
+}
+
+// execute runs the command, which is killed along with its process group
+// if it times out, and parses and checks the metrics it prints
+func (s *sensor) execute(ctx context.Context) ([]prometheus.Metric, error) {
+	var stdout bytes.Buffer
+	s.cfg.exec.Stdout = &stdout
+	if err := s.cfg.exec.RunAndWait(ctx); err != nil {
+		return nil, err
+	}
+	var parser expfmt.TextParser
+	parsed, err := parser.TextToMetricFamilies(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics output: %v", err)
+	}
+	var metrics []prometheus.Metric
+	for name, family := range parsed {
+		if !s.cfg.metrics[name] {
+			return nil, fmt.Errorf("metric %s isn't listed in the sensor's metrics", name)
+		}
+		for _, m := range family.GetMetric() {
+			metric, err := constMetric(family, m)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metric %s: %v", name, err)
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	// catches duplicate samples and inconsistent labels, which would
+	// otherwise fail the scrape
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(constCollector(metrics))
+	if _, err := registry.Gather(); err != nil {
+		return nil, fmt.Errorf("invalid metrics output: %v", err)
+	}
+	return metrics, nil
+}
+
+func (s *sensor) collect(ch chan<- prometheus.Metric) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, metric := range s.metrics {
+		ch <- metric
+	}
+}
+
+// constCollector is an unchecked collector of the metrics from one run
+type constCollector []prometheus.Metric
+
+func (c constCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c constCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range c {
+		ch <- metric
+	}
+}
+
+// constMetric converts a parsed sample back into a prometheus.Metric
+func constMetric(family *dto.MetricFamily, m *dto.Metric) (prometheus.Metric, error) {
+	names := make([]string, 0, len(m.GetLabel()))
+	values := make([]string, 0, len(m.GetLabel()))
+	for _, label := range m.GetLabel() {
+		names = append(names, label.GetName())
+		values = append(values, label.GetValue())
+	}
+	desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), names, nil)
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue,
+			m.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue,
+			m.GetGauge().GetValue(), values...)
+	case dto.MetricType_HISTOGRAM:
+		buckets := map[float64]uint64{}
+		for _, bucket := range m.GetHistogram().GetBucket() {
+			if math.IsInf(bucket.GetUpperBound(), +1) {
+				continue // added by the exposition
+			}
+			buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+		}
+		return prometheus.NewConstHistogram(desc,
+			m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(),
+			buckets, values...)
+	case dto.MetricType_SUMMARY:
+		quantiles := map[float64]float64{}
+		for _, quantile := range m.GetSummary().GetQuantile() {
+			quantiles[quantile.GetQuantile()] = quantile.GetValue()
+		}
+		return prometheus.NewConstSummary(desc,
+			m.GetSummary().GetSampleCount(), m.GetSummary().GetSampleSum(),
+			quantiles, values...)
+	default:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue,
+			m.GetUntyped().GetValue(), values...)
+	}
+}
@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/assert"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSensorConfig(t *testing.T) {
+	sensors, err := NewSensorConfigs(tests.DecodeRawToSlice(
+		`[{name: "queue", exec: "/bin/queue-stats --prometheus",
+		   metrics: ["queue_depth"], interval: "30s"}]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, sensors[0].exec.Exec, "/bin/queue-stats", "expected exec %v but got %v")
+	assert.Equal(t, sensors[0].exec.Args, []string{"--prometheus"}, "expected args %v but got %v")
+	assert.Equal(t, sensors[0].exec.Name, "sensor.queue", "expected name %v but got %v")
+	assert.Equal(t, sensors[0].interval, 30*time.Second, "expected interval %v but got %v")
+	assert.Equal(t, sensors[0].timeout, 30*time.Second, "expected timeout %v but got %v")
+	assert.Equal(t, sensors[0].exec.Timeout, 30*time.Second, "expected exec timeout %v but got %v")
+
+	expectErr := func(raw, errMsg string) {
+		_, err := NewSensorConfigs(tests.DecodeRawToSlice(raw))
+		assert.Error(t, err, errMsg)
+	}
+	expectErr(`[{exec: "true"}]`, "sensor must have a name")
+	expectErr(`[{name: "a"}]`, "sensor[a].exec is invalid: received zero-length argument")
+	expectErr(`[{name: "a", exec: "true", metrics: ["m"], interval: "0s"}]`,
+		"sensor[a].interval must be positive")
+	expectErr(`[{name: "a", exec: "true", metrics: ["m"]},
+		{name: "a", exec: "true", metrics: ["n"]}]`,
+		"sensor[a] is defined more than once")
+	expectErr(`[{name: "a", exec: "true"}]`,
+		"sensor[a].metrics must list the metrics it reports")
+	expectErr(`[{name: "a", exec: "true", metrics: ["queue-depth"]}]`,
+		"sensor[a].metrics: queue-depth is not a valid metric name")
+	expectErr(`[{name: "a", exec: "true", metrics: ["m", "m"]}]`,
+		"sensor[a].metrics: m is listed more than once")
+	expectErr(`[{name: "a", exec: "true", metrics: ["go_goroutines"]}]`,
+		"sensor[a].metrics: go_goroutines uses the reserved prefix 'go_'")
+	expectErr(`[{name: "a", exec: "true", metrics: ["m"]},
+		{name: "b", exec: "true", metrics: ["m"]}]`,
+		"sensor[b].metrics: m is also reported by sensor[a]")
+}
+
+const sensorOutput = `# HELP queue_depth Messages waiting.
+# TYPE queue_depth gauge
+queue_depth{queue="jobs"} 12
+queue_depth{queue="mail"} 3
+# TYPE queue_latency_seconds histogram
+queue_latency_seconds_bucket{le="0.1"} 4
+queue_latency_seconds_bucket{le="+Inf"} 5
+queue_latency_seconds_sum 1.5
+queue_latency_seconds_count 5
+`
+
+func TestSensorCollect(t *testing.T) {
+	cfg := &SensorConfig{Name: "queue",
+		Exec:    []string{"printf", "%s", sensorOutput},
+		Metrics: []string{"queue_depth", "queue_latency_seconds"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := newSensor(cfg)
+	collector := &sensorCollector{}
+	collector.set(nil, []*sensor{s})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	s.update(context.Background())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, len(families), 2, "expected %v metric families but got %v")
+	assert.Equal(t, families[0].GetName(), "queue_depth", "expected %v but got %v")
+	assert.Equal(t, families[0].GetHelp(), "Messages waiting.", "expected help %v but got %v")
+	assert.Equal(t, families[0].GetMetric()[0].GetGauge().GetValue(), 12.0,
+		"expected value %v but got %v")
+	histogram := families[1].GetMetric()[0].GetHistogram()
+	assert.Equal(t, histogram.GetSampleCount(), uint64(5), "expected count %v but got %v")
+	assert.Equal(t, len(histogram.GetBucket()), 1, "expected %v bucket but got %v")
+
+	// a failing run removes the sensor's metrics
+	cfg.exec.Exec, cfg.exec.Args = "false", nil
+	s.update(context.Background())
+	families, _ = registry.Gather()
+	assert.Equal(t, len(families), 0, "expected %v metric families after failure but got %v")
+
+	// invalid output is treated as a failure
+	cfg.exec.Exec, cfg.exec.Args = "echo", []string{"not metrics"}
+	s.update(context.Background())
+	families, _ = registry.Gather()
+	assert.Equal(t, len(families), 0, "expected %v metric families for bad output but got %v")
+
+	// so are metrics that aren't listed, and duplicate samples, rather
+	// than failing the scrape
+	cfg.exec.Exec, cfg.exec.Args = "echo", []string{"queue_size 1"}
+	s.update(context.Background())
+	families, err = registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error for unlisted metric: %v", err)
+	}
+	assert.Equal(t, len(families), 0, "expected %v metric families for unlisted metric but got %v")
+	cfg.exec.Exec, cfg.exec.Args = "printf", []string{"queue_depth 1\nqueue_depth 2\n"}
+	s.update(context.Background())
+	families, err = registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error for duplicate samples: %v", err)
+	}
+	assert.Equal(t, len(families), 0, "expected %v metric families for duplicates but got %v")
+}
+
+func TestSensorTimeout(t *testing.T) {
+	cfg := &SensorConfig{Name: "slow",
+		Exec:    []string{"sh", "-c", "sleep 10 & sleep 10"},
+		Metrics: []string{"m"}, Timeout: "100ms"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Now()
+	_, err := newSensor(cfg).execute(context.Background())
+	assert.Error(t, err, "sensor.slow timeout after 100ms")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the process group to be killed, but waited %v", elapsed)
+	}
+}
+
+func TestSensorCollectorRelease(t *testing.T) {
+	old, reloaded := &Telemetry{}, &Telemetry{}
+	collector := &sensorCollector{}
+	collector.set(old, []*sensor{{}})
+	collector.set(reloaded, []*sensor{{}})
+	collector.release(old)
+	assert.Equal(t, len(collector.sensors), 1,
+		"expected %v sensor after stale release but got %v")
+	collector.release(reloaded)
+	assert.Equal(t, len(collector.sensors), 0,
+		"expected %v sensors after release but got %v")
+}
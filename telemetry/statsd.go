@@ -0,0 +1,154 @@
+package telemetry
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// StatsDConfig configures forwarding of metrics to a StatsD or DogStatsD
+// agent, in addition to the Prometheus endpoint
+type StatsDConfig struct {
+	Address string   `mapstructure:"address"`
+	Type    string   `mapstructure:"type"`
+	Prefix  string   `mapstructure:"prefix"`
+	Tags    []string `mapstructure:"tags"`
+}
+
+// NewStatsDConfig parses and validates the telemetry.statsd config
+func NewStatsDConfig(raw interface{}) (*StatsDConfig, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	cfg := &StatsDConfig{Type: "statsd"}
+	if err := utils.DecodeRaw(raw, cfg); err != nil {
+		return nil, fmt.Errorf("statsd configuration error: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate sets the default address and checks that tags are only used
+// with DogStatsD, as plain StatsD has no tags
+func (cfg *StatsDConfig) Validate() error {
+	switch cfg.Type {
+	case "statsd":
+		if len(cfg.Tags) > 0 {
+			return fmt.Errorf("statsd.tags require statsd.type 'dogstatsd'")
+		}
+	case "dogstatsd":
+	default:
+		return fmt.Errorf("invalid statsd.type '%s': must be 'statsd' or 'dogstatsd'",
+			cfg.Type)
+	}
+	if cfg.Address == "" {
+		cfg.Address = defaultStatsDAddress()
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return fmt.Errorf("invalid statsd.address '%s': %v", cfg.Address, err)
+	}
+	return nil
+}
+
+// defaultStatsDAddress uses the Datadog agent's environment variables,
+// which are usually set to the node's agent when running on Kubernetes
+// or ECS, falling back to the standard local port
+func defaultStatsDAddress() string {
+	host := os.Getenv("DD_AGENT_HOST")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := os.Getenv("DD_DOGSTATSD_PORT")
+	if port == "" {
+		port = "8125"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// statsdSink sends metric values to the agent over UDP
+type statsdSink struct {
+	address   string
+	prefix    string
+	tags      []string
+	dogstatsd bool
+
+	lock sync.Mutex
+	conn net.Conn
+}
+
+func newStatsDSink(cfg *StatsDConfig) *statsdSink {
+	if cfg == nil {
+		return nil
+	}
+	return &statsdSink{
+		address:   cfg.Address,
+		prefix:    cfg.Prefix,
+		tags:      cfg.Tags,
+		dogstatsd: cfg.Type == "dogstatsd",
+	}
+}
+
+// format returns the StatsD line for a value. Histograms and summaries
+// are sent as DogStatsD histograms, or as timers for plain StatsD.
+func (s *statsdSink) format(name string, metricType MetricType, value float64, tags []string) string {
+	var kind string
+	switch metricType {
+	case Counter:
+		kind = "c"
+	case Gauge:
+		kind = "g"
+	default:
+		kind = "ms"
+		if s.dogstatsd {
+			kind = "h"
+		}
+	}
+	line := fmt.Sprintf("%s%s:%s|%s", s.prefix, name,
+		strconv.FormatFloat(value, 'f', -1, 64), kind)
+	if s.dogstatsd {
+		allTags := append(append([]string{}, s.tags...), tags...)
+		if len(allTags) > 0 {
+			line += "|#" + strings.Join(allTags, ",")
+		}
+	}
+	return line
+}
+
+// send writes the value to the agent. Metrics are best-effort, so errors
+// are only logged, and the address is resolved again on the next send in
+// case the agent has moved.
+func (s *statsdSink) send(name string, metricType MetricType, value float64, tags []string) {
+	line := s.format(name, metricType, value, tags)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn == nil {
+		conn, err := net.Dial("udp", s.address)
+		if err != nil {
+			log.Debugf("statsd: unable to connect to %s: %v", s.address, err)
+			return
+		}
+		s.conn = conn
+	}
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		log.Debugf("statsd: unable to send to %s: %v", s.address, err)
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *statsdSink) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
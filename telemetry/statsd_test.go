@@ -0,0 +1,72 @@
+package telemetry
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/tests/assert"
+)
+
+func TestStatsDConfig(t *testing.T) {
+	cfg, err := NewStatsDConfig(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, cfg.Address, "127.0.0.1:8125", "expected address %v but got %v")
+	assert.Equal(t, cfg.Type, "statsd", "expected type %v but got %v")
+
+	os.Setenv("DD_AGENT_HOST", "10.0.0.1")
+	defer os.Unsetenv("DD_AGENT_HOST")
+	cfg, _ = NewStatsDConfig(map[string]interface{}{"type": "dogstatsd"})
+	assert.Equal(t, cfg.Address, "10.0.0.1:8125", "expected address %v but got %v")
+
+	_, err = NewStatsDConfig(map[string]interface{}{"tags": []string{"env:prod"}})
+	assert.Error(t, err, "statsd.tags require statsd.type 'dogstatsd'")
+	_, err = NewStatsDConfig(map[string]interface{}{"type": "graphite"})
+	assert.Error(t, err, "invalid statsd.type 'graphite': must be 'statsd' or 'dogstatsd'")
+	_, err = NewStatsDConfig(map[string]interface{}{"address": "localhost"})
+	assert.Error(t, err, "invalid statsd.address 'localhost': address localhost: missing port in address")
+}
+
+func TestStatsDFormat(t *testing.T) {
+	statsd := &statsdSink{prefix: "app."}
+	assert.Equal(t, statsd.format("requests", Counter, 2, nil),
+		"app.requests:2|c", "expected %v but got %v")
+	assert.Equal(t, statsd.format("latency", Histogram, 0.25, []string{"ignored"}),
+		"app.latency:0.25|ms", "expected %v but got %v")
+
+	dogstatsd := &statsdSink{dogstatsd: true, tags: []string{"env:prod"}}
+	assert.Equal(t, dogstatsd.format("free_memory", Gauge, 1024, []string{"host:a"}),
+		"free_memory:1024|g|#env:prod,host:a", "expected %v but got %v")
+	assert.Equal(t, dogstatsd.format("latency", Summary, 1.5, nil),
+		"latency:1.5|h|#env:prod", "expected %v but got %v")
+}
+
+func TestStatsDSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	cfg := &MetricConfig{
+		Namespace: "telemetry",
+		Subsystem: "statsd",
+		Name:      "TestStatsDSend",
+		Type:      "counter",
+	}
+	cfg.Validate()
+	metric := NewMetric(cfg)
+	metric.sink = &statsdSink{address: conn.LocalAddr().String()}
+	defer metric.sink.close()
+
+	metric.processMetric(metric.Name + "|3")
+	buf := make([]byte, 512)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, string(buf[:n]), "telemetry_statsd_TestStatsDSend:3|c", "expected %v but got %v")
+}
@@ -2,6 +2,7 @@ package telemetry
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 	"time"
@@ -19,6 +20,12 @@ type Telemetry struct {
 	heartbeat time.Duration
 	router    *http.ServeMux
 	addr      net.TCPAddr
+	statsd    *statsdSink
+	otlp      *otlpExporter
+	processes *processCollector
+	sensors   []*sensor
+	cancel    context.CancelFunc // stops the sensors
+	tlsConfig *tls.Config
 
 	http.Server
 	events.EventHandler // Event handling
@@ -37,9 +44,29 @@ func NewTelemetry(cfg *Config) *Telemetry {
 	router := http.NewServeMux()
 	router.Handle(t.Path, prometheus.Handler())
 	t.Handler = router
+	if cfg.BasicAuth != nil {
+		t.Handler = basicAuth{
+			username: cfg.BasicAuth.Username,
+			password: cfg.BasicAuth.Password,
+			handler:  router,
+		}
+	}
+	if cfg.TLS != nil {
+		t.tlsConfig = cfg.TLS.ServerConfig()
+	}
 
+	t.statsd = newStatsDSink(cfg.StatsDConfig)
+	otlp, err := newOTLPExporter(cfg.OTLPConfig)
+	if err != nil {
+		log.Errorf("telemetry: not exporting metrics: %v", err)
+	}
+	t.otlp = otlp
+	for _, sensorCfg := range cfg.SensorConfigs {
+		t.sensors = append(t.sensors, newSensor(sensorCfg))
+	}
 	for _, sensorCfg := range cfg.MetricConfigs {
 		sensor := NewMetric(sensorCfg)
+		sensor.sink = t.statsd
 		t.Metrics = append(t.Metrics, sensor)
 	}
 	t.Rx = make(chan events.Event, 10)
@@ -50,25 +77,57 @@ func NewTelemetry(cfg *Config) *Telemetry {
 func (t *Telemetry) Run(bus *events.EventBus) {
 	t.Subscribe(bus, true)
 	t.Bus = bus
+	if t.processes != nil {
+		// unregister first in case the previous config's collector is
+		// still registered during a reload
+		prometheus.Unregister(t.processes)
+		if err := prometheus.Register(t.processes); err != nil {
+			log.Errorf("telemetry: unable to collect job metrics: %v", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	for _, s := range t.sensors {
+		go s.run(ctx)
+	}
+	runningSensors.set(t, t.sensors)
 	t.Start()
 
 	go func() {
 		defer t.Stop()
+		var export <-chan time.Time
+		if t.otlp != nil {
+			ticker := time.NewTicker(t.otlp.cfg.interval)
+			defer ticker.Stop()
+			export = ticker.C
+		}
 		for {
-			event := <-t.Rx
-			switch event {
-			case
-				events.QuitByClose,
-				events.GlobalShutdown:
-				return
+			select {
+			case event := <-t.Rx:
+				switch event {
+				case
+					events.QuitByClose,
+					events.GlobalShutdown:
+					return
+				}
+			case <-export:
+				t.otlp.export()
 			}
 		}
 	}()
 }
 
-// Start starts serving the telemetry service
+// Start starts serving the telemetry service. If the port is 0 we don't
+// listen at all, and metrics can only be scraped via the control server.
 func (t *Telemetry) Start() {
+	if t.addr.Port == 0 {
+		log.Info("telemetry: port is 0, not serving metrics over TCP")
+		return
+	}
 	ln := t.listenWithRetry()
+	if t.tlsConfig != nil {
+		ln = tls.NewListener(ln, t.tlsConfig)
+	}
 	go func() {
 		log.Infof("telemetry: serving at %s", t.Addr)
 		t.Serve(ln)
@@ -98,6 +157,22 @@ func (t *Telemetry) listenWithRetry() net.Listener {
 // Stop shuts down the telemetry service
 func (t *Telemetry) Stop() {
 	log.Debug("telemetry: stopping server")
+	if t.processes != nil {
+		prometheus.Unregister(t.processes)
+	}
+	if t.cancel != nil {
+		t.cancel()
+	}
+	runningSensors.release(t)
+	if t.statsd != nil {
+		t.statsd.close()
+	}
+	if t.otlp != nil {
+		// push the final values, as short-lived containers may exit
+		// before the next interval
+		t.otlp.export()
+		t.otlp.close()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
 	if err := t.Shutdown(ctx); err != nil {
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/joyent/containerpilot/commands"
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/utils"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Config represents the service to advertise for finding the metrics
@@ -16,9 +18,21 @@ type Config struct {
 	Interfaces []interface{} `mapstructure:"interfaces"` // optional override
 	Tags       []string      `mapstructure:"tags"`
 	Metrics    []interface{} `mapstructure:"metrics"`
+	StatsD     interface{}   `mapstructure:"statsd"`
+	OTLP       interface{}   `mapstructure:"otlp"`
+	Sensors    []interface{} `mapstructure:"sensors"`
+
+	TLS       *TLSConfig       `mapstructure:"tls"`
+	BasicAuth *BasicAuthConfig `mapstructure:"basicAuth"`
+
+	// RuntimeMetrics exposes the Go runtime and process collectors
+	RuntimeMetrics bool `mapstructure:"runtimeMetrics"`
 
 	// derived in Validate
 	MetricConfigs []*MetricConfig
+	StatsDConfig  *StatsDConfig
+	OTLPConfig    *OTLPConfig
+	SensorConfigs []*SensorConfig
 	JobConfig     *jobs.Config
 	addr          net.TCPAddr
 }
@@ -29,13 +43,23 @@ func NewConfig(raw interface{}, disc discovery.Backend) (*Config, error) {
 	if raw == nil {
 		return nil, nil
 	}
-	cfg := &Config{Port: 9090} // default values
+	cfg := &Config{Port: 9090, RuntimeMetrics: true} // default values
 	if err := utils.DecodeRaw(raw, cfg); err != nil {
 		return nil, fmt.Errorf("telemetry configuration error: %v", err)
 	}
 	if err := cfg.Validate(disc); err != nil {
 		return nil, fmt.Errorf("telemetry validation error: %v", err)
 	}
+	if cfg.TLS != nil {
+		if err := cfg.TLS.Validate(); err != nil {
+			return nil, fmt.Errorf("telemetry validation error: %v", err)
+		}
+	}
+	if cfg.BasicAuth != nil {
+		if err := cfg.BasicAuth.Validate(); err != nil {
+			return nil, fmt.Errorf("telemetry validation error: %v", err)
+		}
+	}
 	if cfg.Metrics != nil {
 		// note that we don't return an error if there are no metrics
 		// because the prometheus handler will still pick up metrics
@@ -46,9 +70,49 @@ func NewConfig(raw interface{}, disc discovery.Backend) (*Config, error) {
 		}
 		cfg.MetricConfigs = metrics
 	}
+	statsd, err := NewStatsDConfig(cfg.StatsD)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry validation error: %v", err)
+	}
+	cfg.StatsDConfig = statsd
+	otlp, err := NewOTLPConfig(cfg.OTLP)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry validation error: %v", err)
+	}
+	cfg.OTLPConfig = otlp
+	sensors, err := NewSensorConfigs(cfg.Sensors)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry validation error: %v", err)
+	}
+	cfg.SensorConfigs = sensors
+	for _, metric := range cfg.MetricConfigs {
+		name := prometheus.BuildFQName(metric.Namespace, metric.Subsystem, metric.Name)
+		for _, sensor := range sensors {
+			if sensor.metrics[name] {
+				return nil, fmt.Errorf("telemetry validation error: "+
+					"sensor[%s].metrics: %s is also defined in metrics",
+					sensor.Name, name)
+			}
+		}
+	}
+	setRuntimeMetrics(cfg.RuntimeMetrics)
+	for _, metric := range cfg.MetricConfigs {
+		if len(metric.Tags) > 0 && (statsd == nil || statsd.Type != "dogstatsd") {
+			return nil, fmt.Errorf("telemetry validation error: metric %s has "+
+				"tags, which require statsd.type 'dogstatsd'", metric.Name)
+		}
+	}
 	return cfg, nil
 }
 
+// LimitSensors limits how many of the sensors' commands run at the same
+// time as other commands
+func (cfg *Config) LimitSensors(limiter *commands.Limiter) {
+	for _, sensor := range cfg.SensorConfigs {
+		sensor.exec.Limiters = append(sensor.exec.Limiters, limiter)
+	}
+}
+
 // Validate ...
 func (cfg *Config) Validate(disc discovery.Backend) error {
 	ipAddress, err := utils.IPFromInterfaces(cfg.Interfaces)
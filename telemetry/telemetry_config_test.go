@@ -35,6 +35,35 @@ func TestTelemetryConfigBadMetric(t *testing.T) {
 	}
 }
 
+func TestTelemetryConfigMetricTags(t *testing.T) {
+	testCfg := tests.DecodeRaw(`{"interfaces": ["inet"], "metrics": [
+	{"name": "tagged", "type": "gauge", "tags": ["env:prod"]}]}`)
+	_, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	assert.Error(t, err, "telemetry validation error: metric tagged has tags, "+
+		"which require statsd.type 'dogstatsd'")
+
+	testCfg = tests.DecodeRaw(`{"interfaces": ["inet"],
+	"statsd": {"type": "dogstatsd", "tags": ["service:app"]},
+	"metrics": [{"name": "tagged", "type": "gauge", "tags": ["env:prod"]}]}`)
+	telem, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, telem.StatsDConfig.Tags, []string{"service:app"},
+		"expected statsd tags %v but got %v")
+	assert.Equal(t, telem.MetricConfigs[0].Tags, []string{"env:prod"},
+		"expected metric tags %v but got %v")
+}
+
+func TestTelemetryConfigSensorMetricCollision(t *testing.T) {
+	testCfg := tests.DecodeRaw(`{"interfaces": ["inet"],
+	"metrics": [{"namespace": "app", "name": "queue_depth", "type": "gauge"}],
+	"sensors": [{"name": "queue", "exec": "true", "metrics": ["app_queue_depth"]}]}`)
+	_, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
+	assert.Error(t, err, "telemetry validation error: sensor[queue].metrics: "+
+		"app_queue_depth is also defined in metrics")
+}
+
 func TestTelemetryConfigBadInterface(t *testing.T) {
 	testCfg := tests.DecodeRaw(`{"interfaces": ["xxxx"]}`)
 	_, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
@@ -43,3 +72,28 @@ func TestTelemetryConfigBadInterface(t *testing.T) {
 		t.Fatalf("expected '%v' in error from bad metric type but got %v", expected, err)
 	}
 }
+
+func TestTelemetryConfigRuntimeMetrics(t *testing.T) {
+	hasGoMetrics := func() bool {
+		families, _ := prometheus.DefaultGatherer.Gather()
+		for _, family := range families {
+			if family.GetName() == "go_goroutines" {
+				return true
+			}
+		}
+		return false
+	}
+	_, err := NewConfig(tests.DecodeRaw(`{"interfaces": ["inet"], "runtimeMetrics": false}`),
+		&mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.False(t, hasGoMetrics(), "expected Go runtime metrics to be disabled")
+
+	_, err = NewConfig(tests.DecodeRaw(`{"interfaces": ["inet"]}`),
+		&mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.True(t, hasGoMetrics(), "expected Go runtime metrics to be enabled on reload")
+}
@@ -28,6 +28,21 @@ func TestTelemetryServerRestart(t *testing.T) {
 	checkServerIsListening(t, telem)
 }
 
+// With port 0 we don't open a TCP listener or advertise the service, but
+// the server still starts and stops cleanly
+func TestTelemetryServerNoPort(t *testing.T) {
+	cfg := &Config{Port: 0, Interfaces: []interface{}{"lo", "lo0", "inet"}}
+	if err := cfg.Validate(&mocks.NoopDiscoveryBackend{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.JobConfig.Port != 0 {
+		t.Fatalf("expected telemetry service to not be advertised")
+	}
+	telem := NewTelemetry(cfg)
+	telem.Run(events.NewEventBus())
+	telem.Stop()
+}
+
 func checkServerIsListening(t *testing.T, telem *Telemetry) {
 
 	url := fmt.Sprintf("http://%v:%v/metrics", telem.addr.IP, telem.addr.Port)
@@ -0,0 +1,75 @@
+package telemetry
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+// TLSConfig configures serving the telemetry endpoint over HTTPS
+type TLSConfig struct {
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+
+	certificate tls.Certificate
+}
+
+// Validate loads the certificate and key so that we can fail at config
+// load time rather than when we start serving
+func (cfg *TLSConfig) Validate() error {
+	if cfg.Cert == "" || cfg.Key == "" {
+		return fmt.Errorf("telemetry.tls requires both 'cert' and 'key'")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return fmt.Errorf("unable to load telemetry.tls certificate: %v", err)
+	}
+	cfg.certificate = cert
+	return nil
+}
+
+// ServerConfig returns the tls.Config used by the telemetry server
+func (cfg *TLSConfig) ServerConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cfg.certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// BasicAuthConfig configures the credentials required to scrape the
+// telemetry endpoint
+type BasicAuthConfig struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// Validate ensures both credentials are set
+func (cfg *BasicAuthConfig) Validate() error {
+	if cfg.Username == "" || cfg.Password == "" {
+		return fmt.Errorf("telemetry.basicAuth requires both 'username' and 'password'")
+	}
+	return nil
+}
+
+// basicAuth wraps an http.Handler and requires requests to carry the
+// configured credentials
+type basicAuth struct {
+	username string
+	password string
+	handler  http.Handler
+}
+
+func (a basicAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	// compare both so that we don't leak which one was wrong by timing
+	userOk := subtle.ConstantTimeCompare([]byte(username), []byte(a.username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1
+	if !ok || !userOk || !passOk {
+		w.Header().Set("WWW-Authenticate", `Basic realm="containerpilot"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized),
+			http.StatusUnauthorized)
+		return
+	}
+	a.handler.ServeHTTP(w, r)
+}
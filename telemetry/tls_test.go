@@ -0,0 +1,135 @@
+package telemetry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/assert"
+	"github.com/joyent/containerpilot/tests/mocks"
+)
+
+func TestTelemetryConfigTLS(t *testing.T) {
+	dir := writeTestCert(t)
+	defer os.RemoveAll(dir)
+	disc := &mocks.NoopDiscoveryBackend{}
+
+	_, err := NewConfig(tests.DecodeRaw(`{"tls": {"cert": "/nope.pem"}}`), disc)
+	assert.Error(t, err,
+		"telemetry validation error: telemetry.tls requires both 'cert' and 'key'")
+	_, err = NewConfig(tests.DecodeRaw(`{"basicAuth": {"username": "prometheus"}}`), disc)
+	assert.Error(t, err, "telemetry validation error: "+
+		"telemetry.basicAuth requires both 'username' and 'password'")
+	_, err = NewConfig(tests.DecodeRaw(fmt.Sprintf(
+		`{"tls": {"cert": %q, "key": %q}}`,
+		filepath.Join(dir, "cert.pem"), filepath.Join(dir, "cert.pem"))), disc)
+	if err == nil {
+		t.Fatalf("expected error for invalid key")
+	}
+}
+
+func TestTelemetryServerTLS(t *testing.T) {
+	dir := writeTestCert(t)
+	defer os.RemoveAll(dir)
+
+	// grab a free port from the OS and then release it for the server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cfg, err := NewConfig(tests.DecodeRaw(fmt.Sprintf(`{
+	"port": %d,
+	"interfaces": ["lo", "lo0", "inet"],
+	"tls": {"cert": %q, "key": %q},
+	"basicAuth": {"username": "prometheus", "password": "secret"}
+}`, port, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))),
+		&mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	telem := NewTelemetry(cfg)
+	telem.Run(events.NewEventBus())
+	defer telem.Stop()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	url := fmt.Sprintf("https://127.0.0.1:%d/metrics", port)
+	get := func(username, password string) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("could not connect to telemetry server: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, get("prometheus", "secret"), http.StatusOK,
+		"expected %v with credentials but got %v")
+	assert.Equal(t, get("prometheus", "wrong"), http.StatusUnauthorized,
+		"expected %v with wrong password but got %v")
+	assert.Equal(t, get("", ""), http.StatusUnauthorized,
+		"expected %v without credentials but got %v")
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", port))
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusBadRequest,
+			"expected %v for plain HTTP request but got %v")
+	}
+}
+
+// writeTestCert writes a self-signed certificate and key for 127.0.0.1
+func writeTestCert(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "containerpilot-telemetry-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "telemetry"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	writePEM := func(name, blockType string, data []byte) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		pem.Encode(f, &pem.Block{Type: blockType, Bytes: data})
+	}
+	writePEM("cert.pem", "CERTIFICATE", der)
+	writePEM("key.pem", "EC PRIVATE KEY", keyDER)
+	return dir
+}
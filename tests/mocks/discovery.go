@@ -30,6 +30,16 @@ func (noop *NoopDiscoveryBackend) PassTTL(checkID, note string) error {
 	return nil
 }
 
+// WarnTTL (required for mock interface)
+func (noop *NoopDiscoveryBackend) WarnTTL(checkID, note string) error {
+	return nil
+}
+
+// FailTTL (required for mock interface)
+func (noop *NoopDiscoveryBackend) FailTTL(checkID, note string) error {
+	return nil
+}
+
 // ServiceDeregister (required for mock interface)
 func (noop *NoopDiscoveryBackend) ServiceDeregister(serviceID string) error {
 	return nil
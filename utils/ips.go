@@ -128,6 +128,11 @@ func (s inetInterfaceSpec) Match(index int, iip interfaceIP) bool {
 	if s.Name == "*" && iip.IP.IsLoopback() {
 		return false
 	}
+	// IPv6 link-local addresses are present on every interface but can't
+	// be reached from other hosts, so they're only matched by CIDR spec
+	if s.IPv6 && iip.IP.IsLinkLocalUnicast() {
+		return false
+	}
 	return s.IPv6 != iip.IsIPv4()
 }
 
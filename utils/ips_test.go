@@ -272,6 +272,20 @@ func TestFindIPWithSpecs(t *testing.T) {
 	testIPSpec(t, iips, "fdc6:238c:c4bc::1", "eth3", "fdc6:238c:c4bc::/48", "inet", "inet6")
 	testIPSpec(t, iips, "10.0.0.100", "eth3", "10.0.0.0/16", "inet", "inet6", "fdc6:238c:c4bc::/48")
 
+	// IPv6 prefixes, and link-local addresses only by CIDR
+	dualStack := []interfaceIP{
+		newInterfaceIP("eth0", "10.2.0.1"),
+		newInterfaceIP("eth0", "2001:db8::10"),
+		newInterfaceIP("eth0", "fd00:1::10"),
+		newInterfaceIP("eth0", "fe80::1"),
+		newInterfaceIP("eth1", "fe80::2"),
+	}
+	testIPSpec(t, dualStack, "fd00:1::10", "fd00::/8")
+	testIPSpec(t, dualStack, "2001:db8::10", "inet6", "inet")
+	testIPSpec(t, dualStack, "10.2.0.1", "inet", "inet6")
+	testIPSpec(t, dualStack, "", "eth1:inet6")
+	testIPSpec(t, dualStack, "fe80::1", "eth1:inet6", "fe80::/10")
+
 	// Test that inet and inet6 will never find the loopback address
 	loopback := []interfaceIP{
 		newInterfaceIP(lo6, "::1"),
@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// TemplateFuncs returns the functions available to the templates we
+// render, which are the configuration file and the templates of watches
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"default":         defaultValue,
+		"required":        required,
+		"env":             envFunc,
+		"envPrefix":       envPrefix,
+		"file":            file,
+		"split":           split,
+		"join":            join,
+		"trimSpace":       strings.TrimSpace,
+		"replaceAll":      replaceAll,
+		"regexMatch":      regexMatch,
+		"regexReplace":    regexReplace,
+		"regexReplaceAll": regexReplaceAll,
+		"toJSON":          toJSON,
+		"fromJSON":        fromJSON,
+		"base64Encode":    base64Encode,
+		"base64Decode":    base64Decode,
+		"loop":            loop,
+	}
+}
+
+// split is a version of strings.Split that can be piped
+func split(sep, s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return []string{}, nil
+	}
+	return strings.Split(s, sep), nil
+}
+
+// join is a version of strings.Join that can be piped
+func join(sep string, s []string) (string, error) {
+	if len(s) == 0 {
+		return "", nil
+	}
+	return strings.Join(s, sep), nil
+}
+
+// replaceAll replaces all occurrences of a value in a string with the given
+// replacement value.
+func replaceAll(from, to, s string) (string, error) {
+	return strings.Replace(s, from, to, -1), nil
+}
+
+// regexReplaceAll replaces all occurrences of a regex in a string with the given
+// replacement value.
+func regexReplaceAll(re, to, s string) (string, error) {
+	compiled, err := regexp.Compile(re)
+	if err != nil {
+		return "", err
+	}
+	return compiled.ReplaceAllString(s, to), nil
+}
+
+// regexMatch returns true if the regex matches the string
+func regexMatch(re, s string) (bool, error) {
+	return regexp.MatchString(re, s)
+}
+
+// regexReplace replaces the first occurrence of a regex in a string with
+// the given replacement value, which can refer to submatches as $1 etc.
+func regexReplace(re, to, s string) (string, error) {
+	compiled, err := regexp.Compile(re)
+	if err != nil {
+		return "", err
+	}
+	match := compiled.FindStringSubmatchIndex(s)
+	if match == nil {
+		return s, nil
+	}
+	replaced := compiled.ExpandString(nil, to, s, match)
+	return s[:match[0]] + string(replaced) + s[match[1]:], nil
+}
+
+func envFunc(env string) string {
+	return os.Getenv(env)
+}
+
+// envPrefix returns the environment variables whose names begin with the
+// prefix, so that a template can range over them in order of their names
+func envPrefix(prefix string) map[string]string {
+	env := make(map[string]string)
+	for _, e := range os.Environ() {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 && strings.HasPrefix(kv[0], prefix) {
+			env[kv[0]] = kv[1]
+		}
+	}
+	return env
+}
+
+// required returns the value, or an error with the message if the value
+// is missing or empty, so that a configuration with a missing variable
+// fails with a helpful error rather than being rendered with a blank
+func required(msg string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, errors.New(msg)
+	}
+	if str, ok := value.(string); ok && str == "" {
+		return nil, errors.New(msg)
+	}
+	return value, nil
+}
+
+// file returns the contents of the file at path, such as a secret
+// mounted into the container
+func file(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// toJSON returns the value encoded as JSON
+func toJSON(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// fromJSON decodes a string of JSON, so that a template can use the
+// fields of an object or the items of an array
+func fromJSON(s string) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		return nil, fmt.Errorf("fromJSON: %v", err)
+	}
+	return value, nil
+}
+
+func base64Encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func base64Decode(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("base64Decode: %v", err)
+	}
+	return string(data), nil
+}
+
+// loop accepts 1 or two parameters
+// loop 5 returns 0 1 2 3 4 or loop 5 8 returns 5 6 7 or loop 5 1 returns 5 4 3 2
+func loop(params ...int) ([]int, error) {
+	var start, stop int
+	result := []int{}
+
+	switch len(params) {
+	case 1:
+		start, stop = 0, params[0]
+	case 2:
+		start, stop = params[0], params[1]
+	default:
+		return nil, fmt.Errorf("loop: wrong number of arguments, expected 1 or 2"+
+			", but got %d", len(params))
+	}
+
+	if stop < start {
+		for i := start; i > stop; i-- {
+			result = append(result, i)
+		}
+	} else {
+		for i := start; i < stop; i++ {
+			result = append(result, i)
+
+		}
+	}
+	return result, nil
+}
+
+func defaultValue(defaultValue, templateValue interface{}) string {
+	if templateValue != nil {
+		if str, ok := templateValue.(string); ok && str != "" {
+			return str
+		}
+	}
+	defaultStr, ok := defaultValue.(string)
+	if !ok {
+		return fmt.Sprintf("%v", defaultValue)
+	}
+	return defaultStr
+}
@@ -2,6 +2,7 @@ package watches
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/utils"
@@ -11,9 +12,35 @@ import (
 type Config struct {
 	Name             string `mapstructure:"name"`
 	serviceName      string
-	Poll             int    `mapstructure:"interval"` // time in seconds
-	Tag              string `mapstructure:"tag"`
+	Poll             int         `mapstructure:"interval"` // time in seconds
+	Jitter           float64     `mapstructure:"jitter"`   // percentage of the interval
+	Tag              string      `mapstructure:"tag"`
+	KV               string      `mapstructure:"kv"`
+	File             string      `mapstructure:"file"`
+	Datacenters      interface{} `mapstructure:"dc"`
 	discoveryService discovery.Backend
+	kvBackend        discovery.KVBackend
+	dcBackend        discovery.DatacenterBackend
+	datacenters      []string
+	definition       interface{} // the raw configuration, compared on reload
+
+	Template         *TemplateConfig `mapstructure:"template"`
+	template         *watchTemplate
+	InstancesFile    string `mapstructure:"instancesFile"`
+	instancesBackend discovery.InstancesBackend
+
+	Debounce string `mapstructure:"debounce"`
+	Cooldown string `mapstructure:"cooldown"`
+	debounce time.Duration
+	cooldown time.Duration
+
+	Tags          []string `mapstructure:"tags"`
+	Meta          []string `mapstructure:"meta"`
+	filter        *discovery.InstanceFilter
+	filterBackend discovery.FilterBackend
+
+	URL   string `mapstructure:"url"`
+	Field string `mapstructure:"field"` // dotted path into a JSON response
 }
 
 // NewConfigs parses json config into a validated slice of Configs
@@ -25,7 +52,8 @@ func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
 	if err := utils.DecodeRaw(raw, &watches); err != nil {
 		return watches, fmt.Errorf("Watch configuration error: %v", err)
 	}
-	for _, watch := range watches {
+	for i, watch := range watches {
+		watch.definition = raw[i]
 		if err := watch.Validate(disc); err != nil {
 			return watches, err
 		}
@@ -45,7 +73,124 @@ func (cfg *Config) Validate(disc discovery.Backend) error {
 	if cfg.Poll < 1 {
 		return fmt.Errorf("watch[%s].interval must be > 0", cfg.serviceName)
 	}
+	if cfg.Jitter < 0 || cfg.Jitter > 100 {
+		return fmt.Errorf("watch[%s].jitter must be a percentage between 0 and 100",
+			cfg.serviceName)
+	}
+	if err := cfg.validateDebounce(); err != nil {
+		return err
+	}
+	if cfg.URL != "" {
+		return cfg.validateURL()
+	}
+	if cfg.Field != "" {
+		return fmt.Errorf("watch[%s].field requires a 'url'", cfg.serviceName)
+	}
+	if cfg.File != "" {
+		return cfg.validateFile()
+	}
 	cfg.discoveryService = disc
+	if cfg.KV != "" {
+		if cfg.Tag != "" {
+			return fmt.Errorf("watch[%s] can't have both a 'kv' and a 'tag'",
+				cfg.serviceName)
+		}
+		if !discovery.Find(disc, &cfg.kvBackend) {
+			return fmt.Errorf("watch[%s].kv requires the Consul discovery backend",
+				cfg.serviceName)
+		}
+	}
+	if err := cfg.validateDatacenters(disc); err != nil {
+		return err
+	}
+	if err := cfg.validateFilter(disc); err != nil {
+		return err
+	}
+	if err := cfg.validateInstances(disc); err != nil {
+		return err
+	}
+	return cfg.validateTemplate(disc)
+}
+
+func (cfg *Config) validateDebounce() error {
+	debounce, err := utils.GetTimeout(cfg.Debounce)
+	if err != nil || debounce < 0 {
+		return fmt.Errorf("could not parse watch[%s].debounce '%s'",
+			cfg.serviceName, cfg.Debounce)
+	}
+	cooldown, err := utils.GetTimeout(cfg.Cooldown)
+	if err != nil || cooldown < 0 {
+		return fmt.Errorf("could not parse watch[%s].cooldown '%s'",
+			cfg.serviceName, cfg.Cooldown)
+	}
+	cfg.debounce = debounce
+	cfg.cooldown = cooldown
+	return nil
+}
+
+func (cfg *Config) validateDatacenters(disc discovery.Backend) error {
+	datacenters, err := utils.ToStringArray(cfg.Datacenters)
+	if err != nil {
+		return fmt.Errorf("watch[%s].dc must be a datacenter or list of datacenters",
+			cfg.serviceName)
+	}
+	if len(datacenters) == 0 {
+		return nil
+	}
+	if cfg.KV != "" {
+		return fmt.Errorf("watch[%s] can't have both a 'kv' and a 'dc'",
+			cfg.serviceName)
+	}
+	for _, dc := range datacenters {
+		if dc == "" {
+			return fmt.Errorf("watch[%s].dc must not be empty", cfg.serviceName)
+		}
+	}
+	if !discovery.Find(disc, &cfg.dcBackend) {
+		return fmt.Errorf("watch[%s].dc requires the Consul discovery backend",
+			cfg.serviceName)
+	}
+	cfg.datacenters = datacenters
+	return nil
+}
+
+func (cfg *Config) validateFilter(disc discovery.Backend) error {
+	filter, err := discovery.NewInstanceFilter(cfg.Tags, cfg.Meta)
+	if err != nil {
+		return fmt.Errorf("watch[%s]: %v", cfg.serviceName, err)
+	}
+	if filter == nil {
+		return nil
+	}
+	if cfg.KV != "" {
+		return fmt.Errorf("watch[%s] can't have both a 'kv' and 'tags' or 'meta'",
+			cfg.serviceName)
+	}
+	if !discovery.Find(disc, &cfg.filterBackend) {
+		return fmt.Errorf("watch[%s].tags and meta require the Consul discovery backend",
+			cfg.serviceName)
+	}
+	cfg.filter = filter
+	return nil
+}
+
+func (cfg *Config) validateFile() error {
+	if cfg.Tag != "" || cfg.KV != "" || cfg.Datacenters != nil {
+		return fmt.Errorf("watch[%s].file can't be combined with 'tag', 'kv', or 'dc'",
+			cfg.serviceName)
+	}
+	if len(cfg.Tags) > 0 || len(cfg.Meta) > 0 {
+		return fmt.Errorf("watch[%s].file can't be combined with 'tags' or 'meta'",
+			cfg.serviceName)
+	}
+	if cfg.Template != nil {
+		return fmt.Errorf("watch[%s].template can't be combined with 'file'",
+			cfg.serviceName)
+	}
+	if cfg.InstancesFile != "" {
+		return fmt.Errorf("watch[%s].instancesFile can't be combined with 'file'",
+			cfg.serviceName)
+	}
 	return nil
 }
 
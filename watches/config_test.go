@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/joyent/containerpilot/tests"
 	"github.com/joyent/containerpilot/tests/assert"
@@ -39,4 +40,108 @@ func TestWatchesConfigError(t *testing.T) {
 	_, err = NewConfigs(tests.DecodeRawToSlice(
 		`[{"name": "myName"}]`), nil)
 	assert.Error(t, err, "watch[myName].interval must be > 0")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 10, "jitter": -5}]`), nil)
+	assert.Error(t, err, "watch[myName].jitter must be a percentage between 0 and 100")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "certs", "interval": 10, "file": "/etc/certs", "tag": "dev"}]`), nil)
+	assert.Error(t, err, "watch[certs].file can't be combined with 'tag', 'kv', or 'dc'")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "flags", "interval": 10, "kv": "flags/"}]`), nil)
+	assert.Error(t, err, "watch[flags].kv requires the Consul discovery backend")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "flags", "interval": 10, "kv": "flags/", "tag": "dev"}]`), nil)
+	assert.Error(t, err, "watch[flags] can't have both a 'kv' and a 'tag'")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "failover", "interval": 10, "dc": "dc2"}]`), nil)
+	assert.Error(t, err, "watch[failover].dc requires the Consul discovery backend")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "failover", "interval": 10, "dc": {"name": "dc2"}}]`), nil)
+	assert.Error(t, err, "watch[failover].dc must be a datacenter or list of datacenters")
+}
+
+func TestWatchesTemplateConfigError(t *testing.T) {
+	_, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "template": {"source": "app.tmpl"}}]`), nil)
+	assert.Error(t, err, "watch[app].template must have a 'source' and 'dest'")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "file": "/etc/app",
+		"template": {"source": "app.tmpl", "dest": "app.conf"}}]`), nil)
+	assert.Error(t, err, "watch[app].template can't be combined with 'file'")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10,
+		"template": {"source": "./testdata/app.tmpl", "dest": "app.conf"}}]`), nil)
+	assert.Error(t, err, "watch[app].template requires the Consul discovery backend")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10,
+		"template": {"source": "./testdata/app.tmpl", "dest": "app.conf", "perms": "rw"}}]`), nil)
+	assert.Error(t, err, "watch[app].template.perms 'rw' must be an octal "+
+		"file mode such as '0644'")
+}
+
+func TestWatchesDebounceConfig(t *testing.T) {
+	watches, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "debounce": "5s", "cooldown": 30}]`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, watches[0].debounce, 5*time.Second, "expected %v but got %v")
+	assert.Equal(t, watches[0].cooldown, 30*time.Second, "expected %v but got %v")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "debounce": "soon"}]`), nil)
+	assert.Error(t, err, "could not parse watch[app].debounce 'soon'")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "cooldown": "-1s"}]`), nil)
+	assert.Error(t, err, "could not parse watch[app].cooldown '-1s'")
+}
+
+func TestWatchesFilterConfigError(t *testing.T) {
+	_, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "meta": ["version=v2"]}]`), nil)
+	assert.Error(t, err, "watch[app].tags and meta require the Consul discovery backend")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "meta": ["=v2"]}]`), nil)
+	assert.Error(t, err, "watch[app]: invalid meta expression '=v2'")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "file": "/etc/app", "tags": ["prod"]}]`), nil)
+	assert.Error(t, err, "watch[app].file can't be combined with 'tags' or 'meta'")
+}
+
+func TestWatchesInstancesFileConfigError(t *testing.T) {
+	_, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "instancesFile": "/tmp/app.json"}]`), nil)
+	assert.Error(t, err, "watch[app].instancesFile requires the Consul discovery backend")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "app", "interval": 10, "file": "/etc/app",
+		"instancesFile": "/tmp/app.json"}]`), nil)
+	assert.Error(t, err, "watch[app].instancesFile can't be combined with 'file'")
+}
+
+func TestWatchesURLConfigError(t *testing.T) {
+	_, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "remote", "interval": 10, "url": "ftp://example.com/config"}]`), nil)
+	assert.Error(t, err, "watch[remote].url must be an http or https URL")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "remote", "interval": 10, "url": "http://example.com", "kv": "config/"}]`), nil)
+	assert.Error(t, err, "watch[remote].url can't be combined with "+
+		"'tag', 'tags', 'meta', 'kv', 'file', or 'dc'")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "remote", "interval": 10, "field": "version"}]`), nil)
+	assert.Error(t, err, "watch[remote].field requires a 'url'")
 }
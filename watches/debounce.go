@@ -0,0 +1,94 @@
+package watches
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer holds back the events of a watch so that a burst of changes,
+// such as a rolling deploy of the watched service, runs its handlers
+// once. Each change waits for the debounce period to pass without another
+// change, and for the cooldown period to pass since the last time the
+// events were emitted. The pending changes are then emitted together with
+// the latest health of the watch.
+type debouncer struct {
+	debounce time.Duration
+	cooldown time.Duration
+	emit     func(changed, healthy bool)
+
+	lock    sync.Mutex
+	timer   *time.Timer
+	gen     int // identifies the latest timer, so an earlier one can't fire
+	pending bool
+	changed bool
+	healthy bool
+	last    time.Time // when the events were last emitted
+	stopped bool
+}
+
+func newDebouncer(debounce, cooldown time.Duration,
+	emit func(changed, healthy bool)) *debouncer {
+	if debounce == 0 && cooldown == 0 {
+		return nil
+	}
+	return &debouncer{debounce: debounce, cooldown: cooldown, emit: emit}
+}
+
+// add records a change, or a change in health, and schedules the events
+// to be emitted once the debounce and cooldown periods allow
+func (d *debouncer) add(changed, healthy bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stopped {
+		return
+	}
+	d.pending = true
+	d.changed = d.changed || changed
+	d.healthy = healthy
+
+	now := time.Now()
+	delay := d.debounce
+	if wait := d.last.Add(d.cooldown).Sub(now); wait > delay {
+		delay = wait
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.gen++
+	if delay <= 0 {
+		d.flush(now)
+		return
+	}
+	gen := d.gen
+	d.timer = time.AfterFunc(delay, func() { d.fire(gen) })
+}
+
+func (d *debouncer) fire(gen int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.stopped && gen == d.gen {
+		d.flush(time.Now())
+	}
+}
+
+// flush emits the pending events. The caller must hold the lock.
+func (d *debouncer) flush(now time.Time) {
+	if !d.pending {
+		return
+	}
+	d.emit(d.changed, d.healthy)
+	d.last = now
+	d.pending = false
+	d.changed = false
+}
+
+// stop drops any pending events, so that nothing is emitted once the
+// watch has stopped
+func (d *debouncer) stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
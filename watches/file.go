@@ -0,0 +1,109 @@
+package watches
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// fileSettleDelay is how long a file watch waits after being notified
+// before it checks the file, so that a burst of writes, such as a
+// certificate and its key being replaced, is seen as a single change
+const fileSettleDelay = 100 * time.Millisecond
+
+// notifier wakes a file watch when the paths it watches might have changed
+type notifier interface {
+	add(path string)
+	wait(ctx context.Context, timeout time.Duration) bool
+	close()
+}
+
+// pollNotifier is the notifier where inotify isn't available, which never
+// wakes a file watch before its interval
+type pollNotifier struct{}
+
+func (pollNotifier) add(string) {}
+
+func (pollNotifier) wait(ctx context.Context, timeout time.Duration) bool {
+	select {
+	case <-ctx.Done():
+	case <-time.After(timeout):
+	}
+	return false
+}
+
+func (pollNotifier) close() {}
+
+// watchFile watches a file, or the entries of a directory, until the
+// context is canceled. When they're created, modified, or deleted, the
+// watch publishes its events, with the watch being healthy if the file
+// exists. The watch is woken by inotify where it's available, and checks
+// the file every interval regardless, for filesystems where inotify misses
+// changes.
+func (watch *Watch) watchFile(ctx context.Context) {
+	interval := time.Duration(watch.poll) * time.Second
+	notify, err := newNotifier()
+	if err != nil {
+		log.Debugf("%s: polling for changes: %v", watch.Name, err)
+		notify = pollNotifier{}
+	}
+	defer notify.close()
+	last := map[string]string{}
+	for {
+		// the parent directory sees the file created, replaced, or
+		// deleted, and a directory sees changes to its entries
+		notify.add(filepath.Dir(watch.file))
+		notify.add(watch.file)
+		current := snapshotFile(watch.file)
+		if !reflect.DeepEqual(current, last) {
+			last = current
+			watch.notify(true, len(current) > 0)
+		}
+		if notify.wait(ctx, interval) {
+			select {
+			case <-ctx.Done():
+			case <-time.After(fileSettleDelay):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// snapshotFile returns the size, modification time, and mode of a file, or
+// of each entry of a directory, so that comparing snapshots finds changes.
+// Symlinks are followed, so a secret that's updated by replacing a symlink
+// to it is seen as changed. The snapshot is empty if the file doesn't exist.
+func snapshotFile(path string) map[string]string {
+	snapshot := map[string]string{}
+	info, err := os.Stat(path)
+	if err != nil {
+		return snapshot
+	}
+	snapshot[path] = fileState(info)
+	if !info.IsDir() {
+		return snapshot
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return snapshot
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		if info, err := os.Stat(entryPath); err == nil {
+			snapshot[entryPath] = fileState(info)
+		}
+	}
+	return snapshot
+}
+
+func fileState(info os.FileInfo) string {
+	return fmt.Sprintf("%d %d %v", info.Size(), info.ModTime().UnixNano(), info.Mode())
+}
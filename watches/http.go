@@ -0,0 +1,144 @@
+package watches
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxHTTPBodySize is the most of the response body an HTTP watch reads,
+// so that a misbehaving endpoint can't exhaust our memory
+const maxHTTPBodySize = 1 << 20
+
+func (cfg *Config) validateURL() error {
+	if cfg.Tag != "" || cfg.KV != "" || cfg.File != "" || cfg.Datacenters != nil ||
+		len(cfg.Tags) > 0 || len(cfg.Meta) > 0 {
+		return fmt.Errorf("watch[%s].url can't be combined with "+
+			"'tag', 'tags', 'meta', 'kv', 'file', or 'dc'", cfg.serviceName)
+	}
+	if cfg.Template != nil || cfg.InstancesFile != "" {
+		return fmt.Errorf("watch[%s].url can't be combined with "+
+			"'template' or 'instancesFile'", cfg.serviceName)
+	}
+	parsed, err := url.Parse(cfg.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") ||
+		parsed.Host == "" {
+		return fmt.Errorf("watch[%s].url must be an http or https URL",
+			cfg.serviceName)
+	}
+	return nil
+}
+
+// httpState is the result of polling the URL of an HTTP watch. The value
+// is kept from the last successful poll when a poll fails.
+type httpState struct {
+	value   string
+	fetched bool // whether any poll has succeeded
+	healthy bool
+}
+
+// checkURL polls the URL of an HTTP watch and emits the watch's events if
+// the value (the response body, or the field extracted from it) or the
+// watch's health changed. The watch is healthy while the URL responds with
+// a 2xx status. A failed poll doesn't count as a change to the value, so
+// that a blip in the external service doesn't run the watch's handlers.
+func (watch *Watch) checkURL(ctx context.Context) {
+	value, err := watch.fetchURL(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	last := watch.lastHTTP
+	next := httpState{value: value, fetched: true, healthy: true}
+	if err != nil {
+		log.Warnf("%s: failed to poll %s: %v", watch.Name, watch.url, err)
+		next = httpState{value: last.value, fetched: last.fetched}
+	}
+	if last.healthy == next.healthy && last.fetched == next.fetched &&
+		last.value == next.value {
+		return
+	}
+	watch.lastHTTP = next
+	changed := next.healthy && (!last.fetched || last.value != next.value)
+	if changed {
+		os.Setenv(watch.httpEnvKey(), next.value)
+	}
+	watch.notify(changed, next.healthy)
+}
+
+// httpEnvKey returns the environment variable that holds the value of an
+// HTTP watch, ex. CONTAINERPILOT_REMOTE_CONFIG_HTTP for the watch
+// "remote-config"
+func (watch *Watch) httpEnvKey() string {
+	return watch.envKey("HTTP")
+}
+
+// fetchURL makes the request to the watch's URL and returns the response
+// body, or the field extracted from it
+func (watch *Watch) fetchURL(ctx context.Context) (string, error) {
+	timeout := time.Duration(watch.poll) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, watch.url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPBodySize))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if watch.field == "" {
+		return string(body), nil
+	}
+	return extractField(body, watch.field)
+}
+
+// extractField returns the value at the dotted path in a JSON document,
+// where each part of the path is a key of an object or an index into an
+// array. A string value is returned as is, and any other value as JSON.
+func extractField(body []byte, field string) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("could not parse response as JSON: %v", err)
+	}
+	for _, key := range strings.Split(field, ".") {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			val, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("field '%s' not found", field)
+			}
+			doc = val
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("field '%s' not found", field)
+			}
+			doc = node[i]
+		default:
+			return "", fmt.Errorf("field '%s' not found", field)
+		}
+	}
+	if str, ok := doc.(string); ok {
+		return str, nil
+	}
+	data, err := json.Marshal(doc)
+	return string(data), err
+}
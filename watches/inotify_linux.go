@@ -0,0 +1,76 @@
+// +build linux
+
+package watches
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY |
+	unix.IN_ATTRIB | unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// inotifyPollPeriod is the longest a wait blocks before it checks whether
+// its context has been canceled
+const inotifyPollPeriod = 500 * time.Millisecond
+
+// inotifier wakes a file watch with inotify
+type inotifier struct {
+	fd int
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	return &inotifier{fd: fd}, nil
+}
+
+// add watches a path, which is a no-op if it's already watched. Paths
+// that don't exist yet are added again on the next check.
+func (n *inotifier) add(path string) {
+	unix.InotifyAddWatch(n.fd, path, inotifyMask)
+}
+
+// wait blocks until there are inotify events, which it discards, or until
+// the timeout. It returns true if there were events.
+func (n *inotifier) wait(ctx context.Context, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for ctx.Err() == nil {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return false
+		}
+		if remaining > inotifyPollPeriod {
+			remaining = inotifyPollPeriod
+		}
+		fds := []unix.PollFd{{Fd: int32(n.fd), Events: unix.POLLIN}}
+		count, err := unix.Poll(fds, int(remaining/time.Millisecond))
+		if err != nil && err != unix.EINTR {
+			return false
+		}
+		if count > 0 {
+			n.discard()
+			return true
+		}
+	}
+	return false
+}
+
+// discard reads all of the pending events, as only their arrival matters
+func (n *inotifier) discard() {
+	buf := make([]byte, 4096)
+	for {
+		if count, err := unix.Read(n.fd, buf); count <= 0 || err != nil {
+			return
+		}
+	}
+}
+
+func (n *inotifier) close() {
+	unix.Close(n.fd)
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package watches
+
+import "errors"
+
+func newNotifier() (notifier, error) {
+	return nil, errors.New("inotify is only supported on Linux")
+}
@@ -0,0 +1,97 @@
+package watches
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+	"github.com/joyent/containerpilot/discovery"
+)
+
+// Instance is a healthy instance of the watched service, as passed to
+// the handlers of the watch's changed event and to its template
+type Instance struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Node    string            `json:"node,omitempty"`
+}
+
+// validateInstances finds the backend that returns the instances of a
+// service watch, which is required to write them to the instancesFile
+func (cfg *Config) validateInstances(disc discovery.Backend) error {
+	if cfg.KV == "" {
+		discovery.Find(disc, &cfg.instancesBackend)
+	}
+	if cfg.InstancesFile == "" {
+		return nil
+	}
+	if cfg.KV != "" {
+		return fmt.Errorf("watch[%s] can't have both a 'kv' and an 'instancesFile'",
+			cfg.serviceName)
+	}
+	if cfg.instancesBackend == nil {
+		return fmt.Errorf("watch[%s].instancesFile requires the Consul discovery backend",
+			cfg.serviceName)
+	}
+	return nil
+}
+
+// updateInstances makes the instances of the watched service available to
+// the handlers of its changed event, by setting them in the environment
+// and writing them to the instancesFile (if any), and then renders the
+// template. Returns true if the watch should emit its changed event, and
+// the error if the template failed to render.
+func (watch *Watch) updateInstances() (bool, error) {
+	if watch.instancesBackend == nil {
+		return true, nil
+	}
+	instances := watch.instances()
+	data, _ := json.Marshal(instances)
+	os.Setenv(watch.instancesEnvKey(), string(data))
+	if watch.instancesFile != "" {
+		if err := writeFile(watch.instancesFile, data, 0644); err != nil {
+			log.Errorf("%s: failed to write instances to %s: %v",
+				watch.Name, watch.instancesFile, err)
+		}
+	}
+	return watch.renderTemplate(templateData{Instances: instances})
+}
+
+// instances returns the healthy instances of the watched service found
+// by the last check for upstream changes
+func (watch *Watch) instances() []Instance {
+	entries := watch.instancesBackend.WatchedInstances(
+		watch.serviceName, watch.datacenters, watch.filter)
+	instances := make([]Instance, 0, len(entries))
+	for _, entry := range entries {
+		instances = append(instances, newInstance(entry))
+	}
+	return instances
+}
+
+func newInstance(entry *api.ServiceEntry) Instance {
+	instance := Instance{Tags: []string{}}
+	if entry.Node != nil {
+		instance.Node = entry.Node.Node
+		instance.Address = entry.Node.Address
+	}
+	if entry.Service != nil {
+		instance.ID = entry.Service.ID
+		instance.Name = entry.Service.Service
+		instance.Port = entry.Service.Port
+		if entry.Service.Tags != nil {
+			instance.Tags = entry.Service.Tags
+		}
+		instance.Meta = entry.Service.Meta
+		if entry.Service.Address != "" {
+			instance.Address = entry.Service.Address
+		}
+	}
+	return instance
+}
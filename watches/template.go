@@ -0,0 +1,160 @@
+package watches
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/utils"
+)
+
+// TemplateConfig configures a template that the watch renders to a file
+// whenever it sees a change, before emitting its changed event
+type TemplateConfig struct {
+	Source string `mapstructure:"source"`
+	Dest   string `mapstructure:"dest"`
+	Perms  string `mapstructure:"perms"` // octal file mode
+}
+
+// watchTemplate is the validated TemplateConfig of a watch
+type watchTemplate struct {
+	source string
+	dest   string
+	perms  os.FileMode
+}
+
+// templateData is passed to the watch's template when it's rendered. A
+// service watch fills in the Instances, and a KV watch the KV values.
+type templateData struct {
+	Name      string
+	Instances []Instance
+	KV        map[string]string
+	Env       map[string]string
+}
+
+func (cfg *Config) validateTemplate(disc discovery.Backend) error {
+	if cfg.Template == nil {
+		return nil
+	}
+	if cfg.Template.Source == "" || cfg.Template.Dest == "" {
+		return fmt.Errorf("watch[%s].template must have a 'source' and 'dest'",
+			cfg.serviceName)
+	}
+	if _, err := parseTemplate(cfg.Template.Source); err != nil {
+		return fmt.Errorf("watch[%s].template.source: %v", cfg.serviceName, err)
+	}
+	perms := os.FileMode(0644)
+	if cfg.Template.Perms != "" {
+		mode, err := strconv.ParseUint(cfg.Template.Perms, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("watch[%s].template.perms '%s' must be an octal "+
+				"file mode such as '0644'", cfg.serviceName, cfg.Template.Perms)
+		}
+		perms = os.FileMode(mode)
+	}
+	if cfg.KV == "" && cfg.instancesBackend == nil {
+		return fmt.Errorf("watch[%s].template requires the Consul discovery backend",
+			cfg.serviceName)
+	}
+	cfg.template = &watchTemplate{
+		source: cfg.Template.Source,
+		dest:   cfg.Template.Dest,
+		perms:  perms,
+	}
+	return nil
+}
+
+// parseTemplate reads and parses the template source. We read it again
+// for each render so that changes to the source are picked up.
+func parseTemplate(source string) (*template.Template, error) {
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(source)).Funcs(utils.TemplateFuncs()).
+		Option("missingkey=zero").Parse(string(data))
+}
+
+// render renders the template with the data and replaces the destination
+// file if the result differs from its contents. Returns true if the
+// destination file changed.
+func (tmpl *watchTemplate) render(data templateData) (bool, error) {
+	parsed, err := parseTemplate(tmpl.source)
+	if err != nil {
+		return false, err
+	}
+	var rendered bytes.Buffer
+	if err := parsed.Execute(&rendered, data); err != nil {
+		return false, err
+	}
+	if existing, err := ioutil.ReadFile(tmpl.dest); err == nil &&
+		bytes.Equal(existing, rendered.Bytes()) {
+		return false, nil
+	}
+	if err := writeFile(tmpl.dest, rendered.Bytes(), tmpl.perms); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeFile writes a temporary file and renames it over the path, so that
+// a reader never sees a partial file
+func writeFile(path string, data []byte, perms os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".containerpilot-watch")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perms); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// renderTemplate renders the watch's template, if it has one, and returns
+// true if the watch should emit its changed event: either it has no
+// template, or the rendered file changed. A template that fails to
+// render leaves the destination file alone and is logged, and the error
+// is returned so that the watch renders it again on its next poll.
+func (watch *Watch) renderTemplate(data templateData) (bool, error) {
+	if watch.template == nil {
+		return true, nil
+	}
+	data.Name = watch.serviceName
+	data.Env = environ()
+	changed, err := watch.template.render(data)
+	if err != nil {
+		log.Errorf("%s: failed to render template %s: %v",
+			watch.Name, watch.template.source, err)
+		return false, err
+	}
+	if !changed {
+		log.Debugf("%s: %s is unchanged", watch.Name, watch.template.dest)
+	}
+	return changed, nil
+}
+
+func environ() map[string]string {
+	env := make(map[string]string)
+	for _, e := range os.Environ() {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 {
+			env[kv[0]] = kv[1]
+		}
+	}
+	return env
+}
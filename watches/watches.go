@@ -2,9 +2,14 @@ package watches
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
+	"strings"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
 )
@@ -17,7 +22,24 @@ type Watch struct {
 	serviceName      string
 	tag              string
 	poll             int
+	jitter           float64 // fraction of the poll interval to randomly shorten
 	discoveryService discovery.Backend
+	kv               string
+	file             string
+	kvBackend        discovery.KVBackend
+	dcBackend        discovery.DatacenterBackend
+	datacenters      []string
+	definition       interface{}
+	template         *watchTemplate
+	instancesBackend discovery.InstancesBackend
+	instancesFile    string
+	debouncer        *debouncer
+	filter           *discovery.InstanceFilter
+	filterBackend    discovery.FilterBackend
+	url              string
+	field            string
+	lastHTTP         httpState
+	renderFailed     bool // render the template again on the next poll
 
 	events.EventHandler // Event handling
 }
@@ -29,8 +51,23 @@ func NewWatch(cfg *Config) *Watch {
 		serviceName:      cfg.serviceName,
 		tag:              cfg.Tag,
 		poll:             cfg.Poll,
+		jitter:           cfg.Jitter / 100,
 		discoveryService: cfg.discoveryService,
+		kv:               cfg.KV,
+		file:             cfg.File,
+		kvBackend:        cfg.kvBackend,
+		dcBackend:        cfg.dcBackend,
+		datacenters:      cfg.datacenters,
+		definition:       cfg.definition,
+		template:         cfg.template,
+		instancesBackend: cfg.instancesBackend,
+		instancesFile:    cfg.InstancesFile,
+		filter:           cfg.filter,
+		filterBackend:    cfg.filterBackend,
+		url:              cfg.URL,
+		field:            cfg.Field,
 	}
+	watch.debouncer = newDebouncer(cfg.debounce, cfg.cooldown, watch.publish)
 	watch.Rx = make(chan events.Event, eventBufferSize)
 	return watch
 }
@@ -45,9 +82,45 @@ func FromConfigs(cfgs []*Config) []*Watch {
 	return watches
 }
 
+// Reload compares the running Watches with the Configs of a reloaded
+// configuration. It returns the Watches of the reloaded configuration,
+// the running Watches that have to be stopped because their definition
+// changed or was removed, and the new Watches to start in their place.
+func Reload(running []*Watch, cfgs []*Config) (watches, stop, start []*Watch) {
+	byName := make(map[string]*Watch, len(running))
+	for _, watch := range running {
+		byName[watch.Name] = watch
+	}
+	for _, cfg := range cfgs {
+		watch, ok := byName[cfg.Name]
+		if ok && reflect.DeepEqual(watch.definition, cfg.definition) {
+			watches = append(watches, watch)
+			delete(byName, cfg.Name)
+			continue
+		}
+		watch = NewWatch(cfg)
+		watches = append(watches, watch)
+		start = append(start, watch)
+	}
+	for _, watch := range running {
+		if _, ok := byName[watch.Name]; ok {
+			stop = append(stop, watch)
+		}
+	}
+	return watches, stop, start
+}
+
 // CheckForUpstreamChanges checks the service discovery endpoint for any changes
 // in a dependent backend. Returns true when there has been a change.
 func (watch *Watch) CheckForUpstreamChanges() (bool, bool) {
+	if watch.filterBackend != nil {
+		return watch.filterBackend.CheckForUpstreamChangesFiltered(
+			watch.serviceName, watch.tag, watch.datacenters, watch.filter)
+	}
+	if watch.dcBackend != nil {
+		return watch.dcBackend.CheckForUpstreamChangesInDatacenters(
+			watch.serviceName, watch.tag, watch.datacenters)
+	}
 	return watch.discoveryService.CheckForUpstreamChanges(watch.serviceName, watch.tag)
 }
 
@@ -58,12 +131,21 @@ func (watch *Watch) Run(bus *events.EventBus) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	timerSource := fmt.Sprintf("%s.poll", watch.Name)
-	events.NewEventTimer(ctx, watch.Rx,
-		time.Duration(watch.poll)*time.Second, timerSource)
+	if watch.file != "" {
+		go watch.watchFile(ctx)
+	} else if watch.kvBackend != nil {
+		go watch.watchKV(ctx)
+	} else {
+		events.NewJitteredEventTimer(ctx, watch.Rx,
+			time.Duration(watch.poll)*time.Second, watch.jitter, timerSource)
+	}
 
 	go func() {
 		defer func() {
 			cancel()
+			if watch.debouncer != nil {
+				watch.debouncer.stop()
+			}
 			watch.Unsubscribe(watch.Bus)
 		}()
 		for {
@@ -74,16 +156,23 @@ func (watch *Watch) Run(bus *events.EventBus) {
 				}
 				switch event {
 				case events.Event{events.TimerExpired, timerSource}:
+					if watch.url != "" {
+						watch.checkURL(ctx)
+						continue
+					}
 					didChange, isHealthy := watch.CheckForUpstreamChanges()
-					if didChange {
-						watch.Bus.Publish(events.Event{events.StatusChanged, watch.Name})
+					if !didChange && !watch.renderFailed {
+						continue
+					}
+					// the backend has already recorded the instances as
+					// seen, so a template that failed to render is tried
+					// again on each poll until it succeeds
+					changed, err := watch.updateInstances()
+					watch.renderFailed = err != nil
+					if didChange || changed {
 						// we only send the StatusHealthy and StatusUnhealthy
 						// events if there was a change
-						if isHealthy {
-							watch.Bus.Publish(events.Event{events.StatusHealthy, watch.Name})
-						} else {
-							watch.Bus.Publish(events.Event{events.StatusUnhealthy, watch.Name})
-						}
+						watch.notify(changed, isHealthy)
 					}
 				case
 					events.Event{events.Quit, watch.Name},
@@ -98,6 +187,102 @@ func (watch *Watch) Run(bus *events.EventBus) {
 	}()
 }
 
+// notify emits the watch's events for a change, or holds them back if the
+// watch has a debounce or cooldown
+func (watch *Watch) notify(changed, healthy bool) {
+	if watch.debouncer != nil {
+		watch.debouncer.add(changed, healthy)
+		return
+	}
+	watch.publish(changed, healthy)
+}
+
+// publish emits the StatusChanged event if the watch changed, followed by
+// its health
+func (watch *Watch) publish(changed, healthy bool) {
+	if changed {
+		watch.Bus.Publish(events.Event{events.StatusChanged, watch.Name})
+	}
+	if healthy {
+		watch.Bus.Publish(events.Event{events.StatusHealthy, watch.Name})
+	} else {
+		watch.Bus.Publish(events.Event{events.StatusUnhealthy, watch.Name})
+	}
+}
+
+// watchKV makes blocking queries for the watched KV key or prefix until
+// the context is canceled. When the values change, they're set in the
+// environment for jobs to read and the watch publishes its events, with
+// the watch being healthy if any keys exist.
+func (watch *Watch) watchKV(ctx context.Context) {
+	wait := time.Duration(watch.poll) * time.Second
+	envKey := watch.kvEnvKey()
+	last := map[string]string{}
+	var index uint64
+	for {
+		values, newIndex, err := watch.kvBackend.WatchKV(ctx, watch.kv, index, wait)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Warnf("failed to query %v: %s", watch.kv, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		if newIndex < index {
+			// the index went backwards (ex. the KV store was restored),
+			// so start over without blocking
+			newIndex = 0
+		}
+		index = newIndex
+		unchanged := reflect.DeepEqual(values, last)
+		if unchanged && !watch.renderFailed {
+			continue
+		}
+		// a template that failed to render is tried again each time the
+		// blocking query returns, until it succeeds
+		last = values
+		os.Setenv(envKey, watch.kvEnvValue(values))
+		changed, err := watch.renderTemplate(templateData{KV: values})
+		watch.renderFailed = err != nil
+		if !unchanged || changed {
+			watch.notify(changed, len(values) > 0)
+		}
+	}
+}
+
+// kvEnvKey returns the environment variable that holds the KV values,
+// ex. CONTAINERPILOT_FEATURE_FLAGS_KV for the watch "feature-flags"
+func (watch *Watch) kvEnvKey() string {
+	return watch.envKey("KV")
+}
+
+// instancesEnvKey returns the environment variable that holds the
+// instances of the watched service, ex. CONTAINERPILOT_BACKEND_INSTANCES
+// for the watch "backend"
+func (watch *Watch) instancesEnvKey() string {
+	return watch.envKey("INSTANCES")
+}
+
+func (watch *Watch) envKey(suffix string) string {
+	name := strings.Replace(strings.ToUpper(watch.serviceName), "-", "_", -1)
+	return fmt.Sprintf("CONTAINERPILOT_%s_%s", name, suffix)
+}
+
+// kvEnvValue returns the value of a watched key, or a JSON object of the
+// values of the keys under a watched prefix
+func (watch *Watch) kvEnvValue(values map[string]string) string {
+	if !strings.HasSuffix(watch.kv, "/") {
+		return values[watch.kv]
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
 // String implements the stdlib fmt.Stringer interface for pretty-printing
 func (watch *Watch) String() string {
 	return "watches.Watch[" + watch.Name + "]"
@@ -1,11 +1,23 @@
 package watches
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/assert"
 	"github.com/joyent/containerpilot/tests/mocks"
 )
 
@@ -58,3 +70,403 @@ func runWatchTest(cfg *Config, count int, disc discovery.Backend) map[events.Eve
 	}
 	return got
 }
+
+// fakeKV returns each of its results in turn from blocking queries, and
+// then blocks until the watch is stopped
+type fakeKV struct {
+	mocks.NoopDiscoveryBackend
+	lock    sync.Mutex
+	results []map[string]string
+	indexes []uint64
+}
+
+func (f *fakeKV) queries() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.indexes)
+}
+
+func (f *fakeKV) WatchKV(ctx context.Context, key string, waitIndex uint64, wait time.Duration) (map[string]string, uint64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.results) == 0 {
+		f.lock.Unlock()
+		<-ctx.Done()
+		f.lock.Lock()
+		return nil, 0, ctx.Err()
+	}
+	values := f.results[0]
+	f.results = f.results[1:]
+	f.indexes = append(f.indexes, waitIndex)
+	return values, uint64(len(f.indexes)), nil
+}
+
+func TestWatchKV(t *testing.T) {
+	kv := &fakeKV{results: []map[string]string{
+		{"flags/": "", "flags/beta": "on"},
+		{"flags/": "", "flags/beta": "on"}, // unchanged
+		{"flags/": "", "flags/beta": "off"},
+		{},
+	}}
+	cfg := &Config{Name: "feature-flags", Poll: 1, KV: "flags/"}
+	if err := cfg.Validate(kv); err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	watch := NewWatch(cfg)
+	watch.Run(bus)
+	deadline := time.After(time.Second)
+	for kv.queries() < 4 {
+		select {
+		case <-deadline:
+			t.Fatalf("timed out waiting for KV queries")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	watch.Quit()
+	bus.Wait()
+
+	got := map[events.Event]int{}
+	for _, result := range bus.DebugEvents() {
+		got[result]++
+	}
+	changed := events.Event{events.StatusChanged, "watch.feature-flags"}
+	healthy := events.Event{events.StatusHealthy, "watch.feature-flags"}
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.feature-flags"}
+	if got[changed] != 3 || got[healthy] != 2 || got[unhealthy] != 1 {
+		t.Fatalf("expected 3 changes (2 healthy, 1 unhealthy) but got %v", got)
+	}
+	assert.Equal(t, kv.indexes, []uint64{0, 1, 2, 3},
+		"expected blocking queries on indexes %v but got %v")
+	assert.Equal(t, os.Getenv("CONTAINERPILOT_FEATURE_FLAGS_KV"), "{}",
+		"expected %v but got %v")
+}
+
+// fakeInstances always reports a change, with the same instances
+type fakeInstances struct {
+	mocks.NoopDiscoveryBackend
+}
+
+func (f *fakeInstances) WatchedInstances(backendName string, datacenters []string, filter *discovery.InstanceFilter) []*api.ServiceEntry {
+	return []*api.ServiceEntry{
+		{Service: &api.AgentService{ID: "app-1", Address: "10.0.0.1", Port: 80}},
+		{Node: &api.Node{Node: "node2", Address: "10.0.0.2"},
+			Service: &api.AgentService{ID: "app-2", Port: 8080}},
+	}
+}
+
+func TestWatchTemplate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watchtemplate")
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "app.conf")
+
+	cfg := &Config{Name: "app", Poll: 1, Template: &TemplateConfig{
+		Source: "./testdata/app.tmpl", Dest: dest, Perms: "0600"}}
+	// the backend reports a change on each poll, but the rendered file is
+	// the same after the first, so only the first emits a changed event
+	got := runWatchTest(cfg, 5, &fakeInstances{
+		mocks.NoopDiscoveryBackend{Val: true}})
+	poll := events.Event{events.TimerExpired, "watch.app.poll"}
+	changed := events.Event{events.StatusChanged, "watch.app"}
+	if got[changed] != 1 || got[poll] != 2 {
+		t.Fatalf("expected 1 change after 2 polls but got %v", got)
+	}
+
+	data, _ := ioutil.ReadFile(dest)
+	expected := "upstream app {\n  server 10.0.0.1:80;\n  server 10.0.0.2:8080;\n}\n"
+	assert.Equal(t, string(data), expected, "expected %q but got %q")
+	info, _ := os.Stat(dest)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600), "expected %v but got %v")
+}
+
+func TestWatchTemplateRetry(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watchtemplate")
+	defer os.RemoveAll(dir)
+	// the destination's directory doesn't exist yet, so the first render
+	// fails and must be retried even though the service is unchanged
+	dest := filepath.Join(dir, "conf", "app.conf")
+
+	cfg := &Config{Name: "app", Poll: 1, Template: &TemplateConfig{
+		Source: "./testdata/app.tmpl", Dest: dest}}
+	disc := &fakeInstances{mocks.NoopDiscoveryBackend{Val: true}}
+	if err := cfg.Validate(disc); err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	watch := NewWatch(cfg)
+	watch.Run(bus)
+	poll := events.Event{events.TimerExpired, "watch.app.poll"}
+	bus.Publish(poll)
+	time.Sleep(100 * time.Millisecond)
+	os.Mkdir(filepath.Dir(dest), 0755)
+	bus.Publish(poll)
+	bus.Publish(poll)
+	watch.Quit()
+	bus.Wait()
+
+	got := map[events.Event]int{}
+	for _, result := range bus.DebugEvents() {
+		got[result]++
+	}
+	changed := events.Event{events.StatusChanged, "watch.app"}
+	healthy := events.Event{events.StatusHealthy, "watch.app"}
+	if got[changed] != 1 || got[healthy] != 2 {
+		t.Fatalf("expected a failed render followed by 1 change but got %v", got)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Fatalf("expected template to be rendered on retry: %v", err)
+	}
+}
+
+func TestWatchInstances(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watchinstances")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backend.json")
+	defer os.Unsetenv("CONTAINERPILOT_BACKEND_INSTANCES")
+
+	cfg := &Config{Name: "backend", Poll: 1, InstancesFile: path}
+	got := runWatchTest(cfg, 5, &fakeInstances{
+		mocks.NoopDiscoveryBackend{Val: true}})
+	changed := events.Event{events.StatusChanged, "watch.backend"}
+	if got[changed] != 1 {
+		t.Fatalf("expected a change but got %v", got)
+	}
+
+	expected := `[{"id":"app-1","name":"","address":"10.0.0.1","port":80,"tags":[]},` +
+		`{"id":"app-2","name":"","address":"10.0.0.2","port":8080,"tags":[],"node":"node2"}]`
+	assert.Equal(t, os.Getenv("CONTAINERPILOT_BACKEND_INSTANCES"), expected,
+		"expected %v but got %v")
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(data), expected, "expected %v but got %v")
+}
+
+func TestWatchFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watchfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cert.pem")
+
+	// a long interval, so that only inotify wakes the watch in time
+	cfg := &Config{Name: "certs", Poll: 10, File: path}
+	if err := cfg.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	watch := NewWatch(cfg)
+	watch.Run(bus)
+	time.Sleep(100 * time.Millisecond)
+	ioutil.WriteFile(path, []byte("first"), 0644)
+	time.Sleep(300 * time.Millisecond)
+	ioutil.WriteFile(path, []byte("second!"), 0644)
+	time.Sleep(300 * time.Millisecond)
+	os.Remove(path)
+	time.Sleep(300 * time.Millisecond)
+	watch.Quit()
+	bus.Wait()
+
+	got := map[events.Event]int{}
+	for _, result := range bus.DebugEvents() {
+		got[result]++
+	}
+	changed := events.Event{events.StatusChanged, "watch.certs"}
+	healthy := events.Event{events.StatusHealthy, "watch.certs"}
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.certs"}
+	if got[changed] != 3 || got[healthy] != 2 || got[unhealthy] != 1 {
+		t.Fatalf("expected 3 changes (2 healthy, 1 unhealthy) but got %v", got)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watchfile")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "tls.key"), []byte("key"), 0600)
+	before := snapshotFile(dir)
+	if len(before) != 2 {
+		t.Fatalf("expected the directory and its entry but got %v", before)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("cert"), 0644)
+	if after := snapshotFile(dir); reflect.DeepEqual(before, after) {
+		t.Fatalf("expected a new entry to change the snapshot but got %v", after)
+	}
+	if missing := snapshotFile(filepath.Join(dir, "missing")); len(missing) != 0 {
+		t.Fatalf("expected an empty snapshot for a missing file but got %v", missing)
+	}
+}
+
+func TestWatchKVEnvValue(t *testing.T) {
+	watch := &Watch{kv: "config/level"}
+	assert.Equal(t, watch.kvEnvValue(map[string]string{"config/level": "debug"}),
+		"debug", "expected %v but got %v")
+	watch = &Watch{kv: "config/"}
+	assert.Equal(t, watch.kvEnvValue(map[string]string{"config/level": "debug"}),
+		`{"config/level":"debug"}`, "expected %v but got %v")
+}
+
+// fakeDatacenters records the datacenters it was asked to query
+type fakeDatacenters struct {
+	mocks.NoopDiscoveryBackend
+	datacenters []string
+}
+
+func (f *fakeDatacenters) CheckForUpstreamChangesInDatacenters(name, tag string, datacenters []string) (bool, bool) {
+	f.datacenters = datacenters
+	return true, true
+}
+
+func TestWatchDatacenters(t *testing.T) {
+	disc := &fakeDatacenters{}
+	cfg := &Config{Name: "failover", Poll: 1, Datacenters: []interface{}{"dc1", "dc2"}}
+	got := runWatchTest(cfg, 5, disc)
+	changed := events.Event{events.StatusChanged, "watch.failover"}
+	if got[changed] != 2 {
+		t.Fatalf("expected 2 changed events but got %v", got)
+	}
+	assert.Equal(t, disc.datacenters, []string{"dc1", "dc2"},
+		"expected to query datacenters %v but got %v")
+}
+
+func TestWatchesReload(t *testing.T) {
+	newWatches := func(raw string) []*Config {
+		cfgs, err := NewConfigs(tests.DecodeRawToSlice(raw), &mocks.NoopDiscoveryBackend{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return cfgs
+	}
+	running := FromConfigs(newWatches(`[
+	{name: "db", interval: 5},
+	{name: "cache", interval: 5},
+	{name: "old", interval: 5}]`))
+	watchList, stop, start := Reload(running, newWatches(`[
+	{name: "db", interval: 5},
+	{name: "cache", interval: 10},
+	{name: "new", interval: 5}]`))
+	if len(watchList) != 3 || watchList[0] != running[0] {
+		t.Fatalf("expected unchanged watch to keep running but got %v", watchList)
+	}
+	if len(stop) != 2 || stop[0] != running[1] || stop[1] != running[2] {
+		t.Fatalf("expected changed and removed watches to stop but got %v", stop)
+	}
+	if len(start) != 2 || start[0].Name != "watch.cache" || start[1].Name != "watch.new" {
+		t.Fatalf("expected changed and new watches to start but got %v", start)
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	var lock sync.Mutex
+	var emitted []string
+	emit := func(changed, healthy bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		emitted = append(emitted, fmt.Sprintf("%v/%v", changed, healthy))
+	}
+	getEmitted := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, emitted...)
+	}
+	if newDebouncer(0, 0, emit) != nil {
+		t.Fatal("expected no debouncer without a debounce or cooldown")
+	}
+
+	// a burst of changes is emitted once after the quiet period, with the
+	// latest health
+	d := newDebouncer(50*time.Millisecond, 0, emit)
+	d.add(true, true)
+	time.Sleep(20 * time.Millisecond)
+	d.add(false, false)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, getEmitted(), []string{}, "expected %v before quiet period but got %v")
+	time.Sleep(80 * time.Millisecond)
+	assert.Equal(t, getEmitted(), []string{"true/false"}, "expected %v but got %v")
+
+	// with a cooldown, the first change is emitted at once and the next is
+	// held until the cooldown has passed
+	emitted = nil
+	d = newDebouncer(0, 100*time.Millisecond, emit)
+	d.add(true, true)
+	d.add(true, true)
+	assert.Equal(t, getEmitted(), []string{"true/true"}, "expected %v but got %v")
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, getEmitted(), []string{"true/true", "true/true"},
+		"expected %v after cooldown but got %v")
+
+	// nothing is emitted once stopped
+	d.add(true, false)
+	d.stop()
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, len(getEmitted()), 2, "expected %v events but got %v")
+}
+
+func TestWatchURL(t *testing.T) {
+	var lock sync.Mutex
+	responses := []string{`{"config": {"version": 1}}`, "",
+		`{"config": {"version": 1}, "other": true}`, `{"config": {"version": 2}}`}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			body := responses[0]
+			if len(responses) > 1 {
+				responses = responses[1:]
+			}
+			if body == "" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, body)
+		}))
+	defer server.Close()
+	defer os.Unsetenv("CONTAINERPILOT_REMOTE_CONFIG_HTTP")
+
+	cfg := &Config{Name: "remote-config", Poll: 1, URL: server.URL,
+		Field: "config.version"}
+	if err := cfg.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	watch := NewWatch(cfg)
+	watch.Run(bus)
+	poll := events.Event{events.TimerExpired, "watch.remote-config.poll"}
+	for i := 0; i < 4; i++ {
+		bus.Publish(poll)
+	}
+	time.Sleep(100 * time.Millisecond)
+	watch.Quit()
+	bus.Wait()
+
+	got := map[events.Event]int{}
+	for _, result := range bus.DebugEvents() {
+		got[result]++
+	}
+	// the first poll and the change of version are changes, the failed poll
+	// and the response with the same version aren't
+	changed := events.Event{events.StatusChanged, "watch.remote-config"}
+	healthy := events.Event{events.StatusHealthy, "watch.remote-config"}
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.remote-config"}
+	if got[changed] != 2 || got[healthy] != 3 || got[unhealthy] != 1 {
+		t.Fatalf("expected 2 changes (3 healthy, 1 unhealthy) but got %v", got)
+	}
+	assert.Equal(t, os.Getenv("CONTAINERPILOT_REMOTE_CONFIG_HTTP"), "2",
+		"expected %v but got %v")
+}
+
+func TestExtractField(t *testing.T) {
+	body := []byte(`{"a": {"b": [{"c": "x"}, {"c": {"d": 1}}]}}`)
+	for field, expected := range map[string]string{
+		"a.b.0.c": "x",
+		"a.b.1.c": `{"d":1}`,
+		"a.b.1":   `{"c":{"d":1}}`,
+	} {
+		val, err := extractField(body, field)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", field, err)
+		}
+		assert.Equal(t, val, expected, "expected %v but got %v")
+	}
+	_, err := extractField(body, "a.b.2")
+	assert.Error(t, err, "field 'a.b.2' not found")
+	_, err = extractField([]byte("nope"), "a")
+	if err == nil {
+		t.Fatal("expected error for a response that isn't JSON")
+	}
+}